@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"go-orderbook/pkg/audit"
+	"go-orderbook/pkg/orderbook"
+	"os"
+	"time"
+)
+
+func runAudit(args []string) error {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	dir := fs.String("dir", "audit", "directory of the audit trail")
+	orderId := fs.Uint64("order", 0, "only records of this order id")
+	account := fs.String("account", "", "only records of this account")
+	from := fs.String("from", "", "only records at or after this RFC 3339 time")
+	to := fs.String("to", "", "only records at or before this RFC 3339 time")
+	fs.Parse(args)
+
+	filter := audit.Filter{OrderId: orderbook.OrderId(*orderId), Account: *account}
+	for _, bound := range []struct {
+		value string
+		t     *time.Time
+	}{{*from, &filter.From}, {*to, &filter.To}} {
+		if bound.value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339Nano, bound.value)
+		if err != nil {
+			return fmt.Errorf("invalid time %q: %w", bound.value, err)
+		}
+		*bound.t = t
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	malformed, err := audit.Query(*dir, filter, func(e audit.Entry) error {
+		w.Write(e.Line)
+		return w.WriteByte('\n')
+	})
+	if malformed > 0 {
+		fmt.Fprintf(os.Stderr, "skipped %d malformed lines\n", malformed)
+	}
+	return err
+}
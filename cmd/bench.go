@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"go-orderbook/pkg/backtest"
+	"go-orderbook/pkg/bench"
+	"go-orderbook/pkg/engine"
+	"go-orderbook/pkg/journal"
+	"go-orderbook/pkg/orderbook"
+	"go-orderbook/pkg/sim"
+	"os"
+	"time"
+)
+
+func runBench(args []string) error {
+	cfg := sim.DefaultConfig()
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	rate := fs.Float64("rate", 0, "actions to send per second, 0 sends them back to back")
+	symbol := fs.String("symbol", "DEMO", "symbol to trade")
+	itchFile := fs.String("itch", "", "replay the orders of -stock from this ITCH file")
+	stock := fs.String("stock", "", "stock to replay from the ITCH file")
+	journalFile := fs.String("journal", "", "replay the orders of -symbol from this journal")
+	seed := fs.Int64("seed", cfg.Seed, "seed of the synthetic flow")
+	duration := fs.Duration("duration", time.Minute, "simulated time of synthetic flow to generate")
+	price := fs.Int("price", int(cfg.InitialPrice), "initial price in ticks of the synthetic flow")
+	makers := fs.Int("makers", cfg.MarketMakers.Count, "number of market makers")
+	noise := fs.Int("noise", cfg.NoiseTraders.Count, "number of noise traders")
+	momentum := fs.Int("momentum", cfg.MomentumTakers.Count, "number of momentum takers")
+	fs.Parse(args)
+
+	var actions []backtest.Action
+	switch {
+	case *itchFile != "" && *journalFile != "":
+		return fmt.Errorf("-itch and -journal cannot be combined")
+	case *itchFile != "":
+		if *stock == "" {
+			return fmt.Errorf("-itch needs -stock")
+		}
+		f, err := os.Open(*itchFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if actions, err = bench.Load(backtest.NewITCHSource(bufio.NewReader(f), *stock)); err != nil {
+			return err
+		}
+	case *journalFile != "":
+		f, err := os.Open(*journalFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		records, _, err := journal.Read(bufio.NewReader(f))
+		if err != nil {
+			return err
+		}
+		if actions, err = bench.Load(backtest.NewJournalSource(records, engine.Symbol(*symbol))); err != nil {
+			return err
+		}
+	default:
+		cfg.Seed = *seed
+		cfg.InitialPrice = orderbook.Price(*price)
+		cfg.MarketMakers.Count = *makers
+		cfg.NoiseTraders.Count = *noise
+		cfg.MomentumTakers.Count = *momentum
+		actions = bench.Generate(cfg, *duration)
+	}
+
+	e := engine.NewEngine()
+	if err := e.List(engine.Symbol(*symbol)); err != nil {
+		return err
+	}
+	report, err := bench.Run(e, engine.Symbol(*symbol), actions, bench.Options{Rate: *rate})
+	if err != nil {
+		return err
+	}
+	_, err = report.WriteTo(os.Stdout)
+	return err
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"go-orderbook/pkg/engine"
+	"go-orderbook/pkg/fix"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+)
+
+func runFix(args []string) error {
+	fs := flag.NewFlagSet("fix", flag.ExitOnError)
+	listen := fs.String("listen", ":9878", "address to accept FIX connections on")
+	compID := fs.String("comp-id", "EXCH", "SenderCompID of the acceptor")
+	store := fs.String("store", "fixstore", "directory for session sequence numbers and messages")
+	symbols := fs.String("symbols", "DEMO", "comma separated symbols to list")
+	priceScale := fs.Int("price-scale", 100, "engine price ticks per unit of FIX price")
+	itchFile := fs.String("itch", "", "archive market data to this file in ITCH format")
+	metricsAddr := fs.String("metrics", "", "serve Prometheus metrics on this address at /metrics")
+	fs.Parse(args)
+
+	e := engine.NewEngine()
+	for _, symbol := range strings.Split(*symbols, ",") {
+		if err := e.List(engine.Symbol(strings.TrimSpace(symbol))); err != nil {
+			return err
+		}
+	}
+	if *itchFile != "" {
+		closeItch, err := recordItch(e, *itchFile)
+		if err != nil {
+			return err
+		}
+		defer closeItch()
+	}
+
+	if *metricsAddr != "" {
+		_, closeMetrics, err := serveMetrics(e, *metricsAddr)
+		if err != nil {
+			return err
+		}
+		defer closeMetrics()
+	}
+
+	g := fix.NewGateway(e, *priceScale)
+	defer g.Close()
+	a := fix.NewAcceptor(*compID, g, fix.FileStoreFactory{Dir: *store})
+	if err := a.Listen(*listen); err != nil {
+		return err
+	}
+	log.Printf("accepting FIX connections for %s on %s", *compID, a.Addr())
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	<-interrupt
+	return a.Close()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"go-orderbook/pkg/engine"
+	"go-orderbook/pkg/itch"
+	"io"
+	"os"
+)
+
+func runItch(args []string) error {
+	fs := flag.NewFlagSet("itch", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: itch <file>...\n")
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	for _, path := range fs.Args() {
+		if err := dumpItch(w, path); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+func dumpItch(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := itch.NewDecoder(bufio.NewReader(f))
+	for {
+		m, err := dec.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, m)
+	}
+}
+
+// recordItch archives the events of e to path. The returned function writes
+// the end of the stream and closes the file.
+func recordItch(e *engine.Engine, path string) (func() error, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	r := itch.NewRecorder(f)
+	if err := r.Start(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	e.AddListener(r)
+	return func() error {
+		err := r.Close()
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}, nil
+}
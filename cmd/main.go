@@ -1,19 +1,51 @@
 package main
 
 import (
-	"go-orderbook/pkg/orderbook"
+	"fmt"
+	"os"
+	"sort"
 )
 
+type command struct {
+	run   func(args []string) error
+	usage string
+}
+
+var commands = map[string]command{
+	"audit": {runAudit, "query the audit trail of the order lifecycle"},
+	"bench": {runBench, "replay order flow against the engine and report latency percentiles"},
+	"fix":   {runFix, "run the FIX 4.4 order entry gateway"},
+	"itch":  {runItch, "print ITCH market data files"},
+	"ouch":  {runOuch, "run the OUCH order entry gateway over SoupBinTCP"},
+	"repl":  {runRepl, "drive a single orderbook from an interactive shell"},
+	"rest":  {runRest, "serve the HTTP/JSON order entry API and WebSocket stream"},
+	"sim":   {runSim, "generate synthetic order flow from a population of agents"},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [flags]\n\ncommands:\n", os.Args[0])
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", name, commands[name].usage)
+	}
+}
+
 func main() {
-	ob := orderbook.NewOrderbook()
-	order := orderbook.NewOrder(
-		orderbook.GoodTillCancel,
-		orderbook.OrderId(1),
-		orderbook.Buy,
-		orderbook.Price(100),
-		orderbook.Quantity(10),
-	)
-	if _, err := ob.AddOrder(order); err != nil {
-		panic(err)
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 }
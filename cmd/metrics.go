@@ -0,0 +1,29 @@
+package main
+
+import (
+	"go-orderbook/pkg/engine"
+	"go-orderbook/pkg/metrics"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// serveMetrics instruments an engine and serves its metrics on addr at
+// /metrics, for a Prometheus server to scrape. The returned function stops
+// serving.
+func serveMetrics(e *engine.Engine, addr string) (*metrics.Registry, func() error, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	r := metrics.NewRegistry()
+	metrics.Instrument(r, e)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r)
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go srv.Serve(l)
+	log.Printf("serving metrics on %s/metrics", l.Addr())
+	return r, srv.Close, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"go-orderbook/pkg/engine"
+	"go-orderbook/pkg/ouch"
+	"go-orderbook/pkg/soupbintcp"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+)
+
+func runOuch(args []string) error {
+	fs := flag.NewFlagSet("ouch", flag.ExitOnError)
+	listen := fs.String("listen", ":9879", "address to accept SoupBinTCP connections on")
+	session := fs.String("session", "OUCH", "session name announced at login")
+	password := fs.String("password", "", "password required at login, empty accepts any")
+	symbols := fs.String("symbols", "DEMO", "comma separated symbols to list")
+	itchFile := fs.String("itch", "", "archive market data to this file in ITCH format")
+	metricsAddr := fs.String("metrics", "", "serve Prometheus metrics on this address at /metrics")
+	fs.Parse(args)
+
+	e := engine.NewEngine()
+	for _, symbol := range strings.Split(*symbols, ",") {
+		if err := e.List(engine.Symbol(strings.TrimSpace(symbol))); err != nil {
+			return err
+		}
+	}
+	if *itchFile != "" {
+		closeItch, err := recordItch(e, *itchFile)
+		if err != nil {
+			return err
+		}
+		defer closeItch()
+	}
+
+	if *metricsAddr != "" {
+		_, closeMetrics, err := serveMetrics(e, *metricsAddr)
+		if err != nil {
+			return err
+		}
+		defer closeMetrics()
+	}
+
+	auth := func(username, pass string) bool {
+		return *password == "" || pass == *password
+	}
+	g := ouch.NewGateway(e)
+	defer g.Close()
+	s := soupbintcp.NewServer(*session, auth, g)
+	if err := s.Listen(*listen); err != nil {
+		return err
+	}
+	log.Printf("accepting OUCH connections for session %s on %s", *session, s.Addr())
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	<-interrupt
+	return s.Close()
+}
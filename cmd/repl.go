@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+	"go-orderbook/pkg/repl"
+	"os"
+)
+
+func runRepl(args []string) error {
+	fs := flag.NewFlagSet("repl", flag.ExitOnError)
+	script := fs.String("script", "", "run the commands of a file before reading stdin")
+	batch := fs.Bool("batch", false, "exit after the script instead of reading stdin")
+	fs.Parse(args)
+
+	s := repl.NewShell(os.Stdout)
+	if *script != "" {
+		if err := s.Load(*script); err != nil {
+			return err
+		}
+	}
+	if *batch {
+		return nil
+	}
+
+	prompt := ""
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		prompt = "> "
+	}
+	return s.Run(os.Stdin, prompt)
+}
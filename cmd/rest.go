@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"go-orderbook/pkg/audit"
+	"go-orderbook/pkg/clearing"
+	"go-orderbook/pkg/engine"
+	"go-orderbook/pkg/fee"
+	"go-orderbook/pkg/position"
+	"go-orderbook/pkg/rest"
+	"go-orderbook/pkg/risk"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+)
+
+func runRest(args []string) error {
+	fs := flag.NewFlagSet("rest", flag.ExitOnError)
+	listen := fs.String("listen", ":8080", "address to serve the HTTP API on")
+	symbols := fs.String("symbols", "DEMO", "comma separated symbols to list")
+	itchFile := fs.String("itch", "", "archive market data to this file in ITCH format")
+	auditDir := fs.String("audit", "", "record order requests and events in an audit trail in this directory")
+	auditSize := fs.Int64("audit-max-size", 256<<20, "size in bytes at which audit files are rotated, 0 rotates daily only")
+	auditSync := fs.Bool("audit-sync", false, "fsync every audit record")
+	metricsAddr := fs.String("metrics", "", "serve Prometheus metrics on this address at /metrics")
+	tokens := fs.String("tokens", "", "comma separated token=account pairs; empty disables authentication")
+	admins := fs.String("admins", "", "comma separated accounts allowed to bust and correct trades")
+	riskFile := fs.String("risk", "", "check orders against the account limits of this JSON file")
+	feeFile := fs.String("fees", "", "charge the fee schedules of this JSON file on every fill")
+	mark := fs.String("mark", "last", "value open positions at the last trade (last) or the midpoint (mid)")
+	statement := fs.String("statement", "", "write the positions of every account to this CSV file on shutdown")
+	settlement := fs.String("settlement", "", "write the settlement of the trading session to this file on shutdown, as JSON if it ends in .json and CSV otherwise")
+	fs.Parse(args)
+
+	var markBy position.Mark
+	switch *mark {
+	case "last":
+		markBy = position.MarkLastTrade
+	case "mid":
+		markBy = position.MarkMidpoint
+	default:
+		return fmt.Errorf("invalid mark %q", *mark)
+	}
+
+	var auth rest.Authenticator
+	if *tokens != "" {
+		accounts := make(map[string]string)
+		for _, pair := range strings.Split(*tokens, ",") {
+			token, account, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok || token == "" || account == "" {
+				return fmt.Errorf("invalid token pair %q", pair)
+			}
+			accounts[token] = account
+		}
+		auth = func(token string) (string, bool) {
+			account, ok := accounts[token]
+			return account, ok
+		}
+	}
+
+	e := engine.NewEngine()
+	for _, symbol := range strings.Split(*symbols, ",") {
+		if err := e.List(engine.Symbol(strings.TrimSpace(symbol))); err != nil {
+			return err
+		}
+	}
+	if *itchFile != "" {
+		closeItch, err := recordItch(e, *itchFile)
+		if err != nil {
+			return err
+		}
+		defer closeItch()
+	}
+
+	handler := rest.NewServer(e, auth)
+	if *auditDir != "" {
+		w, err := audit.OpenWriter(*auditDir, audit.Options{MaxSize: *auditSize, Sync: *auditSync})
+		if err != nil {
+			return err
+		}
+		defer w.Close()
+		handler.UseAudit(audit.New(slog.New(slog.NewJSONHandler(w, nil)), e))
+	}
+	if *metricsAddr != "" {
+		registry, closeMetrics, err := serveMetrics(e, *metricsAddr)
+		if err != nil {
+			return err
+		}
+		defer closeMetrics()
+		handler.UseMetrics(registry)
+	}
+	if *admins != "" {
+		for _, account := range strings.Split(*admins, ",") {
+			handler.UseAdmins(strings.TrimSpace(account))
+		}
+	}
+	if *riskFile != "" {
+		cfg, err := risk.LoadConfig(*riskFile)
+		if err != nil {
+			return err
+		}
+		handler.UseRisk(risk.NewChecker(e, cfg))
+	}
+	if *feeFile != "" {
+		cfg, err := fee.LoadConfig(*feeFile)
+		if err != nil {
+			return err
+		}
+		fees := fee.NewCalculator(cfg)
+		e.UseFees(fees)
+		handler.UseFees(fees)
+	}
+	ledger := position.NewLedger(e, markBy)
+	handler.UseLedger(ledger)
+	house := clearing.NewHouse(e)
+	handler.UseClearing(house)
+
+	srv := &http.Server{
+		Addr:              *listen,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.ListenAndServe()
+	}()
+	log.Printf("serving the HTTP API on %s", *listen)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	select {
+	case err := <-errs:
+		return err
+	case <-interrupt:
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	if *statement != "" {
+		if err := writeStatement(ledger, *statement); err != nil {
+			return err
+		}
+	}
+	if *settlement != "" {
+		return writeSettlement(house.Close(), *settlement)
+	}
+	return nil
+}
+
+func writeSettlement(s clearing.Settlement, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	write := s.WriteCSV
+	if strings.HasSuffix(path, ".json") {
+		write = s.WriteJSON
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func writeStatement(ledger *position.Ledger, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := ledger.WriteStatement(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
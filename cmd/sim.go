@@ -0,0 +1,106 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go-orderbook/pkg/engine"
+	"go-orderbook/pkg/journal"
+	"go-orderbook/pkg/orderbook"
+	"go-orderbook/pkg/sim"
+	"time"
+)
+
+// orderEntry is implemented by engine.Engine and journal.Engine.
+type orderEntry interface {
+	List(symbol engine.Symbol) error
+	AddOrder(symbol engine.Symbol, order orderbook.Order) (orderbook.Trades, error)
+	CancelOrder(orderId orderbook.OrderId) error
+}
+
+// simBook routes the orders of the simulation through the engine, so that
+// they reach its listeners and, optionally, the journal.
+type simBook struct {
+	entry  orderEntry
+	symbol engine.Symbol
+	*orderbook.Orderbook
+}
+
+func (b *simBook) AddOrder(order orderbook.Order) (orderbook.Trades, error) {
+	return b.entry.AddOrder(b.symbol, order)
+}
+
+func (b *simBook) CancelOrder(orderId orderbook.OrderId) error {
+	return b.entry.CancelOrder(orderId)
+}
+
+func runSim(args []string) error {
+	cfg := sim.DefaultConfig()
+	fs := flag.NewFlagSet("sim", flag.ExitOnError)
+	seed := fs.Int64("seed", cfg.Seed, "seed of the run")
+	duration := fs.Duration("duration", time.Minute, "simulated time to run for")
+	speed := fs.Float64("speed", 0, "simulated seconds per second, 0 runs as fast as possible")
+	symbol := fs.String("symbol", "DEMO", "symbol to trade")
+	price := fs.Int("price", int(cfg.InitialPrice), "initial price in ticks")
+	makers := fs.Int("makers", cfg.MarketMakers.Count, "number of market makers")
+	noise := fs.Int("noise", cfg.NoiseTraders.Count, "number of noise traders")
+	momentum := fs.Int("momentum", cfg.MomentumTakers.Count, "number of momentum takers")
+	journalFile := fs.String("journal", "", "journal the order flow to this file, recovering the book it holds")
+	itchFile := fs.String("itch", "", "archive market data to this file in ITCH format")
+	fs.Parse(args)
+
+	cfg.Seed = *seed
+	cfg.Start = time.Now()
+	cfg.InitialPrice = orderbook.Price(*price)
+	cfg.MarketMakers.Count = *makers
+	cfg.NoiseTraders.Count = *noise
+	cfg.MomentumTakers.Count = *momentum
+
+	e := engine.NewEngine()
+	var entry orderEntry = e
+	if *journalFile != "" {
+		// A run restarted after a crash carries on from the book of the
+		// journal, with fresh order ids.
+		je, err := journal.Recover(*journalFile, journal.Options{Sync: journal.SyncNever})
+		if err != nil {
+			return err
+		}
+		defer je.Close()
+		e = je.Engine()
+		entry = je
+		cfg.FirstOrderId = je.LastOrderId() + 1
+	}
+	if *itchFile != "" {
+		closeItch, err := recordItch(e, *itchFile)
+		if err != nil {
+			return err
+		}
+		defer closeItch()
+	}
+
+	if _, listed := e.Book(engine.Symbol(*symbol)); !listed {
+		if err := entry.List(engine.Symbol(*symbol)); err != nil {
+			return err
+		}
+	}
+	ob, _ := e.Book(engine.Symbol(*symbol))
+	s := sim.New(&simBook{entry: entry, symbol: engine.Symbol(*symbol), Orderbook: ob}, cfg)
+
+	start := time.Now()
+	for next, ok := s.NextArrival(); ok && next <= *duration; next, ok = s.NextArrival() {
+		if *speed > 0 {
+			due := start.Add(time.Duration(float64(next) / *speed))
+			if wait := time.Until(due); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+		s.Step()
+	}
+
+	stats := s.Stats()
+	bid, ask := ob.Top()
+	fmt.Printf("arrivals %d, actions %d, rejected %d, trades %d, volume %d\n",
+		stats.Arrivals, stats.Actions, stats.Rejected, stats.Trades, stats.Volume)
+	fmt.Printf("%d resting orders, best bid %d x %d, best ask %d x %d\n",
+		ob.Size(), bid.Price, bid.Quantity, ask.Price, ask.Quantity)
+	return nil
+}
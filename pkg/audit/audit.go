@@ -0,0 +1,342 @@
+// Package audit keeps a durable, append-only trail of the lifecycle of every
+// order of an engine, to answer what happened to an order long after the
+// fact.
+//
+// A Trail writes structured records with log/slog. Used with a JSON handler
+// over a Writer, every record is a line of JSON in a file of a directory
+// that is rotated daily and by size and never rewritten:
+//
+//	order.request    an order entry request: op, account, symbol and order
+//	order.accepted   the engine accepted a request, with the resulting trades
+//	order.rejected   the engine refused a request, with the error
+//	order.added      an event of the book, with the account of the order
+//	order.executed
+//	order.reduced
+//	order.deleted
+//	trade.busted
+//	trade.corrected
+//	trade.amended    an operator busted or corrected a trade, with the reason
+//
+// Modify decisions carry the state of the order before and after. Query
+// reads the records back, filtered by order, account and time.
+package audit
+
+import (
+	"context"
+	"go-orderbook/pkg/engine"
+	"go-orderbook/pkg/orderbook"
+	"log/slog"
+	"sync"
+)
+
+// Operations of order.request and decision records.
+const (
+	OpAdd    = "add"
+	OpModify = "modify"
+	OpCancel = "cancel"
+)
+
+// Trail records the requests, decisions and events of the orders of an
+// engine.
+type Trail struct {
+	logger *slog.Logger
+	engine *engine.Engine
+
+	// m guards orders and entering, which the engine listener updates.
+	m      sync.Mutex
+	orders map[orderbook.OrderId]*order
+	// entering holds the account of the orders of requests being entered,
+	// until their events make them resting.
+	entering map[orderbook.OrderId]string
+}
+
+// order is a resting order, with its open quantity to tell its last
+// execution.
+type order struct {
+	account string
+	open    orderbook.Quantity
+}
+
+// New creates a trail that logs to logger every event of the engine, and
+// the requests entered through it.
+func New(logger *slog.Logger, e *engine.Engine) *Trail {
+	t := &Trail{
+		logger:   logger,
+		engine:   e,
+		orders:   make(map[orderbook.OrderId]*order),
+		entering: make(map[orderbook.OrderId]string),
+	}
+	e.AddListener(engine.ListenerFunc(t.onEvent))
+	return t
+}
+
+// AddOrder records an order entered for account and calls enter to enter it,
+// recording the decision and the trades of the engine.
+func (t *Trail) AddOrder(
+	account string,
+	symbol engine.Symbol,
+	order orderbook.Order,
+	enter func() (orderbook.Trades, error),
+) (orderbook.Trades, error) {
+	t.enter(order.OrderId(), account)
+	attrs := []slog.Attr{
+		slog.String("op", OpAdd),
+		slog.String("account", account),
+		slog.String("symbol", string(symbol)),
+		slog.Uint64("order_id", uint64(order.OrderId())),
+		slog.String("type", order.OrderType().String()),
+		slog.String("side", formatSide(order.Side())),
+		slog.Int("price", int(order.Price())),
+		slog.Uint64("quantity", uint64(order.InitialQuantity())),
+	}
+	t.log(slog.LevelInfo, "order.request", attrs...)
+
+	trades, err := enter()
+	t.entered(order.OrderId(), account)
+	t.decision(attrs[:4:4], trades, err)
+	return trades, err
+}
+
+// ModifyOrder records a modify requested by account and calls enter to
+// apply it, recording the decision, the trades and the state of the order
+// before and after.
+func (t *Trail) ModifyOrder(
+	account string,
+	modify orderbook.OrderModify,
+	enter func() (orderbook.Trades, error),
+) (orderbook.Trades, error) {
+	symbol, before := t.state(modify.OrderId())
+	attrs := []slog.Attr{
+		slog.String("op", OpModify),
+		slog.String("account", account),
+		slog.String("symbol", string(symbol)),
+		slog.Uint64("order_id", uint64(modify.OrderId())),
+		slog.String("side", formatSide(modify.Side())),
+		slog.Int("price", int(modify.Price())),
+		slog.Uint64("quantity", uint64(modify.Quantity())),
+	}
+	t.log(slog.LevelInfo, "order.request", attrs...)
+
+	// A replace deletes the order before adding it again, which must keep
+	// its account.
+	owner := t.owner(modify.OrderId(), account)
+	t.enter(modify.OrderId(), owner)
+	trades, err := enter()
+	t.entered(modify.OrderId(), owner)
+	_, after := t.state(modify.OrderId())
+	t.decision(append(attrs[:4:4], slog.Any("before", before), slog.Any("after", after)), trades, err)
+	return trades, err
+}
+
+// CancelOrder records a cancel requested by account and calls enter to
+// apply it, recording the decision.
+func (t *Trail) CancelOrder(account string, orderId orderbook.OrderId, enter func() error) error {
+	symbol, _ := t.engine.Lookup(orderId)
+	attrs := []slog.Attr{
+		slog.String("op", OpCancel),
+		slog.String("account", account),
+		slog.String("symbol", string(symbol)),
+		slog.Uint64("order_id", uint64(orderId)),
+	}
+	t.log(slog.LevelInfo, "order.request", attrs...)
+
+	err := enter()
+	t.decision(attrs[:4:4], nil, err)
+	return err
+}
+
+// enter keeps the account of an order for the events of its request.
+func (t *Trail) enter(orderId orderbook.OrderId, account string) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	t.entering[orderId] = account
+}
+
+// entered forgets the account kept by enter. An order left resting keeps
+// it until its events take it off the book.
+func (t *Trail) entered(orderId orderbook.OrderId, account string) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	// The id may have been entered again by another account meanwhile.
+	if t.entering[orderId] == account {
+		delete(t.entering, orderId)
+	}
+}
+
+// owner returns the account of a resting order, or account if it is not
+// resting.
+func (t *Trail) owner(orderId orderbook.OrderId, account string) string {
+	t.m.Lock()
+	defer t.m.Unlock()
+	if o, ok := t.orders[orderId]; ok {
+		return o.account
+	}
+	return account
+}
+
+// Amended records the bust or correction of a trade by an operator. The
+// busted and corrected events of its orders are recorded as they happen.
+func (t *Trail) Amended(c engine.Correction) {
+	t.log(slog.LevelInfo, "trade.amended",
+		slog.String("type", c.Type.String()),
+		slog.String("operator", c.Operator),
+		slog.String("reason", c.Reason),
+		slog.String("symbol", string(c.Symbol)),
+		slog.Uint64("match_id", c.MatchId),
+		slog.Uint64("buy_order_id", uint64(c.BuyOrderId)),
+		slog.Uint64("sell_order_id", uint64(c.SellOrderId)),
+		slog.Uint64("quantity", uint64(c.Quantity)),
+		slog.Int("old_price", int(c.OldPrice)),
+		slog.Int("new_price", int(c.NewPrice)),
+	)
+}
+
+// OrderState is the state of a resting order in a modify decision.
+type OrderState struct {
+	Side     string `json:"side"`
+	Price    int    `json:"price"`
+	Open     uint64 `json:"open"`
+	Filled   uint64 `json:"filled"`
+	Quantity uint64 `json:"quantity"`
+}
+
+// state returns the symbol and the state of a resting order, nil if it is
+// not resting.
+func (t *Trail) state(orderId orderbook.OrderId) (engine.Symbol, *OrderState) {
+	symbol, ok := t.engine.Lookup(orderId)
+	if !ok {
+		return "", nil
+	}
+	book, ok := t.engine.Book(symbol)
+	if !ok {
+		return symbol, nil
+	}
+	order, ok := book.Order(orderId)
+	if !ok {
+		return symbol, nil
+	}
+	return symbol, &OrderState{
+		Side:     formatSide(order.Side()),
+		Price:    int(order.Price()),
+		Open:     uint64(order.RemainingQuantity()),
+		Filled:   uint64(order.FilledQuantity()),
+		Quantity: uint64(order.InitialQuantity()),
+	}
+}
+
+// TradeRecord is a trade resulting from a request.
+type TradeRecord struct {
+	MatchId     uint64 `json:"match_id"`
+	BuyOrderId  uint64 `json:"buy_order_id"`
+	SellOrderId uint64 `json:"sell_order_id"`
+	Price       int    `json:"price"`
+	Quantity    uint64 `json:"quantity"`
+	Aggressor   string `json:"aggressor"`
+}
+
+func (t *Trail) decision(attrs []slog.Attr, trades orderbook.Trades, err error) {
+	if err != nil {
+		t.log(slog.LevelWarn, "order.rejected", append(attrs, slog.String("error", err.Error()))...)
+		return
+	}
+	records := make([]TradeRecord, 0, len(trades))
+	for _, trade := range trades {
+		bid, ask := trade.BidTrade(), trade.AskTrade()
+		// A trade prints at the price of the resting order.
+		price := bid.Price()
+		if trade.Aggressor() == orderbook.Buy {
+			price = ask.Price()
+		}
+		records = append(records, TradeRecord{
+			MatchId:     trade.Id(),
+			BuyOrderId:  uint64(bid.OrderId()),
+			SellOrderId: uint64(ask.OrderId()),
+			Price:       int(price),
+			Quantity:    uint64(bid.Quantity()),
+			Aggressor:   formatSide(trade.Aggressor()),
+		})
+	}
+	t.log(slog.LevelInfo, "order.accepted", append(attrs, slog.Any("trades", records))...)
+}
+
+func (t *Trail) onEvent(symbol engine.Symbol, ev orderbook.Event) {
+	account := t.track(ev)
+	// Executions and their amendments are logged at the price they traded.
+	price := ev.Price
+	if ev.MatchId != 0 {
+		price = ev.TradePrice
+	}
+	attrs := []slog.Attr{
+		slog.String("account", account),
+		slog.String("symbol", string(symbol)),
+		slog.Uint64("sequence", ev.Sequence),
+		slog.Uint64("order_id", uint64(ev.OrderId)),
+		slog.String("side", formatSide(ev.Side)),
+		slog.Int("price", int(price)),
+		slog.Uint64("quantity", uint64(ev.Quantity)),
+	}
+	var msg string
+	switch ev.Type {
+	case orderbook.OrderAdded:
+		msg = "order.added"
+	case orderbook.OrderExecuted:
+		msg = "order.executed"
+	case orderbook.OrderReduced:
+		msg = "order.reduced"
+	case orderbook.OrderDeleted:
+		msg = "order.deleted"
+	case orderbook.TradeBusted:
+		msg = "trade.busted"
+	case orderbook.TradeCorrected:
+		msg = "trade.corrected"
+	default:
+		return
+	}
+	if ev.MatchId != 0 {
+		attrs = append(attrs,
+			slog.Uint64("match_id", ev.MatchId),
+			slog.Bool("aggressor", ev.Aggressor),
+			slog.Int64("fee", ev.Fee),
+		)
+	}
+	t.log(slog.LevelInfo, msg, attrs...)
+}
+
+// track returns the account of the order of an event, and forgets it once
+// the order leaves the book.
+func (t *Trail) track(ev orderbook.Event) string {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	o, ok := t.orders[ev.OrderId]
+	if !ok {
+		account := t.entering[ev.OrderId]
+		if ev.Type == orderbook.OrderAdded {
+			t.orders[ev.OrderId] = &order{account: account, open: ev.Quantity}
+		}
+		return account
+	}
+	switch ev.Type {
+	case orderbook.OrderAdded:
+		o.open = ev.Quantity
+	case orderbook.OrderExecuted, orderbook.OrderReduced:
+		o.open -= ev.Quantity
+		if o.open == 0 {
+			delete(t.orders, ev.OrderId)
+		}
+	case orderbook.OrderDeleted:
+		delete(t.orders, ev.OrderId)
+	}
+	return o.account
+}
+
+func (t *Trail) log(level slog.Level, msg string, attrs ...slog.Attr) {
+	t.logger.LogAttrs(context.Background(), level, msg, attrs...)
+}
+
+func formatSide(side orderbook.Side) string {
+	if side == orderbook.Sell {
+		return "sell"
+	}
+	return "buy"
+}
@@ -0,0 +1,192 @@
+package audit
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"go-orderbook/pkg/engine"
+	"go-orderbook/pkg/orderbook"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func query(t *testing.T, dir string, f Filter) []Entry {
+	var entries []Entry
+	malformed, err := Query(dir, f, func(e Entry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Zero(t, malformed)
+	return entries
+}
+
+func messages(entries []Entry) []string {
+	var msgs []string
+	for _, e := range entries {
+		msgs = append(msgs, e.Message)
+	}
+	return msgs
+}
+
+func TestTrail(t *testing.T) {
+	dir := t.TempDir()
+	w, err := OpenWriter(dir, Options{})
+	require.NoError(t, err)
+	e := engine.NewEngine()
+	require.NoError(t, e.List("ACME"))
+	trail := New(slog.New(slog.NewJSONHandler(w, nil)), e)
+
+	add := func(account string, order orderbook.Order) error {
+		_, err := trail.AddOrder(account, "ACME", order, func() (orderbook.Trades, error) {
+			return e.AddOrder("ACME", order)
+		})
+		return err
+	}
+	require.NoError(t, add("alice", orderbook.NewOrder(orderbook.GoodTillCancel, 1, orderbook.Sell, 100, 10)))
+	// The buy is limited at 102 and trades at the resting 100.
+	require.NoError(t, add("bob", orderbook.NewOrder(orderbook.GoodTillCancel, 2, orderbook.Buy, 102, 4)))
+	assert.Error(t, add("bob", orderbook.NewOrder(orderbook.GoodTillCancel, 1, orderbook.Buy, 99, 1)))
+
+	var modify orderbook.OrderModify
+	_, err = trail.ModifyOrder("alice", modify.New(1, 101, orderbook.Sell, 3), func() (orderbook.Trades, error) {
+		return e.ModifyOrder(modify.New(1, 101, orderbook.Sell, 3))
+	})
+	require.NoError(t, err)
+	c, err := e.BustTrade("ACME", 1, "ops", "fat finger")
+	require.NoError(t, err)
+	trail.Amended(c)
+	require.NoError(t, trail.CancelOrder("alice", 1, func() error { return e.CancelOrder(1) }))
+	require.NoError(t, w.Close())
+	// Orders are forgotten once they leave the book.
+	assert.Empty(t, trail.orders)
+	assert.Empty(t, trail.entering)
+
+	entries := query(t, dir, Filter{OrderId: 1})
+	assert.Equal(t, []string{
+		"order.request", "order.added", "order.accepted",
+		"order.executed",
+		"order.request", "order.rejected",
+		"order.request", "order.deleted", "order.added", "order.accepted",
+		"trade.busted", "trade.amended",
+		"order.request", "order.deleted", "order.accepted",
+	}, messages(entries))
+	assert.Equal(t, "bob", entries[4].Fields["account"])
+	assert.Equal(t, "good_till_cancel", entries[4].Fields["type"])
+	assert.Contains(t, entries[5].Fields["error"], "1")
+	assert.Equal(t, "ops", entries[11].Fields["operator"])
+	assert.Equal(t, "fat finger", entries[11].Fields["reason"])
+
+	var decision struct {
+		Before *OrderState `json:"before"`
+		After  *OrderState `json:"after"`
+	}
+	require.NoError(t, json.Unmarshal(entries[9].Line, &decision))
+	assert.Equal(t, &OrderState{Side: "sell", Price: 100, Open: 6, Filled: 4, Quantity: 10}, decision.Before)
+	assert.Equal(t, &OrderState{Side: "sell", Price: 101, Open: 3, Quantity: 3}, decision.After)
+
+	var accepted struct {
+		Trades []TradeRecord `json:"trades"`
+	}
+	entries = query(t, dir, Filter{OrderId: 2})
+	require.Equal(t, []string{
+		"order.request", "order.added", "order.executed", "order.accepted", "trade.busted", "trade.amended",
+	}, messages(entries))
+	assert.Equal(t, json.Number("100"), entries[2].Fields["price"])
+	require.NoError(t, json.Unmarshal(entries[3].Line, &accepted))
+	assert.Equal(t, []TradeRecord{{
+		MatchId: 1, BuyOrderId: 2, SellOrderId: 1, Price: 100, Quantity: 4, Aggressor: "buy",
+	}}, accepted.Trades)
+
+	// Events carry the account of their order, so the account filter finds
+	// the fills of resting orders too.
+	entries = query(t, dir, Filter{Account: "alice"})
+	assert.Contains(t, messages(entries), "order.executed")
+	for _, e := range entries {
+		assert.Equal(t, "alice", e.Fields["account"])
+	}
+
+	all := query(t, dir, Filter{})
+	assert.Empty(t, query(t, dir, Filter{To: all[0].Time.Add(-time.Nanosecond)}))
+	assert.Len(t, query(t, dir, Filter{From: all[2].Time, To: all[len(all)-1].Time}), len(all)-2)
+}
+
+func TestWriterRotation(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2026, 10, 18, 23, 59, 0, 0, time.UTC)
+	w, err := OpenWriter(dir, Options{MaxSize: 100})
+	require.NoError(t, err)
+	w.now = func() time.Time { return now }
+
+	record := func(msg string) {
+		_, err := w.Write([]byte(`{"time":"` + now.Format(time.RFC3339Nano) + `","msg":"` + msg + `"}` + "\n"))
+		require.NoError(t, err)
+	}
+	// The writer was opened today; the first record moves to the file of
+	// the day of the clock.
+	record("a")
+	record("b")
+	record("c")
+	now = now.Add(2 * time.Minute)
+	record("d")
+	require.NoError(t, w.Close())
+
+	files, err := listFiles(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, f := range files {
+		names = append(names, filepath.Base(f.path))
+	}
+	assert.Subset(t, names, []string{
+		"audit-2026-10-18-0000.jsonl",
+		"audit-2026-10-18-0001.jsonl",
+		"audit-2026-10-19-0000.jsonl",
+	})
+
+	// A torn record is ended on reopening and skipped by queries.
+	f, err := os.OpenFile(filepath.Join(dir, "audit-2026-10-19-0000.jsonl"), os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"time":"2026-10-19T00:0`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	w, err = OpenWriter(dir, Options{})
+	require.NoError(t, err)
+	w.now = func() time.Time { return now }
+	record("e")
+	require.NoError(t, w.Close())
+
+	// Rotated files may be compressed.
+	path := filepath.Join(dir, "audit-2026-10-18-0000.jsonl")
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	gz, err := os.Create(path + ".gz")
+	require.NoError(t, err)
+	zw := gzip.NewWriter(gz)
+	_, err = zw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	require.NoError(t, gz.Close())
+	require.NoError(t, os.Remove(path))
+
+	var msgs []string
+	malformed, err := Query(dir, Filter{}, func(e Entry) error {
+		msgs = append(msgs, e.Message)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, malformed)
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, msgs)
+
+	msgs = nil
+	_, err = Query(dir, Filter{From: now.Add(-time.Minute)}, func(e Entry) error {
+		msgs = append(msgs, e.Message)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"d", "e"}, msgs)
+}
@@ -0,0 +1,202 @@
+package audit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Files are named audit-YYYY-MM-DD-NNNN.jsonl after the UTC day of their
+// records and their order within the day.
+const (
+	filePrefix = "audit-"
+	fileSuffix = ".jsonl"
+	dayLayout  = "2006-01-02"
+)
+
+// Options of a Writer.
+type Options struct {
+	// MaxSize is the size in bytes past which a file is rotated. Zero only
+	// rotates at the end of the day.
+	MaxSize int64
+	// Sync fsyncs every record before Write returns.
+	Sync bool
+}
+
+// Writer appends records to the files of a directory, rotating to a new file
+// at the start of every UTC day and when a file reaches its maximum size.
+// Files are never rewritten or removed. It expects a record per Write, as
+// written by slog handlers.
+type Writer struct {
+	m    sync.Mutex
+	dir  string
+	opts Options
+	now  func() time.Time
+
+	f     *os.File
+	day   string
+	index int
+	size  int64
+}
+
+// OpenWriter opens the directory of an audit trail, creating it if needed,
+// and appends to its latest file if it is of today.
+func OpenWriter(dir string, opts Options) (*Writer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	w := &Writer{dir: dir, opts: opts, now: time.Now}
+	files, err := listFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) > 0 {
+		last := files[len(files)-1]
+		w.day, w.index = last.day, last.index
+		// A compressed file is closed for good.
+		if strings.HasSuffix(last.path, ".gz") {
+			w.index++
+		}
+	}
+	if err := w.open(w.now()); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// open opens the file to write records of t to. It should only be called
+// with w.m held, or before w is shared.
+func (w *Writer) open(t time.Time) error {
+	day := t.UTC().Format(dayLayout)
+	if day != w.day {
+		w.day, w.index = day, 0
+	}
+	path := filepath.Join(w.dir, fileName(w.day, w.index))
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.f, w.size = f, info.Size()
+
+	// A record torn by a crash is ended, so that the next one starts on a
+	// line of its own.
+	if w.size > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, w.size-1); err != nil {
+			f.Close()
+			return err
+		}
+		if last[0] != '\n' {
+			n, err := f.Write([]byte{'\n'})
+			w.size += int64(n)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.m.Lock()
+	defer w.m.Unlock()
+	if w.f == nil {
+		return 0, os.ErrClosed
+	}
+
+	now := w.now()
+	full := w.opts.MaxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.opts.MaxSize
+	if full || now.UTC().Format(dayLayout) != w.day {
+		if err := w.f.Close(); err != nil {
+			return 0, err
+		}
+		w.f = nil
+		if full {
+			w.index++
+		}
+		if err := w.open(now); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.f.Write(p)
+	w.size += int64(n)
+	if err == nil && w.opts.Sync {
+		err = w.f.Sync()
+	}
+	return n, err
+}
+
+// Close closes the current file.
+func (w *Writer) Close() error {
+	w.m.Lock()
+	defer w.m.Unlock()
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	return err
+}
+
+func fileName(day string, index int) string {
+	return fmt.Sprintf("%s%s-%04d%s", filePrefix, day, index, fileSuffix)
+}
+
+type fileInfo struct {
+	path  string
+	day   string
+	index int
+	// start is the beginning of the UTC day of the file.
+	start time.Time
+}
+
+// listFiles returns the audit files of a directory in the order they were
+// written. Files compressed with gzip after rotation, with a .gz suffix
+// added, are listed too.
+func listFiles(dir string) ([]fileInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []fileInfo
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".gz")
+		if entry.IsDir() || !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileSuffix) {
+			continue
+		}
+		stem := strings.TrimSuffix(strings.TrimPrefix(name, filePrefix), fileSuffix)
+		// The day itself holds dashes; the index follows the last one.
+		i := strings.LastIndexByte(stem, '-')
+		if i < 0 {
+			continue
+		}
+		day, index := stem[:i], stem[i+1:]
+		start, err := time.Parse(dayLayout, day)
+		if err != nil {
+			continue
+		}
+		n, err := strconv.Atoi(index)
+		if err != nil {
+			continue
+		}
+		files = append(files, fileInfo{filepath.Join(dir, entry.Name()), day, n, start})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].day != files[j].day {
+			return files[i].day < files[j].day
+		}
+		return files[i].index < files[j].index
+	})
+	return files, nil
+}
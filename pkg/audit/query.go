@@ -0,0 +1,144 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"go-orderbook/pkg/orderbook"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Filter selects records. Zero fields select everything.
+type Filter struct {
+	// OrderId selects the records of an order, including the trade
+	// amendments naming it as the buyer or the seller.
+	OrderId orderbook.OrderId
+	Account string
+	// From and To bound the time of records, inclusively.
+	From time.Time
+	To   time.Time
+}
+
+// Entry is a record read back from a trail.
+type Entry struct {
+	Time    time.Time
+	Message string
+	// Fields holds every field of the record, numbers as json.Number.
+	Fields map[string]any
+	// Line is the record as written.
+	Line []byte
+}
+
+// dayMargin covers records timed just before midnight and written just
+// after, into the file of the next day.
+const dayMargin = time.Minute
+
+// Query reads the records of the trail in dir that match the filter, in the
+// order they were written, and calls fn for each until it returns an error.
+// It returns the number of lines that were not records, such as one torn by
+// a crash.
+func Query(dir string, f Filter, fn func(Entry) error) (malformed int, err error) {
+	files, err := listFiles(dir)
+	if err != nil {
+		return 0, err
+	}
+	for _, file := range files {
+		// Every file holds the records of a single day.
+		if !f.From.IsZero() && file.start.Add(24*time.Hour).Before(f.From) {
+			continue
+		}
+		if !f.To.IsZero() && file.start.Add(-dayMargin).After(f.To) {
+			continue
+		}
+		n, err := queryFile(file.path, f, fn)
+		malformed += n
+		if err != nil {
+			return malformed, err
+		}
+	}
+	return malformed, nil
+}
+
+func queryFile(path string, f Filter, fn func(Entry) error) (malformed int, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	var r io.Reader = bufio.NewReader(file)
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return 0, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		entry, ok := decodeEntry(line)
+		if !ok {
+			malformed++
+			continue
+		}
+		if !f.match(entry) {
+			continue
+		}
+		entry.Line = append([]byte(nil), line...)
+		if err := fn(entry); err != nil {
+			return malformed, err
+		}
+	}
+	return malformed, scanner.Err()
+}
+
+func decodeEntry(line []byte) (Entry, bool) {
+	d := json.NewDecoder(bytes.NewReader(line))
+	d.UseNumber()
+	var fields map[string]any
+	if err := d.Decode(&fields); err != nil {
+		return Entry{}, false
+	}
+	s, _ := fields["time"].(string)
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return Entry{}, false
+	}
+	msg, _ := fields["msg"].(string)
+	return Entry{Time: t, Message: msg, Fields: fields}, true
+}
+
+func (f Filter) match(e Entry) bool {
+	if !f.From.IsZero() && e.Time.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && e.Time.After(f.To) {
+		return false
+	}
+	if f.Account != "" {
+		if account, _ := e.Fields["account"].(string); account != f.Account {
+			return false
+		}
+	}
+	if f.OrderId != 0 {
+		id := strconv.FormatUint(uint64(f.OrderId), 10)
+		for _, key := range []string{"order_id", "buy_order_id", "sell_order_id"} {
+			if n, ok := e.Fields[key].(json.Number); ok && n.String() == id {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
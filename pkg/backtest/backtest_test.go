@@ -0,0 +1,194 @@
+package backtest
+
+import (
+	"bytes"
+	"go-orderbook/pkg/engine"
+	"go-orderbook/pkg/itch"
+	"go-orderbook/pkg/journal"
+	"go-orderbook/pkg/orderbook"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder is a Strategy that keeps what it is called back with and runs
+// the hooks it is given.
+type recorder struct {
+	trades []Trade
+	fills  []Fill
+	depths []Depth
+
+	onDepth func(h *Harness, d Depth)
+	onFill  func(h *Harness, f Fill)
+}
+
+func (r *recorder) OnTrade(h *Harness, t Trade) {
+	r.trades = append(r.trades, t)
+}
+
+func (r *recorder) OnDepth(h *Harness, d Depth) {
+	r.depths = append(r.depths, d)
+	if r.onDepth != nil {
+		r.onDepth(h, d)
+	}
+}
+
+func (r *recorder) OnFill(h *Harness, f Fill) {
+	r.fills = append(r.fills, f)
+	if r.onFill != nil {
+		r.onFill(h, f)
+	}
+}
+
+type sliceSource []Action
+
+func (s *sliceSource) Next() (Action, error) {
+	if len(*s) == 0 {
+		return Action{}, io.EOF
+	}
+	a := (*s)[0]
+	*s = (*s)[1:]
+	return a, nil
+}
+
+func TestITCHReplay(t *testing.T) {
+	var buf bytes.Buffer
+	e := engine.NewEngine()
+	require.NoError(t, e.List("DEMO"))
+	require.NoError(t, e.List("OTHER"))
+	rec := itch.NewRecorder(&buf)
+	require.NoError(t, rec.Start())
+	e.AddListener(rec)
+
+	var modify orderbook.OrderModify
+	_, err := e.AddOrder("DEMO", orderbook.NewOrder(orderbook.GoodTillCancel, 1, orderbook.Buy, 100, 10))
+	require.NoError(t, err)
+	_, err = e.AddOrder("DEMO", orderbook.NewOrder(orderbook.GoodTillCancel, 2, orderbook.Sell, 102, 10))
+	require.NoError(t, err)
+	_, err = e.AddOrder("OTHER", orderbook.NewOrder(orderbook.GoodTillCancel, 3, orderbook.Buy, 99, 5))
+	require.NoError(t, err)
+	_, err = e.AddOrder("DEMO", orderbook.NewOrder(orderbook.GoodTillCancel, 4, orderbook.Sell, 100, 4))
+	require.NoError(t, err)
+	_, err = e.ModifyOrder(modify.New(2, 103, orderbook.Sell, 10))
+	require.NoError(t, err)
+	_, err = e.ModifyOrder(modify.New(1, 100, orderbook.Buy, 3))
+	require.NoError(t, err)
+	_, err = e.AddOrder("DEMO", orderbook.NewOrder(orderbook.FillAndKill, 5, orderbook.Buy, 103, 12))
+	require.NoError(t, err)
+	require.NoError(t, rec.Close())
+
+	strategy := &recorder{}
+	h := NewHarness(strategy, Options{})
+	report, err := h.Run(NewITCHSource(&buf, "DEMO"))
+	require.NoError(t, err)
+
+	assert.Equal(t, 6, report.Actions)
+	assert.Zero(t, report.Rejected)
+	assert.Empty(t, report.Fills)
+	assert.Equal(t, orderbook.Price(103), report.LastPrice)
+
+	require.Len(t, strategy.trades, 2)
+	// Incoming orders are replayed from the executions of resting orders.
+	assert.Equal(t, orderbook.OrderId(1), strategy.trades[0].BuyOrderId)
+	assert.Equal(t, FirstSyntheticOrderId, strategy.trades[0].SellOrderId)
+	assert.Equal(t, orderbook.Sell, strategy.trades[0].Aggressor)
+	assert.Equal(t, orderbook.Price(100), strategy.trades[0].Price)
+	assert.Equal(t, orderbook.Quantity(4), strategy.trades[0].Quantity)
+	assert.Equal(t, FirstSyntheticOrderId+1, strategy.trades[1].BuyOrderId)
+	assert.Equal(t, orderbook.OrderId(2), strategy.trades[1].SellOrderId)
+	assert.Equal(t, orderbook.Buy, strategy.trades[1].Aggressor)
+	assert.Equal(t, orderbook.Price(103), strategy.trades[1].Price)
+	assert.Equal(t, orderbook.Quantity(10), strategy.trades[1].Quantity)
+
+	info, err := e.OrderInfo("DEMO")
+	require.NoError(t, err)
+	depth := h.Depth()
+	assert.Equal(t, info.GetBids(), depth.Bids)
+	assert.Equal(t, info.GetAsks(), depth.Asks)
+}
+
+func TestStrategyFills(t *testing.T) {
+	records := []journal.Record{
+		{Type: journal.RecordList, Symbol: "DEMO"},
+		{Type: journal.RecordAdd, Symbol: "DEMO", OrderType: orderbook.GoodTillCancel, OrderId: 1, Side: orderbook.Sell, Price: 101, Quantity: 5},
+		{Type: journal.RecordAdd, Symbol: "DEMO", OrderType: orderbook.GoodTillCancel, OrderId: 2, Side: orderbook.Buy, Price: 100, Quantity: 5},
+		{Type: journal.RecordAdd, Symbol: "OTHER", OrderType: orderbook.GoodTillCancel, OrderId: 9, Side: orderbook.Sell, Price: 90, Quantity: 5},
+		{Type: journal.RecordAdd, Symbol: "DEMO", OrderType: orderbook.GoodTillCancel, OrderId: 3, Side: orderbook.Buy, Price: 100, Quantity: 5},
+		{Type: journal.RecordAdd, Symbol: "DEMO", OrderType: orderbook.GoodTillCancel, OrderId: 4, Side: orderbook.Sell, Price: 100, Quantity: 7},
+	}
+
+	var ahead []orderbook.Quantity
+	strategy := &recorder{}
+	strategy.onDepth = func(h *Harness, d Depth) {
+		if len(d.Bids) == 1 && len(strategy.depths) == 2 {
+			orderId, err := h.Submit(orderbook.GoodTillCancel, orderbook.Buy, 100, 4)
+			require.NoError(t, err)
+			queued, ok := h.QueuePosition(orderId)
+			require.True(t, ok)
+			ahead = append(ahead, queued)
+			assert.Error(t, h.Cancel(2))
+		}
+	}
+	strategy.onFill = func(h *Harness, f Fill) {
+		if f.Liquidity == Maker {
+			_, err := h.Submit(orderbook.FillAndKill, orderbook.Buy, 101, 1)
+			require.NoError(t, err)
+		}
+	}
+
+	h := NewHarness(strategy, Options{})
+	report, err := h.Run(NewJournalSource(records, "DEMO"))
+	require.NoError(t, err)
+
+	assert.Equal(t, []orderbook.Quantity{5}, ahead)
+	assert.Equal(t, 4, report.Actions)
+	assert.Equal(t, []Fill{
+		{OrderId: FirstOrderId, Side: orderbook.Buy, Price: 100, Quantity: 2, Liquidity: Maker, MatchId: report.Fills[0].MatchId},
+		{OrderId: FirstOrderId + 1, Side: orderbook.Buy, Price: 101, Quantity: 1, Liquidity: Taker, MatchId: report.Fills[1].MatchId},
+	}, report.Fills)
+	assert.Equal(t, []OpenOrder{
+		{OrderId: FirstOrderId, Side: orderbook.Buy, Price: 100, Quantity: 2, Ahead: 0},
+	}, report.OpenOrders)
+	assert.Equal(t, int64(3), report.Position)
+	assert.Equal(t, int64(-301), report.Cash)
+	assert.Equal(t, orderbook.Price(101), report.LastPrice)
+	assert.Equal(t, int64(2), report.PnL)
+	assert.Len(t, strategy.fills, 2)
+}
+
+func TestRejectedActions(t *testing.T) {
+	src := sliceSource{
+		{Type: ActionAdd, OrderType: orderbook.GoodTillCancel, OrderId: 1, Side: orderbook.Buy, Price: 100, Quantity: 10},
+		{Type: ActionReduce, OrderId: 1, Quantity: 4},
+		{Type: ActionReduce, OrderId: 2, Quantity: 4},
+		{Type: ActionCancel, OrderId: 3},
+	}
+	h := NewHarness(&recorder{}, Options{})
+	report, err := h.Run(&src)
+	require.NoError(t, err)
+	assert.Equal(t, 4, report.Actions)
+	assert.Equal(t, 2, report.Rejected)
+	assert.Equal(t, orderbook.Quantity(6), h.Depth().Bids[0].Quantity)
+}
+
+func TestSpeed(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	src := sliceSource{
+		{Type: ActionAdd, Timestamp: start, OrderId: 1, Side: orderbook.Buy, Price: 100, Quantity: 1},
+		{Type: ActionAdd, Timestamp: start.Add(time.Second), OrderId: 2, Side: orderbook.Buy, Price: 100, Quantity: 1},
+		{Type: ActionAdd, Timestamp: start.Add(3 * time.Second), OrderId: 3, Side: orderbook.Buy, Price: 100, Quantity: 1},
+	}
+
+	var slept []time.Duration
+	h := NewHarness(&recorder{}, Options{Speed: 2})
+	h.clock = func() time.Time { return start }
+	h.sleep = func(d time.Duration) { slept = append(slept, d) }
+
+	_, err := h.Run(&src)
+	require.NoError(t, err)
+	assert.Equal(t, []time.Duration{500 * time.Millisecond, 1500 * time.Millisecond}, slept)
+	assert.Equal(t, start.Add(3*time.Second), h.Now())
+}
@@ -0,0 +1,395 @@
+// Package backtest replays recorded order flow through an Orderbook and lets
+// a Strategy trade against it, so that strategies are tested against the
+// real matching logic.
+//
+// The strategy's orders rest in the same book as the recorded ones and trade
+// with them. Recorded orders that the strategy traded with are no longer in
+// the book when the recording refers to them again; those recorded actions
+// are rejected by the book and counted in the Report.
+package backtest
+
+import (
+	"errors"
+	"go-orderbook/pkg/feed"
+	"go-orderbook/pkg/orderbook"
+	"io"
+	"sort"
+	"time"
+)
+
+// FirstOrderId is the id of the first order a strategy submits. Ids count up
+// from here, well clear of the ids found in recordings.
+const FirstOrderId orderbook.OrderId = 1 << 63
+
+type Liquidity int
+
+const (
+	// Maker is the resting side of a trade.
+	Maker Liquidity = iota
+	// Taker is the side of a trade that arrived last.
+	Taker
+)
+
+func (l Liquidity) String() string {
+	if l == Taker {
+		return "Taker"
+	}
+	return "Maker"
+}
+
+// A Trade is a single match in the book, recorded or not. It is priced at
+// the resting order's price.
+type Trade struct {
+	Timestamp   time.Time
+	BuyOrderId  orderbook.OrderId
+	SellOrderId orderbook.OrderId
+	Aggressor   orderbook.Side
+	Price       orderbook.Price
+	Quantity    orderbook.Quantity
+	MatchId     uint64
+}
+
+// A Fill is the strategy's side of a Trade.
+type Fill struct {
+	Timestamp time.Time
+	OrderId   orderbook.OrderId
+	Side      orderbook.Side
+	Price     orderbook.Price
+	Quantity  orderbook.Quantity
+	Liquidity Liquidity
+	MatchId   uint64
+}
+
+// Depth is the aggregated book, best levels first.
+type Depth struct {
+	Bids orderbook.LevelsInfo
+	Asks orderbook.LevelsInfo
+}
+
+// OpenOrder is a resting order of the strategy.
+type OpenOrder struct {
+	OrderId  orderbook.OrderId
+	Side     orderbook.Side
+	Price    orderbook.Price
+	Quantity orderbook.Quantity
+	// Ahead is the quantity queued before the order at its price level.
+	Ahead orderbook.Quantity
+}
+
+// A Strategy is called back by the Harness as the book changes and can trade
+// through it. Callbacks run on the goroutine of Run, one at a time.
+type Strategy interface {
+	// OnTrade is called for every trade in the book.
+	OnTrade(h *Harness, t Trade)
+	// OnDepth is called after every action that changed the book.
+	OnDepth(h *Harness, d Depth)
+	// OnFill is called for every trade of the strategy's own orders, after
+	// OnTrade.
+	OnFill(h *Harness, f Fill)
+}
+
+type Options struct {
+	// Speed scales the recorded time between actions: 1 replays in real
+	// time and 10 ten times faster. Zero replays as fast as possible.
+	Speed float64
+}
+
+// Report sums up a run. Cash and P&L are in price ticks times quantity.
+type Report struct {
+	Fills []Fill
+	// OpenOrders is sorted by order id.
+	OpenOrders []OpenOrder
+	// Position is the net quantity bought, negative when short.
+	Position int64
+	// Cash is what was received for sales less what was paid for purchases.
+	Cash int64
+	// LastPrice is the price of the last trade in the book.
+	LastPrice orderbook.Price
+	// PnL marks the position to LastPrice.
+	PnL int64
+	// Actions counts the recorded actions replayed and Rejected those among
+	// them the book rejected.
+	Actions  int
+	Rejected int
+}
+
+// Harness drives an Orderbook from a Source. It is not safe for concurrent
+// use; strategies call it from their callbacks.
+type Harness struct {
+	book     orderbook.Orderbook
+	replica  *feed.Replica
+	strategy Strategy
+	opts     Options
+
+	// pending holds the events published by the book that have not been
+	// dispatched to the strategy yet.
+	pending     []orderbook.Event
+	dispatching bool
+	// lastAdded is the order most recently added to the book. Matching only
+	// happens right after an order is added, so it is the aggressor of every
+	// match that follows.
+	lastAdded orderbook.OrderId
+	// execution is the first OrderExecuted event of the current match.
+	execution orderbook.Event
+
+	nextOrderId orderbook.OrderId
+	// open holds the strategy's orders that may still rest in the book.
+	open   map[orderbook.OrderId]struct{}
+	report Report
+	now    time.Time
+
+	clock func() time.Time
+	sleep func(time.Duration)
+}
+
+func NewHarness(strategy Strategy, opts Options) *Harness {
+	h := &Harness{
+		book:        orderbook.NewOrderbook(),
+		replica:     feed.NewReplica(),
+		strategy:    strategy,
+		opts:        opts,
+		nextOrderId: FirstOrderId,
+		open:        make(map[orderbook.OrderId]struct{}),
+		clock:       time.Now,
+		sleep:       time.Sleep,
+	}
+	h.book.AddListener(orderbook.ListenerFunc(func(e orderbook.Event) {
+		h.replica.OnEvent(e)
+		h.pending = append(h.pending, e)
+	}))
+	return h
+}
+
+// Run replays src to its end and reports the result. It stops at the first
+// error of the source other than io.EOF.
+func (h *Harness) Run(src Source) (Report, error) {
+	var start, first time.Time
+	for {
+		a, err := src.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return h.Report(), err
+		}
+
+		if !a.Timestamp.IsZero() {
+			h.now = a.Timestamp
+			if h.opts.Speed > 0 {
+				if first.IsZero() {
+					start, first = h.clock(), a.Timestamp
+				}
+				offset := time.Duration(float64(a.Timestamp.Sub(first)) / h.opts.Speed)
+				if wait := start.Add(offset).Sub(h.clock()); wait > 0 {
+					h.sleep(wait)
+				}
+			}
+		}
+
+		h.report.Actions++
+		if _, err := Apply(&h.book, a); err != nil {
+			h.report.Rejected++
+		}
+		h.dispatch()
+	}
+	return h.Report(), h.replica.Err()
+}
+
+// dispatch hands the pending events to the strategy. Events published by the
+// orders the strategy sends from its callbacks are dispatched by the same
+// loop, after the ones already pending.
+func (h *Harness) dispatch() {
+	if h.dispatching {
+		return
+	}
+	h.dispatching = true
+	defer func() { h.dispatching = false }()
+
+	for len(h.pending) > 0 {
+		for i := 0; i < len(h.pending); i++ {
+			h.onEvent(h.pending[i])
+		}
+		h.pending = h.pending[:0]
+		h.strategy.OnDepth(h, h.Depth())
+	}
+}
+
+func (h *Harness) onEvent(e orderbook.Event) {
+	switch e.Type {
+	case orderbook.OrderAdded:
+		h.lastAdded = e.OrderId
+	case orderbook.OrderExecuted:
+		if h.execution.MatchId != e.MatchId {
+			h.execution = e
+			return
+		}
+		h.onMatch(h.execution, e)
+	}
+}
+
+func (h *Harness) onMatch(first, second orderbook.Event) {
+	buy, sell := first, second
+	if buy.Side != orderbook.Buy {
+		buy, sell = sell, buy
+	}
+	t := Trade{
+		Timestamp:   h.now,
+		BuyOrderId:  buy.OrderId,
+		SellOrderId: sell.OrderId,
+		Aggressor:   orderbook.Sell,
+		Price:       buy.Price,
+		Quantity:    buy.Quantity,
+		MatchId:     buy.MatchId,
+	}
+	if buy.OrderId == h.lastAdded {
+		t.Aggressor = orderbook.Buy
+		t.Price = sell.Price
+	}
+	h.report.LastPrice = t.Price
+	h.strategy.OnTrade(h, t)
+
+	for _, ev := range []orderbook.Event{buy, sell} {
+		if !h.own(ev.OrderId) {
+			continue
+		}
+		if _, ok := h.book.Order(ev.OrderId); !ok {
+			delete(h.open, ev.OrderId)
+		}
+		f := Fill{
+			Timestamp: t.Timestamp,
+			OrderId:   ev.OrderId,
+			Side:      ev.Side,
+			Price:     t.Price,
+			Quantity:  t.Quantity,
+			Liquidity: Maker,
+			MatchId:   t.MatchId,
+		}
+		if ev.Side == t.Aggressor {
+			f.Liquidity = Taker
+		}
+		value := int64(f.Price) * int64(f.Quantity)
+		if f.Side == orderbook.Buy {
+			h.report.Position += int64(f.Quantity)
+			h.report.Cash -= value
+		} else {
+			h.report.Position -= int64(f.Quantity)
+			h.report.Cash += value
+		}
+		h.report.Fills = append(h.report.Fills, f)
+		h.strategy.OnFill(h, f)
+	}
+}
+
+var errNotOwn = errors.New("not an order of the strategy")
+
+func (h *Harness) own(orderId orderbook.OrderId) bool {
+	return orderId >= FirstOrderId && orderId < h.nextOrderId
+}
+
+// Now returns the timestamp of the action being replayed.
+func (h *Harness) Now() time.Time {
+	return h.now
+}
+
+// Submit sends an order of the strategy to the book and returns its id. The
+// price of a Market order is ignored.
+func (h *Harness) Submit(
+	orderType orderbook.OrderType,
+	side orderbook.Side,
+	price orderbook.Price,
+	quantity orderbook.Quantity,
+) (orderbook.OrderId, error) {
+	orderId := h.nextOrderId
+	h.nextOrderId++
+
+	order := orderbook.NewOrder(orderType, orderId, side, price, quantity)
+	if orderType == orderbook.Market {
+		order = orderbook.NewMarketOrder(orderId, side, quantity)
+	}
+	_, err := h.book.AddOrder(order)
+	if _, ok := h.book.Order(orderId); ok {
+		h.open[orderId] = struct{}{}
+	}
+	h.dispatch()
+	return orderId, err
+}
+
+// Cancel cancels an order of the strategy.
+func (h *Harness) Cancel(orderId orderbook.OrderId) error {
+	if !h.own(orderId) {
+		return errNotOwn
+	}
+	err := h.book.CancelOrder(orderId)
+	delete(h.open, orderId)
+	h.dispatch()
+	return err
+}
+
+// Modify replaces an order of the strategy, as Orderbook.ModifyOrder.
+func (h *Harness) Modify(
+	orderId orderbook.OrderId,
+	side orderbook.Side,
+	price orderbook.Price,
+	quantity orderbook.Quantity,
+) error {
+	if !h.own(orderId) {
+		return errNotOwn
+	}
+	var modify orderbook.OrderModify
+	_, err := h.book.ModifyOrder(modify.New(orderId, price, side, quantity))
+	h.dispatch()
+	return err
+}
+
+// QueuePosition returns the quantity queued ahead of a resting order at its
+// price level.
+func (h *Harness) QueuePosition(orderId orderbook.OrderId) (orderbook.Quantity, bool) {
+	order, ok := h.book.Order(orderId)
+	if !ok {
+		return 0, false
+	}
+	var ahead orderbook.Quantity
+	for _, queued := range h.replica.Queue(order.Side(), order.Price()) {
+		if queued.OrderId == orderId {
+			return ahead, true
+		}
+		ahead += queued.Quantity
+	}
+	return 0, false
+}
+
+// Depth returns the aggregated book.
+func (h *Harness) Depth() Depth {
+	return Depth{
+		Bids: h.replica.Levels(orderbook.Buy),
+		Asks: h.replica.Levels(orderbook.Sell),
+	}
+}
+
+// Report sums up the run so far.
+func (h *Harness) Report() Report {
+	r := h.report
+	r.Fills = append([]Fill(nil), r.Fills...)
+	r.PnL = r.Cash + r.Position*int64(r.LastPrice)
+
+	r.OpenOrders = make([]OpenOrder, 0, len(h.open))
+	for orderId := range h.open {
+		order, ok := h.book.Order(orderId)
+		if !ok {
+			delete(h.open, orderId)
+			continue
+		}
+		ahead, _ := h.QueuePosition(orderId)
+		r.OpenOrders = append(r.OpenOrders, OpenOrder{
+			OrderId:  orderId,
+			Side:     order.Side(),
+			Price:    order.Price(),
+			Quantity: order.RemainingQuantity(),
+			Ahead:    ahead,
+		})
+	}
+	sort.Slice(r.OpenOrders, func(i, j int) bool {
+		return r.OpenOrders[i].OrderId < r.OpenOrders[j].OrderId
+	})
+	return r
+}
@@ -0,0 +1,288 @@
+package backtest
+
+import (
+	"fmt"
+	"go-orderbook/pkg/engine"
+	"go-orderbook/pkg/itch"
+	"go-orderbook/pkg/journal"
+	"go-orderbook/pkg/orderbook"
+	"io"
+	"time"
+)
+
+type ActionType int
+
+const (
+	// ActionAdd adds an order.
+	ActionAdd ActionType = iota
+	// ActionCancel cancels an order.
+	ActionCancel
+	// ActionModify replaces the side, price and quantity of an order.
+	ActionModify
+	// ActionReduce lowers the open quantity of an order by Quantity, keeping
+	// its time priority.
+	ActionReduce
+)
+
+func (t ActionType) String() string {
+	switch t {
+	case ActionAdd:
+		return "Add"
+	case ActionCancel:
+		return "Cancel"
+	case ActionModify:
+		return "Modify"
+	case ActionReduce:
+		return "Reduce"
+	}
+	return "Unknown"
+}
+
+// An Action is a single recorded command. Fields that do not apply to the
+// action type are left at their zero value.
+type Action struct {
+	Type ActionType
+	// Timestamp is when the action was recorded. Actions without one are
+	// replayed immediately.
+	Timestamp time.Time
+	OrderType orderbook.OrderType
+	OrderId   orderbook.OrderId
+	Side      orderbook.Side
+	Price     orderbook.Price
+	Quantity  orderbook.Quantity
+}
+
+// A Source yields recorded actions in order. Next returns io.EOF after the
+// last one.
+type Source interface {
+	Next() (Action, error)
+}
+
+type itchOrder struct {
+	side   orderbook.Side
+	price  orderbook.Price
+	shares uint32
+}
+
+// FirstSyntheticOrderId is the id of the first order an ITCHSource makes up
+// for an execution. Ids count up from it, below the ids of strategies.
+const FirstSyntheticOrderId orderbook.OrderId = 1 << 62
+
+// ITCHSource reads the order flow of one stock from an ITCH stream.
+//
+// The stream only describes displayed orders: an order that traded on
+// arrival shows as the executions of the resting orders it matched, and
+// then as an add of what is left of it. Every execution is replayed as a
+// FillAndKill order against the resting order, with an id counting up from
+// FirstSyntheticOrderId, so that the book being replayed trades it again.
+// Trades in the stream are skipped.
+type ITCHSource struct {
+	dec     *itch.Decoder
+	stock   itch.Stock
+	orders  map[uint64]*itchOrder
+	nextId  orderbook.OrderId
+	pending []Action
+}
+
+func NewITCHSource(r io.Reader, stock string) *ITCHSource {
+	return &ITCHSource{
+		dec:    itch.NewDecoder(r),
+		stock:  itch.NewStock(stock),
+		orders: make(map[uint64]*itchOrder),
+		nextId: FirstSyntheticOrderId,
+	}
+}
+
+func (s *ITCHSource) Next() (Action, error) {
+	for len(s.pending) == 0 {
+		m, err := s.dec.Next()
+		if err != nil {
+			return Action{}, err
+		}
+		s.decode(m)
+	}
+	a := s.pending[0]
+	s.pending = s.pending[1:]
+	return a, nil
+}
+
+// decode queues the actions of a message. Messages about orders of other
+// stocks are dropped.
+func (s *ITCHSource) decode(m itch.Message) {
+	switch m := m.(type) {
+	case *itch.AddOrder:
+		if m.Stock != s.stock {
+			return
+		}
+		side := orderbook.Buy
+		if m.Side == itch.SideSell {
+			side = orderbook.Sell
+		}
+		s.orders[m.OrderRef] = &itchOrder{side: side, price: orderbook.Price(m.Price), shares: m.Shares}
+		s.pending = append(s.pending, Action{
+			Type:      ActionAdd,
+			Timestamp: timestamp(m.Timestamp),
+			OrderType: orderbook.GoodTillCancel,
+			OrderId:   orderbook.OrderId(m.OrderRef),
+			Side:      side,
+			Price:     orderbook.Price(m.Price),
+			Quantity:  orderbook.Quantity(m.Shares),
+		})
+	case *itch.OrderExecuted:
+		order, ok := s.orders[m.OrderRef]
+		if !ok {
+			return
+		}
+		side, price := orderbook.Buy, order.price
+		if order.side == orderbook.Buy {
+			side = orderbook.Sell
+		}
+		s.reduce(m.OrderRef, m.ExecutedShares)
+		s.pending = append(s.pending, Action{
+			Type:      ActionAdd,
+			Timestamp: timestamp(m.Timestamp),
+			OrderType: orderbook.FillAndKill,
+			OrderId:   s.nextId,
+			Side:      side,
+			Price:     price,
+			Quantity:  orderbook.Quantity(m.ExecutedShares),
+		})
+		s.nextId++
+	case *itch.OrderCancel:
+		if s.reduce(m.OrderRef, m.CanceledShares) {
+			s.pending = append(s.pending, Action{
+				Type:      ActionReduce,
+				Timestamp: timestamp(m.Timestamp),
+				OrderId:   orderbook.OrderId(m.OrderRef),
+				Quantity:  orderbook.Quantity(m.CanceledShares),
+			})
+		}
+	case *itch.OrderDelete:
+		if _, ok := s.orders[m.OrderRef]; ok {
+			delete(s.orders, m.OrderRef)
+			s.pending = append(s.pending, Action{
+				Type:      ActionCancel,
+				Timestamp: timestamp(m.Timestamp),
+				OrderId:   orderbook.OrderId(m.OrderRef),
+			})
+		}
+	case *itch.OrderReplace:
+		order, ok := s.orders[m.OriginalOrderRef]
+		if !ok {
+			return
+		}
+		delete(s.orders, m.OriginalOrderRef)
+		s.orders[m.NewOrderRef] = &itchOrder{side: order.side, price: orderbook.Price(m.Price), shares: m.Shares}
+		modify := Action{
+			Type:      ActionModify,
+			Timestamp: timestamp(m.Timestamp),
+			OrderId:   orderbook.OrderId(m.NewOrderRef),
+			Side:      order.side,
+			Price:     orderbook.Price(m.Price),
+			Quantity:  orderbook.Quantity(m.Shares),
+		}
+		if m.NewOrderRef != m.OriginalOrderRef {
+			// A replacement under a new reference is a cancel followed by
+			// an add.
+			s.pending = append(s.pending, Action{
+				Type:      ActionCancel,
+				Timestamp: modify.Timestamp,
+				OrderId:   orderbook.OrderId(m.OriginalOrderRef),
+			})
+			modify.Type = ActionAdd
+			modify.OrderType = orderbook.GoodTillCancel
+		}
+		s.pending = append(s.pending, modify)
+	}
+}
+
+// reduce lowers the open shares of a tracked order and reports whether the
+// order is one of the stock.
+func (s *ITCHSource) reduce(ref uint64, shares uint32) bool {
+	order, ok := s.orders[ref]
+	if !ok {
+		return false
+	}
+	if shares >= order.shares {
+		delete(s.orders, ref)
+	} else {
+		order.shares -= shares
+	}
+	return true
+}
+
+func timestamp(nanos uint64) time.Time {
+	return time.Unix(0, int64(nanos))
+}
+
+// JournalSource replays the commands of one symbol from journal records.
+// Journal records carry no timestamps, so they are always replayed as fast
+// as possible.
+type JournalSource struct {
+	records []journal.Record
+	symbol  engine.Symbol
+}
+
+func NewJournalSource(records []journal.Record, symbol engine.Symbol) *JournalSource {
+	return &JournalSource{records: records, symbol: symbol}
+}
+
+func (s *JournalSource) Next() (Action, error) {
+	for len(s.records) > 0 {
+		record := s.records[0]
+		s.records = s.records[1:]
+		if record.Symbol != s.symbol {
+			continue
+		}
+
+		a := Action{
+			OrderType: record.OrderType,
+			OrderId:   record.OrderId,
+			Side:      record.Side,
+			Price:     record.Price,
+			Quantity:  record.Quantity,
+		}
+		switch record.Type {
+		case journal.RecordAdd:
+			a.Type = ActionAdd
+		case journal.RecordCancel:
+			a.Type = ActionCancel
+		case journal.RecordModify:
+			a.Type = ActionModify
+		default:
+			continue
+		}
+		return a, nil
+	}
+	return Action{}, io.EOF
+}
+
+// Apply sends an action to a book. A reduce by at least the open quantity of
+// the order cancels it.
+func Apply(book *orderbook.Orderbook, a Action) (orderbook.Trades, error) {
+	var modify orderbook.OrderModify
+	switch a.Type {
+	case ActionAdd:
+		order := orderbook.NewOrder(a.OrderType, a.OrderId, a.Side, a.Price, a.Quantity)
+		return book.AddOrder(order)
+	case ActionCancel:
+		return nil, book.CancelOrder(a.OrderId)
+	case ActionModify:
+		return book.ModifyOrder(modify.New(a.OrderId, a.Price, a.Side, a.Quantity))
+	case ActionReduce:
+		order, ok := book.Order(a.OrderId)
+		if !ok {
+			return nil, fmt.Errorf("Order %d does not exist", a.OrderId)
+		}
+		if a.Quantity >= order.RemainingQuantity() {
+			return nil, book.CancelOrder(a.OrderId)
+		}
+		return book.ModifyOrder(modify.New(
+			a.OrderId,
+			order.Price(),
+			order.Side(),
+			order.RemainingQuantity()-a.Quantity,
+		))
+	}
+	return nil, fmt.Errorf("unknown action type %d", a.Type)
+}
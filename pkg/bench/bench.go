@@ -0,0 +1,239 @@
+// Package bench replays order flow against an engine at a target rate and
+// records the latency of every action in HDR histograms.
+//
+// The flow is loaded into memory before the run, so that reading and
+// generating it is not measured. When paced, the latency of an action is
+// measured from the time it was due to be sent rather than from when it
+// was sent, so that a stall of the engine is charged to every action that
+// queued behind it instead of being hidden by the pacing.
+package bench
+
+import (
+	"errors"
+	"fmt"
+	"go-orderbook/pkg/backtest"
+	"go-orderbook/pkg/engine"
+	"go-orderbook/pkg/hdr"
+	"go-orderbook/pkg/orderbook"
+	"go-orderbook/pkg/sim"
+	"io"
+	"runtime"
+	"text/tabwriter"
+	"time"
+)
+
+// Latencies are recorded from a nanosecond to a minute, to three
+// significant digits.
+const (
+	lowestLatency  = 1
+	highestLatency = int64(time.Minute)
+	latencyDigits  = 3
+)
+
+// spinThreshold is how long before an action is due the runner stops
+// sleeping and spins, as sleeps overshoot by more than the time between
+// actions at high rates.
+const spinThreshold = 200 * time.Microsecond
+
+// Load reads every action of a source into memory.
+func Load(src backtest.Source) ([]backtest.Action, error) {
+	var actions []backtest.Action
+	for {
+		a, err := src.Next()
+		if errors.Is(err, io.EOF) {
+			return actions, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, a)
+	}
+}
+
+// Generate runs a simulation for d of simulated time against a scratch book
+// and returns the actions its agents sent. Replayed against an empty book,
+// they reproduce the same book, so they can be replayed against an engine
+// without the simulator in the loop.
+func Generate(cfg sim.Config, d time.Duration) []backtest.Action {
+	book := orderbook.NewOrderbook()
+	var actions []backtest.Action
+	sim.New(&book, cfg).Run(d, func(a backtest.Action) {
+		actions = append(actions, a)
+	})
+	return actions
+}
+
+// Options of a run.
+type Options struct {
+	// Rate is the number of actions to send per second. Zero sends them
+	// back to back.
+	Rate float64
+}
+
+// Report sums up a run.
+type Report struct {
+	Actions  int
+	Rejected int
+	Elapsed  time.Duration
+	// Mallocs and Bytes are the heap allocations made during the run.
+	Mallocs uint64
+	Bytes   uint64
+	// Latency holds the latency of every action in nanoseconds, and
+	// Operations that of the actions of each type.
+	Latency    *hdr.Histogram
+	Operations map[backtest.ActionType]*hdr.Histogram
+}
+
+// Throughput returns the actions sent per second.
+func (r Report) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Actions) / r.Elapsed.Seconds()
+}
+
+// AllocsPerAction returns the heap allocations per action.
+func (r Report) AllocsPerAction() float64 {
+	if r.Actions == 0 {
+		return 0
+	}
+	return float64(r.Mallocs) / float64(r.Actions)
+}
+
+// BytesPerAction returns the bytes allocated per action.
+func (r Report) BytesPerAction() float64 {
+	if r.Actions == 0 {
+		return 0
+	}
+	return float64(r.Bytes) / float64(r.Actions)
+}
+
+// WriteTo writes the report as a table of the latency percentiles of each
+// action type.
+func (r Report) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	fmt.Fprintf(cw, "%d actions in %v, %.0f per second, %d rejected\n",
+		r.Actions, r.Elapsed.Round(time.Millisecond), r.Throughput(), r.Rejected)
+	fmt.Fprintf(cw, "%.2f allocations and %.0f bytes per action\n\n",
+		r.AllocsPerAction(), r.BytesPerAction())
+
+	tw := tabwriter.NewWriter(cw, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "action\tcount\tp50\tp99\tp99.9\tmax\t")
+	row := func(name string, h *hdr.Histogram) {
+		fmt.Fprintf(tw, "%s\t%d\t%v\t%v\t%v\t%v\t\n", name, h.Count(),
+			time.Duration(h.ValueAtPercentile(50)),
+			time.Duration(h.ValueAtPercentile(99)),
+			time.Duration(h.ValueAtPercentile(99.9)),
+			time.Duration(h.Max()))
+	}
+	for _, t := range []backtest.ActionType{
+		backtest.ActionAdd, backtest.ActionCancel, backtest.ActionModify, backtest.ActionReduce,
+	} {
+		if h, ok := r.Operations[t]; ok && h.Count() > 0 {
+			row(t.String(), h)
+		}
+	}
+	row("All", r.Latency)
+	err := tw.Flush()
+	return cw.n, err
+}
+
+// Run sends actions to the book of symbol in e and measures them. Actions
+// the engine rejects are counted and measured like the others.
+func Run(e *engine.Engine, symbol engine.Symbol, actions []backtest.Action, opts Options) (Report, error) {
+	book, ok := e.Book(symbol)
+	if !ok {
+		return Report{}, fmt.Errorf("%w: %s", engine.ErrUnknownSymbol, symbol)
+	}
+	r := Report{Operations: make(map[backtest.ActionType]*hdr.Histogram)}
+	r.Latency, _ = hdr.New(lowestLatency, highestLatency, latencyDigits)
+	for _, a := range actions {
+		if _, ok := r.Operations[a.Type]; !ok {
+			r.Operations[a.Type], _ = hdr.New(lowestLatency, highestLatency, latencyDigits)
+		}
+	}
+
+	var interval time.Duration
+	if opts.Rate > 0 {
+		interval = time.Duration(float64(time.Second) / opts.Rate)
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for i, a := range actions {
+		due := time.Now()
+		if interval > 0 {
+			due = start.Add(time.Duration(i) * interval)
+			wait(due)
+		}
+		if err := apply(e, book, symbol, a); err != nil {
+			r.Rejected++
+		}
+		latency := int64(time.Since(due))
+		r.Latency.Record(latency)
+		r.Operations[a.Type].Record(latency)
+	}
+	r.Elapsed = time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	r.Actions = len(actions)
+	r.Mallocs = after.Mallocs - before.Mallocs
+	r.Bytes = after.TotalAlloc - before.TotalAlloc
+	return r, nil
+}
+
+// wait returns once due has passed, sleeping while it is far enough away
+// and spinning for the rest.
+func wait(due time.Time) {
+	if d := time.Until(due); d > spinThreshold {
+		time.Sleep(d - spinThreshold)
+	}
+	for time.Now().Before(due) {
+	}
+}
+
+// apply sends an action to the engine. A reduce by at least the open
+// quantity of the order cancels it, as in backtest.Apply.
+func apply(e *engine.Engine, book *orderbook.Orderbook, symbol engine.Symbol, a backtest.Action) error {
+	var modify orderbook.OrderModify
+	switch a.Type {
+	case backtest.ActionAdd:
+		order := orderbook.NewOrder(a.OrderType, a.OrderId, a.Side, a.Price, a.Quantity)
+		_, err := e.AddOrder(symbol, order)
+		return err
+	case backtest.ActionCancel:
+		return e.CancelOrder(a.OrderId)
+	case backtest.ActionModify:
+		_, err := e.ModifyOrder(modify.New(a.OrderId, a.Price, a.Side, a.Quantity))
+		return err
+	case backtest.ActionReduce:
+		order, ok := book.Order(a.OrderId)
+		if !ok {
+			return fmt.Errorf("%w: %d", engine.ErrUnknownOrder, a.OrderId)
+		}
+		if a.Quantity >= order.RemainingQuantity() {
+			return e.CancelOrder(a.OrderId)
+		}
+		_, err := e.ModifyOrder(modify.New(
+			a.OrderId,
+			order.Price(),
+			order.Side(),
+			order.RemainingQuantity()-a.Quantity,
+		))
+		return err
+	}
+	return fmt.Errorf("unknown action type %d", a.Type)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,88 @@
+package bench
+
+import (
+	"bytes"
+	"go-orderbook/pkg/backtest"
+	"go-orderbook/pkg/engine"
+	"go-orderbook/pkg/orderbook"
+	"go-orderbook/pkg/sim"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newEngine(t testing.TB) *engine.Engine {
+	e := engine.NewEngine()
+	require.NoError(t, e.List("ACME"))
+	return e
+}
+
+func TestRun(t *testing.T) {
+	actions := Generate(sim.DefaultConfig(), time.Minute)
+	require.NotEmpty(t, actions)
+
+	// The engine ends up with the book the flow was generated on.
+	scratch := orderbook.NewOrderbook()
+	rejected := 0
+	for _, a := range actions {
+		if _, err := backtest.Apply(&scratch, a); err != nil {
+			rejected++
+		}
+	}
+
+	e := newEngine(t)
+	r, err := Run(e, "ACME", actions, Options{})
+	require.NoError(t, err)
+	assert.Equal(t, len(actions), r.Actions)
+	assert.Equal(t, rejected, r.Rejected)
+	assert.Equal(t, int64(len(actions)), r.Latency.Count())
+	var count int64
+	for _, h := range r.Operations {
+		count += h.Count()
+	}
+	assert.Equal(t, int64(len(actions)), count)
+	assert.Positive(t, r.Throughput())
+	info, _ := e.OrderInfo("ACME")
+	assert.Equal(t, scratch.OrderInfo(), info)
+
+	var buf bytes.Buffer
+	_, err = r.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), "p99.9")
+	assert.Contains(t, buf.String(), "Add")
+	assert.Contains(t, buf.String(), "Cancel")
+
+	_, err = Run(e, "NOPE", actions, Options{})
+	assert.ErrorIs(t, err, engine.ErrUnknownSymbol)
+}
+
+func TestPacing(t *testing.T) {
+	actions := Generate(sim.DefaultConfig(), time.Minute)[:50]
+	r, err := Run(newEngine(t), "ACME", actions, Options{Rate: 1000})
+	require.NoError(t, err)
+	// The last action is due 49 intervals after the first.
+	assert.GreaterOrEqual(t, r.Elapsed, 49*time.Millisecond)
+	assert.InDelta(t, 1000, r.Throughput(), 100)
+}
+
+// BenchmarkReplay replays simulated flow with unique order ids against an
+// engine, starting a fresh engine whenever the flow runs out.
+func BenchmarkReplay(b *testing.B) {
+	actions := Generate(sim.DefaultConfig(), time.Hour)
+	e := newEngine(b)
+	book, _ := e.Book("ACME")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		j := i % len(actions)
+		if j == 0 && i > 0 {
+			b.StopTimer()
+			e = newEngine(b)
+			book, _ = e.Book("ACME")
+			b.StartTimer()
+		}
+		apply(e, book, "ACME", actions[j])
+	}
+}
@@ -0,0 +1,323 @@
+// Package clearing nets the trades of an engine per account and symbol over
+// a settlement cycle and turns them into settlement obligations.
+//
+// Trades are priced at the resting order. Shares are netted into a single
+// delivery or receipt and cash, in price ticks times shares, into a single
+// payment or collection. Fees are carried separately, in engine.FeeScale
+// units.
+//
+// Busts and price corrections published by the engine unwind a trade. A
+// trade of the current cycle drops out of its netting, or is netted again at
+// its new price; one of a closed cycle is reversed, or its price difference
+// settled, in the current cycle. Trades older than engine.TradeRetention can
+// no longer be amended and are forgotten.
+package clearing
+
+import (
+	"go-orderbook/pkg/engine"
+	"go-orderbook/pkg/orderbook"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Obligation is what an account has to settle in one symbol at the end of
+// a cycle. At most one of Deliver and Receive, and one of Pay and Collect,
+// is non-zero.
+type Obligation struct {
+	Account string        `json:"account"`
+	Symbol  engine.Symbol `json:"symbol"`
+	// Trades, Bought and Sold count the fills of the account and their
+	// shares. A bust of a trade of an earlier cycle counts negatively.
+	Trades int   `json:"trades"`
+	Bought int64 `json:"bought"`
+	Sold   int64 `json:"sold"`
+	// Deliver and Receive are the net shares to hand over or take up.
+	Deliver uint64 `json:"deliver"`
+	Receive uint64 `json:"receive"`
+	// Pay and Collect are the net cash to hand over or take up.
+	Pay     int64 `json:"pay"`
+	Collect int64 `json:"collect"`
+	Fees    int64 `json:"fees"`
+}
+
+// Settlement holds the obligations of a closed cycle, sorted by account and
+// symbol.
+type Settlement struct {
+	Cycle       uint64       `json:"cycle"`
+	Closed      time.Time    `json:"closed"`
+	Obligations []Obligation `json:"obligations"`
+}
+
+// position is the running net of an account in a symbol.
+type position struct {
+	trades   int
+	bought   int64
+	sold     int64
+	quantity int64
+	cash     int64
+	fees     int64
+}
+
+type key struct {
+	account string
+	symbol  engine.Symbol
+}
+
+// fill is one side of a cleared trade.
+type fill struct {
+	orderId  orderbook.OrderId
+	account  string
+	side     orderbook.Side
+	quantity orderbook.Quantity
+	fee      int64
+}
+
+type trade struct {
+	key    tradeKey
+	time   time.Time
+	price  orderbook.Price
+	fills  [2]fill
+	busted bool
+}
+
+type tradeKey struct {
+	symbol  engine.Symbol
+	matchId uint64
+}
+
+// House clears the trades of an engine. Trades of orders without an account
+// are cleared for the empty account.
+type House struct {
+	now func() time.Time
+
+	// m guards the fields below, which the engine listener books trades to.
+	m         sync.Mutex
+	accounts  *engine.OrderAccounts
+	cycle     uint64
+	positions map[key]*position
+	// trades holds the trades that can still be amended, and expiring the
+	// same in match order.
+	trades    map[tradeKey]*trade
+	expiring  []*trade
+	execution map[engine.Symbol]orderbook.Event
+}
+
+// houseListener follows the engine for a House.
+type houseListener struct {
+	h *House
+}
+
+func (hl houseListener) OnEvent(symbol engine.Symbol, ev orderbook.Event) {
+	hl.h.onEvent(symbol, ev)
+}
+
+func (hl houseListener) OnCommandEnd(symbol engine.Symbol) {
+	hl.h.m.Lock()
+	defer hl.h.m.Unlock()
+	hl.h.accounts.OnCommandEnd(symbol)
+}
+
+// NewHouse creates a clearing house for an engine. Trades matched before it
+// was created are not cleared.
+func NewHouse(e *engine.Engine) *House {
+	h := &House{
+		now:       time.Now,
+		accounts:  engine.NewOrderAccounts(),
+		cycle:     1,
+		positions: make(map[key]*position),
+		trades:    make(map[tradeKey]*trade),
+		execution: make(map[engine.Symbol]orderbook.Event),
+	}
+	e.AddListener(houseListener{h: h})
+	return h
+}
+
+// Assign clears the trades of an order for account. It should be called
+// before the order enters the book, and holds until the order left it.
+func (h *House) Assign(orderId orderbook.OrderId, account string) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	h.accounts.Assign(orderId, account)
+}
+
+// Unassign drops the account of an order the engine refused.
+func (h *House) Unassign(orderId orderbook.OrderId) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	h.accounts.Unassign(orderId)
+}
+
+func (h *House) onEvent(symbol engine.Symbol, ev orderbook.Event) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	h.accounts.OnEvent(symbol, ev)
+
+	switch ev.Type {
+	case orderbook.OrderExecuted:
+		h.execute(symbol, ev)
+	case orderbook.TradeBusted:
+		// Both sides of a trade are busted together.
+		if t, ok := h.trades[tradeKey{symbol, ev.MatchId}]; ok && !t.busted {
+			t.busted = true
+			h.book(symbol, t, -1)
+		}
+	case orderbook.TradeCorrected:
+		t, ok := h.trades[tradeKey{symbol, ev.MatchId}]
+		if !ok || t.busted {
+			return
+		}
+		if t.price != ev.Price {
+			h.book(symbol, t, -1)
+			t.price = ev.Price
+			h.book(symbol, t, 1)
+		}
+		// The change to the fee is per side.
+		for i := range t.fills {
+			if f := &t.fills[i]; f.orderId == ev.OrderId {
+				f.fee += ev.Fee
+				h.position(f.account, symbol).fees += ev.Fee
+			}
+		}
+	}
+}
+
+// execute pairs the OrderExecuted events of a match and nets the trade. It
+// should only be called with h.m held.
+func (h *House) execute(symbol engine.Symbol, ev orderbook.Event) {
+	first, ok := h.execution[symbol]
+	if !ok || first.MatchId != ev.MatchId {
+		h.execution[symbol] = ev
+		return
+	}
+	delete(h.execution, symbol)
+
+	resting := first
+	if first.Aggressor {
+		resting = ev
+	}
+	now := h.now()
+	h.expire(now)
+	t := &trade{key: tradeKey{symbol, ev.MatchId}, time: now, price: resting.Price}
+	for i, e := range [2]orderbook.Event{first, ev} {
+		account, _ := h.accounts.Account(e.OrderId)
+		t.fills[i] = fill{
+			orderId:  e.OrderId,
+			account:  account,
+			side:     e.Side,
+			quantity: e.Quantity,
+			fee:      e.Fee,
+		}
+	}
+	h.trades[t.key] = t
+	h.expiring = append(h.expiring, t)
+	h.book(symbol, t, 1)
+}
+
+// expire forgets the trades matched engine.TradeRetention or longer before
+// now. It should only be called with h.m held.
+func (h *House) expire(now time.Time) {
+	n := 0
+	for _, t := range h.expiring {
+		if now.Sub(t.time) < engine.TradeRetention {
+			break
+		}
+		delete(h.trades, t.key)
+		n++
+	}
+	h.expiring = h.expiring[n:]
+}
+
+// book nets a trade into the current cycle, or unwinds it with a sign of
+// -1. It should only be called with h.m held.
+func (h *House) book(symbol engine.Symbol, t *trade, sign int64) {
+	for _, f := range t.fills {
+		p := h.position(f.account, symbol)
+
+		quantity := sign * int64(f.quantity)
+		notional := quantity * int64(t.price)
+		p.trades += int(sign)
+		p.fees += sign * f.fee
+		if f.side == orderbook.Buy {
+			p.bought += quantity
+			p.quantity += quantity
+			p.cash -= notional
+		} else {
+			p.sold += quantity
+			p.quantity -= quantity
+			p.cash += notional
+		}
+	}
+}
+
+// position returns the position of an account in the current cycle. It
+// should only be called with h.m held.
+func (h *House) position(account string, symbol engine.Symbol) *position {
+	k := key{account, symbol}
+	p, ok := h.positions[k]
+	if !ok {
+		p = &position{}
+		h.positions[k] = p
+	}
+	return p
+}
+
+// Pending returns the obligations of the current cycle so far.
+func (h *House) Pending() []Obligation {
+	h.m.Lock()
+	defer h.m.Unlock()
+	return h.obligations()
+}
+
+// Close ends the current cycle and returns its settlement. Positions that
+// netted to nothing are left out.
+func (h *House) Close() Settlement {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	s := Settlement{
+		Cycle:       h.cycle,
+		Closed:      h.now().UTC(),
+		Obligations: h.obligations(),
+	}
+	h.cycle++
+	h.positions = make(map[key]*position)
+	return s
+}
+
+// obligations returns the obligations of the current cycle. It should only
+// be called with h.m held.
+func (h *House) obligations() []Obligation {
+	obligations := make([]Obligation, 0, len(h.positions))
+	for k, p := range h.positions {
+		if p.trades == 0 && p.quantity == 0 && p.cash == 0 && p.fees == 0 {
+			continue
+		}
+		o := Obligation{
+			Account: k.account,
+			Symbol:  k.symbol,
+			Trades:  p.trades,
+			Bought:  p.bought,
+			Sold:    p.sold,
+			Fees:    p.fees,
+		}
+		if p.quantity > 0 {
+			o.Receive = uint64(p.quantity)
+		} else {
+			o.Deliver = uint64(-p.quantity)
+		}
+		if p.cash > 0 {
+			o.Collect = p.cash
+		} else {
+			o.Pay = -p.cash
+		}
+		obligations = append(obligations, o)
+	}
+	sort.Slice(obligations, func(i, j int) bool {
+		if obligations[i].Account != obligations[j].Account {
+			return obligations[i].Account < obligations[j].Account
+		}
+		return obligations[i].Symbol < obligations[j].Symbol
+	})
+	return obligations
+}
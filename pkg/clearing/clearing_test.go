@@ -0,0 +1,165 @@
+package clearing
+
+import (
+	"bytes"
+	"encoding/json"
+	"go-orderbook/pkg/engine"
+	"go-orderbook/pkg/orderbook"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// takerFee charges the aggressor of every match one fee unit per share,
+// whatever the price.
+type takerFee struct{}
+
+func (takerFee) Charge(_ engine.Symbol, ev orderbook.Event) int64 {
+	if !ev.Aggressor {
+		return 0
+	}
+	switch ev.Type {
+	case orderbook.OrderExecuted:
+		return int64(ev.Quantity)
+	case orderbook.TradeBusted:
+		return -int64(ev.Quantity)
+	}
+	return 0
+}
+
+func newHouse(t *testing.T) (*engine.Engine, *House) {
+	t.Helper()
+	e := engine.NewEngine()
+	require.NoError(t, e.List("DEMO"))
+	e.UseFees(takerFee{})
+	h := NewHouse(e)
+	h.now = func() time.Time { return time.Date(2024, 3, 1, 16, 0, 0, 0, time.UTC) }
+	return e, h
+}
+
+func add(t *testing.T, e *engine.Engine, h *House, account string, orderId orderbook.OrderId, side orderbook.Side, price orderbook.Price, quantity orderbook.Quantity) {
+	t.Helper()
+	h.Assign(orderId, account)
+	_, err := e.AddOrder("DEMO", orderbook.NewOrder(orderbook.GoodTillCancel, orderId, side, price, quantity))
+	require.NoError(t, err)
+}
+
+func TestNetting(t *testing.T) {
+	e, h := newHouse(t)
+
+	add(t, e, h, "alice", 1, orderbook.Sell, 100, 10)
+	add(t, e, h, "bob", 2, orderbook.Buy, 101, 4)
+	add(t, e, h, "carol", 3, orderbook.Buy, 100, 6)
+	add(t, e, h, "alice", 4, orderbook.Buy, 98, 3)
+	add(t, e, h, "bob", 5, orderbook.Sell, 97, 3)
+
+	assert.Equal(t, []Obligation{
+		{Account: "alice", Symbol: "DEMO", Trades: 3, Bought: 3, Sold: 10, Deliver: 7, Collect: 706},
+		{Account: "bob", Symbol: "DEMO", Trades: 2, Bought: 4, Sold: 3, Receive: 1, Pay: 106, Fees: 7},
+		{Account: "carol", Symbol: "DEMO", Trades: 1, Bought: 6, Receive: 6, Pay: 600, Fees: 6},
+	}, h.Pending())
+
+	// A bust in the cycle drops the trade from the netting.
+	_, err := e.BustTrade("DEMO", 2, "ops", "")
+	require.NoError(t, err)
+	s := h.Close()
+	assert.Equal(t, uint64(1), s.Cycle)
+	assert.Equal(t, []Obligation{
+		{Account: "alice", Symbol: "DEMO", Trades: 2, Bought: 3, Sold: 4, Deliver: 1, Collect: 106},
+		{Account: "bob", Symbol: "DEMO", Trades: 2, Bought: 4, Sold: 3, Receive: 1, Pay: 106, Fees: 7},
+	}, s.Obligations)
+	assert.Empty(t, h.Pending())
+
+	// A bust of a settled trade is reversed in the next cycle.
+	_, err = e.BustTrade("DEMO", 1, "ops", "")
+	require.NoError(t, err)
+	s = h.Close()
+	assert.Equal(t, uint64(2), s.Cycle)
+	assert.Equal(t, []Obligation{
+		{Account: "alice", Symbol: "DEMO", Trades: -1, Sold: -4, Receive: 4, Pay: 400},
+		{Account: "bob", Symbol: "DEMO", Trades: -1, Bought: -4, Deliver: 4, Collect: 400, Fees: -4},
+	}, s.Obligations)
+}
+
+func TestCorrection(t *testing.T) {
+	e, h := newHouse(t)
+	add(t, e, h, "alice", 1, orderbook.Sell, 100, 10)
+	add(t, e, h, "bob", 2, orderbook.Buy, 100, 4)
+	add(t, e, h, "bob", 3, orderbook.Buy, 100, 2)
+
+	// A correction in the cycle nets the trade at its new price.
+	_, err := e.CorrectTrade("DEMO", 1, 90, "ops", "")
+	require.NoError(t, err)
+	assert.Equal(t, []Obligation{
+		{Account: "alice", Symbol: "DEMO", Trades: 2, Sold: 6, Deliver: 6, Collect: 560},
+		{Account: "bob", Symbol: "DEMO", Trades: 2, Bought: 6, Receive: 6, Pay: 560, Fees: 6},
+	}, h.Close().Obligations)
+
+	// One of a closed cycle settles the difference.
+	_, err = e.CorrectTrade("DEMO", 2, 105, "ops", "")
+	require.NoError(t, err)
+	assert.Equal(t, []Obligation{
+		{Account: "alice", Symbol: "DEMO", Collect: 10},
+		{Account: "bob", Symbol: "DEMO", Pay: 10},
+	}, h.Close().Obligations)
+}
+
+func TestExport(t *testing.T) {
+	e, h := newHouse(t)
+	add(t, e, h, "alice", 1, orderbook.Sell, 100, 10)
+	add(t, e, h, "bob", 2, orderbook.Buy, 100, 4)
+	s := h.Close()
+
+	var buf bytes.Buffer
+	require.NoError(t, s.WriteCSV(&buf))
+	assert.Equal(t, `cycle,account,symbol,trades,bought,sold,deliver,receive,pay,collect,fees
+1,alice,DEMO,1,0,4,4,0,0,400,0
+1,bob,DEMO,1,4,0,0,4,400,0,4
+`, buf.String())
+
+	buf.Reset()
+	require.NoError(t, s.WriteJSON(&buf))
+	var decoded Settlement
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	assert.Equal(t, s, decoded)
+}
+
+func TestReusedOrderId(t *testing.T) {
+	e, h := newHouse(t)
+	add(t, e, h, "alice", 1, orderbook.Sell, 100, 5)
+	add(t, e, h, "bob", 2, orderbook.Buy, 100, 5)
+
+	// Once an order left the book its id is no longer cleared for its
+	// account, even when reused by an order without one.
+	_, err := e.AddOrder("DEMO", orderbook.NewOrder(orderbook.GoodTillCancel, 1, orderbook.Sell, 100, 3))
+	require.NoError(t, err)
+	add(t, e, h, "carol", 3, orderbook.Buy, 100, 3)
+	assert.Equal(t, []Obligation{
+		{Account: "", Symbol: "DEMO", Trades: 1, Sold: 3, Deliver: 3, Collect: 300},
+		{Account: "alice", Symbol: "DEMO", Trades: 1, Sold: 5, Deliver: 5, Collect: 500},
+		{Account: "bob", Symbol: "DEMO", Trades: 1, Bought: 5, Receive: 5, Pay: 500, Fees: 5},
+		{Account: "carol", Symbol: "DEMO", Trades: 1, Bought: 3, Receive: 3, Pay: 300, Fees: 3},
+	}, h.Pending())
+}
+
+func TestRetention(t *testing.T) {
+	e, h := newHouse(t)
+	now := time.Date(2024, 3, 1, 16, 0, 0, 0, time.UTC)
+	h.now = func() time.Time { return now }
+	add(t, e, h, "alice", 1, orderbook.Sell, 100, 10)
+	add(t, e, h, "bob", 2, orderbook.Buy, 100, 4)
+	h.Close()
+	now = now.Add(engine.TradeRetention)
+	add(t, e, h, "bob", 3, orderbook.Buy, 100, 2)
+
+	// The first trade is forgotten, so its bust is no longer reversed.
+	assert.Len(t, h.trades, 1)
+	_, err := e.BustTrade("DEMO", 1, "ops", "")
+	require.NoError(t, err)
+	assert.Equal(t, []Obligation{
+		{Account: "alice", Symbol: "DEMO", Trades: 1, Sold: 2, Deliver: 2, Collect: 200},
+		{Account: "bob", Symbol: "DEMO", Trades: 1, Bought: 2, Receive: 2, Pay: 200, Fees: 2},
+	}, h.Pending())
+}
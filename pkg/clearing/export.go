@@ -0,0 +1,43 @@
+package clearing
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+)
+
+// WriteCSV writes the obligations of a settlement as CSV, with a header
+// line.
+func (s *Settlement) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{
+		"cycle", "account", "symbol", "trades", "bought", "sold",
+		"deliver", "receive", "pay", "collect", "fees",
+	})
+	cycle := strconv.FormatUint(s.Cycle, 10)
+	for _, o := range s.Obligations {
+		cw.Write([]string{
+			cycle,
+			o.Account,
+			string(o.Symbol),
+			strconv.Itoa(o.Trades),
+			strconv.FormatInt(o.Bought, 10),
+			strconv.FormatInt(o.Sold, 10),
+			strconv.FormatUint(o.Deliver, 10),
+			strconv.FormatUint(o.Receive, 10),
+			strconv.FormatInt(o.Pay, 10),
+			strconv.FormatInt(o.Collect, 10),
+			strconv.FormatInt(o.Fees, 10),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON writes a settlement as an indented JSON document.
+func (s *Settlement) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
@@ -0,0 +1,82 @@
+package disruptor
+
+import "go-orderbook/pkg/orderbook"
+
+type CommandType uint8
+
+const (
+	AddOrder CommandType = iota
+	CancelOrder
+	ModifyOrder
+)
+
+// Command is the slot type for order ingestion. Producers fill the request
+// fields; the Matcher fills Trades and Err, which downstream consumers can
+// read from the same slot. An upstream consumer such as the Journaler sets
+// Err to reject a command before it is matched.
+type Command struct {
+	Type    CommandType
+	Order   orderbook.Order
+	OrderId orderbook.OrderId
+	Modify  orderbook.OrderModify
+
+	Trades orderbook.Trades
+	Err    error
+}
+
+// PublishAddOrder claims a slot, writes an add command into it and publishes
+// it, returning its sequence.
+func PublishAddOrder(r *RingBuffer[Command], order orderbook.Order) int64 {
+	sequence := r.Next()
+	c := r.Get(sequence)
+	c.Type = AddOrder
+	c.Order = order
+	r.Publish(sequence)
+	return sequence
+}
+
+// PublishCancelOrder claims a slot, writes a cancel command into it and
+// publishes it, returning its sequence.
+func PublishCancelOrder(r *RingBuffer[Command], orderId orderbook.OrderId) int64 {
+	sequence := r.Next()
+	c := r.Get(sequence)
+	c.Type = CancelOrder
+	c.OrderId = orderId
+	r.Publish(sequence)
+	return sequence
+}
+
+// PublishModifyOrder claims a slot, writes a modify command into it and
+// publishes it, returning its sequence.
+func PublishModifyOrder(r *RingBuffer[Command], modify orderbook.OrderModify) int64 {
+	sequence := r.Next()
+	c := r.Get(sequence)
+	c.Type = ModifyOrder
+	c.Modify = modify
+	r.Publish(sequence)
+	return sequence
+}
+
+// Matcher is the single consumer that applies commands to an Orderbook. It
+// skips commands already rejected by the consumers it depends on.
+type Matcher struct {
+	book *orderbook.Orderbook
+}
+
+func NewMatcher(book *orderbook.Orderbook) *Matcher {
+	return &Matcher{book: book}
+}
+
+func (m *Matcher) OnEvent(c *Command, sequence int64, endOfBatch bool) {
+	if c.Err != nil {
+		return
+	}
+	switch c.Type {
+	case AddOrder:
+		c.Trades, c.Err = m.book.AddOrder(c.Order)
+	case CancelOrder:
+		c.Trades, c.Err = nil, m.book.CancelOrder(c.OrderId)
+	case ModifyOrder:
+		c.Trades, c.Err = m.book.ModifyOrder(c.Modify)
+	}
+}
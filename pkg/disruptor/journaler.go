@@ -0,0 +1,61 @@
+package disruptor
+
+import (
+	"errors"
+	"go-orderbook/pkg/engine"
+	"go-orderbook/pkg/journal"
+)
+
+// Journaler is the consumer that writes commands to a journal before they are
+// matched. It fsyncs once per batch, so the journal should be opened with
+// SyncNever. A command that could not be made durable is given the error,
+// truncated from the journal so that it is not replayed, and skipped by the
+// consumers that depend on the Journaler.
+type Journaler struct {
+	journal *journal.Journal
+	symbol  engine.Symbol
+	// batch holds the commands appended since the last fsync, and start the
+	// journal offset before the first of them.
+	batch []*Command
+	start int64
+}
+
+// NewJournaler creates a journaler that records commands for the book of
+// symbol, so that journal.Recover can rebuild it.
+func NewJournaler(j *journal.Journal, symbol engine.Symbol) *Journaler {
+	return &Journaler{journal: j, symbol: symbol}
+}
+
+func (j *Journaler) OnEvent(c *Command, sequence int64, endOfBatch bool) {
+	offset := j.journal.Offset()
+	if len(j.batch) == 0 {
+		j.start = offset
+	}
+	if err := j.journal.Append(record(j.symbol, c)); err != nil {
+		c.Err = errors.Join(err, j.journal.Truncate(offset))
+	} else {
+		j.batch = append(j.batch, c)
+	}
+	if !endOfBatch {
+		return
+	}
+	if err := j.journal.Sync(); err != nil {
+		err = errors.Join(err, j.journal.Truncate(j.start))
+		for _, c := range j.batch {
+			c.Err = err
+		}
+	}
+	clear(j.batch)
+	j.batch = j.batch[:0]
+}
+
+// record returns the journal record of a command.
+func record(symbol engine.Symbol, c *Command) journal.Record {
+	switch c.Type {
+	case CancelOrder:
+		return journal.Record{Type: journal.RecordCancel, Symbol: symbol, OrderId: c.OrderId}
+	case ModifyOrder:
+		return journal.NewModifyRecord(symbol, c.Modify)
+	}
+	return journal.NewAddRecord(symbol, c.Order)
+}
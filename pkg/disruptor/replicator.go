@@ -0,0 +1,53 @@
+package disruptor
+
+import (
+	"bufio"
+	"go-orderbook/pkg/engine"
+	"go-orderbook/pkg/journal"
+	"io"
+)
+
+// Replicator is the consumer that streams commands to a standby, framed as
+// journal records and flushed once per batch. The standby decodes them with
+// journal.Read and applies them with journal.Replay.
+//
+// The Replicator should depend on the Journaler and skips the commands it
+// rejected, so that the standby applies the same commands as the Matcher.
+// A Matcher that depends on the Replicator matches no command before it was
+// sent.
+//
+// A failed write does not reject commands: the Replicator stops sending and
+// Err reports the failure.
+type Replicator struct {
+	w      *bufio.Writer
+	symbol engine.Symbol
+	buf    []byte
+	err    error
+}
+
+// NewReplicator creates a replicator that sends the commands for the book of
+// symbol to w.
+func NewReplicator(w io.Writer, symbol engine.Symbol) *Replicator {
+	return &Replicator{w: bufio.NewWriter(w), symbol: symbol}
+}
+
+func (r *Replicator) OnEvent(c *Command, sequence int64, endOfBatch bool) {
+	if r.err != nil {
+		return
+	}
+	if c.Err == nil {
+		r.buf, r.err = journal.AppendRecord(r.buf[:0], record(r.symbol, c))
+		if r.err == nil {
+			_, r.err = r.w.Write(r.buf)
+		}
+	}
+	if r.err == nil && endOfBatch {
+		r.err = r.w.Flush()
+	}
+}
+
+// Err returns the error that stopped replication. It may only be called once
+// the ring is stopped.
+func (r *Replicator) Err() error {
+	return r.err
+}
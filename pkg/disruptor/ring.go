@@ -0,0 +1,224 @@
+// Package disruptor implements a pre-allocated ring buffer with a
+// multi-producer sequencer and batching consumers, in the style of the LMAX
+// Disruptor.
+package disruptor
+
+import (
+	"fmt"
+	"math/bits"
+	"sync"
+	"sync/atomic"
+)
+
+// An EventHandler processes slots published to a RingBuffer. endOfBatch is
+// true for the last slot that was available when the batch started, which is
+// a natural point to flush buffered work.
+type EventHandler[T any] interface {
+	OnEvent(slot *T, sequence int64, endOfBatch bool)
+}
+
+// EventHandlerFunc adapts an ordinary function to the EventHandler interface.
+type EventHandlerFunc[T any] func(slot *T, sequence int64, endOfBatch bool)
+
+func (f EventHandlerFunc[T]) OnEvent(slot *T, sequence int64, endOfBatch bool) {
+	f(slot, sequence, endOfBatch)
+}
+
+// RingBuffer holds a fixed number of pre-allocated slots. Producers claim a
+// sequence with Next, fill the slot returned by Get and make it visible with
+// Publish. Consumers read every published sequence in order.
+type RingBuffer[T any] struct {
+	slots []T
+	mask  int64
+	shift int
+
+	// cursor is the highest sequence claimed by a producer
+	cursor *Sequence
+	// available records, for every slot, the lap of the last sequence
+	// published into it
+	available []atomic.Int32
+	// gating holds the consumer sequences producers must not overtake, and
+	// gatingCache the last minimum observed
+	gating      []*Sequence
+	gatingCache *Sequence
+
+	consumers []*Consumer[T]
+	stopping  atomic.Bool
+	wg        sync.WaitGroup
+}
+
+// New creates a ring buffer with size slots. size must be a power of two.
+func New[T any](size int) (*RingBuffer[T], error) {
+	if size < 1 || size&(size-1) != 0 {
+		return nil, fmt.Errorf("ring buffer size %d is not a power of two", size)
+	}
+
+	r := &RingBuffer[T]{
+		slots:       make([]T, size),
+		mask:        int64(size - 1),
+		shift:       bits.TrailingZeros(uint(size)),
+		cursor:      newSequence(-1),
+		available:   make([]atomic.Int32, size),
+		gatingCache: newSequence(-1),
+	}
+	for i := range r.available {
+		r.available[i].Store(-1)
+	}
+	return r, nil
+}
+
+// Size returns the number of slots.
+func (r *RingBuffer[T]) Size() int {
+	return len(r.slots)
+}
+
+// Cursor returns the highest claimed sequence.
+func (r *RingBuffer[T]) Cursor() int64 {
+	return r.cursor.Get()
+}
+
+// Next claims the next sequence, blocking while the ring is full. It is safe
+// to call from several goroutines.
+func (r *RingBuffer[T]) Next() int64 {
+	sequence := r.cursor.value.Add(1)
+	wrap := sequence - int64(len(r.slots))
+
+	if wrap > r.gatingCache.Get() {
+		for i := 0; ; i++ {
+			gate := minimum(r.gating, sequence)
+			r.gatingCache.set(gate)
+			if wrap <= gate {
+				break
+			}
+			wait(i)
+		}
+	}
+	return sequence
+}
+
+// Get returns the slot for a sequence. Producers may only write to slots they
+// have claimed and not yet published; a claimed slot is zeroed.
+func (r *RingBuffer[T]) Get(sequence int64) *T {
+	return &r.slots[sequence&r.mask]
+}
+
+// Publish makes a claimed slot visible to consumers.
+func (r *RingBuffer[T]) Publish(sequence int64) {
+	r.available[sequence&r.mask].Store(int32(sequence >> r.shift))
+}
+
+func (r *RingBuffer[T]) isPublished(sequence int64) bool {
+	return r.available[sequence&r.mask].Load() == int32(sequence>>r.shift)
+}
+
+// highestPublished returns the highest sequence in [low, high] such that every
+// sequence from low up to it has been published. Producers may publish out of
+// order, so a claimed but unpublished slot ends the range.
+func (r *RingBuffer[T]) highestPublished(low, high int64) int64 {
+	for sequence := low; sequence <= high; sequence++ {
+		if !r.isPublished(sequence) {
+			return sequence - 1
+		}
+	}
+	return high
+}
+
+// AddConsumer registers a handler that reads every published sequence after
+// all the consumers it depends on have processed it. Consumers without
+// dependencies read the same sequence concurrently. It must be called before
+// Start.
+func (r *RingBuffer[T]) AddConsumer(
+	handler EventHandler[T],
+	dependsOn ...*Consumer[T],
+) *Consumer[T] {
+	c := &Consumer[T]{
+		ring:     r,
+		handler:  handler,
+		sequence: newSequence(-1),
+	}
+	for _, d := range dependsOn {
+		c.dependencies = append(c.dependencies, d.sequence)
+	}
+	r.consumers = append(r.consumers, c)
+	r.gating = append(r.gating, c.sequence)
+	return c
+}
+
+// Start launches a goroutine for every consumer, and one that resets every
+// slot once all the consumers have processed it, so that the ring keeps no
+// references to consumed commands and producers claim zeroed slots.
+func (r *RingBuffer[T]) Start() {
+	r.AddConsumer(EventHandlerFunc[T](func(slot *T, sequence int64, endOfBatch bool) {
+		var zero T
+		*slot = zero
+	}), r.consumers...)
+	for _, c := range r.consumers {
+		r.wg.Add(1)
+		go c.run(&r.wg)
+	}
+}
+
+// Stop waits for the consumers to process every claimed sequence and stops
+// them. Producers must have published all their claims before calling it.
+func (r *RingBuffer[T]) Stop() {
+	r.stopping.Store(true)
+	r.wg.Wait()
+}
+
+// Consumer is a batching reader of a RingBuffer.
+type Consumer[T any] struct {
+	ring         *RingBuffer[T]
+	handler      EventHandler[T]
+	sequence     *Sequence
+	dependencies []*Sequence
+}
+
+// Sequence returns the last sequence processed by the consumer.
+func (c *Consumer[T]) Sequence() int64 {
+	return c.sequence.Get()
+}
+
+func (c *Consumer[T]) run(wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	next := c.sequence.Get() + 1
+	for {
+		available, ok := c.waitFor(next)
+		if !ok {
+			return
+		}
+		for sequence := next; sequence <= available; sequence++ {
+			c.handler.OnEvent(c.ring.Get(sequence), sequence, sequence == available)
+		}
+		c.sequence.set(available)
+		next = available + 1
+	}
+}
+
+// waitFor blocks until next is available and returns the highest sequence that
+// can be processed in one batch. It returns false once the ring is stopping
+// and every claimed sequence has been processed.
+func (c *Consumer[T]) waitFor(next int64) (int64, bool) {
+	for i := 0; ; i++ {
+		stopping := c.ring.stopping.Load()
+		cursor := c.ring.cursor.Get()
+
+		var available int64
+		if len(c.dependencies) > 0 {
+			// upstream consumers only advance over published sequences
+			available = minimum(c.dependencies, cursor)
+		} else if cursor >= next {
+			available = c.ring.highestPublished(next, cursor)
+		} else {
+			available = next - 1
+		}
+
+		if available >= next {
+			return available, true
+		}
+		if stopping && next > cursor {
+			return 0, false
+		}
+		wait(i)
+	}
+}
@@ -0,0 +1,148 @@
+package disruptor
+
+import (
+	"bytes"
+	"go-orderbook/pkg/engine"
+	"go-orderbook/pkg/journal"
+	"go-orderbook/pkg/orderbook"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRequiresPowerOfTwo(t *testing.T) {
+	_, err := New[int](12)
+	assert.Error(t, err)
+	r, err := New[int](16)
+	assert.NoError(t, err)
+	assert.Equal(t, 16, r.Size())
+}
+
+func TestRingBuffer(t *testing.T) {
+	const producers = 4
+	const perProducer = 5000
+
+	// The ring is much smaller than the number of commands, so producers
+	// wrap many times and are gated by the slowest consumer.
+	r, err := New[Command](64)
+	assert.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "journal")
+	j, _, err := journal.Open(path, journal.Options{Sync: journal.SyncNever})
+	require.NoError(t, err)
+	var standby bytes.Buffer
+	replicator := NewReplicator(&standby, "DEMO")
+
+	ob := orderbook.NewOrderbook()
+	var downstream, errs int
+	var lastBatch int64 = -1
+
+	// Commands are journaled, then sent to the standby, then matched.
+	journaler := r.AddConsumer(NewJournaler(j, "DEMO"))
+	replication := r.AddConsumer(replicator, journaler)
+	matcher := r.AddConsumer(NewMatcher(&ob), replication)
+	r.AddConsumer(EventHandlerFunc[Command](func(c *Command, sequence int64, endOfBatch bool) {
+		assert.Equal(t, lastBatch+1, sequence)
+		lastBatch = sequence
+		downstream++
+		if c.Err != nil {
+			errs++
+		}
+	}), matcher)
+	r.Start()
+
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < perProducer; i++ {
+				id := orderbook.OrderId(p*perProducer + i + 1)
+				// Every producer rests orders on its own side of the
+				// book, far away from each other, so nothing trades.
+				side, price := orderbook.Buy, orderbook.Price(100-p)
+				if p%2 == 1 {
+					side, price = orderbook.Sell, orderbook.Price(200+p)
+				}
+				PublishAddOrder(r, orderbook.NewOrder(orderbook.GoodTillCancel, id, side, price, 1))
+			}
+		}(p)
+	}
+	wg.Wait()
+	r.Stop()
+
+	assert.Equal(t, producers*perProducer, downstream)
+	assert.Equal(t, 0, errs)
+	assert.Equal(t, producers*perProducer, ob.Size())
+	assert.Equal(t, int64(producers*perProducer-1), matcher.Sequence())
+
+	// Consumed slots hold no references.
+	for i := range r.slots {
+		assert.Zero(t, r.slots[i])
+	}
+
+	// The standby receives what was journaled, and rebuilds the book.
+	require.NoError(t, j.Close())
+	j, journaled, err := journal.Open(path, journal.Options{})
+	require.NoError(t, err)
+	require.NoError(t, j.Close())
+	require.NoError(t, replicator.Err())
+	records, _, err := journal.Read(&standby)
+	require.NoError(t, err)
+	assert.Len(t, records, producers*perProducer)
+	assert.Equal(t, journaled, records)
+	e := engine.NewEngine()
+	require.NoError(t, e.List("DEMO"))
+	require.NoError(t, journal.Replay(e, records))
+	assert.Equal(t, producers*perProducer, e.Size())
+}
+
+func TestJournalerRejects(t *testing.T) {
+	r, err := New[Command](8)
+	require.NoError(t, err)
+	j, _, err := journal.Open(filepath.Join(t.TempDir(), "journal"), journal.Options{Sync: journal.SyncNever})
+	require.NoError(t, err)
+	require.NoError(t, j.Close())
+
+	// A command that cannot be journaled is neither replicated nor matched.
+	ob := orderbook.NewOrderbook()
+	var standby bytes.Buffer
+	replicator := NewReplicator(&standby, "DEMO")
+	var rejected error
+	journaler := r.AddConsumer(NewJournaler(j, "DEMO"))
+	replication := r.AddConsumer(replicator, journaler)
+	matcher := r.AddConsumer(NewMatcher(&ob), replication)
+	r.AddConsumer(EventHandlerFunc[Command](func(c *Command, sequence int64, endOfBatch bool) {
+		rejected = c.Err
+	}), matcher)
+	r.Start()
+	PublishAddOrder(r, orderbook.NewOrder(orderbook.GoodTillCancel, 1, orderbook.Buy, 100, 1))
+	r.Stop()
+
+	assert.Error(t, rejected)
+	assert.Zero(t, ob.Size())
+	assert.NoError(t, replicator.Err())
+	assert.Zero(t, standby.Len())
+}
+
+// BenchmarkRingBufferHandoff measures a publish and consume round through the
+// ring. The handoff itself must not allocate.
+func BenchmarkRingBufferHandoff(b *testing.B) {
+	r, _ := New[Command](1024)
+	var n int
+	r.AddConsumer(EventHandlerFunc[Command](func(c *Command, sequence int64, endOfBatch bool) {
+		n++
+	}))
+	r.Start()
+
+	order := orderbook.NewOrder(orderbook.GoodTillCancel, 1, orderbook.Buy, 100, 1)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		PublishAddOrder(r, order)
+	}
+	r.Stop()
+}
@@ -0,0 +1,55 @@
+package disruptor
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// Sequence is a cursor into a RingBuffer. It is padded to its own cache line
+// so that producers and consumers updating neighbouring sequences do not
+// contend.
+type Sequence struct {
+	_     [56]byte
+	value atomic.Int64
+	_     [56]byte
+}
+
+func newSequence(value int64) *Sequence {
+	s := &Sequence{}
+	s.value.Store(value)
+	return s
+}
+
+// Get returns the last sequence number processed or claimed.
+func (s *Sequence) Get() int64 {
+	return s.value.Load()
+}
+
+func (s *Sequence) set(value int64) {
+	s.value.Store(value)
+}
+
+// minimum returns the lowest of the sequences, or fallback if there are none.
+func minimum(sequences []*Sequence, fallback int64) int64 {
+	m := fallback
+	for _, s := range sequences {
+		if v := s.Get(); v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+// wait backs off progressively while a producer or consumer is blocked: it
+// busy-spins first, then yields the processor and finally sleeps, so that a
+// short wait costs no system call and an idle consumer does not burn a core.
+func wait(iteration int) {
+	switch {
+	case iteration < 100:
+	case iteration < 1000:
+		runtime.Gosched()
+	default:
+		time.Sleep(50 * time.Microsecond)
+	}
+}
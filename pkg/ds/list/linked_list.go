@@ -62,6 +62,61 @@ func (l *LinkedList[T]) Append(value T) {
 	l.tail = newNode
 }
 
+// Element is a handle to a value stored in a LinkedList. It allows the
+// value to be removed in constant time, regardless of its position.
+type Element[T any] struct {
+	node *node[T]
+}
+
+// Value returns the value referenced by the element
+func (e Element[T]) Value() T {
+	return e.node.value
+}
+
+// AppendElement adds a new value to the end of the list and returns a handle
+// to it
+func (l *LinkedList[T]) AppendElement(value T) Element[T] {
+	l.Append(value)
+	return Element[T]{node: l.tail}
+}
+
+// Remove removes the element from the list. The element must belong to the
+// list.
+func (l *LinkedList[T]) Remove(e Element[T]) bool {
+	if e.node == nil || l.size == 0 {
+		return false
+	}
+
+	if e.node == l.head {
+		return l.DeleteHead()
+	}
+
+	if e.node == l.tail {
+		return l.DeleteTail()
+	}
+
+	e.node.prev.next = e.node.next
+	e.node.next.prev = e.node.prev
+	e.node.next = nil
+	e.node.prev = nil
+	l.size--
+	return true
+}
+
+// Contains reports whether the element belongs to the list. It walks the
+// list, so it is meant for consistency checks rather than hot paths.
+func (l *LinkedList[T]) Contains(e Element[T]) bool {
+	if e.node == nil {
+		return false
+	}
+	for n := l.head; n != nil; n = n.next {
+		if n == e.node {
+			return true
+		}
+	}
+	return false
+}
+
 // Prepend adds a new value to the beginning of the list
 func (l *LinkedList[T]) Prepend(value T) {
 	newNode := &node[T]{value: value, next: l.head, prev: nil}
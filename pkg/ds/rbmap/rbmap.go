@@ -217,9 +217,11 @@ func (m *Map[K, V]) deleteNode(node *Node[K, V]) {
 
 	if node.left == nil {
 		child = node.right
+		parent = node.parent
 		m.transplant(node, node.right)
 	} else if node.right == nil {
 		child = node.left
+		parent = node.parent
 		m.transplant(node, node.left)
 	} else {
 		// Node has two children
@@ -228,10 +230,12 @@ func (m *Map[K, V]) deleteNode(node *Node[K, V]) {
 		child = successor.right
 
 		if successor.parent == node {
+			parent = successor
 			if child != nil {
 				child.parent = successor
 			}
 		} else {
+			parent = successor.parent
 			m.transplant(successor, successor.right)
 			successor.right = node.right
 			successor.right.parent = successor
@@ -33,3 +33,33 @@ func TestMap(t *testing.T) {
 	it := intMap.Begin()
 	assert.Equal(t, it.Key(), 3)
 }
+
+func TestMapDelete(t *testing.T) {
+	m := NewMap[int, int](Descending[int])
+
+	for i := 0; i < 1000; i++ {
+		m.Insert((i*7919)%1000, i)
+	}
+	assert.Equal(t, 1000, m.Size())
+
+	// Delete every even key, in a scattered order
+	for i := 0; i < 1000; i++ {
+		k := (i * 7919) % 1000
+		if k%2 == 0 {
+			assert.True(t, m.Delete(k))
+		}
+	}
+	assert.False(t, m.Delete(0))
+	assert.Equal(t, 500, m.Size())
+
+	// Remaining keys must still be in descending order
+	prev := 1000
+	n := 0
+	for it := m.Begin(); it.Valid(); it.Next() {
+		assert.Less(t, it.Key(), prev)
+		assert.Equal(t, 1, it.Key()%2)
+		prev = it.Key()
+		n++
+	}
+	assert.Equal(t, 500, n)
+}
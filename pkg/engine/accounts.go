@@ -0,0 +1,90 @@
+package engine
+
+import "go-orderbook/pkg/orderbook"
+
+// OrderAccounts keeps the account of orders from before they enter the book
+// until they leave it, for listeners that book, charge or clear their
+// executions. Order ids may be reused once an order left the book, so an
+// account must not outlive its order.
+//
+// It is not safe for concurrent use. Its owner calls it with its own lock
+// held, and forwards it the events and command ends of the engine: an order
+// is only known to have left the book once the command ends, since a replace
+// deletes the order before adding it again.
+type OrderAccounts struct {
+	accounts map[orderbook.OrderId]string
+	// open holds the open quantity of the orders with an account that rest
+	// on a book.
+	open map[orderbook.OrderId]orderbook.Quantity
+	// touched holds, for every book, the orders with an account that had
+	// events in the current command.
+	touched map[Symbol][]orderbook.OrderId
+}
+
+// NewOrderAccounts creates an empty mapping of orders to accounts.
+func NewOrderAccounts() *OrderAccounts {
+	return &OrderAccounts{
+		accounts: make(map[orderbook.OrderId]string),
+		open:     make(map[orderbook.OrderId]orderbook.Quantity),
+		touched:  make(map[Symbol][]orderbook.OrderId),
+	}
+}
+
+// Assign keeps the account of an order about to enter the book. A resting
+// order with the same id keeps its own, as the new order will be rejected
+// as a duplicate.
+func (a *OrderAccounts) Assign(orderId orderbook.OrderId, account string) {
+	if _, resting := a.open[orderId]; !resting {
+		a.accounts[orderId] = account
+	}
+}
+
+// Unassign forgets the account of an order that was rejected before it
+// entered the book.
+func (a *OrderAccounts) Unassign(orderId orderbook.OrderId) {
+	if _, resting := a.open[orderId]; !resting {
+		delete(a.accounts, orderId)
+	}
+}
+
+// Account returns the account of an order.
+func (a *OrderAccounts) Account(orderId orderbook.OrderId) (string, bool) {
+	account, ok := a.accounts[orderId]
+	return account, ok
+}
+
+// OnEvent follows the open quantity of the orders with an account.
+func (a *OrderAccounts) OnEvent(symbol Symbol, ev orderbook.Event) {
+	if _, ok := a.accounts[ev.OrderId]; !ok {
+		return
+	}
+	switch ev.Type {
+	case orderbook.OrderAdded:
+		a.open[ev.OrderId] = ev.Quantity
+	case orderbook.OrderExecuted, orderbook.OrderReduced:
+		if open, resting := a.open[ev.OrderId]; resting {
+			if open <= ev.Quantity {
+				delete(a.open, ev.OrderId)
+			} else {
+				a.open[ev.OrderId] = open - ev.Quantity
+			}
+		}
+	case orderbook.OrderDeleted:
+		delete(a.open, ev.OrderId)
+	default:
+		return
+	}
+	a.touched[symbol] = append(a.touched[symbol], ev.OrderId)
+}
+
+// OnCommandEnd forgets the accounts of the orders of the book of symbol that
+// left it, or never rested on it, during the command.
+func (a *OrderAccounts) OnCommandEnd(symbol Symbol) {
+	touched := a.touched[symbol]
+	for _, orderId := range touched {
+		if _, resting := a.open[orderId]; !resting {
+			delete(a.accounts, orderId)
+		}
+	}
+	a.touched[symbol] = touched[:0]
+}
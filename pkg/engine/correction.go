@@ -0,0 +1,223 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"go-orderbook/pkg/orderbook"
+	"time"
+)
+
+var (
+	ErrUnknownTrade = errors.New("unknown trade")
+	ErrTradeBusted  = errors.New("trade already busted")
+)
+
+// TradeRetention is how long after it matched a trade can still be busted or
+// corrected.
+const TradeRetention = 24 * time.Hour
+
+// CorrectionType is the action taken on a trade after the fact.
+type CorrectionType int
+
+const (
+	// Bust cancels a trade.
+	Bust CorrectionType = iota + 1
+	// PriceCorrection changes the price of a trade.
+	PriceCorrection
+)
+
+func (t CorrectionType) String() string {
+	switch t {
+	case Bust:
+		return "bust"
+	case PriceCorrection:
+		return "price_correction"
+	}
+	return "unknown"
+}
+
+// Correction is the audit record of a bust or price correction.
+type Correction struct {
+	Type     CorrectionType
+	Time     time.Time
+	Operator string
+	Reason   string
+	Symbol   Symbol
+	// MatchId identifies the trade within the book of Symbol.
+	MatchId     uint64
+	BuyOrderId  orderbook.OrderId
+	SellOrderId orderbook.OrderId
+	Quantity    orderbook.Quantity
+	// OldPrice is the price of the trade before the action and NewPrice
+	// after it, zero for a bust.
+	OldPrice orderbook.Price
+	NewPrice orderbook.Price
+}
+
+type tradeKey struct {
+	symbol  Symbol
+	matchId uint64
+}
+
+// trade is the record of a match kept for corrections: the OrderExecuted
+// events of both sides, with their fees, the buy first.
+type trade struct {
+	key        tradeKey
+	executions [2]orderbook.Event
+	price      orderbook.Price
+	busted     bool
+	time       time.Time
+}
+
+// recordTrade keeps the executions of a match for corrections, and applies
+// published corrections to it. It runs with the publishing book locked.
+func (e *Engine) recordTrade(symbol Symbol, ev orderbook.Event) {
+	e.tradesM.Lock()
+	defer e.tradesM.Unlock()
+
+	k := tradeKey{symbol, ev.MatchId}
+	switch ev.Type {
+	case orderbook.OrderExecuted:
+		first, ok := e.executions[symbol]
+		if !ok || first.MatchId != ev.MatchId {
+			e.executions[symbol] = ev
+			return
+		}
+		delete(e.executions, symbol)
+		now := e.now()
+		e.expireTrades(now)
+		t := &trade{key: k, executions: [2]orderbook.Event{first, ev}, time: now}
+		if t.executions[0].Side != orderbook.Buy {
+			t.executions[0], t.executions[1] = ev, first
+		}
+		t.price = ev.TradePrice
+		e.trades[k] = t
+		e.retained = append(e.retained, t)
+	case orderbook.TradeBusted:
+		if t, exists := e.trades[k]; exists {
+			t.busted = true
+		}
+	case orderbook.TradeCorrected:
+		t, exists := e.trades[k]
+		if !exists {
+			return
+		}
+		t.price = ev.TradePrice
+		for i := range t.executions {
+			if t.executions[i].OrderId == ev.OrderId {
+				t.executions[i].Price = ev.Price
+				t.executions[i].TradePrice = ev.TradePrice
+				t.executions[i].Fee += ev.Fee
+			}
+		}
+	}
+}
+
+// expireTrades forgets the trades that matched TradeRetention or longer
+// before now. It should only be called with e.tradesM held.
+func (e *Engine) expireTrades(now time.Time) {
+	n := 0
+	for _, t := range e.retained {
+		if now.Sub(t.time) < TradeRetention {
+			break
+		}
+		// The match id of a relisted symbol may have been taken again.
+		if e.trades[t.key] == t {
+			delete(e.trades, t.key)
+		}
+		n++
+	}
+	e.retained = e.retained[n:]
+}
+
+// BustTrade cancels a trade after the fact. The book is left untouched: a
+// TradeBusted event is published for each side of the trade, with the
+// fields of its OrderExecuted event, for listeners to unwind it.
+func (e *Engine) BustTrade(symbol Symbol, matchId uint64, operator, reason string) (Correction, error) {
+	return e.correct(Correction{
+		Type:     Bust,
+		Operator: operator,
+		Reason:   reason,
+		Symbol:   symbol,
+		MatchId:  matchId,
+	})
+}
+
+// CorrectTrade changes the price of a trade after the fact. The book is left
+// untouched: a TradeCorrected event is published for each side of the
+// trade, with the fields of its OrderExecuted event and the new price.
+func (e *Engine) CorrectTrade(symbol Symbol, matchId uint64, price orderbook.Price, operator, reason string) (Correction, error) {
+	if price <= 0 {
+		return Correction{}, fmt.Errorf("invalid price %d", price)
+	}
+	return e.correct(Correction{
+		Type:     PriceCorrection,
+		Operator: operator,
+		Reason:   reason,
+		Symbol:   symbol,
+		MatchId:  matchId,
+		NewPrice: price,
+	})
+}
+
+func (e *Engine) correct(c Correction) (Correction, error) {
+	// Corrections are serialized, so that a trade cannot change between
+	// its check and the events correcting it.
+	e.correctionsM.Lock()
+	defer e.correctionsM.Unlock()
+
+	e.m.RLock()
+	defer e.m.RUnlock()
+
+	ob, exists := e.books[c.Symbol]
+	if !exists {
+		return Correction{}, fmt.Errorf("%w: %s", ErrUnknownSymbol, c.Symbol)
+	}
+
+	var (
+		executions [2]orderbook.Event
+		busted     bool
+	)
+	now := e.now()
+	e.tradesM.Lock()
+	e.expireTrades(now)
+	t, exists := e.trades[tradeKey{c.Symbol, c.MatchId}]
+	if exists {
+		executions, busted = t.executions, t.busted
+		c.OldPrice = t.price
+	}
+	e.tradesM.Unlock()
+	switch {
+	case !exists:
+		return Correction{}, fmt.Errorf("%w: %s %d", ErrUnknownTrade, c.Symbol, c.MatchId)
+	case busted:
+		return Correction{}, fmt.Errorf("%w: %s %d", ErrTradeBusted, c.Symbol, c.MatchId)
+	}
+
+	c.Time = now.UTC()
+	c.BuyOrderId = executions[0].OrderId
+	c.SellOrderId = executions[1].OrderId
+	c.Quantity = executions[0].Quantity
+	for _, ev := range executions {
+		ev.Fee = 0
+		if c.Type == Bust {
+			ev.Type = orderbook.TradeBusted
+		} else {
+			ev.Type = orderbook.TradeCorrected
+			ev.Price = c.NewPrice
+			ev.TradePrice = c.NewPrice
+		}
+		ob.Publish(ev)
+	}
+	e.endCommand(c.Symbol)
+	e.corrections = append(e.corrections, c)
+	return c, nil
+}
+
+// Corrections returns the audit records of every bust and price correction,
+// oldest first.
+func (e *Engine) Corrections() []Correction {
+	e.correctionsM.Lock()
+	defer e.correctionsM.Unlock()
+	return append([]Correction(nil), e.corrections...)
+}
@@ -0,0 +1,459 @@
+// Package engine manages many orderbooks keyed by instrument symbol.
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"go-orderbook/pkg/orderbook"
+	"sort"
+	"sync"
+	"time"
+)
+
+type Symbol string
+
+var (
+	ErrUnknownSymbol  = errors.New("unknown symbol")
+	ErrSymbolListed   = errors.New("symbol already listed")
+	ErrUnknownOrder   = errors.New("unknown order")
+	ErrDuplicateOrder = errors.New("duplicate order id")
+	ErrInvalidSymbol  = errors.New("invalid symbol")
+)
+
+// A Listener receives the events of every listed book, tagged with the symbol
+// of the book that published them. It is called with that book locked, so it
+// must not call back into the engine, and a lock it takes must never be held
+// while calling the engine.
+type Listener interface {
+	OnEvent(symbol Symbol, e orderbook.Event)
+}
+
+// A CommandListener is a Listener that is also told when a command of the
+// engine has published all the events of the book of symbol, such as to
+// write what it held back. It is called once the book is unlocked, so the
+// events of a later command of the same book may come first.
+type CommandListener interface {
+	Listener
+	OnCommandEnd(symbol Symbol)
+}
+
+// ListenerFunc adapts an ordinary function to the Listener interface.
+type ListenerFunc func(symbol Symbol, e orderbook.Event)
+
+func (f ListenerFunc) OnEvent(symbol Symbol, e orderbook.Event) {
+	f(symbol, e)
+}
+
+// FeeScale is the number of fee units in a price tick times a share, so that
+// fees of fractional ticks stay exact.
+const FeeScale = 1_000_000
+
+// A FeeModel prices the executions of an engine. Charge is called for every
+// OrderExecuted event with the publishing book locked, before the event
+// reaches any listener, and returns its fee in FeeScale units; a negative
+// fee is a rebate. It is called as well for TradeBusted and TradeCorrected
+// events, and returns the change to the fee of the execution they amend.
+type FeeModel interface {
+	Charge(symbol Symbol, e orderbook.Event) int64
+}
+
+// Operation is an order operation of an engine.
+type Operation int
+
+const (
+	OpAdd Operation = iota
+	OpCancel
+	OpModify
+)
+
+func (op Operation) String() string {
+	switch op {
+	case OpAdd:
+		return "add"
+	case OpCancel:
+		return "cancel"
+	case OpModify:
+		return "modify"
+	}
+	return "unknown"
+}
+
+// An Observer is told the outcome of every order operation of an engine and
+// how long it took, including the wait for locks. Symbol is empty when the
+// order was not found. It is called before the operation returns, so it
+// must not call back into the engine.
+type Observer interface {
+	ObserveOrder(op Operation, symbol Symbol, took time.Duration, err error)
+}
+
+// Quote is the top of book for a single symbol. A side with no resting orders
+// has a zero Quantity.
+type Quote struct {
+	Symbol Symbol
+	Bid    orderbook.LevelInfo
+	Ask    orderbook.LevelInfo
+}
+
+type liveOrder struct {
+	symbol    Symbol
+	remaining orderbook.Quantity
+	// modifying counts the modifies of the order in progress. They keep its
+	// id reserved while the book replaces it, between the delete of the
+	// original and the add of the replacement.
+	modifying int
+}
+
+// Engine routes orders to the book of their symbol and keeps order ids unique
+// across all books. An id may be reused once its order has left the book.
+type Engine struct {
+	m         sync.RWMutex
+	books     map[Symbol]*orderbook.Orderbook
+	listeners []Listener
+	fees      FeeModel
+	observer  Observer
+
+	// idsM guards ids. It is taken by the book listeners while the book is
+	// locked, so it must never be held while calling into a book.
+	idsM sync.Mutex
+	ids  map[orderbook.OrderId]liveOrder
+
+	// tradesM guards trades, retained and executions, which are kept by the
+	// book listeners like ids.
+	tradesM    sync.Mutex
+	trades     map[tradeKey]*trade
+	retained   []*trade
+	executions map[Symbol]orderbook.Event
+	now        func() time.Time
+
+	correctionsM sync.Mutex
+	corrections  []Correction
+}
+
+func NewEngine() *Engine {
+	return &Engine{
+		books: make(map[Symbol]*orderbook.Orderbook),
+		ids:   make(map[orderbook.OrderId]liveOrder),
+
+		trades:     make(map[tradeKey]*trade),
+		executions: make(map[Symbol]orderbook.Event),
+		now:        time.Now,
+	}
+}
+
+// AddListener registers a Listener for the events of all books, including
+// books listed later. It only receives the events published after the call,
+// so a listener that follows the books from their events should be added
+// before orders are entered.
+func (e *Engine) AddListener(l Listener) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	e.listeners = append(e.listeners, l)
+}
+
+// UseFees charges every execution through a fee model and records the fee
+// on its event. A fee model that is also a Listener, such as to follow the
+// orders it charges, is added as one. It should be called before orders are
+// entered.
+func (e *Engine) UseFees(m FeeModel) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	e.fees = m
+	if l, ok := m.(Listener); ok {
+		e.listeners = append(e.listeners, l)
+	}
+}
+
+// UseObserver reports every order operation to an observer. It should be
+// called before orders are entered.
+func (e *Engine) UseObserver(o Observer) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	e.observer = o
+}
+
+// observe reports an operation that started at start to the observer, if
+// any. It should only be called with e.m held.
+func (e *Engine) observe(op Operation, symbol Symbol, start time.Time, err error) {
+	if e.observer != nil {
+		e.observer.ObserveOrder(op, symbol, time.Since(start), err)
+	}
+}
+
+// endCommand tells the command listeners that a command on the book of
+// symbol has published its events. It should only be called with e.m held.
+func (e *Engine) endCommand(symbol Symbol) {
+	for _, l := range e.listeners {
+		if cl, ok := l.(CommandListener); ok {
+			cl.OnCommandEnd(symbol)
+		}
+	}
+}
+
+// List creates an empty book for the symbol.
+func (e *Engine) List(symbol Symbol) error {
+	if symbol == "" {
+		return ErrInvalidSymbol
+	}
+
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	if _, exists := e.books[symbol]; exists {
+		return fmt.Errorf("%w: %s", ErrSymbolListed, symbol)
+	}
+
+	ob := orderbook.NewOrderbook()
+	ob.AddListener(orderbook.ListenerFunc(func(ev orderbook.Event) {
+		e.onEvent(symbol, ev)
+	}))
+	e.books[symbol] = &ob
+	return nil
+}
+
+// Delist cancels every resting order of the symbol and removes its book.
+func (e *Engine) Delist(symbol Symbol) error {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	ob, exists := e.books[symbol]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrUnknownSymbol, symbol)
+	}
+
+	var orderIds orderbook.OrderIds
+	e.idsM.Lock()
+	for id, live := range e.ids {
+		if live.symbol == symbol {
+			orderIds = append(orderIds, id)
+		}
+	}
+	e.idsM.Unlock()
+
+	err := ob.CancelOrders(orderIds)
+	e.endCommand(symbol)
+	if err != nil {
+		return err
+	}
+	delete(e.books, symbol)
+	return nil
+}
+
+// Symbols returns the listed symbols in sorted order.
+func (e *Engine) Symbols() []Symbol {
+	e.m.RLock()
+	defer e.m.RUnlock()
+
+	symbols := make([]Symbol, 0, len(e.books))
+	for symbol := range e.books {
+		symbols = append(symbols, symbol)
+	}
+	sort.Slice(symbols, func(i, j int) bool {
+		return symbols[i] < symbols[j]
+	})
+	return symbols
+}
+
+// Book returns the orderbook of a listed symbol.
+func (e *Engine) Book(symbol Symbol) (*orderbook.Orderbook, bool) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	ob, exists := e.books[symbol]
+	return ob, exists
+}
+
+// AddOrder adds the order to the book of the symbol. The order id must not be
+// in use by a resting order of any book.
+func (e *Engine) AddOrder(
+	symbol Symbol,
+	order orderbook.Order,
+) (trades orderbook.Trades, err error) {
+	start := time.Now()
+	e.m.RLock()
+	defer e.m.RUnlock()
+	defer func() { e.observe(OpAdd, symbol, start, err) }()
+
+	ob, exists := e.books[symbol]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSymbol, symbol)
+	}
+
+	// Reserve the id before entering the book, so that a concurrent order
+	// with the same id for another symbol is rejected.
+	e.idsM.Lock()
+	if _, exists := e.ids[order.OrderId()]; exists {
+		e.idsM.Unlock()
+		return nil, fmt.Errorf("%w: %d", ErrDuplicateOrder, order.OrderId())
+	}
+	e.ids[order.OrderId()] = liveOrder{symbol: symbol}
+	e.idsM.Unlock()
+
+	trades, err = ob.AddOrder(order)
+	e.endCommand(symbol)
+	if err != nil {
+		// A rejected order never reached the book, so no event released the
+		// reservation.
+		e.idsM.Lock()
+		delete(e.ids, order.OrderId())
+		e.idsM.Unlock()
+	}
+	return trades, err
+}
+
+// CancelOrder cancels a resting order in whichever book holds it.
+func (e *Engine) CancelOrder(orderId orderbook.OrderId) (err error) {
+	start := time.Now()
+	e.m.RLock()
+	defer e.m.RUnlock()
+
+	symbol, ob, err := e.bookOf(orderId)
+	defer func() { e.observe(OpCancel, symbol, start, err) }()
+	if err != nil {
+		return err
+	}
+	defer e.endCommand(symbol)
+	return ob.CancelOrder(orderId)
+}
+
+// ModifyOrder modifies a resting order in whichever book holds it.
+func (e *Engine) ModifyOrder(
+	modify orderbook.OrderModify,
+) (trades orderbook.Trades, err error) {
+	start := time.Now()
+	e.m.RLock()
+	defer e.m.RUnlock()
+
+	symbol, ob, err := e.bookOf(modify.OrderId())
+	defer func() { e.observe(OpModify, symbol, start, err) }()
+	if err != nil {
+		return nil, err
+	}
+	defer e.endCommand(symbol)
+
+	e.idsM.Lock()
+	if live, exists := e.ids[modify.OrderId()]; exists && live.symbol == symbol {
+		live.modifying++
+		e.ids[modify.OrderId()] = live
+		defer e.modified(modify.OrderId())
+	}
+	e.idsM.Unlock()
+	return ob.ModifyOrder(modify)
+}
+
+// modified ends a modify of an order, releasing its id if the order left
+// the book meanwhile.
+func (e *Engine) modified(orderId orderbook.OrderId) {
+	e.idsM.Lock()
+	defer e.idsM.Unlock()
+	live, exists := e.ids[orderId]
+	if !exists {
+		return
+	}
+	live.modifying--
+	e.update(orderId, live)
+}
+
+// update stores the state of a live order, or releases its id once the
+// order has left the book and is not being modified. It should only be
+// called with e.idsM held.
+func (e *Engine) update(orderId orderbook.OrderId, live liveOrder) {
+	if live.remaining == 0 && live.modifying == 0 {
+		delete(e.ids, orderId)
+		return
+	}
+	e.ids[orderId] = live
+}
+
+// Lookup returns the symbol of a resting order.
+func (e *Engine) Lookup(orderId orderbook.OrderId) (Symbol, bool) {
+	e.idsM.Lock()
+	defer e.idsM.Unlock()
+	live, exists := e.ids[orderId]
+	return live.symbol, exists
+}
+
+// OrderInfo returns the aggregated depth of a single book.
+func (e *Engine) OrderInfo(
+	symbol Symbol,
+) (orderbook.OrderbookLevelsInfo, error) {
+	ob, exists := e.Book(symbol)
+	if !exists {
+		return orderbook.OrderbookLevelsInfo{},
+			fmt.Errorf("%w: %s", ErrUnknownSymbol, symbol)
+	}
+	return ob.OrderInfo(), nil
+}
+
+// Quotes returns the top of book of every listed symbol, sorted by symbol.
+func (e *Engine) Quotes() []Quote {
+	e.m.RLock()
+	defer e.m.RUnlock()
+
+	quotes := make([]Quote, 0, len(e.books))
+	for symbol, ob := range e.books {
+		q := Quote{Symbol: symbol}
+		info := ob.OrderInfo()
+		if bids := info.GetBids(); len(bids) > 0 {
+			q.Bid = bids[0]
+		}
+		if asks := info.GetAsks(); len(asks) > 0 {
+			q.Ask = asks[0]
+		}
+		quotes = append(quotes, q)
+	}
+	sort.Slice(quotes, func(i, j int) bool {
+		return quotes[i].Symbol < quotes[j].Symbol
+	})
+	return quotes
+}
+
+// Size returns the number of resting orders across all books.
+func (e *Engine) Size() int {
+	e.idsM.Lock()
+	defer e.idsM.Unlock()
+	return len(e.ids)
+}
+
+// bookOf returns the symbol and the book of a resting order. It should only
+// be called with e.m held.
+func (e *Engine) bookOf(orderId orderbook.OrderId) (Symbol, *orderbook.Orderbook, error) {
+	symbol, exists := e.Lookup(orderId)
+	if !exists {
+		return "", nil, fmt.Errorf("%w: %d", ErrUnknownOrder, orderId)
+	}
+	ob, exists := e.books[symbol]
+	if !exists {
+		return symbol, nil, fmt.Errorf("%w: %s", ErrUnknownSymbol, symbol)
+	}
+	return symbol, ob, nil
+}
+
+// onEvent keeps the id registry in step with the books and forwards the
+// event to the engine listeners. It runs with the publishing book locked.
+func (e *Engine) onEvent(symbol Symbol, ev orderbook.Event) {
+	e.idsM.Lock()
+	live := e.ids[ev.OrderId]
+	switch ev.Type {
+	case orderbook.OrderAdded:
+		live.symbol = symbol
+		live.remaining = ev.Quantity
+		e.update(ev.OrderId, live)
+	case orderbook.OrderExecuted, orderbook.OrderReduced:
+		live.remaining -= ev.Quantity
+		e.update(ev.OrderId, live)
+	case orderbook.OrderDeleted:
+		live.remaining = 0
+		e.update(ev.OrderId, live)
+	}
+	e.idsM.Unlock()
+
+	switch ev.Type {
+	case orderbook.OrderExecuted, orderbook.TradeBusted, orderbook.TradeCorrected:
+		if e.fees != nil {
+			ev.Fee = e.fees.Charge(symbol, ev)
+		}
+		e.recordTrade(symbol, ev)
+	}
+	for _, l := range e.listeners {
+		l.OnEvent(symbol, ev)
+	}
+}
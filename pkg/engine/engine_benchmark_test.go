@@ -0,0 +1,112 @@
+package engine
+
+import (
+	"fmt"
+	"go-orderbook/pkg/orderbook"
+	"sync/atomic"
+	"testing"
+)
+
+const benchmarkSymbols = 64
+
+var symbols = func() []Symbol {
+	symbols := make([]Symbol, benchmarkSymbols)
+	for i := range symbols {
+		symbols[i] = Symbol(fmt.Sprintf("SYM%03d", i))
+	}
+	return symbols
+}()
+
+func benchmarkSymbol(i uint64) Symbol {
+	return symbols[i%benchmarkSymbols]
+}
+
+// benchmarkOrder alternates buys and sells around a fixed price, so that
+// roughly half of the orders trade and the books stay shallow.
+func benchmarkOrder(id uint64) orderbook.Order {
+	side := orderbook.Buy
+	if id%2 == 1 {
+		side = orderbook.Sell
+	}
+	price := orderbook.Price(100 + id%5)
+	if side == orderbook.Sell {
+		price = orderbook.Price(102 - id%5)
+	}
+	return orderbook.NewOrder(
+		orderbook.GoodTillCancel,
+		orderbook.OrderId(id),
+		side,
+		price,
+		orderbook.Quantity(1+id%10),
+	)
+}
+
+// BenchmarkEngineMutex measures parallel order entry through the mutex
+// protected Engine.
+func BenchmarkEngineMutex(b *testing.B) {
+	e := NewEngine()
+	for i := uint64(0); i < benchmarkSymbols; i++ {
+		e.List(benchmarkSymbol(i))
+	}
+
+	var ids atomic.Uint64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			id := ids.Add(1)
+			e.AddOrder(benchmarkSymbol(id/2), benchmarkOrder(id))
+		}
+	})
+}
+
+// BenchmarkEngineSharded measures parallel order entry through the
+// single-writer ShardedEngine, waiting on a Future for every order.
+func BenchmarkEngineSharded(b *testing.B) {
+	for _, shards := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			e := newBenchmarkShardedEngine(shards)
+			defer e.Stop()
+
+			var ids atomic.Uint64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					id := ids.Add(1)
+					e.AddOrder(benchmarkSymbol(id/2), benchmarkOrder(id)).Wait()
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkEngineShardedPipelined measures ShardedEngine throughput when
+// producers do not wait for each result.
+func BenchmarkEngineShardedPipelined(b *testing.B) {
+	for _, shards := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			e := newBenchmarkShardedEngine(shards)
+			defer e.Stop()
+
+			var ids atomic.Uint64
+			discard := func(orderbook.Trades, error) {}
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					id := ids.Add(1)
+					e.AddOrderFunc(benchmarkSymbol(id/2), benchmarkOrder(id), discard)
+				}
+			})
+			e.Sync()
+		})
+	}
+}
+
+func newBenchmarkShardedEngine(shards int) *ShardedEngine {
+	e := NewShardedEngine(shards, 1024)
+	e.Start()
+	for i := uint64(0); i < benchmarkSymbols; i++ {
+		e.List(benchmarkSymbol(i))
+	}
+	e.Sync()
+	return e
+}
@@ -0,0 +1,269 @@
+package engine
+
+import (
+	"go-orderbook/pkg/orderbook"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEngine(t *testing.T) {
+	e := NewEngine()
+	assert.NoError(t, e.List("AAPL"))
+	assert.NoError(t, e.List("MSFT"))
+	assert.ErrorIs(t, e.List("AAPL"), ErrSymbolListed)
+	assert.Equal(t, []Symbol{"AAPL", "MSFT"}, e.Symbols())
+
+	_, err := e.AddOrder("AAPL", orderbook.NewOrder(orderbook.GoodTillCancel, 1, orderbook.Buy, 100, 10))
+	assert.NoError(t, err)
+
+	// Order ids are unique across books
+	_, err = e.AddOrder("MSFT", orderbook.NewOrder(orderbook.GoodTillCancel, 1, orderbook.Sell, 200, 10))
+	assert.ErrorIs(t, err, ErrDuplicateOrder)
+	_, err = e.AddOrder("TSLA", orderbook.NewOrder(orderbook.GoodTillCancel, 2, orderbook.Sell, 200, 10))
+	assert.ErrorIs(t, err, ErrUnknownSymbol)
+
+	_, err = e.AddOrder("MSFT", orderbook.NewOrder(orderbook.GoodTillCancel, 2, orderbook.Sell, 200, 10))
+	assert.NoError(t, err)
+	symbol, ok := e.Lookup(2)
+	assert.True(t, ok)
+	assert.Equal(t, Symbol("MSFT"), symbol)
+
+	// A filled order releases its id
+	trades, err := e.AddOrder("MSFT", orderbook.NewOrder(orderbook.GoodTillCancel, 3, orderbook.Buy, 200, 10))
+	assert.NoError(t, err)
+	assert.Len(t, trades, 1)
+	assert.Equal(t, 1, e.Size())
+	_, ok = e.Lookup(2)
+	assert.False(t, ok)
+
+	// Cancels and modifies are routed by order id
+	var modify orderbook.OrderModify
+	_, err = e.ModifyOrder(modify.New(1, 101, orderbook.Buy, 5))
+	assert.NoError(t, err)
+	quotes := e.Quotes()
+	assert.Equal(t, orderbook.LevelInfo{Price: 101, Quantity: 5}, quotes[0].Bid)
+	assert.Equal(t, orderbook.LevelInfo{}, quotes[1].Ask)
+	assert.NoError(t, e.CancelOrder(1))
+	assert.ErrorIs(t, e.CancelOrder(1), ErrUnknownOrder)
+}
+
+func TestModifyKeepsId(t *testing.T) {
+	e := NewEngine()
+	assert.NoError(t, e.List("AAPL"))
+	assert.NoError(t, e.List("MSFT"))
+	_, err := e.AddOrder("AAPL", orderbook.NewOrder(orderbook.GoodTillCancel, 1, orderbook.Buy, 100, 10))
+	assert.NoError(t, err)
+
+	// An order of another book entered between the delete of the original
+	// and the add of the replacement cannot take the id.
+	var raced error
+	e.AddListener(ListenerFunc(func(symbol Symbol, ev orderbook.Event) {
+		if symbol == "AAPL" && ev.Type == orderbook.OrderDeleted {
+			_, raced = e.AddOrder("MSFT", orderbook.NewOrder(orderbook.GoodTillCancel, 1, orderbook.Sell, 200, 10))
+		}
+	}))
+	var modify orderbook.OrderModify
+	_, err = e.ModifyOrder(modify.New(1, 101, orderbook.Buy, 10))
+	assert.NoError(t, err)
+	assert.ErrorIs(t, raced, ErrDuplicateOrder)
+	symbol, ok := e.Lookup(1)
+	assert.True(t, ok)
+	assert.Equal(t, Symbol("AAPL"), symbol)
+
+	// A modify that takes the order off the book releases the id when it ends.
+	_, err = e.ModifyOrder(modify.New(1, 101, orderbook.Buy, 0))
+	assert.NoError(t, err)
+	assert.ErrorIs(t, raced, ErrDuplicateOrder)
+	_, ok = e.Lookup(1)
+	assert.False(t, ok)
+	assert.Zero(t, e.Size())
+}
+
+func TestOrderAccounts(t *testing.T) {
+	a := NewOrderAccounts()
+	added := orderbook.Event{Type: orderbook.OrderAdded, OrderId: 1, Quantity: 10}
+	a.Assign(1, "alice")
+	a.OnEvent("AAPL", added)
+	a.OnCommandEnd("AAPL")
+
+	// A replace deletes and adds the order again within one command.
+	a.Assign(1, "bob")
+	a.OnEvent("AAPL", orderbook.Event{Type: orderbook.OrderDeleted, OrderId: 1})
+	a.OnEvent("AAPL", added)
+	a.OnCommandEnd("AAPL")
+	account, ok := a.Account(1)
+	assert.True(t, ok)
+	assert.Equal(t, "alice", account)
+
+	// The account is forgotten once the order is fully executed.
+	a.OnEvent("AAPL", orderbook.Event{Type: orderbook.OrderExecuted, OrderId: 1, Quantity: 4})
+	a.OnCommandEnd("AAPL")
+	_, ok = a.Account(1)
+	assert.True(t, ok)
+	a.OnEvent("AAPL", orderbook.Event{Type: orderbook.OrderExecuted, OrderId: 1, Quantity: 6})
+	_, ok = a.Account(1)
+	assert.True(t, ok)
+	a.OnCommandEnd("AAPL")
+	_, ok = a.Account(1)
+	assert.False(t, ok)
+
+	// So is the account of an order that never rested.
+	a.Assign(2, "alice")
+	a.OnEvent("AAPL", orderbook.Event{Type: orderbook.OrderAdded, OrderId: 2, Quantity: 5})
+	a.OnEvent("AAPL", orderbook.Event{Type: orderbook.OrderExecuted, OrderId: 2, Quantity: 5})
+	a.OnCommandEnd("AAPL")
+	_, ok = a.Account(2)
+	assert.False(t, ok)
+	a.Assign(3, "alice")
+	a.Unassign(3)
+	_, ok = a.Account(3)
+	assert.False(t, ok)
+}
+
+func TestEngineDelist(t *testing.T) {
+	e := NewEngine()
+	var deleted []orderbook.OrderId
+	e.AddListener(ListenerFunc(func(symbol Symbol, ev orderbook.Event) {
+		if ev.Type == orderbook.OrderDeleted {
+			deleted = append(deleted, ev.OrderId)
+		}
+	}))
+
+	assert.NoError(t, e.List("AAPL"))
+	_, err := e.AddOrder("AAPL", orderbook.NewOrder(orderbook.GoodTillCancel, 1, orderbook.Buy, 100, 10))
+	assert.NoError(t, err)
+
+	assert.NoError(t, e.Delist("AAPL"))
+	assert.Equal(t, []orderbook.OrderId{1}, deleted)
+	assert.Equal(t, 0, e.Size())
+	assert.Empty(t, e.Symbols())
+	assert.ErrorIs(t, e.Delist("AAPL"), ErrUnknownSymbol)
+}
+
+func TestCorrections(t *testing.T) {
+	e := NewEngine()
+	assert.NoError(t, e.List("AAPL"))
+	var events []orderbook.Event
+	e.AddListener(ListenerFunc(func(_ Symbol, ev orderbook.Event) {
+		events = append(events, ev)
+	}))
+
+	e.AddOrder("AAPL", orderbook.NewOrder(orderbook.GoodTillCancel, 1, orderbook.Sell, 100, 10))
+	trades, _ := e.AddOrder("AAPL", orderbook.NewOrder(orderbook.GoodTillCancel, 2, orderbook.Buy, 101, 4))
+	assert.Equal(t, uint64(1), trades[0].Id())
+
+	events = nil
+	c, err := e.CorrectTrade("AAPL", 1, 99, "ops", "fat finger")
+	assert.NoError(t, err)
+	assert.Equal(t, Correction{
+		Type: PriceCorrection, Time: c.Time, Operator: "ops", Reason: "fat finger",
+		Symbol: "AAPL", MatchId: 1, BuyOrderId: 2, SellOrderId: 1, Quantity: 4,
+		OldPrice: 100, NewPrice: 99,
+	}, c)
+	assert.Len(t, events, 2)
+	assert.Equal(t, events[0].Sequence+1, events[1].Sequence)
+	for _, ev := range events {
+		assert.Equal(t, orderbook.TradeCorrected, ev.Type)
+		assert.Equal(t, orderbook.Price(99), ev.Price)
+		assert.Equal(t, uint64(1), ev.MatchId)
+	}
+
+	// The book is left untouched.
+	info, _ := e.OrderInfo("AAPL")
+	assert.Equal(t, orderbook.LevelsInfo{{Price: 100, Quantity: 6}}, info.GetAsks())
+
+	events = nil
+	c, err = e.BustTrade("AAPL", 1, "ops", "erroneous")
+	assert.NoError(t, err)
+	assert.Equal(t, orderbook.Price(99), c.OldPrice)
+	assert.Zero(t, c.NewPrice)
+	assert.Equal(t, []orderbook.EventType{orderbook.TradeBusted, orderbook.TradeBusted},
+		[]orderbook.EventType{events[0].Type, events[1].Type})
+
+	_, err = e.BustTrade("AAPL", 1, "ops", "")
+	assert.ErrorIs(t, err, ErrTradeBusted)
+	_, err = e.CorrectTrade("AAPL", 1, 100, "ops", "")
+	assert.ErrorIs(t, err, ErrTradeBusted)
+	_, err = e.BustTrade("AAPL", 2, "ops", "")
+	assert.ErrorIs(t, err, ErrUnknownTrade)
+	_, err = e.BustTrade("MSFT", 1, "ops", "")
+	assert.ErrorIs(t, err, ErrUnknownSymbol)
+	_, err = e.CorrectTrade("AAPL", 1, 0, "ops", "")
+	assert.Error(t, err)
+
+	corrections := e.Corrections()
+	assert.Len(t, corrections, 2)
+	assert.Equal(t, PriceCorrection, corrections[0].Type)
+	assert.Equal(t, Bust, corrections[1].Type)
+
+	// An amendment of an unknown trade is ignored.
+	ob := e.books["AAPL"]
+	ob.Publish(orderbook.Event{Type: orderbook.TradeCorrected, OrderId: 2, MatchId: 9})
+	ob.Publish(orderbook.Event{Type: orderbook.TradeBusted, OrderId: 2, MatchId: 9})
+}
+
+func TestTradeRetention(t *testing.T) {
+	e := NewEngine()
+	assert.NoError(t, e.List("AAPL"))
+	now := time.Date(2024, 3, 1, 15, 0, 0, 0, time.UTC)
+	e.now = func() time.Time { return now }
+	trade := func(id orderbook.OrderId) {
+		e.AddOrder("AAPL", orderbook.NewOrder(orderbook.GoodTillCancel, id, orderbook.Sell, 100, 1))
+		e.AddOrder("AAPL", orderbook.NewOrder(orderbook.GoodTillCancel, id+1, orderbook.Buy, 100, 1))
+	}
+
+	trade(1)
+	now = now.Add(TradeRetention - time.Second)
+	trade(3)
+	_, err := e.CorrectTrade("AAPL", 1, 99, "ops", "")
+	assert.NoError(t, err)
+
+	// Trades are forgotten once they are older than the retention.
+	now = now.Add(time.Second)
+	_, err = e.BustTrade("AAPL", 1, "ops", "")
+	assert.ErrorIs(t, err, ErrUnknownTrade)
+	_, err = e.BustTrade("AAPL", 2, "ops", "")
+	assert.NoError(t, err)
+	assert.Len(t, e.trades, 1)
+}
+
+type observation struct {
+	op     Operation
+	symbol Symbol
+	err    error
+}
+
+type observerFunc func(op Operation, symbol Symbol, took time.Duration, err error)
+
+func (f observerFunc) ObserveOrder(op Operation, symbol Symbol, took time.Duration, err error) {
+	f(op, symbol, took, err)
+}
+
+func TestObserver(t *testing.T) {
+	e := NewEngine()
+	assert.NoError(t, e.List("AAPL"))
+	var observed []observation
+	e.UseObserver(observerFunc(func(op Operation, symbol Symbol, took time.Duration, err error) {
+		assert.Positive(t, took)
+		observed = append(observed, observation{op, symbol, err})
+	}))
+
+	e.AddOrder("AAPL", orderbook.NewOrder(orderbook.GoodTillCancel, 1, orderbook.Buy, 100, 10))
+	_, dupErr := e.AddOrder("AAPL", orderbook.NewOrder(orderbook.GoodTillCancel, 1, orderbook.Buy, 100, 10))
+	var modify orderbook.OrderModify
+	e.ModifyOrder(modify.New(1, 101, orderbook.Buy, 10))
+	e.CancelOrder(1)
+	cancelErr := e.CancelOrder(1)
+
+	assert.Equal(t, []observation{
+		{OpAdd, "AAPL", nil},
+		{OpAdd, "AAPL", dupErr},
+		{OpModify, "AAPL", nil},
+		{OpCancel, "AAPL", nil},
+		{OpCancel, "", cancelErr},
+	}, observed)
+	assert.ErrorIs(t, dupErr, ErrDuplicateOrder)
+	assert.ErrorIs(t, cancelErr, ErrUnknownOrder)
+}
@@ -0,0 +1,380 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"go-orderbook/pkg/orderbook"
+	"sync"
+)
+
+var ErrStopped = errors.New("engine stopped")
+
+// A Future holds the result of a command executed asynchronously by a
+// ShardedEngine.
+type Future[T any] struct {
+	done  chan struct{}
+	value T
+	err   error
+}
+
+func newFuture[T any]() *Future[T] {
+	return &Future[T]{done: make(chan struct{})}
+}
+
+func (f *Future[T]) complete(value T, err error) {
+	f.value = value
+	f.err = err
+	close(f.done)
+}
+
+// Done returns a channel that is closed once the result is available.
+func (f *Future[T]) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait blocks until the command has been executed and returns its result.
+func (f *Future[T]) Wait() (T, error) {
+	<-f.done
+	return f.value, f.err
+}
+
+// A command runs on the goroutine that owns the shard.
+type command func(s *shard)
+
+type shard struct {
+	commands chan command
+	books    map[Symbol]*orderbook.Orderbook
+	// ends holds the CommandListeners of every book.
+	ends map[Symbol][]CommandListener
+}
+
+func (s *shard) run(wg *sync.WaitGroup) {
+	defer wg.Done()
+	for c := range s.commands {
+		c(s)
+	}
+}
+
+func (s *shard) book(symbol Symbol) (*orderbook.Orderbook, error) {
+	ob, exists := s.books[symbol]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSymbol, symbol)
+	}
+	return ob, nil
+}
+
+// endCommand tells the command listeners of the book of symbol that a
+// command has published all its events.
+func (s *shard) endCommand(symbol Symbol) {
+	for _, l := range s.ends[symbol] {
+		l.OnCommandEnd(symbol)
+	}
+}
+
+// ShardedEngine is a single-writer alternative to Engine. Every symbol is
+// owned by one shard, and each shard is a goroutine consuming a bounded queue
+// of commands. Commands for the same symbol are executed in the order they
+// were submitted, and books are never touched by more than one goroutine.
+//
+// Cancels and modifies are routed by symbol rather than by order id, so
+// order ids only need to be unique within a book.
+//
+// Listeners and callbacks run on a shard goroutine. They must not wait for a
+// Future, and must not submit commands: a submit blocks while the queue of
+// its shard is full, which never drains if it is the shard of the caller, or
+// if that shard is in turn waiting on the caller.
+type ShardedEngine struct {
+	m         sync.RWMutex
+	shards    []*shard
+	listeners []Listener
+	wg        sync.WaitGroup
+	started   bool
+	stopped   bool
+	// submitting counts the submits past the stopped check, which Stop
+	// waits for before closing the queues.
+	submitting sync.WaitGroup
+}
+
+// NewShardedEngine creates an engine with the given number of shards, each
+// with a command queue of queueSize entries. Submitting to a full queue blocks
+// until the shard catches up.
+func NewShardedEngine(shards int, queueSize int) *ShardedEngine {
+	if shards < 1 {
+		shards = 1
+	}
+
+	e := &ShardedEngine{shards: make([]*shard, shards)}
+	for i := range e.shards {
+		e.shards[i] = &shard{
+			commands: make(chan command, queueSize),
+			books:    make(map[Symbol]*orderbook.Orderbook),
+			ends:     make(map[Symbol][]CommandListener),
+		}
+	}
+	return e
+}
+
+// AddListener registers a Listener for the events of books listed after the
+// call. Listeners are invoked on the shard goroutine and must not block. A
+// CommandListener is told when each command has published its events, as by
+// Engine.
+func (e *ShardedEngine) AddListener(l Listener) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	e.listeners = append(e.listeners, l)
+}
+
+// Start launches one goroutine per shard.
+func (e *ShardedEngine) Start() {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if e.started {
+		return
+	}
+	e.started = true
+
+	for _, s := range e.shards {
+		e.wg.Add(1)
+		go s.run(&e.wg)
+	}
+}
+
+// Stop rejects new commands, waits for every queued command to be executed
+// and stops the shard goroutines.
+func (e *ShardedEngine) Stop() {
+	e.m.Lock()
+	if e.stopped {
+		e.m.Unlock()
+		return
+	}
+	e.stopped = true
+	e.m.Unlock()
+
+	e.submitting.Wait()
+	for _, s := range e.shards {
+		close(s.commands)
+	}
+	e.wg.Wait()
+}
+
+// shardOf maps a symbol onto its owning shard using FNV-1a.
+func (e *ShardedEngine) shardOf(symbol Symbol) *shard {
+	h := uint32(2166136261)
+	for i := 0; i < len(symbol); i++ {
+		h ^= uint32(symbol[i])
+		h *= 16777619
+	}
+	return e.shards[h%uint32(len(e.shards))]
+}
+
+// submit queues a command on a shard. The lock is not held while the queue is
+// full, so that Stop is not kept waiting behind it.
+func (e *ShardedEngine) submit(s *shard, c command) error {
+	e.m.RLock()
+	if e.stopped {
+		e.m.RUnlock()
+		return ErrStopped
+	}
+	e.submitting.Add(1)
+	e.m.RUnlock()
+	defer e.submitting.Done()
+
+	s.commands <- c
+	return nil
+}
+
+// List creates an empty book for the symbol on its owning shard.
+func (e *ShardedEngine) List(symbol Symbol) *Future[struct{}] {
+	f := newFuture[struct{}]()
+	if symbol == "" {
+		f.complete(struct{}{}, ErrInvalidSymbol)
+		return f
+	}
+
+	e.m.RLock()
+	listeners := e.listeners
+	e.m.RUnlock()
+	err := e.submit(e.shardOf(symbol), func(s *shard) {
+		if _, exists := s.books[symbol]; exists {
+			f.complete(struct{}{}, fmt.Errorf("%w: %s", ErrSymbolListed, symbol))
+			return
+		}
+
+		ob := orderbook.NewOrderbook()
+		var ends []CommandListener
+		for _, l := range listeners {
+			l := l
+			ob.AddListener(orderbook.ListenerFunc(func(ev orderbook.Event) {
+				l.OnEvent(symbol, ev)
+			}))
+			if cl, ok := l.(CommandListener); ok {
+				ends = append(ends, cl)
+			}
+		}
+		s.books[symbol] = &ob
+		s.ends[symbol] = ends
+		f.complete(struct{}{}, nil)
+	})
+	if err != nil {
+		f.complete(struct{}{}, err)
+	}
+	return f
+}
+
+// Delist removes the book of the symbol, cancelling its resting orders.
+func (e *ShardedEngine) Delist(symbol Symbol) *Future[struct{}] {
+	f := newFuture[struct{}]()
+	err := e.submit(e.shardOf(symbol), func(s *shard) {
+		ob, err := s.book(symbol)
+		if err != nil {
+			f.complete(struct{}{}, err)
+			return
+		}
+
+		err = ob.CancelOrders(ob.OrderIds())
+		s.endCommand(symbol)
+		if err != nil {
+			f.complete(struct{}{}, err)
+			return
+		}
+		delete(s.books, symbol)
+		delete(s.ends, symbol)
+		f.complete(struct{}{}, nil)
+	})
+	if err != nil {
+		f.complete(struct{}{}, err)
+	}
+	return f
+}
+
+// AddOrderFunc submits an order and calls callback with the result on the
+// shard goroutine.
+func (e *ShardedEngine) AddOrderFunc(
+	symbol Symbol,
+	order orderbook.Order,
+	callback func(orderbook.Trades, error),
+) {
+	err := e.submit(e.shardOf(symbol), func(s *shard) {
+		ob, err := s.book(symbol)
+		if err != nil {
+			callback(nil, err)
+			return
+		}
+		trades, err := ob.AddOrder(order)
+		s.endCommand(symbol)
+		callback(trades, err)
+	})
+	if err != nil {
+		callback(nil, err)
+	}
+}
+
+// AddOrder submits an order and returns a Future for its trades.
+func (e *ShardedEngine) AddOrder(
+	symbol Symbol,
+	order orderbook.Order,
+) *Future[orderbook.Trades] {
+	f := newFuture[orderbook.Trades]()
+	e.AddOrderFunc(symbol, order, f.complete)
+	return f
+}
+
+// CancelOrderFunc submits a cancel and calls callback with the result on the
+// shard goroutine.
+func (e *ShardedEngine) CancelOrderFunc(
+	symbol Symbol,
+	orderId orderbook.OrderId,
+	callback func(error),
+) {
+	err := e.submit(e.shardOf(symbol), func(s *shard) {
+		ob, err := s.book(symbol)
+		if err != nil {
+			callback(err)
+			return
+		}
+		err = ob.CancelOrder(orderId)
+		s.endCommand(symbol)
+		callback(err)
+	})
+	if err != nil {
+		callback(err)
+	}
+}
+
+// CancelOrder submits a cancel and returns a Future for its result.
+func (e *ShardedEngine) CancelOrder(
+	symbol Symbol,
+	orderId orderbook.OrderId,
+) *Future[struct{}] {
+	f := newFuture[struct{}]()
+	e.CancelOrderFunc(symbol, orderId, func(err error) {
+		f.complete(struct{}{}, err)
+	})
+	return f
+}
+
+// ModifyOrderFunc submits a modify and calls callback with the result on the
+// shard goroutine.
+func (e *ShardedEngine) ModifyOrderFunc(
+	symbol Symbol,
+	modify orderbook.OrderModify,
+	callback func(orderbook.Trades, error),
+) {
+	err := e.submit(e.shardOf(symbol), func(s *shard) {
+		ob, err := s.book(symbol)
+		if err != nil {
+			callback(nil, err)
+			return
+		}
+		trades, err := ob.ModifyOrder(modify)
+		s.endCommand(symbol)
+		callback(trades, err)
+	})
+	if err != nil {
+		callback(nil, err)
+	}
+}
+
+// ModifyOrder submits a modify and returns a Future for its trades.
+func (e *ShardedEngine) ModifyOrder(
+	symbol Symbol,
+	modify orderbook.OrderModify,
+) *Future[orderbook.Trades] {
+	f := newFuture[orderbook.Trades]()
+	e.ModifyOrderFunc(symbol, modify, f.complete)
+	return f
+}
+
+// OrderInfo reads the aggregated depth of a book on its owning shard, after
+// every command submitted before it.
+func (e *ShardedEngine) OrderInfo(
+	symbol Symbol,
+) *Future[orderbook.OrderbookLevelsInfo] {
+	f := newFuture[orderbook.OrderbookLevelsInfo]()
+	err := e.submit(e.shardOf(symbol), func(s *shard) {
+		ob, err := s.book(symbol)
+		if err != nil {
+			f.complete(orderbook.OrderbookLevelsInfo{}, err)
+			return
+		}
+		f.complete(ob.OrderInfo(), nil)
+	})
+	if err != nil {
+		f.complete(orderbook.OrderbookLevelsInfo{}, err)
+	}
+	return f
+}
+
+// Sync blocks until every command submitted before the call has been
+// executed.
+func (e *ShardedEngine) Sync() error {
+	var wg sync.WaitGroup
+	for _, s := range e.shards {
+		wg.Add(1)
+		if err := e.submit(s, func(*shard) { wg.Done() }); err != nil {
+			return err
+		}
+	}
+	wg.Wait()
+	return nil
+}
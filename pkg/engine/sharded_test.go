@@ -0,0 +1,128 @@
+package engine
+
+import (
+	"go-orderbook/pkg/orderbook"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// commandCounter counts the commands that ended on every book.
+type commandCounter struct {
+	m     sync.Mutex
+	ended map[Symbol]int
+}
+
+func (c *commandCounter) OnEvent(symbol Symbol, ev orderbook.Event) {}
+
+func (c *commandCounter) OnCommandEnd(symbol Symbol) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.ended[symbol]++
+}
+
+func TestShardedEngine(t *testing.T) {
+	e := NewShardedEngine(4, 16)
+	e.Start()
+	var added int
+	e.AddListener(ListenerFunc(func(symbol Symbol, ev orderbook.Event) {
+		if symbol == "AAPL" && ev.Type == orderbook.OrderAdded {
+			added++
+		}
+	}))
+	commands := &commandCounter{ended: make(map[Symbol]int)}
+	e.AddListener(commands)
+
+	for _, symbol := range []Symbol{"AAPL", "MSFT", "TSLA"} {
+		_, err := e.List(symbol).Wait()
+		assert.NoError(t, err)
+	}
+	_, err := e.List("AAPL").Wait()
+	assert.ErrorIs(t, err, ErrSymbolListed)
+
+	// Order ids only need to be unique within a book
+	for _, symbol := range []Symbol{"AAPL", "MSFT"} {
+		_, err = e.AddOrder(symbol, orderbook.NewOrder(orderbook.GoodTillCancel, 1, orderbook.Sell, 100, 10)).Wait()
+		assert.NoError(t, err)
+	}
+
+	var filled orderbook.Trades
+	e.AddOrderFunc("AAPL", orderbook.NewOrder(orderbook.GoodTillCancel, 2, orderbook.Buy, 100, 4),
+		func(trades orderbook.Trades, err error) {
+			assert.NoError(t, err)
+			filled = trades
+		})
+	assert.NoError(t, e.Sync())
+	assert.Len(t, filled, 1)
+	assert.Equal(t, 2, added)
+
+	var modify orderbook.OrderModify
+	_, err = e.ModifyOrder("AAPL", modify.New(1, 101, orderbook.Sell, 6)).Wait()
+	assert.NoError(t, err)
+	info, err := e.OrderInfo("AAPL").Wait()
+	assert.NoError(t, err)
+	assert.Equal(t, orderbook.LevelsInfo{{Price: 101, Quantity: 6}}, info.GetAsks())
+
+	_, err = e.CancelOrder("MSFT", 1).Wait()
+	assert.NoError(t, err)
+	_, err = e.CancelOrder("TSLA", 1).Wait()
+	assert.Error(t, err)
+	_, err = e.Delist("AAPL").Wait()
+	assert.NoError(t, err)
+	_, err = e.OrderInfo("AAPL").Wait()
+	assert.ErrorIs(t, err, ErrUnknownSymbol)
+	// Commands on a listed book end, even when they are rejected.
+	commands.m.Lock()
+	assert.Equal(t, map[Symbol]int{"AAPL": 4, "MSFT": 2, "TSLA": 1}, commands.ended)
+	commands.m.Unlock()
+
+	e.Stop()
+	_, err = e.AddOrder("MSFT", orderbook.NewOrder(orderbook.GoodTillCancel, 3, orderbook.Buy, 100, 4)).Wait()
+	assert.ErrorIs(t, err, ErrStopped)
+}
+
+func TestShardedEngineStopWhileFull(t *testing.T) {
+	e := NewShardedEngine(1, 1)
+	e.Start()
+	_, err := e.List("AAPL").Wait()
+	assert.NoError(t, err)
+
+	// The shard is held by the first order, the second fills its queue and
+	// the third blocks submitting.
+	release := make(chan struct{})
+	var done sync.WaitGroup
+	done.Add(3)
+	add := func(id orderbook.OrderId) {
+		e.AddOrderFunc("AAPL", orderbook.NewOrder(orderbook.GoodTillCancel, id, orderbook.Buy, 100, 1),
+			func(orderbook.Trades, error) {
+				if id == 1 {
+					<-release
+				}
+				done.Done()
+			})
+	}
+	add(1)
+	assert.Eventually(t, func() bool { return len(e.shards[0].commands) == 0 }, time.Second, time.Millisecond)
+	add(2)
+	go add(3)
+	time.Sleep(10 * time.Millisecond)
+
+	stopped := make(chan struct{})
+	go func() {
+		e.Stop()
+		close(stopped)
+	}()
+	assert.Eventually(t, func() bool {
+		e.m.RLock()
+		defer e.m.RUnlock()
+		return e.stopped
+	}, time.Second, time.Millisecond)
+	_, err = e.OrderInfo("AAPL").Wait()
+	assert.ErrorIs(t, err, ErrStopped)
+
+	close(release)
+	<-stopped
+	done.Wait()
+}
@@ -0,0 +1,260 @@
+// Package fee charges maker and taker fees, or pays rebates, on the
+// executions of an engine.
+//
+// Fees are set by tiered schedules per account and symbol. The tier of an
+// account follows its volume, in shares across all symbols, over a rolling
+// window of 30 days; a fill is charged at the tier reached before it.
+// Executions of the order that took liquidity pay the taker rate, those of
+// the resting order the maker rate. A busted trade refunds its fees and
+// leaves the volume; a price correction charges the difference at the rate
+// of the original fill. Fills older than engine.TradeRetention can no longer
+// be busted or corrected, so their charges are forgotten.
+package fee
+
+import (
+	"go-orderbook/pkg/engine"
+	"go-orderbook/pkg/orderbook"
+	"sync"
+	"time"
+)
+
+// Window is the period the volume of an account is summed over.
+const Window = 30 * 24 * time.Hour
+
+const day = 24 * time.Hour
+
+// Total is what an account has traded and been charged.
+type Total struct {
+	Volume uint64
+	// Maker and Taker are the fees charged for providing and taking
+	// liquidity, in engine.FeeScale units.
+	Maker int64
+	Taker int64
+}
+
+type fillKey struct {
+	symbol  engine.Symbol
+	matchId uint64
+	orderId orderbook.OrderId
+}
+
+// charge is what a fill was charged, kept for busts and corrections.
+type charge struct {
+	key       fillKey
+	time      time.Time
+	account   string
+	day       int64
+	quantity  orderbook.Quantity
+	aggressor bool
+	rate      int64
+	fee       int64
+}
+
+// Calculator is an engine.FeeModel, and an engine.CommandListener to follow
+// the orders it charges. It is called by the engine while a book is locked,
+// so it never calls the engine.
+type Calculator struct {
+	cfg Config
+	now func() time.Time
+
+	m        sync.Mutex
+	accounts *engine.OrderAccounts
+	// volumes holds the shares traded by each account per UTC day, keyed
+	// by days since the epoch.
+	volumes map[string]map[int64]uint64
+	totals  map[string]Total
+	// charges holds the charges of the fills that can still be amended, and
+	// expiring the same in the order they were made.
+	charges  map[fillKey]*charge
+	expiring []*charge
+}
+
+// NewCalculator creates a calculator charging the schedules of cfg. It is
+// installed with engine.Engine.UseFees.
+func NewCalculator(cfg Config) *Calculator {
+	return &Calculator{
+		cfg:      cfg,
+		now:      time.Now,
+		accounts: engine.NewOrderAccounts(),
+		volumes:  make(map[string]map[int64]uint64),
+		totals:   make(map[string]Total),
+		charges:  make(map[fillKey]*charge),
+	}
+}
+
+// Assign charges the executions of an order to account. It should be called
+// before the order enters the book; executions of orders without an account,
+// or of orders that left the book since, are charged to the empty account.
+func (c *Calculator) Assign(orderId orderbook.OrderId, account string) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.accounts.Assign(orderId, account)
+}
+
+// Unassign takes back an Assign when the order was not accepted.
+func (c *Calculator) Unassign(orderId orderbook.OrderId) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.accounts.Unassign(orderId)
+}
+
+// OnEvent follows the orders charged to an account. The engine calls it
+// once the fee of the event is charged.
+func (c *Calculator) OnEvent(symbol engine.Symbol, ev orderbook.Event) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.accounts.OnEvent(symbol, ev)
+}
+
+// OnCommandEnd forgets the accounts of the orders that left the book.
+func (c *Calculator) OnCommandEnd(symbol engine.Symbol) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.accounts.OnCommandEnd(symbol)
+}
+
+// Charge returns the fee of an execution and adds it to the totals and
+// volume of its account. For a bust or correction it returns the change to
+// the fee of the execution.
+func (c *Calculator) Charge(symbol engine.Symbol, ev orderbook.Event) int64 {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	k := fillKey{symbol, ev.MatchId, ev.OrderId}
+	switch ev.Type {
+	case orderbook.TradeBusted:
+		return c.bust(k)
+	case orderbook.TradeCorrected:
+		return c.correct(k, ev.TradePrice)
+	}
+
+	account, _ := c.accounts.Account(ev.OrderId)
+	now := c.now()
+	c.expire(now)
+	today := now.UnixNano() / int64(day)
+	volumes, ok := c.volumes[account]
+	if !ok {
+		volumes = make(map[int64]uint64)
+		c.volumes[account] = volumes
+	}
+
+	ch := &charge{
+		key:       k,
+		time:      now,
+		account:   account,
+		day:       today,
+		quantity:  ev.Quantity,
+		aggressor: ev.Aggressor,
+	}
+	if tier, ok := c.cfg.schedule(account, symbol).tier(c.volume(volumes, today)); ok {
+		ch.rate = tier.Maker
+		if ev.Aggressor {
+			ch.rate = tier.Taker
+		}
+		ch.fee = int64(ev.TradePrice) * int64(ev.Quantity) * ch.rate
+	}
+	c.charges[k] = ch
+	c.expiring = append(c.expiring, ch)
+
+	volumes[today] += uint64(ev.Quantity)
+	c.add(ch, int64(ch.quantity), ch.fee)
+	return ch.fee
+}
+
+// expire forgets the charges made engine.TradeRetention or longer before
+// now. It should only be called with c.m held.
+func (c *Calculator) expire(now time.Time) {
+	n := 0
+	for _, ch := range c.expiring {
+		if now.Sub(ch.time) < engine.TradeRetention {
+			break
+		}
+		if c.charges[ch.key] == ch {
+			delete(c.charges, ch.key)
+		}
+		n++
+	}
+	c.expiring = c.expiring[n:]
+}
+
+// bust refunds the fee of a fill and takes it out of the volume of its
+// account. It should only be called with c.m held.
+func (c *Calculator) bust(k fillKey) int64 {
+	ch, ok := c.charges[k]
+	if !ok {
+		return 0
+	}
+	delete(c.charges, k)
+	// The volume of days that left the window is already gone.
+	if volumes := c.volumes[ch.account]; volumes[ch.day] >= uint64(ch.quantity) {
+		volumes[ch.day] -= uint64(ch.quantity)
+	}
+	c.add(ch, -int64(ch.quantity), -ch.fee)
+	return -ch.fee
+}
+
+// correct charges a fill again at a new price and returns the difference.
+// It should only be called with c.m held.
+func (c *Calculator) correct(k fillKey, price orderbook.Price) int64 {
+	ch, ok := c.charges[k]
+	if !ok {
+		return 0
+	}
+	fee := int64(price) * int64(ch.quantity) * ch.rate
+	delta := fee - ch.fee
+	ch.fee = fee
+	c.add(ch, 0, delta)
+	return delta
+}
+
+// add changes the totals of the account of a fill. It should only be
+// called with c.m held.
+func (c *Calculator) add(ch *charge, volume int64, fee int64) {
+	total := c.totals[ch.account]
+	total.Volume = uint64(int64(total.Volume) + volume)
+	if ch.aggressor {
+		total.Taker += fee
+	} else {
+		total.Maker += fee
+	}
+	c.totals[ch.account] = total
+}
+
+// volume sums the daily volumes of the window ending today, dropping the
+// older ones. It should only be called with c.m held.
+func (c *Calculator) volume(volumes map[int64]uint64, today int64) uint64 {
+	var volume uint64
+	for d, v := range volumes {
+		if d <= today-int64(Window/day) {
+			delete(volumes, d)
+			continue
+		}
+		volume += v
+	}
+	return volume
+}
+
+// Volume returns the shares an account traded over the last Window.
+func (c *Calculator) Volume(account string) uint64 {
+	c.m.Lock()
+	defer c.m.Unlock()
+	volumes, ok := c.volumes[account]
+	if !ok {
+		return 0
+	}
+	return c.volume(volumes, c.now().UnixNano()/int64(day))
+}
+
+// Tier returns the tier an account is charged at in a symbol.
+func (c *Calculator) Tier(account string, symbol engine.Symbol) (Tier, bool) {
+	volume := c.Volume(account)
+	return c.cfg.schedule(account, symbol).tier(volume)
+}
+
+// Total returns what an account has traded and been charged since the
+// calculator was created.
+func (c *Calculator) Total(account string) Total {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.totals[account]
+}
@@ -0,0 +1,217 @@
+package fee
+
+import (
+	"go-orderbook/pkg/engine"
+	"go-orderbook/pkg/orderbook"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fixture struct {
+	engine     *engine.Engine
+	calculator *Calculator
+	now        time.Time
+	nextId     orderbook.OrderId
+	// fees holds the fees of the last match, bust or correction, by whether
+	// the order was the aggressor.
+	fees map[bool]int64
+}
+
+func newFixture(t *testing.T, cfg Config) *fixture {
+	t.Helper()
+	f := &fixture{
+		engine:     engine.NewEngine(),
+		calculator: NewCalculator(cfg),
+		now:        time.Date(2024, 3, 1, 15, 0, 0, 0, time.UTC),
+		nextId:     1,
+		fees:       make(map[bool]int64),
+	}
+	require.NoError(t, f.engine.List("DEMO"))
+	require.NoError(t, f.engine.List("OTHER"))
+	f.calculator.now = func() time.Time { return f.now }
+	f.engine.UseFees(f.calculator)
+	f.engine.AddListener(engine.ListenerFunc(func(_ engine.Symbol, ev orderbook.Event) {
+		switch ev.Type {
+		case orderbook.OrderExecuted, orderbook.TradeBusted, orderbook.TradeCorrected:
+			f.fees[ev.Aggressor] = ev.Fee
+		}
+	}))
+	return f
+}
+
+// trade matches a resting sell of maker against a buy of taker and returns
+// the fees of both.
+func (f *fixture) trade(t *testing.T, symbol engine.Symbol, maker, taker string, price orderbook.Price, quantity orderbook.Quantity) (int64, int64) {
+	t.Helper()
+	for _, o := range []struct {
+		account string
+		side    orderbook.Side
+	}{{maker, orderbook.Sell}, {taker, orderbook.Buy}} {
+		f.calculator.Assign(f.nextId, o.account)
+		_, err := f.engine.AddOrder(symbol, orderbook.NewOrder(orderbook.GoodTillCancel, f.nextId, o.side, price, quantity))
+		require.NoError(t, err)
+		f.nextId++
+	}
+	return f.fees[false], f.fees[true]
+}
+
+func TestSchedules(t *testing.T) {
+	f := newFixture(t, Config{Schedules: map[string]map[engine.Symbol]Schedule{
+		Any:   {Any: {{Maker: -20, Taker: 30}}, "OTHER": {{Maker: 0, Taker: 50}}},
+		"mm":  {Any: {{Maker: -30, Taker: 25}}},
+		"vip": {"DEMO": {{Maker: -40, Taker: 10}}},
+	}})
+
+	maker, taker := f.trade(t, "DEMO", "a", "b", 100, 10)
+	assert.Equal(t, int64(-20*1000), maker)
+	assert.Equal(t, int64(30*1000), taker)
+
+	maker, taker = f.trade(t, "OTHER", "a", "mm", 100, 10)
+	assert.Zero(t, maker)
+	assert.Equal(t, int64(25*1000), taker)
+
+	// The account takes precedence over the symbol.
+	maker, taker = f.trade(t, "OTHER", "vip", "mm", 100, 10)
+	assert.Equal(t, int64(0), maker)
+	assert.Equal(t, int64(25*1000), taker)
+	maker, _ = f.trade(t, "DEMO", "vip", "a", 100, 10)
+	assert.Equal(t, int64(-40*1000), maker)
+
+	assert.Equal(t, Total{Volume: 30, Maker: -20 * 1000, Taker: 30 * 1000}, f.calculator.Total("a"))
+	assert.Equal(t, Total{Volume: 20, Taker: 50 * 1000}, f.calculator.Total("mm"))
+}
+
+func TestTiers(t *testing.T) {
+	f := newFixture(t, Config{Schedules: map[string]map[engine.Symbol]Schedule{
+		Any: {Any: {
+			{MinVolume: 1000, Maker: -25, Taker: 20},
+			{MinVolume: 0, Maker: -10, Taker: 30},
+			{MinVolume: 100, Maker: -20, Taker: 25},
+		}},
+	}})
+
+	_, taker := f.trade(t, "DEMO", "a", "b", 10, 100)
+	assert.Equal(t, int64(10*100*30), taker)
+
+	// The fill that reaches a tier is still charged at the one below.
+	_, taker = f.trade(t, "DEMO", "a", "b", 10, 900)
+	assert.Equal(t, int64(10*900*25), taker)
+	tier, ok := f.calculator.Tier("b", "DEMO")
+	assert.True(t, ok)
+	assert.Equal(t, int64(20), tier.Taker)
+
+	// Volume older than the window no longer counts.
+	f.now = f.now.Add(29 * 24 * time.Hour)
+	_, taker = f.trade(t, "DEMO", "a", "b", 10, 1)
+	assert.Equal(t, int64(10*20), taker)
+	assert.Equal(t, uint64(1001), f.calculator.Volume("b"))
+	f.now = f.now.Add(24 * time.Hour)
+	assert.Equal(t, uint64(1), f.calculator.Volume("b"))
+	_, taker = f.trade(t, "DEMO", "a", "b", 10, 1)
+	assert.Equal(t, int64(10*30), taker)
+}
+
+func TestNoSchedule(t *testing.T) {
+	f := newFixture(t, Config{Schedules: map[string]map[engine.Symbol]Schedule{
+		"a": {Any: {{MinVolume: 10, Maker: -10, Taker: 10}}},
+	}})
+	maker, taker := f.trade(t, "DEMO", "a", "b", 100, 5)
+	assert.Zero(t, maker)
+	assert.Zero(t, taker)
+	_, ok := f.calculator.Tier("b", "DEMO")
+	assert.False(t, ok)
+}
+
+func TestPriceImprovement(t *testing.T) {
+	f := newFixture(t, Config{Schedules: map[string]map[engine.Symbol]Schedule{
+		Any: {Any: {{Maker: -20, Taker: 30}}},
+	}})
+	f.calculator.Assign(1, "a")
+	_, err := f.engine.AddOrder("DEMO", orderbook.NewOrder(orderbook.GoodTillCancel, 1, orderbook.Sell, 100, 10))
+	require.NoError(t, err)
+	// The buy is limited at 105 but trades at the resting 100.
+	f.calculator.Assign(2, "b")
+	_, err = f.engine.AddOrder("DEMO", orderbook.NewOrder(orderbook.GoodTillCancel, 2, orderbook.Buy, 105, 10))
+	require.NoError(t, err)
+	assert.Equal(t, int64(-20*1000), f.fees[false])
+	assert.Equal(t, int64(30*1000), f.fees[true])
+	assert.Equal(t, Total{Volume: 10, Taker: 30 * 1000}, f.calculator.Total("b"))
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fees.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"schedules":{"*":{"DEMO":[
+		{"min_volume":0,"maker_rate":-20,"taker_rate":30}
+	]}}}`), 0o644))
+	cfg, err := LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, Schedule{{Maker: -20, Taker: 30}}, cfg.Schedules[Any]["DEMO"])
+
+	require.NoError(t, os.WriteFile(path, []byte(`{`), 0o644))
+	_, err = LoadConfig(path)
+	assert.ErrorContains(t, err, path)
+}
+
+func TestCorrections(t *testing.T) {
+	f := newFixture(t, Config{Schedules: map[string]map[engine.Symbol]Schedule{
+		Any: {Any: {{Maker: -20, Taker: 30}}},
+	}})
+	f.trade(t, "DEMO", "a", "b", 100, 10)
+	f.trade(t, "DEMO", "a", "b", 100, 5)
+
+	// A correction charges the difference at the original rates.
+	_, err := f.engine.CorrectTrade("DEMO", 1, 110, "ops", "")
+	require.NoError(t, err)
+	assert.Equal(t, int64(-20*100), f.fees[false])
+	assert.Equal(t, int64(30*100), f.fees[true])
+	assert.Equal(t, Total{Volume: 15, Taker: 30 * 1600}, f.calculator.Total("b"))
+
+	// A bust refunds the fees and the volume.
+	_, err = f.engine.BustTrade("DEMO", 1, "ops", "")
+	require.NoError(t, err)
+	assert.Equal(t, int64(20*1100), f.fees[false])
+	assert.Equal(t, int64(-30*1100), f.fees[true])
+	assert.Equal(t, Total{Volume: 5, Taker: 30 * 500}, f.calculator.Total("b"))
+	assert.Equal(t, Total{Volume: 5, Maker: -20 * 500}, f.calculator.Total("a"))
+	assert.Equal(t, uint64(5), f.calculator.Volume("b"))
+}
+
+func TestReusedOrderId(t *testing.T) {
+	f := newFixture(t, Config{Schedules: map[string]map[engine.Symbol]Schedule{
+		"a": {Any: {{Maker: -20, Taker: 30}}},
+	}})
+	f.trade(t, "DEMO", "a", "b", 100, 10)
+
+	// Once an order left the book its id is no longer charged to its
+	// account, even when reused by an order without one.
+	_, err := f.engine.AddOrder("DEMO", orderbook.NewOrder(orderbook.GoodTillCancel, 1, orderbook.Sell, 100, 5))
+	require.NoError(t, err)
+	f.calculator.Assign(3, "c")
+	_, err = f.engine.AddOrder("DEMO", orderbook.NewOrder(orderbook.GoodTillCancel, 3, orderbook.Buy, 100, 5))
+	require.NoError(t, err)
+	assert.Zero(t, f.fees[false])
+	assert.Equal(t, Total{Volume: 10, Maker: -20 * 1000}, f.calculator.Total("a"))
+	assert.Equal(t, Total{Volume: 5}, f.calculator.Total(""))
+}
+
+func TestRetention(t *testing.T) {
+	f := newFixture(t, Config{Schedules: map[string]map[engine.Symbol]Schedule{
+		Any: {Any: {{Maker: -20, Taker: 30}}},
+	}})
+	f.trade(t, "DEMO", "a", "b", 100, 10)
+	f.now = f.now.Add(engine.TradeRetention)
+	f.trade(t, "DEMO", "a", "b", 100, 5)
+
+	// The charges of the first trade are forgotten, so it is no longer
+	// refunded.
+	assert.Len(t, f.calculator.charges, 2)
+	assert.Len(t, f.calculator.expiring, 2)
+	_, err := f.engine.BustTrade("DEMO", 1, "ops", "")
+	require.NoError(t, err)
+	assert.Equal(t, Total{Volume: 15, Taker: 30 * 1500}, f.calculator.Total("b"))
+}
@@ -0,0 +1,78 @@
+package fee
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-orderbook/pkg/engine"
+	"os"
+)
+
+// Any matches every account or every symbol in a Config.
+const Any = "*"
+
+// RateScale is the number of rate units in the whole notional of a fill, so
+// a rate of 100 is one basis point. A rate times a notional in price ticks
+// times shares is a fee in engine.FeeScale units.
+const RateScale = engine.FeeScale
+
+// Tier holds the rates of an account whose volume over the last Window is
+// at least MinVolume shares. Negative rates are rebates.
+type Tier struct {
+	MinVolume uint64 `json:"min_volume"`
+	Maker     int64  `json:"maker_rate"`
+	Taker     int64  `json:"taker_rate"`
+}
+
+// Schedule is a list of tiers. The tier with the highest MinVolume the
+// account reaches applies; below every tier nothing is charged.
+type Schedule []Tier
+
+func (s Schedule) tier(volume uint64) (Tier, bool) {
+	var (
+		tier  Tier
+		found bool
+	)
+	for _, t := range s {
+		if t.MinVolume <= volume && (!found || t.MinVolume >= tier.MinVolume) {
+			tier, found = t, true
+		}
+	}
+	return tier, found
+}
+
+// Config holds the fee schedules keyed by account and then symbol. Either
+// key can be Any. The most specific entry applies, the account taking
+// precedence over the symbol.
+type Config struct {
+	Schedules map[string]map[engine.Symbol]Schedule `json:"schedules"`
+}
+
+// LoadConfig reads a Config from a JSON file.
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func (c *Config) schedule(account string, symbol engine.Symbol) Schedule {
+	for _, k := range []struct {
+		account string
+		symbol  engine.Symbol
+	}{
+		{account, symbol},
+		{account, Any},
+		{Any, symbol},
+		{Any, Any},
+	} {
+		if s, ok := c.Schedules[k.account][k.symbol]; ok {
+			return s
+		}
+	}
+	return nil
+}
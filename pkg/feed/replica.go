@@ -0,0 +1,191 @@
+// Package feed consumes the order-by-order events published by an Orderbook.
+package feed
+
+import (
+	"fmt"
+	"go-orderbook/pkg/ds/list"
+	"go-orderbook/pkg/orderbook"
+	"sort"
+)
+
+// QueuedOrder is a resting order as seen by a feed consumer.
+type QueuedOrder struct {
+	OrderId  orderbook.OrderId
+	Quantity orderbook.Quantity
+}
+
+type levelKey struct {
+	side  orderbook.Side
+	price orderbook.Price
+}
+
+type replicaEntry struct {
+	key      levelKey
+	location list.Element[*QueuedOrder]
+}
+
+// Replica rebuilds the exact queue at every price level of a book from the
+// events it publishes. It implements orderbook.Listener, so it can be attached
+// directly to a book with AddListener.
+type Replica struct {
+	orders   map[orderbook.OrderId]replicaEntry
+	levels   map[levelKey]*list.LinkedList[*QueuedOrder]
+	sequence uint64
+	err      error
+}
+
+func NewReplica() *Replica {
+	return &Replica{
+		orders: make(map[orderbook.OrderId]replicaEntry),
+		levels: make(map[levelKey]*list.LinkedList[*QueuedOrder]),
+	}
+}
+
+// OnEvent applies the event, keeping the first error encountered so that it
+// can be inspected later with Err.
+func (r *Replica) OnEvent(e orderbook.Event) {
+	if err := r.Apply(e); err != nil && r.err == nil {
+		r.err = err
+	}
+}
+
+// Err returns the first error encountered by OnEvent.
+func (r *Replica) Err() error {
+	return r.err
+}
+
+// Sequence returns the sequence number of the last applied event.
+func (r *Replica) Sequence() uint64 {
+	return r.sequence
+}
+
+// Apply updates the replica with a single event. Events must be applied in
+// sequence order without gaps. Trade busts and corrections leave the book
+// untouched, so they only advance the sequence.
+func (r *Replica) Apply(e orderbook.Event) error {
+	if e.Sequence != r.sequence+1 {
+		return fmt.Errorf(
+			"sequence gap: expected %d, got %d",
+			r.sequence+1,
+			e.Sequence,
+		)
+	}
+	r.sequence = e.Sequence
+
+	switch e.Type {
+	case orderbook.OrderAdded:
+		return r.add(e)
+	case orderbook.TradeBusted, orderbook.TradeCorrected:
+		return nil
+	}
+
+	entry, exists := r.orders[e.OrderId]
+	if !exists {
+		return fmt.Errorf("%s event for unknown order %d", e.Type, e.OrderId)
+	}
+	order := entry.location.Value()
+
+	switch e.Type {
+	case orderbook.OrderExecuted, orderbook.OrderReduced:
+		if e.Quantity > order.Quantity {
+			return fmt.Errorf(
+				"%s event for order %d exceeds its quantity",
+				e.Type,
+				e.OrderId,
+			)
+		}
+		order.Quantity -= e.Quantity
+		if order.Quantity == 0 {
+			r.remove(e.OrderId, entry)
+		}
+	case orderbook.OrderDeleted:
+		r.remove(e.OrderId, entry)
+	default:
+		return fmt.Errorf("unknown event type %d", e.Type)
+	}
+	return nil
+}
+
+func (r *Replica) add(e orderbook.Event) error {
+	if _, exists := r.orders[e.OrderId]; exists {
+		return fmt.Errorf("order %d added twice", e.OrderId)
+	}
+
+	key := levelKey{side: e.Side, price: e.Price}
+	orders, exists := r.levels[key]
+	if !exists {
+		orders = list.NewLinkedList[*QueuedOrder]()
+		r.levels[key] = orders
+	}
+	if orders.Size() != e.Position {
+		return fmt.Errorf(
+			"order %d added at position %d, expected %d",
+			e.OrderId,
+			e.Position,
+			orders.Size(),
+		)
+	}
+
+	r.orders[e.OrderId] = replicaEntry{
+		key: key,
+		location: orders.AppendElement(&QueuedOrder{
+			OrderId:  e.OrderId,
+			Quantity: e.Quantity,
+		}),
+	}
+	return nil
+}
+
+func (r *Replica) remove(orderId orderbook.OrderId, entry replicaEntry) {
+	orders := r.levels[entry.key]
+	orders.Remove(entry.location)
+	if orders.IsEmpty() {
+		delete(r.levels, entry.key)
+	}
+	delete(r.orders, orderId)
+}
+
+// Queue returns the resting orders at a price level in time priority.
+func (r *Replica) Queue(side orderbook.Side, price orderbook.Price) []QueuedOrder {
+	orders, exists := r.levels[levelKey{side: side, price: price}]
+	if !exists {
+		return nil
+	}
+
+	queue := make([]QueuedOrder, 0, orders.Size())
+	it := orders.Iterator()
+	for order, ok := it.Next(); ok; order, ok = it.Next() {
+		queue = append(queue, *order)
+	}
+	return queue
+}
+
+// Levels aggregates the queues on one side into price levels, best first, in
+// the same form as Orderbook.OrderInfo.
+func (r *Replica) Levels(side orderbook.Side) orderbook.LevelsInfo {
+	var levels orderbook.LevelsInfo
+	for key, orders := range r.levels {
+		if key.side != side {
+			continue
+		}
+		l := orderbook.LevelInfo{Price: key.price}
+		it := orders.Iterator()
+		for order, ok := it.Next(); ok; order, ok = it.Next() {
+			l.Quantity += order.Quantity
+		}
+		levels = append(levels, l)
+	}
+
+	sort.Slice(levels, func(i, j int) bool {
+		if side == orderbook.Buy {
+			return levels[i].Price > levels[j].Price
+		}
+		return levels[i].Price < levels[j].Price
+	})
+	return levels
+}
+
+// Size returns the number of resting orders.
+func (r *Replica) Size() int {
+	return len(r.orders)
+}
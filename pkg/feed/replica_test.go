@@ -0,0 +1,60 @@
+package feed
+
+import (
+	"go-orderbook/pkg/orderbook"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplica(t *testing.T) {
+	ob := orderbook.NewOrderbook()
+	replica := NewReplica()
+	ob.AddListener(replica)
+
+	var modify orderbook.OrderModify
+	add := func(id orderbook.OrderId, side orderbook.Side, price orderbook.Price, quantity orderbook.Quantity) {
+		_, err := ob.AddOrder(orderbook.NewOrder(orderbook.GoodTillCancel, id, side, price, quantity))
+		assert.NoError(t, err)
+	}
+
+	add(1, orderbook.Sell, 101, 10)
+	add(2, orderbook.Sell, 101, 20)
+	add(3, orderbook.Sell, 102, 5)
+	add(4, orderbook.Buy, 99, 7)
+	add(5, orderbook.Buy, 99, 8)
+
+	// Partially execute the ask queue at 101
+	add(6, orderbook.Buy, 101, 15)
+	assert.Equal(t, []QueuedOrder{{OrderId: 2, Quantity: 15}}, replica.Queue(orderbook.Sell, 101))
+
+	// Reduce in place keeps priority, a price change goes to the back
+	_, err := ob.ModifyOrder(modify.New(4, 99, orderbook.Buy, 3))
+	assert.NoError(t, err)
+	add(7, orderbook.Buy, 99, 1)
+	_, err = ob.ModifyOrder(modify.New(5, 98, orderbook.Buy, 8))
+	assert.NoError(t, err)
+	assert.Equal(t,
+		[]QueuedOrder{{OrderId: 4, Quantity: 3}, {OrderId: 7, Quantity: 1}},
+		replica.Queue(orderbook.Buy, 99),
+	)
+
+	assert.NoError(t, ob.CancelOrder(3))
+
+	// A bust takes a sequence number but leaves the queues untouched
+	ob.Publish(orderbook.Event{Type: orderbook.TradeBusted, OrderId: 6, MatchId: 1})
+	add(8, orderbook.Buy, 97, 2)
+	assert.Equal(t, []QueuedOrder{{OrderId: 8, Quantity: 2}}, replica.Queue(orderbook.Buy, 97))
+
+	assert.NoError(t, replica.Err())
+	assert.Equal(t, ob.Size(), replica.Size())
+	info := ob.OrderInfo()
+	assert.Equal(t, info.GetBids(), replica.Levels(orderbook.Buy))
+	assert.Equal(t, info.GetAsks(), replica.Levels(orderbook.Sell))
+}
+
+func TestReplicaSequenceGap(t *testing.T) {
+	replica := NewReplica()
+	err := replica.Apply(orderbook.Event{Type: orderbook.OrderAdded, Sequence: 2})
+	assert.Error(t, err)
+}
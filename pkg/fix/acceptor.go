@@ -0,0 +1,148 @@
+package fix
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"time"
+)
+
+// logonTimeout bounds the wait for the first message of a new connection.
+const logonTimeout = 10 * time.Second
+
+// Acceptor accepts FIX connections addressed to its CompID and runs a
+// Session for every counterparty.
+type Acceptor struct {
+	compID string
+	app    Application
+	stores StoreFactory
+
+	m        sync.Mutex
+	sessions map[SessionID]*Session
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	wg       sync.WaitGroup
+}
+
+func NewAcceptor(compID string, app Application, stores StoreFactory) *Acceptor {
+	return &Acceptor{
+		compID:   compID,
+		app:      app,
+		stores:   stores,
+		sessions: make(map[SessionID]*Session),
+		conns:    make(map[net.Conn]struct{}),
+	}
+}
+
+// Listen starts accepting connections on addr in the background.
+func (a *Acceptor) Listen(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	a.m.Lock()
+	a.listener = l
+	a.m.Unlock()
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			a.m.Lock()
+			a.conns[conn] = struct{}{}
+			a.m.Unlock()
+
+			a.wg.Add(1)
+			go func() {
+				defer a.wg.Done()
+				a.handle(conn)
+				a.m.Lock()
+				delete(a.conns, conn)
+				a.m.Unlock()
+			}()
+		}
+	}()
+	return nil
+}
+
+// Addr returns the address the acceptor is listening on.
+func (a *Acceptor) Addr() net.Addr {
+	a.m.Lock()
+	defer a.m.Unlock()
+	return a.listener.Addr()
+}
+
+// Close stops accepting connections and drops every open connection.
+func (a *Acceptor) Close() error {
+	a.m.Lock()
+	err := a.listener.Close()
+	for conn := range a.conns {
+		conn.Close()
+	}
+	a.m.Unlock()
+
+	a.wg.Wait()
+	return err
+}
+
+// Session returns a session that has logged on at least once.
+func (a *Acceptor) Session(id SessionID) (*Session, bool) {
+	a.m.Lock()
+	defer a.m.Unlock()
+	s, ok := a.sessions[id]
+	return s, ok
+}
+
+func (a *Acceptor) session(id SessionID) (*Session, error) {
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	if s, ok := a.sessions[id]; ok {
+		return s, nil
+	}
+	store, err := a.stores.Open(id)
+	if err != nil {
+		return nil, err
+	}
+	s := &Session{id: id, store: store, app: a.app}
+	a.sessions[id] = s
+	return s, nil
+}
+
+func (a *Acceptor) handle(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(logonTimeout))
+	msg, err := ReadMessage(r)
+	if err != nil || msg.MsgType() != MsgLogon {
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	target, _ := msg.Get(TagTargetCompID)
+	sender, _ := msg.Get(TagSenderCompID)
+	if target != a.compID || sender == "" {
+		return
+	}
+
+	s, err := a.session(SessionID{SenderCompID: a.compID, TargetCompID: sender})
+	if err != nil {
+		return
+	}
+	if err := s.logon(conn, msg); err != nil {
+		s.disconnect(conn)
+		return
+	}
+	a.app.OnLogon(s)
+
+	s.run(conn, r)
+
+	s.disconnect(conn)
+	a.app.OnLogout(s)
+}
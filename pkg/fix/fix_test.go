@@ -0,0 +1,261 @@
+package fix
+
+import (
+	"bufio"
+	"bytes"
+	"go-orderbook/pkg/engine"
+	"go-orderbook/pkg/fee"
+	"go-orderbook/pkg/orderbook"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testClient is a minimal initiator used to drive the acceptor over loopback.
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	r      *bufio.Reader
+	compID string
+	seqNum int
+}
+
+func dial(t *testing.T, addr net.Addr, compID string, seqNum int) *testClient {
+	conn, err := net.Dial("tcp", addr.String())
+	require.NoError(t, err)
+	return &testClient{t: t, conn: conn, r: bufio.NewReader(conn), compID: compID, seqNum: seqNum}
+}
+
+func (c *testClient) send(msg *Message) {
+	full := msg.withHeader(
+		Field{Tag: TagSenderCompID, Value: c.compID},
+		Field{Tag: TagTargetCompID, Value: "EXCH"},
+		Field{Tag: TagMsgSeqNum, Value: strconv.Itoa(c.seqNum)},
+		Field{Tag: TagSendingTime, Value: time.Now().UTC().Format(timeFormat)},
+	)
+	c.seqNum++
+	_, err := c.conn.Write(full.Bytes())
+	require.NoError(c.t, err)
+}
+
+func (c *testClient) expect(msgType string) *Message {
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	msg, err := ReadMessage(c.r)
+	require.NoError(c.t, err)
+	require.Equal(c.t, msgType, msg.MsgType(), msg.String())
+	return msg
+}
+
+func (c *testClient) logon() *Message {
+	c.send(NewMessage(MsgLogon).Set(TagEncryptMethod, "0").SetInt(TagHeartBtInt, 30))
+	return c.expect(MsgLogon)
+}
+
+func get(msg *Message, tag int) string {
+	v, _ := msg.Get(tag)
+	return v
+}
+
+func newOrder(clOrdID, side, qty, price string) *Message {
+	return NewMessage(MsgNewOrderSingle).
+		Set(TagClOrdID, clOrdID).
+		Set(TagSymbol, "AAPL").
+		Set(TagSide, side).
+		Set(TagOrderQty, qty).
+		Set(TagOrdType, ordTypeLimit).
+		Set(TagPrice, price).
+		Set(TagTimeInForce, tifGTC)
+}
+
+func startAcceptor(t *testing.T, dir string, e *engine.Engine) *Acceptor {
+	g := NewGateway(e, 100)
+	t.Cleanup(g.Close)
+	a := NewAcceptor("EXCH", g, FileStoreFactory{Dir: dir})
+	require.NoError(t, a.Listen("127.0.0.1:0"))
+	return a
+}
+
+func TestGateway(t *testing.T) {
+	e := engine.NewEngine()
+	require.NoError(t, e.List("AAPL"))
+	e.UseFees(fee.NewCalculator(fee.Config{Schedules: map[string]map[engine.Symbol]fee.Schedule{
+		fee.Any: {fee.Any: {{Maker: -20, Taker: 30}}},
+	}}))
+	dir := t.TempDir()
+	a := startAcceptor(t, dir, e)
+
+	alice := dial(t, a.Addr(), "ALICE", 1)
+	alice.logon()
+	alice.send(newOrder("a1", sideSell, "10", "100.5"))
+	report := alice.expect(MsgExecutionReport)
+	assert.Equal(t, execTypeNew, get(report, TagExecType))
+	assert.Equal(t, "100.5", get(report, TagPrice))
+
+	bob := dial(t, a.Addr(), "BOB", 1)
+	bob.logon()
+	bob.send(newOrder("b1", sideBuy, "4", "100.50"))
+	assert.Equal(t, execTypeNew, get(bob.expect(MsgExecutionReport), TagExecType))
+	report = bob.expect(MsgExecutionReport)
+	assert.Equal(t, execTypeTrade, get(report, TagExecType))
+	assert.Equal(t, ordStatusFilled, get(report, TagOrdStatus))
+	assert.Equal(t, "4", get(report, TagLastQty))
+	assert.Equal(t, lastLiquidityRemoved, get(report, TagLastLiquidityInd))
+	assert.Equal(t, "0.01206", get(report, TagCommission))
+	assert.Equal(t, commTypeAbsolute, get(report, TagCommType))
+
+	// The resting order is told about the passive fill
+	report = alice.expect(MsgExecutionReport)
+	assert.Equal(t, execTypeTrade, get(report, TagExecType))
+	assert.Equal(t, ordStatusPartiallyFilled, get(report, TagOrdStatus))
+	assert.Equal(t, "6", get(report, TagLeavesQty))
+	assert.Equal(t, lastLiquidityAdded, get(report, TagLastLiquidityInd))
+	assert.Equal(t, "-0.00804", get(report, TagCommission))
+
+	// OrderQty of a replace includes the filled quantity
+	alice.send(newOrder("a2", sideSell, "8", "101").
+		Set(TagMsgType, MsgOrderCancelReplaceRequest).
+		Set(TagOrigClOrdID, "a1"))
+	report = alice.expect(MsgExecutionReport)
+	assert.Equal(t, execTypeReplaced, get(report, TagExecType))
+	assert.Equal(t, "4", get(report, TagLeavesQty))
+	assert.Equal(t, "a1", get(report, TagOrigClOrdID))
+
+	alice.send(NewMessage(MsgOrderCancelRequest).
+		Set(TagClOrdID, "a3").
+		Set(TagOrigClOrdID, "a1").
+		Set(TagSymbol, "AAPL").
+		Set(TagSide, sideSell))
+	reject := alice.expect(MsgOrderCancelReject)
+	assert.Equal(t, cxlRejReasonUnknownOrder, get(reject, TagCxlRejReason))
+
+	alice.send(NewMessage(MsgOrderCancelRequest).
+		Set(TagClOrdID, "a4").
+		Set(TagOrigClOrdID, "a2").
+		Set(TagSymbol, "AAPL").
+		Set(TagSide, sideSell))
+	report = alice.expect(MsgExecutionReport)
+	assert.Equal(t, execTypeCanceled, get(report, TagExecType))
+	assert.Equal(t, 0, e.Size())
+
+	alice.send(newOrder("a5", sideSell, "1", "100").Set(TagSymbol, "MSFT"))
+	report = alice.expect(MsgExecutionReport)
+	assert.Equal(t, execTypeRejected, get(report, TagExecType))
+	assert.Equal(t, ordRejReasonUnknownSymbol, get(report, TagOrdRejReason))
+
+	alice.send(NewMessage(MsgTestRequest).Set(TagTestReqID, "T1"))
+	assert.Equal(t, "T1", get(alice.expect(MsgHeartbeat), TagTestReqID))
+
+	// A gap is answered with a ResendRequest, and filled by a gap fill
+	alice.seqNum += 2
+	alice.send(NewMessage(MsgHeartbeat))
+	request := alice.expect(MsgResendRequest)
+	begin, _ := request.GetInt(TagBeginSeqNo)
+	alice.seqNum = begin
+	alice.send(NewMessage(MsgSequenceReset).
+		Set(TagGapFillFlag, "Y").
+		Set(TagPossDupFlag, "Y").
+		SetInt(TagNewSeqNo, begin+3))
+	alice.seqNum = begin + 3
+	alice.send(NewMessage(MsgTestRequest).Set(TagTestReqID, "T2"))
+	assert.Equal(t, "T2", get(alice.expect(MsgHeartbeat), TagTestReqID))
+
+	alice.send(NewMessage(MsgLogout))
+	alice.expect(MsgLogout)
+	alice.conn.Close()
+	bob.conn.Close()
+	assert.NoError(t, a.Close())
+
+	// Sequence numbers survive a restart, and sent reports can be resent
+	a = startAcceptor(t, dir, e)
+	defer a.Close()
+	alice = dial(t, a.Addr(), "ALICE", alice.seqNum)
+	logon := alice.logon()
+	seqNum, _ := logon.GetInt(TagMsgSeqNum)
+	assert.Greater(t, seqNum, 8)
+
+	alice.send(NewMessage(MsgResendRequest).SetInt(TagBeginSeqNo, 1).SetInt(TagEndSeqNo, 0))
+	gap := alice.expect(MsgSequenceReset)
+	assert.Equal(t, "Y", get(gap, TagGapFillFlag))
+	assert.Equal(t, "2", get(gap, TagNewSeqNo))
+	resent := alice.expect(MsgExecutionReport)
+	assert.Equal(t, "2", get(resent, TagMsgSeqNum))
+	assert.Equal(t, "Y", get(resent, TagPossDupFlag))
+	assert.Equal(t, execTypeNew, get(resent, TagExecType))
+}
+
+func TestPriceImprovement(t *testing.T) {
+	e := engine.NewEngine()
+	require.NoError(t, e.List("AAPL"))
+	a := startAcceptor(t, t.TempDir(), e)
+	defer a.Close()
+
+	alice := dial(t, a.Addr(), "ALICE", 1)
+	defer alice.conn.Close()
+	alice.logon()
+	alice.send(newOrder("a1", sideSell, "10", "100"))
+	alice.expect(MsgExecutionReport)
+
+	// The buy is limited at 105 and fills at the resting 100.
+	alice.send(newOrder("a2", sideBuy, "4", "105"))
+	assert.Equal(t, execTypeNew, get(alice.expect(MsgExecutionReport), TagExecType))
+	for i := 0; i < 2; i++ {
+		report := alice.expect(MsgExecutionReport)
+		assert.Equal(t, execTypeTrade, get(report, TagExecType))
+		assert.Equal(t, "100", get(report, TagLastPx))
+		assert.Equal(t, "100", get(report, TagAvgPx))
+	}
+}
+
+func TestRestingFill(t *testing.T) {
+	e := engine.NewEngine()
+	require.NoError(t, e.List("AAPL"))
+	a := startAcceptor(t, t.TempDir(), e)
+	defer a.Close()
+
+	alice := dial(t, a.Addr(), "ALICE", 1)
+	defer alice.conn.Close()
+	alice.logon()
+	alice.send(newOrder("a1", sideSell, "10", "100"))
+	alice.expect(MsgExecutionReport)
+
+	// A fill by an order entered outside the gateway is reported without
+	// waiting for the next message of the session.
+	_, err := e.AddOrder("AAPL", orderbook.NewOrder(orderbook.GoodTillCancel, 1000, orderbook.Buy, 100*100, 4))
+	require.NoError(t, err)
+	report := alice.expect(MsgExecutionReport)
+	assert.Equal(t, execTypeTrade, get(report, TagExecType))
+	assert.Equal(t, "4", get(report, TagLastQty))
+	assert.Equal(t, "6", get(report, TagLeavesQty))
+}
+
+func TestLogonSeqNumTooLow(t *testing.T) {
+	e := engine.NewEngine()
+	a := startAcceptor(t, t.TempDir(), e)
+	defer a.Close()
+
+	c := dial(t, a.Addr(), "ALICE", 1)
+	c.logon()
+	c.send(NewMessage(MsgLogout))
+	c.expect(MsgLogout)
+	c.conn.Close()
+
+	c = dial(t, a.Addr(), "ALICE", 1)
+	c.send(NewMessage(MsgLogon).Set(TagEncryptMethod, "0").SetInt(TagHeartBtInt, 30))
+	c.expect(MsgLogout)
+}
+
+func TestReadMessage(t *testing.T) {
+	msg := NewMessage(MsgNewOrderSingle).Set(TagClOrdID, "1").Set(TagSymbol, "AAPL")
+	parsed, err := ReadMessage(bufio.NewReader(bytes.NewReader(msg.Bytes())))
+	require.NoError(t, err)
+	assert.Equal(t, msg.Fields(), parsed.Fields())
+
+	garbled := msg.Bytes()
+	garbled[len(garbled)-9] = 'X'
+	_, err = ReadMessage(bufio.NewReader(bytes.NewReader(garbled)))
+	assert.ErrorIs(t, err, ErrGarbled)
+}
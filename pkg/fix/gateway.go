@@ -0,0 +1,555 @@
+package fix
+
+import (
+	"errors"
+	"fmt"
+	"go-orderbook/pkg/engine"
+	"go-orderbook/pkg/orderbook"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Values of the enumerated fields used by the gateway.
+const (
+	sideBuy  = "1"
+	sideSell = "2"
+
+	ordTypeMarket = "1"
+	ordTypeLimit  = "2"
+
+	tifDay = "0"
+	tifGTC = "1"
+	tifIOC = "3"
+	tifFOK = "4"
+
+	execTypeNew      = "0"
+	execTypeCanceled = "4"
+	execTypeReplaced = "5"
+	execTypeRejected = "8"
+	execTypeTrade    = "F"
+
+	ordStatusNew             = "0"
+	ordStatusPartiallyFilled = "1"
+	ordStatusFilled          = "2"
+	ordStatusCanceled        = "4"
+	ordStatusRejected        = "8"
+
+	cxlRejResponseToCancel  = "1"
+	cxlRejResponseToReplace = "2"
+
+	cxlRejReasonTooLate      = "0"
+	cxlRejReasonUnknownOrder = "1"
+
+	ordRejReasonUnknownSymbol = "1"
+	ordRejReasonDuplicate     = "6"
+	ordRejReasonOther         = "99"
+
+	commTypeAbsolute = "3"
+
+	lastLiquidityAdded   = "1"
+	lastLiquidityRemoved = "2"
+)
+
+type clOrdKey struct {
+	session SessionID
+	clOrdID string
+}
+
+// orderState is the FIX view of an order entered through the gateway.
+type orderState struct {
+	session  *Session
+	orderId  orderbook.OrderId
+	clOrdID  string
+	symbol   engine.Symbol
+	side     orderbook.Side
+	price    orderbook.Price
+	orderQty orderbook.Quantity
+	cumQty   orderbook.Quantity
+	leaves   orderbook.Quantity
+	notional int64
+}
+
+type pendingEvent struct {
+	symbol engine.Symbol
+	event  orderbook.Event
+}
+
+// Gateway is the FIX Application that maps NewOrderSingle,
+// OrderCancelRequest and OrderCancelReplaceRequest onto an engine.Engine and
+// reports the outcome with ExecutionReport and OrderCancelReject.
+//
+// FIX prices are decimal; the engine works in integer ticks of
+// 1/PriceScale.
+type Gateway struct {
+	engine     *engine.Engine
+	priceScale int
+
+	// m serializes commands, so that the reports of one command are sent
+	// before those of the next.
+	m           sync.Mutex
+	orders      map[orderbook.OrderId]*orderState
+	clOrdIDs    map[clOrdKey]orderbook.OrderId
+	nextOrderId orderbook.OrderId
+	nextExecId  uint64
+	// suppress is the order being cancelled or replaced by the current
+	// command, whose book events are reported by the command itself rather
+	// than by drain.
+	suppress orderbook.OrderId
+
+	// pendingM guards pending, which is appended to by the engine listener
+	// while a book is locked.
+	pendingM sync.Mutex
+	pending  []pendingEvent
+	// wake is signalled when a command has published its events, for run to
+	// report those of commands that did not come through FromApp. done stops
+	// run.
+	wake chan struct{}
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// gatewayListener collects the book events for a Gateway.
+type gatewayListener struct {
+	g *Gateway
+}
+
+func (l gatewayListener) OnEvent(symbol engine.Symbol, ev orderbook.Event) {
+	l.g.pendingM.Lock()
+	l.g.pending = append(l.g.pending, pendingEvent{symbol: symbol, event: ev})
+	l.g.pendingM.Unlock()
+}
+
+func (l gatewayListener) OnCommandEnd(symbol engine.Symbol) {
+	select {
+	case l.g.wake <- struct{}{}:
+	default:
+	}
+}
+
+// NewGateway creates a gateway on an engine. Orders entered through the
+// gateway are given engine order ids from its own counter, skipping ids
+// already in use. The gateway reports fills and cancels caused by other users
+// of the engine from its own goroutine until Close.
+func NewGateway(e *engine.Engine, priceScale int) *Gateway {
+	g := &Gateway{
+		engine:      e,
+		priceScale:  priceScale,
+		orders:      make(map[orderbook.OrderId]*orderState),
+		clOrdIDs:    make(map[clOrdKey]orderbook.OrderId),
+		nextOrderId: 1,
+		wake:        make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+	e.AddListener(gatewayListener{g: g})
+	g.wg.Add(1)
+	go g.run()
+	return g
+}
+
+// Close stops reporting the events of commands that did not come through the
+// gateway.
+func (g *Gateway) Close() {
+	close(g.done)
+	g.wg.Wait()
+}
+
+// run drains the pending events whenever a command ends. Those of a command
+// entered through FromApp are drained by FromApp itself, after its own
+// reports, since run waits for g.m.
+func (g *Gateway) run() {
+	defer g.wg.Done()
+	for {
+		select {
+		case <-g.wake:
+			g.m.Lock()
+			g.drain()
+			g.m.Unlock()
+		case <-g.done:
+			return
+		}
+	}
+}
+
+func (g *Gateway) OnLogon(s *Session)  {}
+func (g *Gateway) OnLogout(s *Session) {}
+
+func (g *Gateway) FromApp(s *Session, msg *Message) error {
+	g.m.Lock()
+	defer g.m.Unlock()
+	defer func() {
+		g.drain()
+		g.suppress = 0
+	}()
+
+	switch msg.MsgType() {
+	case MsgNewOrderSingle:
+		return g.newOrderSingle(s, msg)
+	case MsgOrderCancelRequest:
+		return g.orderCancelRequest(s, msg)
+	case MsgOrderCancelReplaceRequest:
+		return g.orderCancelReplaceRequest(s, msg)
+	}
+	return fmt.Errorf("unsupported MsgType %s", msg.MsgType())
+}
+
+func (g *Gateway) newOrderSingle(s *Session, msg *Message) error {
+	clOrdID, ok := msg.Get(TagClOrdID)
+	if !ok {
+		return fmt.Errorf("missing tag %d", TagClOrdID)
+	}
+	symbol, _ := msg.Get(TagSymbol)
+
+	reject := func(reason string, text string) error {
+		return s.Send(NewMessage(MsgExecutionReport).
+			Set(TagOrderID, "NONE").
+			Set(TagClOrdID, clOrdID).
+			Set(TagExecID, g.execId()).
+			Set(TagExecType, execTypeRejected).
+			Set(TagOrdStatus, ordStatusRejected).
+			Set(TagSymbol, symbol).
+			Set(TagOrdRejReason, reason).
+			Set(TagLeavesQty, "0").
+			Set(TagCumQty, "0").
+			Set(TagAvgPx, "0").
+			Set(TagText, text))
+	}
+
+	if _, exists := g.clOrdIDs[clOrdKey{s.id, clOrdID}]; exists {
+		return reject(ordRejReasonDuplicate, "Duplicate ClOrdID")
+	}
+	side, orderType, price, quantity, err := g.parseOrder(msg)
+	if err != nil {
+		return reject(ordRejReasonOther, err.Error())
+	}
+
+	for {
+		id := g.nextOrderId
+		g.nextOrderId++
+
+		o := &orderState{
+			session:  s,
+			orderId:  id,
+			clOrdID:  clOrdID,
+			symbol:   engine.Symbol(symbol),
+			side:     side,
+			price:    price,
+			orderQty: quantity,
+			leaves:   quantity,
+		}
+		g.orders[id] = o
+		g.clOrdIDs[clOrdKey{s.id, clOrdID}] = id
+
+		// the New report must precede the fills reported by drain
+		_, err = g.engine.AddOrder(
+			o.symbol,
+			orderbook.NewOrder(orderType, id, side, price, quantity),
+		)
+		if err == nil {
+			if orderType == orderbook.Market {
+				o.price = 0
+			}
+			return s.Send(g.executionReport(o, execTypeNew, ordStatusNew))
+		}
+
+		delete(g.orders, id)
+		delete(g.clOrdIDs, clOrdKey{s.id, clOrdID})
+		if !errors.Is(err, engine.ErrDuplicateOrder) {
+			break
+		}
+	}
+
+	if errors.Is(err, engine.ErrUnknownSymbol) {
+		return reject(ordRejReasonUnknownSymbol, err.Error())
+	}
+	return reject(ordRejReasonOther, err.Error())
+}
+
+func (g *Gateway) orderCancelRequest(s *Session, msg *Message) error {
+	clOrdID, _ := msg.Get(TagClOrdID)
+	origClOrdID, _ := msg.Get(TagOrigClOrdID)
+
+	o, ok := g.lookup(s, origClOrdID)
+	if !ok {
+		return s.Send(g.cancelReject(nil, clOrdID, origClOrdID,
+			cxlRejResponseToCancel, cxlRejReasonUnknownOrder, "Unknown order"))
+	}
+
+	g.suppress = o.orderId
+	err := g.engine.CancelOrder(o.orderId)
+	if err != nil {
+		return s.Send(g.cancelReject(o, clOrdID, origClOrdID,
+			cxlRejResponseToCancel, cxlRejReasonTooLate, err.Error()))
+	}
+
+	g.forget(o)
+	o.leaves = 0
+	o.clOrdID = clOrdID
+	return s.Send(g.executionReport(o, execTypeCanceled, ordStatusCanceled).
+		Set(TagOrigClOrdID, origClOrdID))
+}
+
+func (g *Gateway) orderCancelReplaceRequest(s *Session, msg *Message) error {
+	clOrdID, _ := msg.Get(TagClOrdID)
+	origClOrdID, _ := msg.Get(TagOrigClOrdID)
+
+	o, ok := g.lookup(s, origClOrdID)
+	if !ok {
+		return s.Send(g.cancelReject(nil, clOrdID, origClOrdID,
+			cxlRejResponseToReplace, cxlRejReasonUnknownOrder, "Unknown order"))
+	}
+	if _, exists := g.clOrdIDs[clOrdKey{s.id, clOrdID}]; exists {
+		return s.Send(g.cancelReject(o, clOrdID, origClOrdID,
+			cxlRejResponseToReplace, ordRejReasonOther, "Duplicate ClOrdID"))
+	}
+
+	side, _, price, quantity, err := g.parseOrder(msg)
+	if err == nil && quantity <= o.cumQty {
+		err = fmt.Errorf("OrderQty must exceed the filled quantity %d", o.cumQty)
+	}
+	if err != nil {
+		return s.Send(g.cancelReject(o, clOrdID, origClOrdID,
+			cxlRejResponseToReplace, ordRejReasonOther, err.Error()))
+	}
+
+	// OrderQty includes what has already been filled, the book only tracks
+	// the open quantity
+	var modify orderbook.OrderModify
+	g.suppress = o.orderId
+	_, err = g.engine.ModifyOrder(modify.New(o.orderId, price, side, quantity-o.cumQty))
+	if err != nil {
+		return s.Send(g.cancelReject(o, clOrdID, origClOrdID,
+			cxlRejResponseToReplace, cxlRejReasonTooLate, err.Error()))
+	}
+
+	delete(g.clOrdIDs, clOrdKey{s.id, o.clOrdID})
+	g.clOrdIDs[clOrdKey{s.id, clOrdID}] = o.orderId
+	o.clOrdID = clOrdID
+	o.side = side
+	o.price = price
+	o.orderQty = quantity
+	o.leaves = quantity - o.cumQty
+
+	status := ordStatusNew
+	if o.cumQty > 0 {
+		status = ordStatusPartiallyFilled
+	}
+	return s.Send(g.executionReport(o, execTypeReplaced, status).
+		Set(TagOrigClOrdID, origClOrdID))
+}
+
+// drain reports the book events collected since the last drain: executions of
+// any gateway order, and cancels that were not requested through the gateway,
+// such as FillAndKill remainders. It should only be called with g.m held.
+func (g *Gateway) drain() {
+	g.pendingM.Lock()
+	pending := g.pending
+	g.pending = nil
+	g.pendingM.Unlock()
+
+	for _, p := range pending {
+		ev := p.event
+		o, ok := g.orders[ev.OrderId]
+		if !ok || o.symbol != p.symbol {
+			continue
+		}
+
+		switch ev.Type {
+		case orderbook.OrderExecuted:
+			o.cumQty += ev.Quantity
+			o.leaves -= ev.Quantity
+			o.notional += int64(ev.TradePrice) * int64(ev.Quantity)
+
+			status := ordStatusPartiallyFilled
+			if o.leaves == 0 {
+				status = ordStatusFilled
+				g.forget(o)
+			}
+			liquidity := lastLiquidityAdded
+			if ev.Aggressor {
+				liquidity = lastLiquidityRemoved
+			}
+			report := g.executionReport(o, execTypeTrade, status).
+				SetInt(TagLastQty, int(ev.Quantity)).
+				Set(TagLastPx, g.formatPrice(ev.TradePrice)).
+				Set(TagLastLiquidityInd, liquidity)
+			if ev.Fee != 0 {
+				// Commission is the amount of the fee of this fill,
+				// negative for a rebate.
+				report.Set(TagCommission, strconv.FormatFloat(
+					float64(ev.Fee)/engine.FeeScale/float64(g.priceScale), 'f', -1, 64)).
+					Set(TagCommType, commTypeAbsolute)
+			}
+			o.session.Send(report)
+		case orderbook.OrderReduced:
+			if ev.OrderId != g.suppress {
+				o.leaves -= ev.Quantity
+			}
+		case orderbook.OrderDeleted:
+			if ev.OrderId != g.suppress {
+				g.forget(o)
+				o.leaves = 0
+				o.session.Send(g.executionReport(o, execTypeCanceled, ordStatusCanceled))
+			}
+		}
+	}
+}
+
+func (g *Gateway) lookup(s *Session, clOrdID string) (*orderState, bool) {
+	id, ok := g.clOrdIDs[clOrdKey{s.id, clOrdID}]
+	if !ok {
+		return nil, false
+	}
+	o, ok := g.orders[id]
+	return o, ok
+}
+
+func (g *Gateway) forget(o *orderState) {
+	delete(g.orders, o.orderId)
+	delete(g.clOrdIDs, clOrdKey{o.session.id, o.clOrdID})
+}
+
+func (g *Gateway) parseOrder(msg *Message) (
+	orderbook.Side,
+	orderbook.OrderType,
+	orderbook.Price,
+	orderbook.Quantity,
+	error,
+) {
+	var (
+		side      orderbook.Side
+		orderType orderbook.OrderType
+		price     orderbook.Price
+	)
+
+	switch v, _ := msg.Get(TagSide); v {
+	case sideBuy:
+		side = orderbook.Buy
+	case sideSell:
+		side = orderbook.Sell
+	default:
+		return side, orderType, price, 0, fmt.Errorf("unsupported Side %q", v)
+	}
+
+	quantity, err := msg.GetInt(TagOrderQty)
+	if err != nil || quantity <= 0 || quantity > math.MaxUint32 {
+		return side, orderType, price, 0, fmt.Errorf("invalid OrderQty")
+	}
+
+	ordType, _ := msg.Get(TagOrdType)
+	tif, ok := msg.Get(TagTimeInForce)
+	if !ok {
+		tif = tifDay
+	}
+	switch {
+	case ordType == ordTypeMarket:
+		orderType = orderbook.Market
+	case ordType == ordTypeLimit && tif == tifDay:
+		orderType = orderbook.GoodForDay
+	case ordType == ordTypeLimit && tif == tifGTC:
+		orderType = orderbook.GoodTillCancel
+	case ordType == ordTypeLimit && tif == tifIOC:
+		orderType = orderbook.FillAndKill
+	case ordType == ordTypeLimit && tif == tifFOK:
+		orderType = orderbook.FillOrKill
+	default:
+		return side, orderType, price, 0,
+			fmt.Errorf("unsupported OrdType %q and TimeInForce %q", ordType, tif)
+	}
+
+	if orderType != orderbook.Market {
+		px, ok := msg.Get(TagPrice)
+		if !ok {
+			return side, orderType, price, 0, fmt.Errorf("missing Price")
+		}
+		price, err = g.parsePrice(px)
+		if err != nil {
+			return side, orderType, price, 0, err
+		}
+	}
+	return side, orderType, price, orderbook.Quantity(quantity), nil
+}
+
+func (g *Gateway) parsePrice(px string) (orderbook.Price, error) {
+	f, err := strconv.ParseFloat(px, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid Price %q", px)
+	}
+	ticks := math.Round(f * float64(g.priceScale))
+	if ticks <= 0 || ticks > math.MaxInt32 {
+		return 0, fmt.Errorf("invalid Price %q", px)
+	}
+	return orderbook.Price(ticks), nil
+}
+
+func (g *Gateway) formatPrice(price orderbook.Price) string {
+	return strconv.FormatFloat(float64(price)/float64(g.priceScale), 'f', -1, 64)
+}
+
+func (g *Gateway) execId() string {
+	g.nextExecId++
+	return strconv.FormatUint(g.nextExecId, 10)
+}
+
+func (g *Gateway) executionReport(
+	o *orderState,
+	execType string,
+	ordStatus string,
+) *Message {
+	side := sideBuy
+	if o.side == orderbook.Sell {
+		side = sideSell
+	}
+	avgPx := "0"
+	if o.cumQty > 0 {
+		avgPx = strconv.FormatFloat(
+			float64(o.notional)/float64(o.cumQty)/float64(g.priceScale),
+			'f', -1, 64,
+		)
+	}
+
+	m := NewMessage(MsgExecutionReport).
+		Set(TagOrderID, strconv.FormatUint(uint64(o.orderId), 10)).
+		Set(TagClOrdID, o.clOrdID).
+		Set(TagExecID, g.execId()).
+		Set(TagExecType, execType).
+		Set(TagOrdStatus, ordStatus).
+		Set(TagSymbol, string(o.symbol)).
+		Set(TagSide, side).
+		SetInt(TagOrderQty, int(o.orderQty))
+	if o.price > 0 {
+		m.Set(TagPrice, g.formatPrice(o.price))
+	}
+	return m.
+		SetInt(TagLeavesQty, int(o.leaves)).
+		SetInt(TagCumQty, int(o.cumQty)).
+		Set(TagAvgPx, avgPx).
+		SetTime(TagTransactTime, time.Now())
+}
+
+func (g *Gateway) cancelReject(
+	o *orderState,
+	clOrdID string,
+	origClOrdID string,
+	responseTo string,
+	reason string,
+	text string,
+) *Message {
+	orderId, status := "NONE", ordStatusRejected
+	if o != nil {
+		orderId = strconv.FormatUint(uint64(o.orderId), 10)
+		status = ordStatusNew
+		if o.cumQty > 0 {
+			status = ordStatusPartiallyFilled
+		}
+	}
+	return NewMessage(MsgOrderCancelReject).
+		Set(TagOrderID, orderId).
+		Set(TagClOrdID, clOrdID).
+		Set(TagOrigClOrdID, origClOrdID).
+		Set(TagOrdStatus, status).
+		Set(TagCxlRejResponseTo, responseTo).
+		Set(TagCxlRejReason, reason).
+		Set(TagText, text)
+}
@@ -0,0 +1,290 @@
+// Package fix implements a FIX 4.4 order entry acceptor on top of the engine.
+package fix
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+const (
+	beginString = "FIX.4.4"
+	soh         = '\x01'
+	// timeFormat is the UTCTimestamp format with milliseconds.
+	timeFormat = "20060102-15:04:05.000"
+)
+
+// Tags used by the session and application layers.
+const (
+	TagAvgPx            = 6
+	TagBeginSeqNo       = 7
+	TagBeginString      = 8
+	TagBodyLength       = 9
+	TagCheckSum         = 10
+	TagClOrdID          = 11
+	TagCommission       = 12
+	TagCommType         = 13
+	TagCumQty           = 14
+	TagEndSeqNo         = 16
+	TagExecID           = 17
+	TagLastPx           = 31
+	TagLastQty          = 32
+	TagMsgSeqNum        = 34
+	TagMsgType          = 35
+	TagNewSeqNo         = 36
+	TagOrderID          = 37
+	TagOrderQty         = 38
+	TagOrdStatus        = 39
+	TagOrdType          = 40
+	TagOrigClOrdID      = 41
+	TagPossDupFlag      = 43
+	TagPrice            = 44
+	TagRefSeqNum        = 45
+	TagSenderCompID     = 49
+	TagSendingTime      = 52
+	TagSide             = 54
+	TagSymbol           = 55
+	TagTargetCompID     = 56
+	TagText             = 58
+	TagTimeInForce      = 59
+	TagTransactTime     = 60
+	TagEncryptMethod    = 98
+	TagCxlRejReason     = 102
+	TagOrdRejReason     = 103
+	TagHeartBtInt       = 108
+	TagTestReqID        = 112
+	TagOrigSendingTime  = 122
+	TagGapFillFlag      = 123
+	TagResetSeqNumFlag  = 141
+	TagExecType         = 150
+	TagLeavesQty        = 151
+	TagCxlRejResponseTo = 434
+	TagLastLiquidityInd = 851
+)
+
+// Message types.
+const (
+	MsgHeartbeat                 = "0"
+	MsgTestRequest               = "1"
+	MsgResendRequest             = "2"
+	MsgReject                    = "3"
+	MsgSequenceReset             = "4"
+	MsgLogout                    = "5"
+	MsgExecutionReport           = "8"
+	MsgOrderCancelReject         = "9"
+	MsgLogon                     = "A"
+	MsgNewOrderSingle            = "D"
+	MsgOrderCancelRequest        = "F"
+	MsgOrderCancelReplaceRequest = "G"
+)
+
+var ErrGarbled = errors.New("garbled FIX message")
+
+type Field struct {
+	Tag   int
+	Value string
+}
+
+// Message is a FIX message as an ordered list of fields, without the
+// BeginString, BodyLength and CheckSum fields, which are added on encoding.
+type Message struct {
+	fields []Field
+}
+
+func NewMessage(msgType string) *Message {
+	return &Message{fields: []Field{{Tag: TagMsgType, Value: msgType}}}
+}
+
+// MsgType returns the value of tag 35.
+func (m *Message) MsgType() string {
+	v, _ := m.Get(TagMsgType)
+	return v
+}
+
+// Get returns the value of the first occurrence of a tag.
+func (m *Message) Get(tag int) (string, bool) {
+	for _, f := range m.fields {
+		if f.Tag == tag {
+			return f.Value, true
+		}
+	}
+	return "", false
+}
+
+// GetInt returns the value of a tag parsed as an integer.
+func (m *Message) GetInt(tag int) (int, error) {
+	v, ok := m.Get(tag)
+	if !ok {
+		return 0, fmt.Errorf("missing tag %d", tag)
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("tag %d: invalid integer %q", tag, v)
+	}
+	return n, nil
+}
+
+// GetBool returns true if the tag is present with the value Y.
+func (m *Message) GetBool(tag int) bool {
+	v, _ := m.Get(tag)
+	return v == "Y"
+}
+
+// Set replaces the value of a tag, or appends it if it is not present.
+func (m *Message) Set(tag int, value string) *Message {
+	for i := range m.fields {
+		if m.fields[i].Tag == tag {
+			m.fields[i].Value = value
+			return m
+		}
+	}
+	m.fields = append(m.fields, Field{Tag: tag, Value: value})
+	return m
+}
+
+// SetInt sets a tag to an integer value.
+func (m *Message) SetInt(tag int, value int) *Message {
+	return m.Set(tag, strconv.Itoa(value))
+}
+
+// SetTime sets a tag to a UTC timestamp.
+func (m *Message) SetTime(tag int, t time.Time) *Message {
+	return m.Set(tag, t.UTC().Format(timeFormat))
+}
+
+// Fields returns the fields of the message in order.
+func (m *Message) Fields() []Field {
+	return m.fields
+}
+
+// withHeader returns a copy of the message with the session header fields
+// placed directly after the MsgType.
+func (m *Message) withHeader(header ...Field) *Message {
+	fields := make([]Field, 0, len(m.fields)+len(header))
+	fields = append(fields, m.fields[0])
+	fields = append(fields, header...)
+	for _, f := range m.fields[1:] {
+		replaced := false
+		for _, h := range header {
+			if f.Tag == h.Tag {
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			fields = append(fields, f)
+		}
+	}
+	return &Message{fields: fields}
+}
+
+// Bytes encodes the message, computing the BodyLength and CheckSum.
+func (m *Message) Bytes() []byte {
+	var body bytes.Buffer
+	for _, f := range m.fields {
+		body.WriteString(strconv.Itoa(f.Tag))
+		body.WriteByte('=')
+		body.WriteString(f.Value)
+		body.WriteByte(soh)
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "8=%s\x019=%d\x01", beginString, body.Len())
+	b.Write(body.Bytes())
+	fmt.Fprintf(&b, "10=%03d\x01", checksum(b.Bytes()))
+	return b.Bytes()
+}
+
+// String renders the message with | as the field delimiter.
+func (m *Message) String() string {
+	return string(bytes.ReplaceAll(m.Bytes(), []byte{soh}, []byte{'|'}))
+}
+
+func checksum(b []byte) int {
+	var sum int
+	for _, c := range b {
+		sum += int(c)
+	}
+	return sum % 256
+}
+
+// ReadMessage reads a single message, validating its BeginString, BodyLength
+// and CheckSum.
+func ReadMessage(r *bufio.Reader) (*Message, error) {
+	begin, err := r.ReadString(soh)
+	if err != nil {
+		return nil, err
+	}
+	if begin != "8="+beginString+"\x01" {
+		return nil, fmt.Errorf("%w: unexpected BeginString %q", ErrGarbled, begin)
+	}
+
+	length, err := r.ReadString(soh)
+	if err != nil {
+		return nil, err
+	}
+	if len(length) < 4 || length[:2] != "9=" {
+		return nil, fmt.Errorf("%w: missing BodyLength", ErrGarbled)
+	}
+	size, err := strconv.Atoi(length[2 : len(length)-1])
+	if err != nil || size <= 0 || size > 1<<16 {
+		return nil, fmt.Errorf("%w: invalid BodyLength %q", ErrGarbled, length)
+	}
+
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	trailer, err := r.ReadString(soh)
+	if err != nil {
+		return nil, err
+	}
+	if len(trailer) != 7 || trailer[:3] != "10=" {
+		return nil, fmt.Errorf("%w: missing CheckSum", ErrGarbled)
+	}
+	sum, err := strconv.Atoi(trailer[3:6])
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid CheckSum %q", ErrGarbled, trailer)
+	}
+
+	expected := checksum([]byte(begin)) + checksum([]byte(length)) + checksum(body)
+	if expected%256 != sum {
+		return nil, fmt.Errorf("%w: CheckSum mismatch", ErrGarbled)
+	}
+
+	m, err := parseBody(body)
+	if err != nil {
+		return nil, err
+	}
+	if m.MsgType() == "" || m.fields[0].Tag != TagMsgType {
+		return nil, fmt.Errorf("%w: MsgType must be the first body field", ErrGarbled)
+	}
+	return m, nil
+}
+
+func parseBody(body []byte) (*Message, error) {
+	m := &Message{}
+	for len(body) > 0 {
+		end := bytes.IndexByte(body, soh)
+		if end < 0 {
+			return nil, fmt.Errorf("%w: unterminated field", ErrGarbled)
+		}
+		field := body[:end]
+		body = body[end+1:]
+
+		eq := bytes.IndexByte(field, '=')
+		if eq <= 0 {
+			return nil, fmt.Errorf("%w: invalid field %q", ErrGarbled, field)
+		}
+		tag, err := strconv.Atoi(string(field[:eq]))
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid tag %q", ErrGarbled, field[:eq])
+		}
+		m.fields = append(m.fields, Field{Tag: tag, Value: string(field[eq+1:])})
+	}
+	return m, nil
+}
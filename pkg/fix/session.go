@@ -0,0 +1,406 @@
+package fix
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// An Application receives the application messages of logged on sessions.
+// An error returned by FromApp is answered with a session-level Reject.
+type Application interface {
+	OnLogon(s *Session)
+	OnLogout(s *Session)
+	FromApp(s *Session, msg *Message) error
+}
+
+var errDisconnect = errors.New("disconnect")
+
+// Session holds the state of a FIX session. It outlives the connections it is
+// logged on through, and its sequence numbers are kept in a Store.
+type Session struct {
+	id    SessionID
+	store Store
+	app   Application
+
+	m           sync.Mutex
+	conn        net.Conn
+	heartBtInt  time.Duration
+	lastSent    time.Time
+	lastRecv    time.Time
+	testReqSent bool
+	// resendEnd is the sequence number of the message that revealed a gap.
+	// No further ResendRequest is sent until the gap up to it is filled.
+	resendEnd int
+	testReqId int
+}
+
+// ID returns the identity of the session.
+func (s *Session) ID() SessionID {
+	return s.id
+}
+
+// LoggedOn returns true while the session has a connection.
+func (s *Session) LoggedOn() bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.conn != nil
+}
+
+// Send assigns the next sequence number to an application or admin message
+// and writes it. Application messages sent while the counterparty is not
+// logged on are stored, and delivered when it requests a resend.
+func (s *Session) Send(msg *Message) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.send(msg)
+}
+
+func (s *Session) send(msg *Message) error {
+	seqNum := s.store.NextSenderSeqNum()
+	full := msg.withHeader(
+		Field{Tag: TagSenderCompID, Value: s.id.SenderCompID},
+		Field{Tag: TagTargetCompID, Value: s.id.TargetCompID},
+		Field{Tag: TagMsgSeqNum, Value: strconv.Itoa(seqNum)},
+		Field{Tag: TagSendingTime, Value: time.Now().UTC().Format(timeFormat)},
+	)
+	if err := s.store.SetNextSenderSeqNum(seqNum + 1); err != nil {
+		return err
+	}
+	if !isAdmin(msg.MsgType()) {
+		if err := s.store.SaveMessage(seqNum, full); err != nil {
+			return err
+		}
+	}
+	return s.write(full)
+}
+
+// write sends an encoded message on the current connection, if any.
+func (s *Session) write(msg *Message) error {
+	if s.conn == nil {
+		return nil
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if _, err := s.conn.Write(msg.Bytes()); err != nil {
+		return err
+	}
+	s.lastSent = time.Now()
+	return nil
+}
+
+func isAdmin(msgType string) bool {
+	switch msgType {
+	case MsgHeartbeat, MsgTestRequest, MsgResendRequest, MsgReject,
+		MsgSequenceReset, MsgLogout, MsgLogon:
+		return true
+	}
+	return false
+}
+
+// logon validates a Logon message received on a new connection and answers
+// it.
+func (s *Session) logon(conn net.Conn, msg *Message) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.conn != nil {
+		return fmt.Errorf("session %s is already logged on", s.id)
+	}
+
+	heartBtInt, err := msg.GetInt(TagHeartBtInt)
+	if err != nil || heartBtInt <= 0 {
+		return fmt.Errorf("invalid HeartBtInt")
+	}
+	seqNum, err := msg.GetInt(TagMsgSeqNum)
+	if err != nil {
+		return err
+	}
+
+	reset := msg.GetBool(TagResetSeqNumFlag)
+	if reset {
+		if err := s.store.Reset(); err != nil {
+			return err
+		}
+	}
+
+	expected := s.store.NextTargetSeqNum()
+	if seqNum < expected {
+		s.conn = conn
+		s.send(NewMessage(MsgLogout).Set(TagText, fmt.Sprintf(
+			"MsgSeqNum too low, expecting %d but received %d", expected, seqNum)))
+		s.conn = nil
+		return fmt.Errorf("MsgSeqNum too low")
+	}
+
+	s.conn = conn
+	s.heartBtInt = time.Duration(heartBtInt) * time.Second
+	s.lastRecv = time.Now()
+	s.testReqSent = false
+	s.resendEnd = 0
+
+	reply := NewMessage(MsgLogon).
+		Set(TagEncryptMethod, "0").
+		SetInt(TagHeartBtInt, heartBtInt)
+	if reset {
+		reply.Set(TagResetSeqNumFlag, "Y")
+	}
+	if err := s.send(reply); err != nil {
+		return err
+	}
+
+	if seqNum > expected {
+		return s.requestResend(expected, seqNum)
+	}
+	return s.store.SetNextTargetSeqNum(expected + 1)
+}
+
+func (s *Session) requestResend(begin, trigger int) error {
+	if s.resendEnd >= trigger {
+		return nil
+	}
+	s.resendEnd = trigger
+	return s.send(NewMessage(MsgResendRequest).
+		SetInt(TagBeginSeqNo, begin).
+		SetInt(TagEndSeqNo, 0))
+}
+
+// process handles a message received after logon. Application messages are
+// passed to the Application outside of the session lock, so that it can send
+// replies.
+func (s *Session) process(msg *Message) error {
+	app, err := s.processAdmin(msg)
+	if err != nil || !app {
+		return err
+	}
+
+	if err := s.app.FromApp(s, msg); err != nil {
+		seqNum, _ := msg.GetInt(TagMsgSeqNum)
+		return s.Send(NewMessage(MsgReject).
+			SetInt(TagRefSeqNum, seqNum).
+			Set(TagText, err.Error()))
+	}
+	return nil
+}
+
+// processAdmin checks the sequence number of a message and handles it if it
+// is an admin message. It returns true if the message must be passed to the
+// Application.
+func (s *Session) processAdmin(msg *Message) (bool, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.lastRecv = time.Now()
+	s.testReqSent = false
+
+	seqNum, err := msg.GetInt(TagMsgSeqNum)
+	if err != nil {
+		return false, err
+	}
+	msgType := msg.MsgType()
+
+	// A SequenceReset in reset mode is processed whatever its sequence number
+	if msgType == MsgSequenceReset && !msg.GetBool(TagGapFillFlag) {
+		newSeqNo, err := msg.GetInt(TagNewSeqNo)
+		if err != nil {
+			return false, err
+		}
+		if newSeqNo > s.store.NextTargetSeqNum() {
+			return false, s.store.SetNextTargetSeqNum(newSeqNo)
+		}
+		return false, nil
+	}
+
+	expected := s.store.NextTargetSeqNum()
+	if seqNum > expected {
+		return false, s.requestResend(expected, seqNum)
+	}
+	if seqNum < expected {
+		if msg.GetBool(TagPossDupFlag) {
+			return false, nil
+		}
+		s.send(NewMessage(MsgLogout).Set(TagText, fmt.Sprintf(
+			"MsgSeqNum too low, expecting %d but received %d", expected, seqNum)))
+		return false, errDisconnect
+	}
+
+	if msgType == MsgSequenceReset {
+		newSeqNo, err := msg.GetInt(TagNewSeqNo)
+		if err != nil {
+			return false, err
+		}
+		if newSeqNo > expected {
+			return false, s.store.SetNextTargetSeqNum(newSeqNo)
+		}
+		return false, s.store.SetNextTargetSeqNum(expected + 1)
+	}
+	if err := s.store.SetNextTargetSeqNum(expected + 1); err != nil {
+		return false, err
+	}
+
+	switch msgType {
+	case MsgHeartbeat, MsgReject:
+		return false, nil
+	case MsgTestRequest:
+		testReqId, _ := msg.Get(TagTestReqID)
+		return false, s.send(NewMessage(MsgHeartbeat).Set(TagTestReqID, testReqId))
+	case MsgResendRequest:
+		begin, err := msg.GetInt(TagBeginSeqNo)
+		if err != nil {
+			return false, err
+		}
+		end, err := msg.GetInt(TagEndSeqNo)
+		if err != nil {
+			return false, err
+		}
+		return false, s.resend(begin, end)
+	case MsgLogout:
+		s.send(NewMessage(MsgLogout))
+		return false, errDisconnect
+	case MsgLogon:
+		return false, s.send(NewMessage(MsgReject).
+			SetInt(TagRefSeqNum, seqNum).
+			Set(TagText, "Already logged on"))
+	}
+	return true, nil
+}
+
+// resend answers a ResendRequest. Stored application messages are sent again
+// with PossDupFlag set; everything else is skipped with a gap fill.
+func (s *Session) resend(begin, end int) error {
+	last := s.store.NextSenderSeqNum() - 1
+	if end == 0 || end > last {
+		end = last
+	}
+	if begin < 1 {
+		begin = 1
+	}
+	messages := s.store.Messages(begin, end)
+
+	gapStart := 0
+	fillGap := func(next int) error {
+		if gapStart == 0 {
+			return nil
+		}
+		gap := NewMessage(MsgSequenceReset).withHeader(
+			Field{Tag: TagSenderCompID, Value: s.id.SenderCompID},
+			Field{Tag: TagTargetCompID, Value: s.id.TargetCompID},
+			Field{Tag: TagMsgSeqNum, Value: strconv.Itoa(gapStart)},
+			Field{Tag: TagPossDupFlag, Value: "Y"},
+			Field{Tag: TagSendingTime, Value: time.Now().UTC().Format(timeFormat)},
+		)
+		gap.Set(TagGapFillFlag, "Y").SetInt(TagNewSeqNo, next)
+		gapStart = 0
+		return s.write(gap)
+	}
+
+	for seqNum := begin; seqNum <= end; seqNum++ {
+		msg, ok := messages[seqNum]
+		if !ok {
+			if gapStart == 0 {
+				gapStart = seqNum
+			}
+			continue
+		}
+		if err := fillGap(seqNum); err != nil {
+			return err
+		}
+
+		origSendingTime, _ := msg.Get(TagSendingTime)
+		dup := msg.withHeader(
+			Field{Tag: TagSenderCompID, Value: s.id.SenderCompID},
+			Field{Tag: TagTargetCompID, Value: s.id.TargetCompID},
+			Field{Tag: TagMsgSeqNum, Value: strconv.Itoa(seqNum)},
+			Field{Tag: TagPossDupFlag, Value: "Y"},
+			Field{Tag: TagSendingTime, Value: time.Now().UTC().Format(timeFormat)},
+			Field{Tag: TagOrigSendingTime, Value: origSendingTime},
+		)
+		if err := s.write(dup); err != nil {
+			return err
+		}
+	}
+	return fillGap(end + 1)
+}
+
+// checkHeartbeat sends a Heartbeat when nothing has been sent for a heartbeat
+// interval, and a TestRequest when nothing has been received for slightly
+// longer. A counterparty silent for two intervals is disconnected.
+func (s *Session) checkHeartbeat(now time.Time) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.conn == nil {
+		return errDisconnect
+	}
+
+	silence := now.Sub(s.lastRecv)
+	if silence >= s.heartBtInt*12/5 {
+		return fmt.Errorf("no message received for %v", silence)
+	}
+	if silence >= s.heartBtInt*6/5 && !s.testReqSent {
+		s.testReqSent = true
+		s.testReqId++
+		return s.send(NewMessage(MsgTestRequest).
+			Set(TagTestReqID, "TEST"+strconv.Itoa(s.testReqId)))
+	}
+	if now.Sub(s.lastSent) >= s.heartBtInt {
+		return s.send(NewMessage(MsgHeartbeat))
+	}
+	return nil
+}
+
+// run processes messages from a logged on connection until it fails or the
+// session logs out.
+func (s *Session) run(conn net.Conn, r *bufio.Reader) {
+	done := make(chan struct{})
+	defer close(done)
+
+	s.m.Lock()
+	tick := s.heartBtInt / 4
+	s.m.Unlock()
+	if tick > time.Second {
+		tick = time.Second
+	}
+
+	go func() {
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				if err := s.checkHeartbeat(now); err != nil {
+					conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		msg, err := ReadMessage(r)
+		if errors.Is(err, ErrGarbled) {
+			// garbled messages are ignored without consuming a sequence number
+			continue
+		}
+		if err != nil {
+			return
+		}
+		if err := s.process(msg); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Session) disconnect(conn net.Conn) {
+	s.m.Lock()
+	if s.conn == conn {
+		s.conn = nil
+	}
+	s.m.Unlock()
+	conn.Close()
+}
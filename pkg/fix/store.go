@@ -0,0 +1,274 @@
+package fix
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SessionID identifies a session from the point of view of the acceptor.
+type SessionID struct {
+	SenderCompID string
+	TargetCompID string
+}
+
+func (id SessionID) String() string {
+	return id.SenderCompID + "-" + id.TargetCompID
+}
+
+// A Store keeps the sequence numbers of a session and the application
+// messages it has sent, so that they can be resent on request.
+type Store interface {
+	NextSenderSeqNum() int
+	NextTargetSeqNum() int
+	SetNextSenderSeqNum(seqNum int) error
+	SetNextTargetSeqNum(seqNum int) error
+	// SaveMessage records an outgoing message under its sequence number.
+	SaveMessage(seqNum int, msg *Message) error
+	// Messages returns the saved messages in [begin, end], keyed by sequence
+	// number.
+	Messages(begin, end int) map[int]*Message
+	// Reset starts both sequences again from 1 and forgets saved messages.
+	Reset() error
+}
+
+// A StoreFactory opens the store of a session.
+type StoreFactory interface {
+	Open(id SessionID) (Store, error)
+}
+
+// MemoryStore is a Store that does not survive a restart.
+type MemoryStore struct {
+	m         sync.Mutex
+	senderSeq int
+	targetSeq int
+	messages  map[int]*Message
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		senderSeq: 1,
+		targetSeq: 1,
+		messages:  make(map[int]*Message),
+	}
+}
+
+func (s *MemoryStore) NextSenderSeqNum() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.senderSeq
+}
+
+func (s *MemoryStore) NextTargetSeqNum() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.targetSeq
+}
+
+func (s *MemoryStore) SetNextSenderSeqNum(seqNum int) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.senderSeq = seqNum
+	return nil
+}
+
+func (s *MemoryStore) SetNextTargetSeqNum(seqNum int) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.targetSeq = seqNum
+	return nil
+}
+
+func (s *MemoryStore) SaveMessage(seqNum int, msg *Message) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.messages[seqNum] = msg
+	return nil
+}
+
+func (s *MemoryStore) Messages(begin, end int) map[int]*Message {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	messages := make(map[int]*Message)
+	for seqNum, msg := range s.messages {
+		if seqNum >= begin && seqNum <= end {
+			messages[seqNum] = msg
+		}
+	}
+	return messages
+}
+
+func (s *MemoryStore) Reset() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.senderSeq = 1
+	s.targetSeq = 1
+	s.messages = make(map[int]*Message)
+	return nil
+}
+
+// MemoryStoreFactory opens a fresh MemoryStore for every session.
+type MemoryStoreFactory struct{}
+
+func (MemoryStoreFactory) Open(id SessionID) (Store, error) {
+	return NewMemoryStore(), nil
+}
+
+// FileStore persists the sequence numbers of a session in <id>.seqnums and
+// its outgoing messages in <id>.body inside a directory.
+type FileStore struct {
+	*MemoryStore
+	seqPath string
+	body    *os.File
+}
+
+// FileStoreFactory opens FileStores in a directory.
+type FileStoreFactory struct {
+	Dir string
+}
+
+func (f FileStoreFactory) Open(id SessionID) (Store, error) {
+	return OpenFileStore(f.Dir, id)
+}
+
+func OpenFileStore(dir string, id SessionID) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	s := &FileStore{
+		MemoryStore: NewMemoryStore(),
+		seqPath:     filepath.Join(dir, id.String()+".seqnums"),
+	}
+	if err := s.loadSeqNums(); err != nil {
+		return nil, err
+	}
+
+	bodyPath := filepath.Join(dir, id.String()+".body")
+	if err := s.loadMessages(bodyPath); err != nil {
+		return nil, err
+	}
+	body, err := os.OpenFile(bodyPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s.body = body
+	return s, nil
+}
+
+func (s *FileStore) loadSeqNums() error {
+	b, err := os.ReadFile(s.seqPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var sender, target int
+	if _, err := fmt.Sscanf(string(b), "%d : %d", &sender, &target); err != nil {
+		return fmt.Errorf("%s: %w", s.seqPath, err)
+	}
+	s.senderSeq = sender
+	s.targetSeq = target
+	return nil
+}
+
+// loadMessages reads records of the form "<seqNum> <length>\n<message>". A
+// torn final record is ignored.
+func (s *FileStore) loadMessages(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil
+		}
+		parts := strings.Fields(line)
+		if len(parts) != 2 {
+			return nil
+		}
+		seqNum, err1 := strconv.Atoi(parts[0])
+		size, err2 := strconv.Atoi(parts[1])
+		if err1 != nil || err2 != nil {
+			return nil
+		}
+		raw := make([]byte, size)
+		if _, err := io.ReadFull(r, raw); err != nil {
+			return nil
+		}
+		msg, err := ReadMessage(bufio.NewReader(strings.NewReader(string(raw))))
+		if err != nil {
+			return nil
+		}
+		s.messages[seqNum] = msg
+	}
+}
+
+func (s *FileStore) saveSeqNums() error {
+	tmp := s.seqPath + ".tmp"
+	data := fmt.Sprintf("%d : %d", s.senderSeq, s.targetSeq)
+	if err := os.WriteFile(tmp, []byte(data), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.seqPath)
+}
+
+func (s *FileStore) SetNextSenderSeqNum(seqNum int) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.senderSeq = seqNum
+	return s.saveSeqNums()
+}
+
+func (s *FileStore) SetNextTargetSeqNum(seqNum int) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.targetSeq = seqNum
+	return s.saveSeqNums()
+}
+
+func (s *FileStore) SaveMessage(seqNum int, msg *Message) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	raw := msg.Bytes()
+	if _, err := fmt.Fprintf(s.body, "%d %d\n%s", seqNum, len(raw), raw); err != nil {
+		return err
+	}
+	s.messages[seqNum] = msg
+	return nil
+}
+
+func (s *FileStore) Reset() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.senderSeq = 1
+	s.targetSeq = 1
+	s.messages = make(map[int]*Message)
+	if err := s.body.Truncate(0); err != nil {
+		return err
+	}
+	return s.saveSeqNums()
+}
+
+// Close closes the message file.
+func (s *FileStore) Close() error {
+	return s.body.Close()
+}
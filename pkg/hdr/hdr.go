@@ -0,0 +1,185 @@
+// Package hdr implements a high dynamic range histogram of integer values,
+// such as latencies in nanoseconds.
+//
+// Values are counted in buckets whose width grows with the value, so that
+// every value is recorded to a fixed number of significant decimal digits
+// across the whole range with a constant memory footprint. Recording is a
+// few shifts and an increment, cheap enough for a hot path.
+package hdr
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// Histogram counts values between a lowest discernible value and a highest
+// trackable value. Values above the highest are counted at the highest. It
+// is not safe for concurrent use.
+type Histogram struct {
+	lowest  int64
+	highest int64
+	digits  int
+
+	unitMagnitude               uint
+	subBucketHalfCountMagnitude uint
+	subBucketCount              int
+	subBucketHalfCount          int
+	subBucketMask               int64
+
+	counts []int64
+	total  int64
+	min    int64
+	max    int64
+	sum    float64
+}
+
+// New creates a histogram of values from lowest, at least 1, to highest,
+// at least twice lowest, recorded to digits significant digits between 1
+// and 5.
+func New(lowest, highest int64, digits int) (*Histogram, error) {
+	switch {
+	case lowest < 1:
+		return nil, fmt.Errorf("lowest value %d is not positive", lowest)
+	case highest < 2*lowest:
+		return nil, fmt.Errorf("highest value %d is less than twice the lowest %d", highest, lowest)
+	case digits < 1 || digits > 5:
+		return nil, fmt.Errorf("%d significant digits are not between 1 and 5", digits)
+	}
+
+	h := &Histogram{lowest: lowest, highest: highest, digits: digits}
+	// Values up to twice 10^digits need a bucket of their own to keep the
+	// precision; every further power of two halves the resolution.
+	single := 2 * math.Pow10(digits)
+	countMagnitude := uint(math.Ceil(math.Log2(single)))
+	h.subBucketHalfCountMagnitude = countMagnitude - 1
+	h.unitMagnitude = uint(math.Floor(math.Log2(float64(lowest))))
+	h.subBucketCount = 1 << countMagnitude
+	h.subBucketHalfCount = h.subBucketCount / 2
+	h.subBucketMask = int64(h.subBucketCount-1) << h.unitMagnitude
+
+	buckets := 1
+	for untrackable := int64(h.subBucketCount) << h.unitMagnitude; untrackable <= highest; untrackable <<= 1 {
+		buckets++
+		if untrackable > math.MaxInt64/2 {
+			break
+		}
+	}
+	h.counts = make([]int64, (buckets+1)*h.subBucketHalfCount)
+	h.Reset()
+	return h, nil
+}
+
+// Reset forgets every recorded value.
+func (h *Histogram) Reset() {
+	clear(h.counts)
+	h.total = 0
+	h.min = math.MaxInt64
+	h.max = 0
+	h.sum = 0
+}
+
+// Record counts a value. Negative values are counted as zero.
+func (h *Histogram) Record(v int64) {
+	h.RecordN(v, 1)
+}
+
+// RecordN counts a value n times.
+func (h *Histogram) RecordN(v, n int64) {
+	v = max(v, 0)
+	h.counts[h.index(min(v, h.highest))] += n
+	h.total += n
+	h.min = min(h.min, v)
+	h.max = max(h.max, v)
+	h.sum += float64(v) * float64(n)
+}
+
+// Merge adds the values recorded by another histogram, which must have
+// been created with the same bounds and digits.
+func (h *Histogram) Merge(other *Histogram) error {
+	if other.lowest != h.lowest || other.highest != h.highest || other.digits != h.digits {
+		return fmt.Errorf("histograms of different ranges cannot be merged")
+	}
+	for i, n := range other.counts {
+		h.counts[i] += n
+	}
+	h.total += other.total
+	h.min = min(h.min, other.min)
+	h.max = max(h.max, other.max)
+	h.sum += other.sum
+	return nil
+}
+
+// Count returns the number of recorded values.
+func (h *Histogram) Count() int64 {
+	return h.total
+}
+
+// Min returns the smallest recorded value, exactly, or zero when empty.
+func (h *Histogram) Min() int64 {
+	if h.total == 0 {
+		return 0
+	}
+	return h.min
+}
+
+// Max returns the largest recorded value, exactly.
+func (h *Histogram) Max() int64 {
+	return h.max
+}
+
+// Mean returns the average of the recorded values, exactly.
+func (h *Histogram) Mean() float64 {
+	if h.total == 0 {
+		return 0
+	}
+	return h.sum / float64(h.total)
+}
+
+// ValueAtPercentile returns the value that p percent of the recorded values
+// are at or below, to the precision of the histogram. It never exceeds the
+// largest recorded value.
+func (h *Histogram) ValueAtPercentile(p float64) int64 {
+	if h.total == 0 {
+		return 0
+	}
+	p = min(max(p, 0), 100)
+	target := max(int64(math.Ceil(p/100*float64(h.total))), 1)
+	var seen int64
+	for i, n := range h.counts {
+		seen += n
+		if seen >= target {
+			return min(h.highestEquivalent(h.valueAt(i)), h.max)
+		}
+	}
+	return h.max
+}
+
+// index returns the position in counts of the bucket of v.
+func (h *Histogram) index(v int64) int {
+	bucket := h.bucketOf(v)
+	sub := int(v >> (uint(bucket) + h.unitMagnitude))
+	return (bucket+1)<<h.subBucketHalfCountMagnitude + sub - h.subBucketHalfCount
+}
+
+func (h *Histogram) bucketOf(v int64) int {
+	pow2Ceiling := 64 - bits.LeadingZeros64(uint64(v|h.subBucketMask))
+	return pow2Ceiling - int(h.unitMagnitude) - int(h.subBucketHalfCountMagnitude+1)
+}
+
+// valueAt returns the lowest value counted at position i of counts.
+func (h *Histogram) valueAt(i int) int64 {
+	bucket := i>>h.subBucketHalfCountMagnitude - 1
+	sub := i&(h.subBucketHalfCount-1) + h.subBucketHalfCount
+	if bucket < 0 {
+		sub -= h.subBucketHalfCount
+		bucket = 0
+	}
+	return int64(sub) << (uint(bucket) + h.unitMagnitude)
+}
+
+// highestEquivalent returns the highest value counted with v, the lowest
+// value of its bucket.
+func (h *Histogram) highestEquivalent(v int64) int64 {
+	return v + int64(1)<<(uint(h.bucketOf(v))+h.unitMagnitude) - 1
+}
@@ -0,0 +1,85 @@
+package hdr
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	_, err := New(0, 100, 3)
+	assert.Error(t, err)
+	_, err = New(10, 15, 3)
+	assert.Error(t, err)
+	_, err = New(1, 100, 6)
+	assert.Error(t, err)
+
+	h, err := New(1, 3_600_000_000_000, 3)
+	require.NoError(t, err)
+	assert.Zero(t, h.ValueAtPercentile(50))
+	assert.Zero(t, h.Min())
+	h.Record(3_600_000_000_000)
+	h.Record(1 << 62)
+	assert.Equal(t, int64(1<<62), h.Max())
+	assert.Equal(t, int64(2), h.Count())
+}
+
+func TestPercentiles(t *testing.T) {
+	h, err := New(1, 1_000_000_000, 3)
+	require.NoError(t, err)
+	for v := int64(1); v <= 10000; v++ {
+		h.Record(v)
+	}
+	assert.Equal(t, int64(10000), h.Count())
+	assert.Equal(t, int64(1), h.Min())
+	assert.Equal(t, int64(10000), h.Max())
+	assert.InDelta(t, 5000.5, h.Mean(), 1e-9)
+	// Values are kept to three significant digits.
+	assert.InDelta(t, 5000, h.ValueAtPercentile(50), 5)
+	assert.InDelta(t, 9900, h.ValueAtPercentile(99), 10)
+	assert.InDelta(t, 9990, h.ValueAtPercentile(99.9), 10)
+	assert.Equal(t, int64(10000), h.ValueAtPercentile(100))
+	assert.Equal(t, int64(1), h.ValueAtPercentile(0))
+
+	// Small values are exact.
+	h.Reset()
+	h.RecordN(7, 3)
+	h.Record(9)
+	assert.Equal(t, int64(7), h.ValueAtPercentile(75))
+	assert.Equal(t, int64(9), h.ValueAtPercentile(76))
+}
+
+func TestPrecision(t *testing.T) {
+	h, err := New(1, 60_000_000_000, 2)
+	require.NoError(t, err)
+	rng := rand.New(rand.NewSource(1))
+	values := make([]int64, 100000)
+	for i := range values {
+		values[i] = int64(rng.ExpFloat64() * 50_000)
+		h.Record(values[i])
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	for _, p := range []float64{10, 50, 90, 99, 99.9, 99.99} {
+		want := values[int(p/100*float64(len(values)))-1]
+		got := h.ValueAtPercentile(p)
+		assert.InEpsilon(t, want, got, 0.01, "p%v", p)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	a, _ := New(1, 1000, 3)
+	b, _ := New(1, 1000, 3)
+	a.Record(10)
+	b.Record(20)
+	b.Record(5)
+	require.NoError(t, a.Merge(b))
+	assert.Equal(t, int64(3), a.Count())
+	assert.Equal(t, int64(5), a.Min())
+	assert.Equal(t, int64(20), a.Max())
+
+	c, _ := New(1, 1000, 2)
+	assert.Error(t, a.Merge(c))
+}
@@ -0,0 +1,187 @@
+package importer
+
+import (
+	"encoding/csv"
+	"fmt"
+	"go-orderbook/pkg/backtest"
+	"go-orderbook/pkg/orderbook"
+	"io"
+	"time"
+)
+
+// Columns are the zero-based positions of the fields of a CSV record. A
+// negative Timestamp means the file has none, and its actions are replayed
+// immediately.
+type Columns struct {
+	Timestamp int
+	Event     int
+	OrderId   int
+	Side      int
+	Price     int
+	Size      int
+}
+
+// CSVLayout describes a CSV order-flow file.
+type CSVLayout struct {
+	// Comma is the field separator. Zero means ','.
+	Comma   rune
+	Header  bool
+	Columns Columns
+	// TimeFormat is the layout of timestamps for time.Parse. Empty means
+	// Unix seconds with an optional fraction.
+	TimeFormat string
+	// Events maps the values of the event column to actions. Adds are
+	// GoodTillCancel orders and reduces are partial cancels by Size.
+	// Cancels only use the order id and reduces the order id and size.
+	Events map[string]backtest.ActionType
+	// Ignore lists event values to skip. Any other value missing from
+	// Events is an error.
+	Ignore []string
+	Buy    string
+	Sell   string
+	// PriceDecimals is the number of decimal places of a book tick: with 2,
+	// a price of 12.34 is 1234 ticks. Finer prices are an error.
+	PriceDecimals int
+}
+
+// DefaultCSVLayout has a header and the columns
+// timestamp,event,order_id,side,price,size with Unix timestamps, the events
+// add, cancel, reduce and modify, and the sides B and S.
+var DefaultCSVLayout = CSVLayout{
+	Header: true,
+	Columns: Columns{
+		Timestamp: 0,
+		Event:     1,
+		OrderId:   2,
+		Side:      3,
+		Price:     4,
+		Size:      5,
+	},
+	Events: map[string]backtest.ActionType{
+		"add":    backtest.ActionAdd,
+		"cancel": backtest.ActionCancel,
+		"reduce": backtest.ActionReduce,
+		"modify": backtest.ActionModify,
+	},
+	Buy:  "B",
+	Sell: "S",
+}
+
+// CSVReader reads order flow laid out as described by a CSVLayout.
+type CSVReader struct {
+	r      *csv.Reader
+	layout CSVLayout
+	ignore map[string]bool
+	fields int
+	header bool
+}
+
+func NewCSVReader(r io.Reader, layout CSVLayout) *CSVReader {
+	c := &CSVReader{
+		r:      newCSVReader(r, layout.Comma),
+		layout: layout,
+		ignore: make(map[string]bool, len(layout.Ignore)),
+		header: layout.Header,
+	}
+	for _, event := range layout.Ignore {
+		c.ignore[event] = true
+	}
+	columns := layout.Columns
+	for _, column := range []int{
+		columns.Timestamp, columns.Event, columns.OrderId,
+		columns.Side, columns.Price, columns.Size,
+	} {
+		c.fields = max(c.fields, column+1)
+	}
+	return c
+}
+
+func (c *CSVReader) Next() (backtest.Action, error) {
+	for {
+		record, err := c.r.Read()
+		if err != nil {
+			return backtest.Action{}, err
+		}
+		if c.header {
+			c.header = false
+			continue
+		}
+		a, skip, err := c.parse(record)
+		if err != nil {
+			return backtest.Action{}, lineError(c.r, "%v", err)
+		}
+		if !skip {
+			return a, nil
+		}
+	}
+}
+
+func (c *CSVReader) parse(record []string) (a backtest.Action, skip bool, err error) {
+	if len(record) < c.fields {
+		return a, false, fmt.Errorf("expected %d fields, got %d", c.fields, len(record))
+	}
+	columns := c.layout.Columns
+
+	event := record[columns.Event]
+	if c.ignore[event] {
+		return a, true, nil
+	}
+	actionType, ok := c.layout.Events[event]
+	if !ok {
+		return a, false, fmt.Errorf("unknown event %q", event)
+	}
+	a.Type = actionType
+	a.OrderType = orderbook.GoodTillCancel
+
+	if columns.Timestamp >= 0 {
+		if a.Timestamp, err = c.parseTime(record[columns.Timestamp]); err != nil {
+			return a, false, err
+		}
+	}
+	if a.OrderId, err = parseOrderId(record[columns.OrderId]); err != nil {
+		return a, false, err
+	}
+	if a.Type == backtest.ActionCancel {
+		return a, false, nil
+	}
+	if a.Quantity, err = parseQuantity(record[columns.Size]); err != nil {
+		return a, false, err
+	}
+	if a.Type == backtest.ActionReduce {
+		return a, false, nil
+	}
+
+	price := record[columns.Price]
+	ticks, ok := parseDecimal(price, c.layout.PriceDecimals)
+	if !ok {
+		return a, false, fmt.Errorf("invalid price %q", price)
+	}
+	if a.Price, ok = toPrice(ticks); !ok {
+		return a, false, fmt.Errorf("invalid price %q", price)
+	}
+
+	switch side := record[columns.Side]; side {
+	case c.layout.Buy:
+		a.Side = orderbook.Buy
+	case c.layout.Sell:
+		a.Side = orderbook.Sell
+	default:
+		return a, false, fmt.Errorf("invalid side %q", side)
+	}
+	return a, false, nil
+}
+
+func (c *CSVReader) parseTime(s string) (time.Time, error) {
+	if c.layout.TimeFormat != "" {
+		t, err := time.Parse(c.layout.TimeFormat, s)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid timestamp %q", s)
+		}
+		return t, nil
+	}
+	nanos, ok := parseDecimal(s, 9)
+	if !ok {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", s)
+	}
+	return time.Unix(0, nanos), nil
+}
@@ -0,0 +1,100 @@
+// Package importer reads order flow recorded by other systems as
+// backtest.Actions, which can be replayed by a backtest.Harness or loaded
+// into a book in bulk with Load.
+//
+// Problems mapping a record are reported with the line it was read from.
+package importer
+
+import (
+	"encoding/csv"
+	"fmt"
+	"go-orderbook/pkg/backtest"
+	"go-orderbook/pkg/orderbook"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Load applies every action of src to book, as fast as possible. Actions the
+// book rejects, such as cancels of orders added before the recording
+// started, are counted and skipped.
+func Load(book *orderbook.Orderbook, src backtest.Source) (applied, rejected int, err error) {
+	for {
+		a, err := src.Next()
+		if err == io.EOF {
+			return applied, rejected, nil
+		}
+		if err != nil {
+			return applied, rejected, err
+		}
+		if _, err := backtest.Apply(book, a); err != nil {
+			rejected++
+		} else {
+			applied++
+		}
+	}
+}
+
+// lineError reports a mapping problem at the line of a record.
+func lineError(r *csv.Reader, format string, args ...any) error {
+	line, _ := r.FieldPos(0)
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+func newCSVReader(r io.Reader, comma rune) *csv.Reader {
+	cr := csv.NewReader(r)
+	if comma != 0 {
+		cr.Comma = comma
+	}
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+	cr.TrimLeadingSpace = true
+	return cr
+}
+
+func parseOrderId(s string) (orderbook.OrderId, error) {
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid order id %q", s)
+	}
+	return orderbook.OrderId(v), nil
+}
+
+func parseQuantity(s string) (orderbook.Quantity, error) {
+	v, err := strconv.ParseUint(s, 10, 32)
+	if err != nil || v == 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return orderbook.Quantity(v), nil
+}
+
+// parseDecimal parses a decimal number with at most decimals digits after
+// the point and returns it scaled by 10^decimals.
+func parseDecimal(s string, decimals int) (int64, bool) {
+	if s == "" {
+		return 0, false
+	}
+	whole, fraction, _ := strings.Cut(s, ".")
+	if len(fraction) > decimals {
+		// Trailing zeros do not add precision.
+		trimmed := strings.TrimRight(fraction, "0")
+		if len(trimmed) > decimals {
+			return 0, false
+		}
+		fraction = trimmed
+	}
+	fraction += strings.Repeat("0", decimals-len(fraction))
+	if whole == "" || whole == "-" {
+		whole += "0"
+	}
+	v, err := strconv.ParseInt(whole+fraction, 10, 64)
+	return v, err == nil
+}
+
+func toPrice(v int64) (orderbook.Price, bool) {
+	if v <= 0 || v > math.MaxInt32 {
+		return 0, false
+	}
+	return orderbook.Price(v), true
+}
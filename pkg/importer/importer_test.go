@@ -0,0 +1,206 @@
+package importer
+
+import (
+	"fmt"
+	"go-orderbook/pkg/backtest"
+	"go-orderbook/pkg/orderbook"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readAll(t *testing.T, src backtest.Source) []backtest.Action {
+	t.Helper()
+	var actions []backtest.Action
+	for {
+		a, err := src.Next()
+		if err == io.EOF {
+			return actions
+		}
+		require.NoError(t, err)
+		actions = append(actions, a)
+	}
+}
+
+const lobsterSample = `34200.004241176,1,16113575,18,5853300,1
+34200.005288579,1,16113584,18,5853200,1
+34200.011800000,1,16120456,50,5859100,-1
+34200.025232000,5,0,100,5855000,-1
+34200.050000000,4,16113575,10,5853300,1
+34200.060000000,2,16113584,8,5853200,1
+34200.070000000,3,16120456,50,5859100,-1
+34200.080000000,7,-1,0,-1,-1
+`
+
+// firstError reads src up to its first error, returning nil at the end.
+func firstError(src backtest.Source) error {
+	for {
+		_, err := src.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func TestLOBSTER(t *testing.T) {
+	date := time.Date(2012, 6, 21, 0, 0, 0, 0, time.UTC)
+	actions := readAll(t, NewLOBSTERReader(
+		strings.NewReader(lobsterSample),
+		LOBSTEROptions{Date: date, PriceDivisor: 100},
+	))
+
+	at := func(nanos int64) time.Time {
+		return date.Add(time.Duration(nanos))
+	}
+	assert.Equal(t, []backtest.Action{
+		{Type: backtest.ActionAdd, Timestamp: at(34200004241176), OrderType: orderbook.GoodTillCancel, OrderId: 16113575, Side: orderbook.Buy, Price: 58533, Quantity: 18},
+		{Type: backtest.ActionAdd, Timestamp: at(34200005288579), OrderType: orderbook.GoodTillCancel, OrderId: 16113584, Side: orderbook.Buy, Price: 58532, Quantity: 18},
+		{Type: backtest.ActionAdd, Timestamp: at(34200011800000), OrderType: orderbook.GoodTillCancel, OrderId: 16120456, Side: orderbook.Sell, Price: 58591, Quantity: 50},
+		{Type: backtest.ActionReduce, Timestamp: at(34200050000000), OrderId: 16113575, Quantity: 10},
+		{Type: backtest.ActionReduce, Timestamp: at(34200060000000), OrderId: 16113584, Quantity: 8},
+		{Type: backtest.ActionCancel, Timestamp: at(34200070000000), OrderId: 16120456},
+	}, actions)
+
+	book := orderbook.NewOrderbook()
+	applied, rejected, err := Load(&book, NewLOBSTERReader(
+		strings.NewReader(lobsterSample),
+		LOBSTEROptions{Date: date, PriceDivisor: 100},
+	))
+	require.NoError(t, err)
+	assert.Equal(t, 6, applied)
+	assert.Zero(t, rejected)
+
+	info := book.OrderInfo()
+	require.Len(t, info.GetBids(), 2)
+	assert.Equal(t, orderbook.Quantity(8), info.GetBids()[0].Quantity)
+	assert.Equal(t, orderbook.Quantity(10), info.GetBids()[1].Quantity)
+	assert.Empty(t, info.GetAsks())
+}
+
+func TestLOBSTERErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		err   string
+	}{
+		{"34200.1,1,1,10,5853300\n", "line 1: expected 6 fields, got 5"},
+		{"34200.1,1,1,10,5853300,1\n34200.2,9,1,10,5853300,1\n", "line 2: unknown event type 9"},
+		{"34200.1,1,1,10,5853350,1\n", `line 1: invalid price "5853350"`},
+		{"34200.1,1,1,10,5853300,0\n", `line 1: invalid direction "0"`},
+		{"34200.1,1,x,10,5853300,1\n", `line 1: invalid order id "x"`},
+		{"34200.1,2,1,0,5853300,1\n", `line 1: invalid size "0"`},
+		{"34200.1234567891,1,1,10,5853300,1\n", `line 1: invalid time "34200.1234567891"`},
+	}
+	for _, test := range tests {
+		err := firstError(NewLOBSTERReader(
+			strings.NewReader(test.input),
+			LOBSTEROptions{PriceDivisor: 100},
+		))
+		assert.EqualError(t, err, test.err, test.input)
+	}
+}
+
+func TestCSVDefaultLayout(t *testing.T) {
+	input := `timestamp,event,order_id,side,price,size
+1700000000.5,add,1,B,100,10
+1700000001,add,2,S,101,5
+1700000002,reduce,1,,,4
+1700000003,modify,2,S,102,5
+1700000004,cancel,1,,,
+`
+	actions := readAll(t, NewCSVReader(strings.NewReader(input), DefaultCSVLayout))
+	assert.Equal(t, []backtest.Action{
+		{Type: backtest.ActionAdd, Timestamp: time.Unix(1700000000, 5e8), OrderType: orderbook.GoodTillCancel, OrderId: 1, Side: orderbook.Buy, Price: 100, Quantity: 10},
+		{Type: backtest.ActionAdd, Timestamp: time.Unix(1700000001, 0), OrderType: orderbook.GoodTillCancel, OrderId: 2, Side: orderbook.Sell, Price: 101, Quantity: 5},
+		{Type: backtest.ActionReduce, Timestamp: time.Unix(1700000002, 0), OrderType: orderbook.GoodTillCancel, OrderId: 1, Quantity: 4},
+		{Type: backtest.ActionModify, Timestamp: time.Unix(1700000003, 0), OrderType: orderbook.GoodTillCancel, OrderId: 2, Side: orderbook.Sell, Price: 102, Quantity: 5},
+		{Type: backtest.ActionCancel, Timestamp: time.Unix(1700000004, 0), OrderType: orderbook.GoodTillCancel, OrderId: 1},
+	}, actions)
+}
+
+func TestCSVCustomLayout(t *testing.T) {
+	layout := CSVLayout{
+		Comma: ';',
+		Columns: Columns{
+			Timestamp: -1,
+			Event:     4,
+			OrderId:   0,
+			Side:      1,
+			Price:     2,
+			Size:      3,
+		},
+		Events: map[string]backtest.ActionType{
+			"N": backtest.ActionAdd,
+			"D": backtest.ActionCancel,
+		},
+		Ignore:        []string{"T"},
+		Buy:           "buy",
+		Sell:          "sell",
+		PriceDecimals: 2,
+	}
+	input := "7;buy;12.34;100;N\n7;buy;12.34;100;T\n8;sell;12.5;30;N\n7;;;;D\n"
+
+	book := orderbook.NewOrderbook()
+	applied, rejected, err := Load(&book, NewCSVReader(strings.NewReader(input), layout))
+	require.NoError(t, err)
+	assert.Equal(t, 3, applied)
+	assert.Zero(t, rejected)
+
+	order, ok := book.Order(8)
+	require.True(t, ok)
+	assert.Equal(t, orderbook.Price(1250), order.Price())
+	assert.Equal(t, 1, book.Size())
+
+	_, err = NewCSVReader(strings.NewReader("1;buy;12.345;100;N\n"), layout).Next()
+	assert.EqualError(t, err, `line 1: invalid price "12.345"`)
+	_, err = NewCSVReader(strings.NewReader("1;buy;12.34;100;X\n"), layout).Next()
+	assert.EqualError(t, err, `line 1: unknown event "X"`)
+}
+
+func TestCSVErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		err   string
+	}{
+		{"h\n1,add,1,B,100\n", "line 2: expected 6 fields, got 5"},
+		{"h\n1,add,1,X,100,10\n", `line 2: invalid side "X"`},
+		{"h\nnow,add,1,B,100,10\n", `line 2: invalid timestamp "now"`},
+		{"h\n\n1,add,1,B,-1,10\n", `line 3: invalid price "-1"`},
+		{"h\n1,add,1,B,100,-10\n", `line 2: invalid size "-10"`},
+	}
+	for _, test := range tests {
+		err := firstError(NewCSVReader(strings.NewReader(test.input), DefaultCSVLayout))
+		assert.EqualError(t, err, test.err, test.input)
+	}
+}
+
+func BenchmarkLoadLOBSTER(b *testing.B) {
+	var sb strings.Builder
+	for i := 0; i < 10000; i++ {
+		direction := 1
+		price := 5990000 - i%10*1000
+		if i%2 == 1 {
+			direction = -1
+			price = 6000000 + i%10*1000
+		}
+		fmt.Fprintf(&sb, "%d.%09d,1,%d,%d,%d,%d\n", 34200+i/1000, i, i+1, 35+i%10, price, direction)
+		if i%3 == 2 {
+			fmt.Fprintf(&sb, "%d.%09d,2,%d,%d,%d,%d\n", 34200+i/1000, i, i, 5, price, direction)
+		}
+	}
+	data := sb.String()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		book := orderbook.NewOrderbook()
+		if _, _, err := Load(&book, NewLOBSTERReader(strings.NewReader(data), LOBSTEROptions{})); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package importer
+
+import (
+	"encoding/csv"
+	"fmt"
+	"go-orderbook/pkg/backtest"
+	"go-orderbook/pkg/orderbook"
+	"io"
+	"strconv"
+	"time"
+)
+
+// LOBSTER event types.
+const (
+	lobsterSubmit         = 1
+	lobsterCancel         = 2
+	lobsterDelete         = 3
+	lobsterExecuteVisible = 4
+	lobsterExecuteHidden  = 5
+	lobsterCross          = 6
+	lobsterHalt           = 7
+)
+
+const lobsterFields = 6
+
+type LOBSTEROptions struct {
+	// Date is the trading day of the file. LOBSTER times are seconds after
+	// its midnight.
+	Date time.Time
+	// PriceDivisor converts LOBSTER prices, in ten thousandths of a dollar,
+	// to book ticks: 100 gives cents. Zero is taken as 1. Prices must divide
+	// evenly.
+	PriceDivisor int64
+}
+
+// LOBSTERReader reads a LOBSTER message file: comma separated time, event
+// type, order id, size, price and direction, without a header.
+//
+// LOBSTER only describes the visible resting orders of the book, not the
+// orders that traded with them on arrival, so executions of visible orders
+// are replayed as partial cancels. Executions of hidden orders, cross trades
+// and trading halts do not change the visible book and are skipped.
+type LOBSTERReader struct {
+	r    *csv.Reader
+	opts LOBSTEROptions
+}
+
+func NewLOBSTERReader(r io.Reader, opts LOBSTEROptions) *LOBSTERReader {
+	if opts.PriceDivisor == 0 {
+		opts.PriceDivisor = 1
+	}
+	return &LOBSTERReader{r: newCSVReader(r, ','), opts: opts}
+}
+
+func (l *LOBSTERReader) Next() (backtest.Action, error) {
+	for {
+		record, err := l.r.Read()
+		if err != nil {
+			return backtest.Action{}, err
+		}
+		a, skip, err := l.parse(record)
+		if err != nil {
+			return backtest.Action{}, lineError(l.r, "%v", err)
+		}
+		if !skip {
+			return a, nil
+		}
+	}
+}
+
+func (l *LOBSTERReader) parse(record []string) (a backtest.Action, skip bool, err error) {
+	if len(record) < lobsterFields {
+		return a, false, fmt.Errorf("expected %d fields, got %d", lobsterFields, len(record))
+	}
+
+	event, err := strconv.Atoi(record[1])
+	if err != nil {
+		return a, false, fmt.Errorf("invalid event type %q", record[1])
+	}
+	switch event {
+	case lobsterSubmit:
+		a.Type = backtest.ActionAdd
+		a.OrderType = orderbook.GoodTillCancel
+	case lobsterCancel, lobsterExecuteVisible:
+		a.Type = backtest.ActionReduce
+	case lobsterDelete:
+		a.Type = backtest.ActionCancel
+	case lobsterExecuteHidden, lobsterCross, lobsterHalt:
+		return a, true, nil
+	default:
+		return a, false, fmt.Errorf("unknown event type %d", event)
+	}
+
+	nanos, ok := parseDecimal(record[0], 9)
+	if !ok || nanos < 0 {
+		return a, false, fmt.Errorf("invalid time %q", record[0])
+	}
+	a.Timestamp = l.opts.Date.Add(time.Duration(nanos))
+
+	if a.OrderId, err = parseOrderId(record[2]); err != nil {
+		return a, false, err
+	}
+	if a.Type == backtest.ActionCancel {
+		return a, false, nil
+	}
+	if a.Quantity, err = parseQuantity(record[3]); err != nil {
+		return a, false, err
+	}
+	if a.Type == backtest.ActionReduce {
+		return a, false, nil
+	}
+
+	price, err := strconv.ParseInt(record[4], 10, 64)
+	if err != nil || price%l.opts.PriceDivisor != 0 {
+		return a, false, fmt.Errorf("invalid price %q", record[4])
+	}
+	if a.Price, ok = toPrice(price / l.opts.PriceDivisor); !ok {
+		return a, false, fmt.Errorf("invalid price %q", record[4])
+	}
+
+	switch record[5] {
+	case "1":
+		a.Side = orderbook.Buy
+	case "-1":
+		a.Side = orderbook.Sell
+	default:
+		return a, false, fmt.Errorf("invalid direction %q", record[5])
+	}
+	return a, false, nil
+}
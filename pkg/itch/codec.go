@@ -0,0 +1,94 @@
+package itch
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var ErrUnknownMessage = errors.New("unknown message type")
+
+// Encoder writes length prefixed messages to a stream. It does not buffer, so
+// files should be written through a bufio.Writer.
+type Encoder struct {
+	w   io.Writer
+	buf []byte
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w, buf: make([]byte, 0, 2+MaxMessageSize)}
+}
+
+func (e *Encoder) Encode(m Message) error {
+	b := m.AppendTo(append(e.buf[:0], 0, 0))
+	binary.BigEndian.PutUint16(b, uint16(len(b)-2))
+	e.buf = b
+	_, err := e.w.Write(b)
+	return err
+}
+
+// Decoder reads the messages written by an Encoder. The messages it returns
+// are reused, so they are only valid until the next call to Next.
+type Decoder struct {
+	r   io.Reader
+	buf [2 + MaxMessageSize]byte
+
+	systemEvent   SystemEvent
+	addOrder      AddOrder
+	orderExecuted OrderExecuted
+	orderCancel   OrderCancel
+	orderDelete   OrderDelete
+	orderReplace  OrderReplace
+	trade         Trade
+	brokenTrade   BrokenTrade
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// Next returns the next message. It returns io.EOF at the end of the stream
+// and io.ErrUnexpectedEOF if the stream ends inside a message.
+func (d *Decoder) Next() (Message, error) {
+	if _, err := io.ReadFull(d.r, d.buf[:2]); err != nil {
+		return nil, err
+	}
+	length := int(binary.BigEndian.Uint16(d.buf[:2]))
+	if length == 0 || length > MaxMessageSize {
+		return nil, fmt.Errorf("%w: message of %d bytes", ErrMessageSize, length)
+	}
+	b := d.buf[2 : 2+length]
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	var m Message
+	switch b[0] {
+	case MsgSystemEvent:
+		m = &d.systemEvent
+	case MsgAddOrder:
+		m = &d.addOrder
+	case MsgOrderExecuted:
+		m = &d.orderExecuted
+	case MsgOrderCancel:
+		m = &d.orderCancel
+	case MsgOrderDelete:
+		m = &d.orderDelete
+	case MsgOrderReplace:
+		m = &d.orderReplace
+	case MsgTrade:
+		m = &d.trade
+	case MsgBrokenTrade:
+		m = &d.brokenTrade
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownMessage, b[0])
+	}
+	if err := m.Decode(b); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
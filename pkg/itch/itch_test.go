@@ -0,0 +1,156 @@
+package itch
+
+import (
+	"bytes"
+	"go-orderbook/pkg/engine"
+	"go-orderbook/pkg/orderbook"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	messages := []Message{
+		&SystemEvent{Timestamp: 1, Event: StartOfMessages},
+		&AddOrder{Timestamp: 2, OrderRef: 7, Side: SideSell, Shares: 10, Stock: NewStock("ACME"), Price: -5},
+		&OrderExecuted{Timestamp: 3, OrderRef: 7, ExecutedShares: 4, MatchNumber: 1},
+		&OrderCancel{Timestamp: 4, OrderRef: 7, CanceledShares: 2},
+		&OrderReplace{Timestamp: 5, OriginalOrderRef: 7, NewOrderRef: 7, Shares: 3, Price: 9},
+		&OrderDelete{Timestamp: 6, OrderRef: 7},
+		&Trade{Timestamp: 7, BuyOrderRef: 8, SellOrderRef: 7, Aggressor: SideBuy, Shares: 4, Stock: NewStock("ACME"), Price: 100, MatchNumber: 1},
+		&BrokenTrade{Timestamp: 8, Stock: NewStock("ACME"), MatchNumber: 1},
+		&SystemEvent{Timestamp: 9, Event: EndOfMessages},
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	for _, m := range messages {
+		require.NoError(t, enc.Encode(m))
+	}
+
+	dec := NewDecoder(bytes.NewReader(buf.Bytes()))
+	for _, want := range messages {
+		got, err := dec.Next()
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+	_, err := dec.Next()
+	assert.Equal(t, io.EOF, err)
+
+	dec = NewDecoder(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	for range messages[1:] {
+		_, err = dec.Next()
+		require.NoError(t, err)
+	}
+	_, err = dec.Next()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	_, err = NewDecoder(bytes.NewReader([]byte{0, 1, 'Q'})).Next()
+	assert.ErrorIs(t, err, ErrUnknownMessage)
+}
+
+func TestRecorder(t *testing.T) {
+	e := engine.NewEngine()
+	require.NoError(t, e.List("ACME"))
+	require.NoError(t, e.List("OTHER"))
+
+	var buf bytes.Buffer
+	r := NewRecorder(&buf)
+	var clock uint64
+	r.now = func() uint64 {
+		clock++
+		return clock
+	}
+	e.AddListener(r)
+	require.NoError(t, r.Start())
+
+	_, err := e.AddOrder("ACME", orderbook.NewOrder(orderbook.GoodTillCancel, 1, orderbook.Sell, 100, 10))
+	require.NoError(t, err)
+	_, err = e.AddOrder("ACME", orderbook.NewOrder(orderbook.GoodTillCancel, 2, orderbook.Buy, 101, 4))
+	require.NoError(t, err)
+
+	var modify orderbook.OrderModify
+	_, err = e.ModifyOrder(modify.New(1, 100, orderbook.Sell, 5))
+	require.NoError(t, err)
+	_, err = e.ModifyOrder(modify.New(1, 102, orderbook.Sell, 5))
+	require.NoError(t, err)
+	require.NoError(t, e.CancelOrder(1))
+	// A delete is written at the end of its command, before the events of
+	// other books.
+	_, err = e.AddOrder("OTHER", orderbook.NewOrder(orderbook.GoodTillCancel, 3, orderbook.Buy, 50, 1))
+	require.NoError(t, err)
+	// Only what is left of an incoming order is displayed, after the
+	// executions of the orders it matched.
+	_, err = e.AddOrder("OTHER", orderbook.NewOrder(orderbook.GoodTillCancel, 4, orderbook.Sell, 49, 3))
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+
+	want := []string{
+		"1 SystemEvent event=O",
+		"3 AddOrder ref=1 side=S shares=10 stock=ACME price=100",
+		"6 OrderExecuted ref=1 shares=4 match=1",
+		"7 OrderCancel ref=1 shares=1",
+		"10 OrderReplace ref=1 new=1 shares=5 price=102",
+		"11 OrderDelete ref=1",
+		"13 AddOrder ref=3 side=B shares=1 stock=OTHER price=50",
+		"15 OrderExecuted ref=3 shares=1 match=1",
+		"17 AddOrder ref=4 side=S shares=2 stock=OTHER price=49",
+		"18 SystemEvent event=C",
+	}
+	var got []string
+	dec := NewDecoder(&buf)
+	for {
+		m, err := dec.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		got = append(got, m.String())
+	}
+	assert.Equal(t, want, got)
+}
+
+func TestRecorderCorrections(t *testing.T) {
+	e := engine.NewEngine()
+	require.NoError(t, e.List("ACME"))
+
+	var buf bytes.Buffer
+	r := NewRecorder(&buf)
+	var clock uint64
+	r.now = func() uint64 {
+		clock++
+		return clock
+	}
+	e.AddListener(r)
+
+	_, err := e.AddOrder("ACME", orderbook.NewOrder(orderbook.GoodTillCancel, 1, orderbook.Buy, 100, 10))
+	require.NoError(t, err)
+	_, err = e.AddOrder("ACME", orderbook.NewOrder(orderbook.FillAndKill, 2, orderbook.Sell, 100, 4))
+	require.NoError(t, err)
+	_, err = e.CorrectTrade("ACME", 1, 99, "ops", "wrong print")
+	require.NoError(t, err)
+	_, err = e.BustTrade("ACME", 1, "ops", "erroneous")
+	require.NoError(t, err)
+	require.NoError(t, r.Flush())
+
+	want := []string{
+		"2 AddOrder ref=1 side=B shares=10 stock=ACME price=100",
+		"4 OrderExecuted ref=1 shares=4 match=1",
+		"7 BrokenTrade stock=ACME match=1",
+		"7 Trade buy=1 sell=2 aggressor=S shares=4 stock=ACME price=99 match=1",
+		"9 BrokenTrade stock=ACME match=1",
+	}
+	var got []string
+	dec := NewDecoder(&buf)
+	for {
+		m, err := dec.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		got = append(got, m.String())
+	}
+	assert.Equal(t, want, got)
+}
@@ -0,0 +1,380 @@
+// Package itch implements an ITCH-style binary market data format for the
+// order-by-order events of the engine.
+//
+// Every message starts with its type and a nanosecond Unix timestamp. All
+// integers are big-endian and stock symbols are ASCII, left justified and
+// padded with spaces. In a stream, each message is preceded by its length as
+// a two byte big-endian integer.
+package itch
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Message types.
+const (
+	MsgSystemEvent   byte = 'S'
+	MsgAddOrder      byte = 'A'
+	MsgOrderExecuted byte = 'E'
+	MsgOrderCancel   byte = 'X'
+	MsgOrderDelete   byte = 'D'
+	MsgOrderReplace  byte = 'U'
+	MsgTrade         byte = 'P'
+	MsgBrokenTrade   byte = 'B'
+)
+
+// System event codes.
+const (
+	StartOfMessages byte = 'O'
+	EndOfMessages   byte = 'C'
+)
+
+// Sides.
+const (
+	SideBuy  byte = 'B'
+	SideSell byte = 'S'
+)
+
+// Encoded sizes, including the message type.
+const (
+	headerSize = 1 + 8
+
+	SystemEventSize   = headerSize + 1
+	AddOrderSize      = headerSize + 8 + 1 + 4 + 8 + 4
+	OrderExecutedSize = headerSize + 8 + 4 + 8
+	OrderCancelSize   = headerSize + 8 + 4
+	OrderDeleteSize   = headerSize + 8
+	OrderReplaceSize  = headerSize + 8 + 8 + 4 + 4
+	TradeSize         = headerSize + 8 + 8 + 1 + 4 + 8 + 4 + 8
+	BrokenTradeSize   = headerSize + 8 + 8
+
+	// MaxMessageSize is the size of the largest message.
+	MaxMessageSize = TradeSize
+)
+
+var ErrMessageSize = errors.New("invalid message size")
+
+// Stock is the symbol of an instrument.
+type Stock [8]byte
+
+// NewStock pads s with spaces. Longer strings are truncated.
+func NewStock(s string) Stock {
+	var st Stock
+	n := copy(st[:], s)
+	for i := n; i < len(st); i++ {
+		st[i] = ' '
+	}
+	return st
+}
+
+func (s Stock) String() string {
+	b := s[:]
+	for len(b) > 0 && b[len(b)-1] == ' ' {
+		b = b[:len(b)-1]
+	}
+	return string(b)
+}
+
+// Message is implemented by every message type. Decode expects the message
+// type as the first byte of b.
+type Message interface {
+	fmt.Stringer
+	Type() byte
+	AppendTo(b []byte) []byte
+	Decode(b []byte) error
+}
+
+func checkSize(b []byte, msgType byte, size int) error {
+	if len(b) != size || b[0] != msgType {
+		return fmt.Errorf("%w: %q message of %d bytes", ErrMessageSize, msgType, len(b))
+	}
+	return nil
+}
+
+func appendHeader(b []byte, msgType byte, timestamp uint64) []byte {
+	b = append(b, msgType)
+	return binary.BigEndian.AppendUint64(b, timestamp)
+}
+
+// SystemEvent marks the start and the end of a stream.
+type SystemEvent struct {
+	Timestamp uint64
+	Event     byte
+}
+
+func (m *SystemEvent) Type() byte { return MsgSystemEvent }
+
+func (m *SystemEvent) AppendTo(b []byte) []byte {
+	return append(appendHeader(b, MsgSystemEvent, m.Timestamp), m.Event)
+}
+
+func (m *SystemEvent) Decode(b []byte) error {
+	if err := checkSize(b, MsgSystemEvent, SystemEventSize); err != nil {
+		return err
+	}
+	m.Timestamp = binary.BigEndian.Uint64(b[1:])
+	m.Event = b[9]
+	return nil
+}
+
+func (m *SystemEvent) String() string {
+	return fmt.Sprintf("%d SystemEvent event=%c", m.Timestamp, m.Event)
+}
+
+// AddOrder places an order at the back of the queue of its price level.
+type AddOrder struct {
+	Timestamp uint64
+	OrderRef  uint64
+	Side      byte
+	Shares    uint32
+	Stock     Stock
+	Price     int32
+}
+
+func (m *AddOrder) Type() byte { return MsgAddOrder }
+
+func (m *AddOrder) AppendTo(b []byte) []byte {
+	b = appendHeader(b, MsgAddOrder, m.Timestamp)
+	b = binary.BigEndian.AppendUint64(b, m.OrderRef)
+	b = append(b, m.Side)
+	b = binary.BigEndian.AppendUint32(b, m.Shares)
+	b = append(b, m.Stock[:]...)
+	return binary.BigEndian.AppendUint32(b, uint32(m.Price))
+}
+
+func (m *AddOrder) Decode(b []byte) error {
+	if err := checkSize(b, MsgAddOrder, AddOrderSize); err != nil {
+		return err
+	}
+	m.Timestamp = binary.BigEndian.Uint64(b[1:])
+	m.OrderRef = binary.BigEndian.Uint64(b[9:])
+	m.Side = b[17]
+	m.Shares = binary.BigEndian.Uint32(b[18:])
+	copy(m.Stock[:], b[22:30])
+	m.Price = int32(binary.BigEndian.Uint32(b[30:]))
+	return nil
+}
+
+func (m *AddOrder) String() string {
+	return fmt.Sprintf(
+		"%d AddOrder ref=%d side=%c shares=%d stock=%s price=%d",
+		m.Timestamp, m.OrderRef, m.Side, m.Shares, m.Stock, m.Price,
+	)
+}
+
+// OrderExecuted reports a fill of a displayed resting order, at its price.
+// The incoming order of the match is not reported.
+type OrderExecuted struct {
+	Timestamp      uint64
+	OrderRef       uint64
+	ExecutedShares uint32
+	MatchNumber    uint64
+}
+
+func (m *OrderExecuted) Type() byte { return MsgOrderExecuted }
+
+func (m *OrderExecuted) AppendTo(b []byte) []byte {
+	b = appendHeader(b, MsgOrderExecuted, m.Timestamp)
+	b = binary.BigEndian.AppendUint64(b, m.OrderRef)
+	b = binary.BigEndian.AppendUint32(b, m.ExecutedShares)
+	return binary.BigEndian.AppendUint64(b, m.MatchNumber)
+}
+
+func (m *OrderExecuted) Decode(b []byte) error {
+	if err := checkSize(b, MsgOrderExecuted, OrderExecutedSize); err != nil {
+		return err
+	}
+	m.Timestamp = binary.BigEndian.Uint64(b[1:])
+	m.OrderRef = binary.BigEndian.Uint64(b[9:])
+	m.ExecutedShares = binary.BigEndian.Uint32(b[17:])
+	m.MatchNumber = binary.BigEndian.Uint64(b[21:])
+	return nil
+}
+
+func (m *OrderExecuted) String() string {
+	return fmt.Sprintf(
+		"%d OrderExecuted ref=%d shares=%d match=%d",
+		m.Timestamp, m.OrderRef, m.ExecutedShares, m.MatchNumber,
+	)
+}
+
+// OrderCancel reduces the open shares of an order, keeping its priority.
+type OrderCancel struct {
+	Timestamp      uint64
+	OrderRef       uint64
+	CanceledShares uint32
+}
+
+func (m *OrderCancel) Type() byte { return MsgOrderCancel }
+
+func (m *OrderCancel) AppendTo(b []byte) []byte {
+	b = appendHeader(b, MsgOrderCancel, m.Timestamp)
+	b = binary.BigEndian.AppendUint64(b, m.OrderRef)
+	return binary.BigEndian.AppendUint32(b, m.CanceledShares)
+}
+
+func (m *OrderCancel) Decode(b []byte) error {
+	if err := checkSize(b, MsgOrderCancel, OrderCancelSize); err != nil {
+		return err
+	}
+	m.Timestamp = binary.BigEndian.Uint64(b[1:])
+	m.OrderRef = binary.BigEndian.Uint64(b[9:])
+	m.CanceledShares = binary.BigEndian.Uint32(b[17:])
+	return nil
+}
+
+func (m *OrderCancel) String() string {
+	return fmt.Sprintf(
+		"%d OrderCancel ref=%d shares=%d",
+		m.Timestamp, m.OrderRef, m.CanceledShares,
+	)
+}
+
+// OrderDelete removes an order from the book.
+type OrderDelete struct {
+	Timestamp uint64
+	OrderRef  uint64
+}
+
+func (m *OrderDelete) Type() byte { return MsgOrderDelete }
+
+func (m *OrderDelete) AppendTo(b []byte) []byte {
+	b = appendHeader(b, MsgOrderDelete, m.Timestamp)
+	return binary.BigEndian.AppendUint64(b, m.OrderRef)
+}
+
+func (m *OrderDelete) Decode(b []byte) error {
+	if err := checkSize(b, MsgOrderDelete, OrderDeleteSize); err != nil {
+		return err
+	}
+	m.Timestamp = binary.BigEndian.Uint64(b[1:])
+	m.OrderRef = binary.BigEndian.Uint64(b[9:])
+	return nil
+}
+
+func (m *OrderDelete) String() string {
+	return fmt.Sprintf("%d OrderDelete ref=%d", m.Timestamp, m.OrderRef)
+}
+
+// OrderReplace removes an order and adds its replacement, on the same side
+// and at the back of the queue of its new price level.
+type OrderReplace struct {
+	Timestamp        uint64
+	OriginalOrderRef uint64
+	NewOrderRef      uint64
+	Shares           uint32
+	Price            int32
+}
+
+func (m *OrderReplace) Type() byte { return MsgOrderReplace }
+
+func (m *OrderReplace) AppendTo(b []byte) []byte {
+	b = appendHeader(b, MsgOrderReplace, m.Timestamp)
+	b = binary.BigEndian.AppendUint64(b, m.OriginalOrderRef)
+	b = binary.BigEndian.AppendUint64(b, m.NewOrderRef)
+	b = binary.BigEndian.AppendUint32(b, m.Shares)
+	return binary.BigEndian.AppendUint32(b, uint32(m.Price))
+}
+
+func (m *OrderReplace) Decode(b []byte) error {
+	if err := checkSize(b, MsgOrderReplace, OrderReplaceSize); err != nil {
+		return err
+	}
+	m.Timestamp = binary.BigEndian.Uint64(b[1:])
+	m.OriginalOrderRef = binary.BigEndian.Uint64(b[9:])
+	m.NewOrderRef = binary.BigEndian.Uint64(b[17:])
+	m.Shares = binary.BigEndian.Uint32(b[25:])
+	m.Price = int32(binary.BigEndian.Uint32(b[29:]))
+	return nil
+}
+
+func (m *OrderReplace) String() string {
+	return fmt.Sprintf(
+		"%d OrderReplace ref=%d new=%d shares=%d price=%d",
+		m.Timestamp, m.OriginalOrderRef, m.NewOrderRef, m.Shares, m.Price,
+	)
+}
+
+// Trade is the print of a match that no OrderExecuted message reports, such
+// as the reprint of a corrected trade after its BrokenTrade. It carries the
+// price of the resting order.
+type Trade struct {
+	Timestamp    uint64
+	BuyOrderRef  uint64
+	SellOrderRef uint64
+	// Aggressor is the side of the incoming order.
+	Aggressor   byte
+	Shares      uint32
+	Stock       Stock
+	Price       int32
+	MatchNumber uint64
+}
+
+func (m *Trade) Type() byte { return MsgTrade }
+
+func (m *Trade) AppendTo(b []byte) []byte {
+	b = appendHeader(b, MsgTrade, m.Timestamp)
+	b = binary.BigEndian.AppendUint64(b, m.BuyOrderRef)
+	b = binary.BigEndian.AppendUint64(b, m.SellOrderRef)
+	b = append(b, m.Aggressor)
+	b = binary.BigEndian.AppendUint32(b, m.Shares)
+	b = append(b, m.Stock[:]...)
+	b = binary.BigEndian.AppendUint32(b, uint32(m.Price))
+	return binary.BigEndian.AppendUint64(b, m.MatchNumber)
+}
+
+func (m *Trade) Decode(b []byte) error {
+	if err := checkSize(b, MsgTrade, TradeSize); err != nil {
+		return err
+	}
+	m.Timestamp = binary.BigEndian.Uint64(b[1:])
+	m.BuyOrderRef = binary.BigEndian.Uint64(b[9:])
+	m.SellOrderRef = binary.BigEndian.Uint64(b[17:])
+	m.Aggressor = b[25]
+	m.Shares = binary.BigEndian.Uint32(b[26:])
+	copy(m.Stock[:], b[30:38])
+	m.Price = int32(binary.BigEndian.Uint32(b[38:]))
+	m.MatchNumber = binary.BigEndian.Uint64(b[42:])
+	return nil
+}
+
+func (m *Trade) String() string {
+	return fmt.Sprintf(
+		"%d Trade buy=%d sell=%d aggressor=%c shares=%d stock=%s price=%d match=%d",
+		m.Timestamp, m.BuyOrderRef, m.SellOrderRef, m.Aggressor,
+		m.Shares, m.Stock, m.Price, m.MatchNumber,
+	)
+}
+
+// BrokenTrade cancels an earlier Trade of a stock. A price correction is
+// reported as a BrokenTrade followed by a new Trade with the same match
+// number at the corrected price.
+type BrokenTrade struct {
+	Timestamp   uint64
+	Stock       Stock
+	MatchNumber uint64
+}
+
+func (m *BrokenTrade) Type() byte { return MsgBrokenTrade }
+
+func (m *BrokenTrade) AppendTo(b []byte) []byte {
+	b = appendHeader(b, MsgBrokenTrade, m.Timestamp)
+	b = append(b, m.Stock[:]...)
+	return binary.BigEndian.AppendUint64(b, m.MatchNumber)
+}
+
+func (m *BrokenTrade) Decode(b []byte) error {
+	if err := checkSize(b, MsgBrokenTrade, BrokenTradeSize); err != nil {
+		return err
+	}
+	m.Timestamp = binary.BigEndian.Uint64(b[1:])
+	copy(m.Stock[:], b[9:17])
+	m.MatchNumber = binary.BigEndian.Uint64(b[17:])
+	return nil
+}
+
+func (m *BrokenTrade) String() string {
+	return fmt.Sprintf("%d BrokenTrade stock=%s match=%d", m.Timestamp, m.Stock, m.MatchNumber)
+}
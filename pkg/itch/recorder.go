@@ -0,0 +1,271 @@
+package itch
+
+import (
+	"bufio"
+	"go-orderbook/pkg/engine"
+	"go-orderbook/pkg/orderbook"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+type recorderBook struct {
+	stock Stock
+	// amended is the buy side of the trade being busted or corrected.
+	amended orderbook.Event
+	// added holds back the OrderAdded event of an incoming order while it
+	// matches. Only what is left of it once it has matched is displayed;
+	// its executions are published as those of the resting orders.
+	added          orderbook.Event
+	addedIsPending bool
+	// deleted holds back an OrderDeleted event, so that it can be merged with
+	// an OrderAdded event of the same order into an OrderReplace.
+	deleted          orderbook.Event
+	deletedAt        uint64
+	deletedIsPending bool
+}
+
+// Recorder encodes the events of an engine as a stream of messages. It
+// implements engine.CommandListener, so it can be attached with AddListener.
+//
+// As in ITCH, the stream only describes displayed orders: an incoming order
+// is added with what is left of it once it has matched, if anything, and an
+// execution is published as an OrderExecuted of the resting order alone.
+// Trade messages only reprint corrected trades. A cancel/replace is
+// published by the book as a delete followed by an add of the same order,
+// which the Recorder merges into an OrderReplace. Events are held back until
+// the end of their command.
+type Recorder struct {
+	m     sync.Mutex
+	w     *bufio.Writer
+	enc   *Encoder
+	books map[engine.Symbol]*recorderBook
+	err   error
+	now   func() uint64
+}
+
+func NewRecorder(w io.Writer) *Recorder {
+	bw := bufio.NewWriter(w)
+	return &Recorder{
+		w:     bw,
+		enc:   NewEncoder(bw),
+		books: make(map[engine.Symbol]*recorderBook),
+		now: func() uint64 {
+			return uint64(time.Now().UnixNano())
+		},
+	}
+}
+
+func (r *Recorder) encode(m Message) {
+	if r.err == nil {
+		r.err = r.enc.Encode(m)
+	}
+}
+
+// Start writes the StartOfMessages system event.
+func (r *Recorder) Start() error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.encode(&SystemEvent{Timestamp: r.now(), Event: StartOfMessages})
+	return r.err
+}
+
+// Err returns the first write error. Once a write has failed, nothing more
+// is written.
+func (r *Recorder) Err() error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.err
+}
+
+func (r *Recorder) OnEvent(symbol engine.Symbol, ev orderbook.Event) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	book, ok := r.books[symbol]
+	if !ok {
+		book = &recorderBook{stock: NewStock(string(symbol))}
+		r.books[symbol] = book
+	}
+	timestamp := r.now()
+
+	// The executions of an incoming order, and the delete of the remainder
+	// of a FillAndKill order, follow its add. Any other event starts a new
+	// command.
+	incoming := book.addedIsPending && ev.OrderId == book.added.OrderId
+	switch {
+	case ev.Type == orderbook.OrderExecuted && book.addedIsPending:
+	case ev.Type == orderbook.OrderDeleted && incoming:
+	case ev.Type == orderbook.OrderAdded && !book.addedIsPending &&
+		(!book.deletedIsPending || book.deleted.OrderId == ev.OrderId):
+	default:
+		r.flush(book)
+	}
+
+	switch ev.Type {
+	case orderbook.OrderAdded:
+		book.added = ev
+		book.addedIsPending = true
+	case orderbook.OrderExecuted:
+		if incoming {
+			book.added.Quantity -= ev.Quantity
+			return
+		}
+		r.encode(&OrderExecuted{
+			Timestamp:      timestamp,
+			OrderRef:       uint64(ev.OrderId),
+			ExecutedShares: uint32(ev.Quantity),
+			MatchNumber:    ev.MatchId,
+		})
+	case orderbook.OrderReduced:
+		r.encode(&OrderCancel{
+			Timestamp:      timestamp,
+			OrderRef:       uint64(ev.OrderId),
+			CanceledShares: uint32(ev.Quantity),
+		})
+	case orderbook.OrderDeleted:
+		if incoming {
+			// The remainder of a FillAndKill order was never displayed.
+			book.addedIsPending = false
+			return
+		}
+		book.deleted = ev
+		book.deletedAt = timestamp
+		book.deletedIsPending = true
+	case orderbook.TradeBusted, orderbook.TradeCorrected:
+		// The engine amends the buy side of a trade first.
+		if ev.Side == orderbook.Buy {
+			book.amended = ev
+			return
+		}
+		r.encode(&BrokenTrade{Timestamp: timestamp, Stock: book.stock, MatchNumber: ev.MatchId})
+		if ev.Type == orderbook.TradeCorrected {
+			r.encode(book.trade(timestamp, book.amended, ev))
+		}
+	}
+}
+
+// flush writes the events held back for a book: the add of an incoming
+// order with what is left of it, merged with the delete of the same order
+// into an OrderReplace, and any other delete. The order is displayed once
+// it has matched, so it is timestamped now.
+func (r *Recorder) flush(book *recorderBook) {
+	added, deleted := book.added, book.deleted
+	replace := book.addedIsPending && book.deletedIsPending &&
+		added.OrderId == deleted.OrderId && added.Side == deleted.Side
+	if book.deletedIsPending && (!replace || added.Quantity == 0) {
+		r.encode(&OrderDelete{Timestamp: book.deletedAt, OrderRef: uint64(deleted.OrderId)})
+	}
+	if book.addedIsPending && added.Quantity > 0 {
+		timestamp := r.now()
+		if replace {
+			r.encode(&OrderReplace{
+				Timestamp:        timestamp,
+				OriginalOrderRef: uint64(deleted.OrderId),
+				NewOrderRef:      uint64(added.OrderId),
+				Shares:           uint32(added.Quantity),
+				Price:            int32(added.Price),
+			})
+		} else {
+			r.encode(&AddOrder{
+				Timestamp: timestamp,
+				OrderRef:  uint64(added.OrderId),
+				Side:      side(added.Side),
+				Shares:    uint32(added.Quantity),
+				Stock:     book.stock,
+				Price:     int32(added.Price),
+			})
+		}
+	}
+	book.addedIsPending = false
+	book.deletedIsPending = false
+}
+
+// trade reprints a corrected trade from the amendments of its two sides.
+func (b *recorderBook) trade(timestamp uint64, first, second orderbook.Event) *Trade {
+	buy, sell := first, second
+	if buy.Side != orderbook.Buy {
+		buy, sell = sell, buy
+	}
+	t := &Trade{
+		Timestamp:    timestamp,
+		BuyOrderRef:  uint64(buy.OrderId),
+		SellOrderRef: uint64(sell.OrderId),
+		Aggressor:    SideSell,
+		Shares:       uint32(buy.Quantity),
+		Stock:        b.stock,
+		Price:        int32(buy.TradePrice),
+		MatchNumber:  buy.MatchId,
+	}
+	if buy.Aggressor {
+		t.Aggressor = SideBuy
+	}
+	return t
+}
+
+// OnCommandEnd writes the events held back for the book of symbol.
+func (r *Recorder) OnCommandEnd(symbol engine.Symbol) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if book, ok := r.books[symbol]; ok {
+		r.flush(book)
+	}
+}
+
+// flushAll writes the events held back for every book, in the order they
+// were held back.
+func (r *Recorder) flushAll() {
+	books := make([]*recorderBook, 0, len(r.books))
+	for _, book := range r.books {
+		if book.addedIsPending || book.deletedIsPending {
+			books = append(books, book)
+		}
+	}
+	sort.Slice(books, func(i, j int) bool {
+		return books[i].heldAt() < books[j].heldAt()
+	})
+	for _, book := range books {
+		r.flush(book)
+	}
+}
+
+// heldAt returns when the delete held back for the book happened, zero if
+// only an add is.
+func (b *recorderBook) heldAt() uint64 {
+	if b.deletedIsPending {
+		return b.deletedAt
+	}
+	return 0
+}
+
+// Flush writes the events held back and any buffered data.
+func (r *Recorder) Flush() error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.flushAll()
+	if r.err == nil {
+		r.err = r.w.Flush()
+	}
+	return r.err
+}
+
+// Close writes the EndOfMessages system event and flushes the stream. It
+// does not close the underlying writer.
+func (r *Recorder) Close() error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.flushAll()
+	r.encode(&SystemEvent{Timestamp: r.now(), Event: EndOfMessages})
+	if r.err == nil {
+		r.err = r.w.Flush()
+	}
+	return r.err
+}
+
+func side(s orderbook.Side) byte {
+	if s == orderbook.Sell {
+		return SideSell
+	}
+	return SideBuy
+}
@@ -0,0 +1,161 @@
+package journal
+
+import (
+	"fmt"
+	"go-orderbook/pkg/engine"
+	"go-orderbook/pkg/orderbook"
+	"sync"
+)
+
+// Engine journals every command before applying it to an engine.Engine.
+// Commands are serialized, so that replaying the journal applies them in the
+// same order and rebuilds every book exactly.
+type Engine struct {
+	m       sync.Mutex
+	engine  *engine.Engine
+	journal *Journal
+	// lastOrderId is the highest order id of the add records.
+	lastOrderId orderbook.OrderId
+}
+
+// Recover opens the journal at path, replays its records into a new engine
+// and returns an Engine that appends to the same journal.
+func Recover(path string, opts Options) (*Engine, error) {
+	j, records, err := Open(path, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	e := engine.NewEngine()
+	if err := Replay(e, records); err != nil {
+		j.Close()
+		return nil, err
+	}
+	je := NewEngine(e, j)
+	for _, record := range records {
+		je.journaled(record)
+	}
+	return je, nil
+}
+
+// NewEngine wraps an engine whose state already matches the journal.
+func NewEngine(e *engine.Engine, j *Journal) *Engine {
+	return &Engine{
+		engine:  e,
+		journal: j,
+	}
+}
+
+// Replay applies records to an engine in order. Commands that were rejected
+// when they were first applied are rejected again and ignored; only records
+// of an unknown type stop the replay.
+func Replay(e *engine.Engine, records []Record) error {
+	for i, record := range records {
+		if _, err := apply(e, record); err != nil {
+			if _, ok := err.(unknownRecordError); ok {
+				return fmt.Errorf("record %d: %w", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+type unknownRecordError RecordType
+
+func (e unknownRecordError) Error() string {
+	return fmt.Sprintf("unknown record type %d", uint8(e))
+}
+
+func apply(e *engine.Engine, record Record) (orderbook.Trades, error) {
+	switch record.Type {
+	case RecordAdd:
+		return e.AddOrder(record.Symbol, record.Order())
+	case RecordCancel:
+		return nil, e.CancelOrder(record.OrderId)
+	case RecordModify:
+		return e.ModifyOrder(record.Modify())
+	case RecordList:
+		return nil, e.List(record.Symbol)
+	case RecordDelist:
+		return nil, e.Delist(record.Symbol)
+	}
+	return nil, unknownRecordError(record.Type)
+}
+
+// execute journals the record and then applies it. The engine is only
+// touched once the record is durable according to the sync policy.
+func (e *Engine) execute(record Record) (orderbook.Trades, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	if err := e.journal.Append(record); err != nil {
+		return nil, err
+	}
+	e.journaled(record)
+	return apply(e.engine, record)
+}
+
+// journaled keeps track of the order ids of the journal. It should only be
+// called with e.m held, or before e is shared.
+func (e *Engine) journaled(record Record) {
+	if record.Type == RecordAdd && record.OrderId > e.lastOrderId {
+		e.lastOrderId = record.OrderId
+	}
+}
+
+// LastOrderId returns the highest order id added through the journal, so
+// that a client restarting after a crash can carry on with fresh ids.
+func (e *Engine) LastOrderId() orderbook.OrderId {
+	e.m.Lock()
+	defer e.m.Unlock()
+	return e.lastOrderId
+}
+
+// Engine returns the wrapped engine for queries. Commands sent to it directly
+// bypass the journal.
+func (e *Engine) Engine() *engine.Engine {
+	return e.engine
+}
+
+func (e *Engine) List(symbol engine.Symbol) error {
+	_, err := e.execute(Record{Type: RecordList, Symbol: symbol})
+	return err
+}
+
+func (e *Engine) Delist(symbol engine.Symbol) error {
+	_, err := e.execute(Record{Type: RecordDelist, Symbol: symbol})
+	return err
+}
+
+func (e *Engine) AddOrder(
+	symbol engine.Symbol,
+	order orderbook.Order,
+) (orderbook.Trades, error) {
+	return e.execute(NewAddRecord(symbol, order))
+}
+
+func (e *Engine) CancelOrder(orderId orderbook.OrderId) error {
+	symbol, _ := e.engine.Lookup(orderId)
+	_, err := e.execute(Record{
+		Type:    RecordCancel,
+		Symbol:  symbol,
+		OrderId: orderId,
+	})
+	return err
+}
+
+func (e *Engine) ModifyOrder(
+	modify orderbook.OrderModify,
+) (orderbook.Trades, error) {
+	// The symbol is not needed to route cancels and modifies, but recording
+	// it keeps the journal readable on its own.
+	symbol, _ := e.engine.Lookup(modify.OrderId())
+	return e.execute(NewModifyRecord(symbol, modify))
+}
+
+// Close closes the journal.
+func (e *Engine) Close() error {
+	e.m.Lock()
+	defer e.m.Unlock()
+	return e.journal.Close()
+}
@@ -0,0 +1,290 @@
+// Package journal implements a write-ahead journal of engine commands and
+// recovers an engine by replaying it.
+//
+// Every record is written as a little-endian uint32 payload length, a CRC-32C
+// checksum of the payload and the payload itself. A crash can only tear the
+// last record, so a bad record that runs to the end of the file is truncated
+// when the journal is opened, while one followed by more records is
+// reported as corruption.
+package journal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+type SyncPolicy int
+
+const (
+	// SyncAlways fsyncs the journal before every Append returns.
+	SyncAlways SyncPolicy = iota
+	// SyncInterval fsyncs on the first Append after SyncInterval has elapsed
+	// since the previous fsync.
+	SyncInterval
+	// SyncNever leaves flushing to the operating system.
+	SyncNever
+)
+
+type Options struct {
+	Sync         SyncPolicy
+	SyncInterval time.Duration
+}
+
+const (
+	headerSize = 8
+	// maxRecordSize bounds the length prefix, so that a torn length is not
+	// mistaken for a huge record.
+	maxRecordSize = 1 << 16
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrCorrupt is returned by Open for a bad record that is not the last one of
+// the journal, which no crash explains.
+var ErrCorrupt = errors.New("corrupt journal")
+
+// Journal appends records to a file.
+type Journal struct {
+	m        sync.Mutex
+	f        *os.File
+	w        *bufio.Writer
+	opts     Options
+	lastSync time.Time
+	buf      []byte
+	// size is the offset just past the last record appended in full.
+	size int64
+}
+
+// Open opens or creates the journal at path and returns it together with the
+// records it already holds. A torn record at the tail is truncated, so that
+// new records are appended after the last valid one.
+func Open(path string, opts Options) (*Journal, []Record, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	records, end, err := Read(bufio.NewReader(f))
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if err := checkTail(f, end); err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := f.Truncate(end); err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if _, err := f.Seek(end, io.SeekStart); err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	return &Journal{
+		f:        f,
+		w:        bufio.NewWriter(f),
+		opts:     opts,
+		lastSync: time.Now(),
+		size:     end,
+	}, records, nil
+}
+
+// checkTail returns ErrCorrupt unless the bad record at end, if any, can be
+// the last write torn by a crash: the bytes after end must fit in one record
+// and none of them may start a valid record.
+func checkTail(f *os.File, end int64) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	rest := info.Size() - end
+	if rest > headerSize+maxRecordSize {
+		return fmt.Errorf("%w: bad record at offset %d followed by %d bytes",
+			ErrCorrupt, end, rest)
+	}
+	tail := make([]byte, rest)
+	if _, err := f.ReadAt(tail, end); err != nil {
+		return err
+	}
+	for i := 1; i+headerSize <= len(tail); i++ {
+		if validRecord(tail[i:]) {
+			return fmt.Errorf("%w: bad record at offset %d followed by a record at offset %d",
+				ErrCorrupt, end, end+int64(i))
+		}
+	}
+	return nil
+}
+
+// validRecord reports whether b starts with a whole record with a good
+// checksum.
+func validRecord(b []byte) bool {
+	size := int(binary.LittleEndian.Uint32(b[0:]))
+	if size > maxRecordSize || len(b) < headerSize+size {
+		return false
+	}
+	payload := b[headerSize : headerSize+size]
+	if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(b[4:]) {
+		return false
+	}
+	_, err := decodeRecord(payload)
+	return err == nil
+}
+
+// Read decodes records until the end of r or the first torn record. It returns
+// the valid records and the offset just past the last one. Only I/O errors are
+// reported; a torn tail is not an error.
+func Read(r io.Reader) ([]Record, int64, error) {
+	var (
+		records []Record
+		end     int64
+		header  [headerSize]byte
+		payload []byte
+	)
+
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return records, end, nil
+			}
+			return nil, 0, err
+		}
+
+		size := binary.LittleEndian.Uint32(header[0:])
+		checksum := binary.LittleEndian.Uint32(header[4:])
+		if size > maxRecordSize {
+			return records, end, nil
+		}
+
+		if cap(payload) < int(size) {
+			payload = make([]byte, size)
+		}
+		payload = payload[:size]
+		if _, err := io.ReadFull(r, payload); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return records, end, nil
+			}
+			return nil, 0, err
+		}
+		if crc32.Checksum(payload, crcTable) != checksum {
+			return records, end, nil
+		}
+
+		record, err := decodeRecord(payload)
+		if err != nil {
+			return records, end, nil
+		}
+		records = append(records, record)
+		end += headerSize + int64(size)
+	}
+}
+
+// Append writes a record to the journal. When it returns without error the
+// record has reached the operating system, and the disk if required by the
+// sync policy.
+func (j *Journal) Append(record Record) error {
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	var err error
+	j.buf, err = AppendRecord(j.buf[:0], record)
+	if err != nil {
+		return err
+	}
+	if _, err := j.w.Write(j.buf); err != nil {
+		return fmt.Errorf("journal write: %w", err)
+	}
+	if err := j.w.Flush(); err != nil {
+		return fmt.Errorf("journal flush: %w", err)
+	}
+	j.size += int64(len(j.buf))
+
+	switch j.opts.Sync {
+	case SyncAlways:
+		return j.sync()
+	case SyncInterval:
+		if time.Since(j.lastSync) >= j.opts.SyncInterval {
+			return j.sync()
+		}
+	}
+	return nil
+}
+
+// AppendRecord appends a record to b as it is written to a journal, with its
+// length and checksum, so that a stream of records can be decoded by Read.
+func AppendRecord(b []byte, record Record) ([]byte, error) {
+	start := len(b)
+	var header [headerSize]byte
+	b, err := record.appendTo(append(b, header[:]...))
+	if err != nil {
+		return b[:start], err
+	}
+	payload := b[start+headerSize:]
+	binary.LittleEndian.PutUint32(b[start:], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(b[start+4:], crc32.Checksum(payload, crcTable))
+	return b, nil
+}
+
+// Sync fsyncs the journal regardless of the sync policy.
+func (j *Journal) Sync() error {
+	j.m.Lock()
+	defer j.m.Unlock()
+	return j.sync()
+}
+
+// Offset returns the offset just past the last record appended in full,
+// whether or not it was synced.
+func (j *Journal) Offset() int64 {
+	j.m.Lock()
+	defer j.m.Unlock()
+	return j.size
+}
+
+// Truncate drops everything written after offset, such as records that could
+// not be synced or a partly written record, so that they are not replayed.
+func (j *Journal) Truncate(offset int64) error {
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	j.w.Reset(j.f)
+	if err := j.f.Truncate(offset); err != nil {
+		return fmt.Errorf("journal truncate: %w", err)
+	}
+	if _, err := j.f.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("journal truncate: %w", err)
+	}
+	j.size = offset
+	return nil
+}
+
+func (j *Journal) sync() error {
+	if err := j.f.Sync(); err != nil {
+		return fmt.Errorf("journal sync: %w", err)
+	}
+	j.lastSync = time.Now()
+	return nil
+}
+
+// Close syncs and closes the journal.
+func (j *Journal) Close() error {
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	if err := j.w.Flush(); err != nil {
+		j.f.Close()
+		return err
+	}
+	if err := j.f.Sync(); err != nil {
+		j.f.Close()
+		return err
+	}
+	return j.f.Close()
+}
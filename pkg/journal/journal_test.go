@@ -0,0 +1,170 @@
+package journal
+
+import (
+	"go-orderbook/pkg/orderbook"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecover(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "engine.journal")
+
+	e, err := Recover(path, Options{Sync: SyncAlways})
+	assert.NoError(t, err)
+	assert.NoError(t, e.List("AAPL"))
+	_, err = e.AddOrder("AAPL", orderbook.NewOrder(orderbook.GoodTillCancel, 1, orderbook.Sell, 101, 10))
+	assert.NoError(t, err)
+	_, err = e.AddOrder("AAPL", orderbook.NewOrder(orderbook.GoodTillCancel, 2, orderbook.Sell, 102, 10))
+	assert.NoError(t, err)
+	_, err = e.AddOrder("AAPL", orderbook.NewOrder(orderbook.GoodTillCancel, 3, orderbook.Buy, 101, 4))
+	assert.NoError(t, err)
+	var modify orderbook.OrderModify
+	_, err = e.ModifyOrder(modify.New(2, 103, orderbook.Sell, 8))
+	assert.NoError(t, err)
+	// Rejected commands are journaled and rejected again on replay
+	assert.Error(t, e.CancelOrder(42))
+	want, err := e.Engine().OrderInfo("AAPL")
+	assert.NoError(t, err)
+	assert.NoError(t, e.Close())
+
+	recovered, err := Recover(path, Options{Sync: SyncNever})
+	assert.NoError(t, err)
+	got, err := recovered.Engine().OrderInfo("AAPL")
+	assert.NoError(t, err)
+	assert.Equal(t, want, got)
+	assert.Equal(t, 2, recovered.Engine().Size())
+	assert.NoError(t, recovered.Close())
+}
+
+func TestOpenTruncatesTornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "engine.journal")
+
+	j, _, err := Open(path, Options{Sync: SyncNever})
+	assert.NoError(t, err)
+	assert.NoError(t, j.Append(Record{Type: RecordList, Symbol: "AAPL"}))
+	assert.NoError(t, j.Append(NewAddRecord("AAPL", orderbook.NewOrder(orderbook.GoodTillCancel, 1, orderbook.Buy, 100, 5))))
+	assert.NoError(t, j.Close())
+
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	valid := info.Size()
+
+	// Simulate a crash in the middle of writing the third record
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	assert.NoError(t, err)
+	_, err = f.Write([]byte{30, 0, 0, 0, 1, 2, 3, 4, 5})
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	j, records, err := Open(path, Options{Sync: SyncNever})
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+	assert.Equal(t, orderbook.Quantity(5), records[1].Quantity)
+
+	info, err = os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, valid, info.Size())
+
+	// New records follow the last valid one
+	assert.NoError(t, j.Append(Record{Type: RecordCancel, Symbol: "AAPL", OrderId: 1}))
+	assert.NoError(t, j.Close())
+	j, records, err = Open(path, Options{Sync: SyncNever})
+	assert.NoError(t, err)
+	assert.Len(t, records, 3)
+	assert.Equal(t, RecordCancel, records[2].Type)
+	assert.NoError(t, j.Close())
+}
+
+func TestReadStopsAtBadChecksum(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "engine.journal")
+
+	j, _, err := Open(path, Options{Sync: SyncNever})
+	assert.NoError(t, err)
+	assert.NoError(t, j.Append(Record{Type: RecordList, Symbol: "AAPL"}))
+	assert.NoError(t, j.Append(Record{Type: RecordList, Symbol: "MSFT"}))
+	assert.NoError(t, j.Close())
+
+	b, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	b[len(b)-1] ^= 0xff
+	assert.NoError(t, os.WriteFile(path, b, 0o644))
+
+	j, records, err := Open(path, Options{Sync: SyncNever})
+	assert.NoError(t, err)
+	assert.Equal(t, []Record{{Type: RecordList, Symbol: "AAPL"}}, records)
+	assert.NoError(t, j.Close())
+}
+
+func TestOpenRejectsCorruption(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "engine.journal")
+
+	j, _, err := Open(path, Options{Sync: SyncNever})
+	assert.NoError(t, err)
+	assert.NoError(t, j.Append(Record{Type: RecordList, Symbol: "AAPL"}))
+	assert.NoError(t, j.Append(Record{Type: RecordList, Symbol: "MSFT"}))
+	assert.NoError(t, j.Close())
+
+	// A bad record followed by a valid one was not torn by a crash
+	b, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	b[headerSize] ^= 0xff
+	assert.NoError(t, os.WriteFile(path, b, 0o644))
+
+	_, _, err = Open(path, Options{Sync: SyncNever})
+	assert.ErrorIs(t, err, ErrCorrupt)
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(b)), info.Size())
+}
+
+func TestOpenRejectsBadLength(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "engine.journal")
+
+	j, _, err := Open(path, Options{Sync: SyncNever})
+	assert.NoError(t, err)
+	for id := orderbook.OrderId(1); id <= 4; id++ {
+		assert.NoError(t, j.Append(NewAddRecord("AAPL", orderbook.NewOrder(orderbook.GoodTillCancel, id, orderbook.Buy, 100, 5))))
+	}
+	assert.NoError(t, j.Close())
+
+	// A flipped bit in the length of a middle record makes it look like it
+	// runs past the end of the file, but valid records follow it.
+	b, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	b[len(b)/4+1] ^= 0x01
+	assert.NoError(t, os.WriteFile(path, b, 0o644))
+
+	_, _, err = Open(path, Options{Sync: SyncNever})
+	assert.ErrorIs(t, err, ErrCorrupt)
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(b)), info.Size())
+}
+
+func TestTruncate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "engine.journal")
+
+	j, _, err := Open(path, Options{Sync: SyncNever})
+	assert.NoError(t, err)
+	assert.NoError(t, j.Append(Record{Type: RecordList, Symbol: "AAPL"}))
+	offset := j.Offset()
+	assert.NoError(t, j.Append(Record{Type: RecordList, Symbol: "MSFT"}))
+	assert.Greater(t, j.Offset(), offset)
+
+	// Records after the offset are dropped, and new ones follow it
+	assert.NoError(t, j.Truncate(offset))
+	assert.Equal(t, offset, j.Offset())
+	assert.NoError(t, j.Append(Record{Type: RecordList, Symbol: "IBM"}))
+	assert.NoError(t, j.Close())
+
+	j, records, err := Open(path, Options{Sync: SyncNever})
+	assert.NoError(t, err)
+	assert.Equal(t, []Record{
+		{Type: RecordList, Symbol: "AAPL"},
+		{Type: RecordList, Symbol: "IBM"},
+	}, records)
+	assert.NoError(t, j.Close())
+}
@@ -0,0 +1,123 @@
+package journal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"go-orderbook/pkg/engine"
+	"go-orderbook/pkg/orderbook"
+)
+
+type RecordType uint8
+
+const (
+	RecordAdd RecordType = iota + 1
+	RecordCancel
+	RecordModify
+	RecordList
+	RecordDelist
+)
+
+func (t RecordType) String() string {
+	switch t {
+	case RecordAdd:
+		return "Add"
+	case RecordCancel:
+		return "Cancel"
+	case RecordModify:
+		return "Modify"
+	case RecordList:
+		return "List"
+	case RecordDelist:
+		return "Delist"
+	}
+	return "Unknown"
+}
+
+// A Record is a single command accepted by the engine. Fields that do not
+// apply to the record type are left at their zero value.
+type Record struct {
+	Type      RecordType
+	Symbol    engine.Symbol
+	OrderType orderbook.OrderType
+	OrderId   orderbook.OrderId
+	Side      orderbook.Side
+	Price     orderbook.Price
+	Quantity  orderbook.Quantity
+}
+
+var errShortRecord = errors.New("record too short")
+
+// payloadSize is the encoded size of a record without its symbol bytes: type,
+// symbol length, order type, order id, side, price and quantity.
+const payloadSize = 1 + 1 + 1 + 8 + 1 + 4 + 4
+
+// NewAddRecord records an order added to the book of symbol.
+func NewAddRecord(symbol engine.Symbol, order orderbook.Order) Record {
+	return Record{
+		Type:      RecordAdd,
+		Symbol:    symbol,
+		OrderType: order.OrderType(),
+		OrderId:   order.OrderId(),
+		Side:      order.Side(),
+		Price:     order.Price(),
+		Quantity:  order.InitialQuantity(),
+	}
+}
+
+// NewModifyRecord records a modify of an order in the book of symbol.
+func NewModifyRecord(symbol engine.Symbol, modify orderbook.OrderModify) Record {
+	return Record{
+		Type:     RecordModify,
+		Symbol:   symbol,
+		OrderId:  modify.OrderId(),
+		Side:     modify.Side(),
+		Price:    modify.Price(),
+		Quantity: modify.Quantity(),
+	}
+}
+
+// Order rebuilds the order of an add record.
+func (r Record) Order() orderbook.Order {
+	return orderbook.NewOrder(r.OrderType, r.OrderId, r.Side, r.Price, r.Quantity)
+}
+
+// Modify rebuilds the modify of a modify record.
+func (r Record) Modify() orderbook.OrderModify {
+	var modify orderbook.OrderModify
+	return modify.New(r.OrderId, r.Price, r.Side, r.Quantity)
+}
+
+func (r Record) appendTo(b []byte) ([]byte, error) {
+	if len(r.Symbol) > 255 {
+		return b, fmt.Errorf("symbol %q is too long", r.Symbol)
+	}
+	b = append(b, byte(r.Type), byte(len(r.Symbol)))
+	b = append(b, r.Symbol...)
+	b = append(b, byte(r.OrderType))
+	b = binary.LittleEndian.AppendUint64(b, uint64(r.OrderId))
+	b = append(b, byte(r.Side))
+	b = binary.LittleEndian.AppendUint32(b, uint32(r.Price))
+	b = binary.LittleEndian.AppendUint32(b, uint32(r.Quantity))
+	return b, nil
+}
+
+func decodeRecord(b []byte) (Record, error) {
+	var r Record
+	if len(b) < 2 {
+		return r, errShortRecord
+	}
+	r.Type = RecordType(b[0])
+	n := int(b[1])
+	if len(b) != payloadSize+n {
+		return r, errShortRecord
+	}
+	r.Symbol = engine.Symbol(b[2 : 2+n])
+	b = b[2+n:]
+	r.OrderType = orderbook.OrderType(b[0])
+	r.OrderId = orderbook.OrderId(binary.LittleEndian.Uint64(b[1:]))
+	r.Side = orderbook.Side(b[9])
+	r.Price = orderbook.Price(binary.LittleEndian.Uint32(b[10:]))
+	r.Quantity = orderbook.Quantity(binary.LittleEndian.Uint32(b[14:]))
+	return r, nil
+}
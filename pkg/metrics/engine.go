@@ -0,0 +1,160 @@
+package metrics
+
+import (
+	"errors"
+	"go-orderbook/pkg/engine"
+	"go-orderbook/pkg/orderbook"
+	"sync"
+	"time"
+)
+
+// engineMetrics follows the orders and events of an engine.
+type engineMetrics struct {
+	engine *engine.Engine
+
+	accepted    *Counter
+	rejected    *Counter
+	canceled    *Counter
+	cancelRatio *Gauge
+	trades      *Counter
+	volume      *Counter
+	resting     *Gauge
+	levels      *Gauge
+	lockTaken   *Counter
+	lockWaits   *Counter
+	lockWait    *Counter
+	latency     *Histogram
+
+	// m guards the fields below, updated by the observer and the engine
+	// listener.
+	m          sync.Mutex
+	adds       map[engine.Symbol]uint64
+	cancels    map[engine.Symbol]uint64
+	executions map[engine.Symbol]uint64
+}
+
+// Instrument registers the metrics of an engine and its books:
+//
+//	orderbook_orders_accepted_total{symbol}         orders that entered a book
+//	orderbook_orders_rejected_total{symbol,reason}  orders refused by the engine
+//	orderbook_orders_canceled_total{symbol}         orders canceled on request
+//	orderbook_cancel_ratio{symbol}                  canceled per accepted order
+//	orderbook_trades_total{symbol}                  matches
+//	orderbook_volume_total{symbol}                  shares matched
+//	orderbook_resting_orders{symbol}                orders in the book
+//	orderbook_levels{symbol,side}                   price levels in the book
+//	orderbook_lock_acquisitions_total{symbol}       times the book was locked
+//	orderbook_lock_contentions_total{symbol}        of those, times it was held
+//	orderbook_lock_wait_seconds_total{symbol}       time spent waiting for it
+//	orderbook_order_latency_seconds{operation}      add, cancel and modify times
+//
+// Rejects are counted by reason: duplicate_order, unknown_symbol,
+// unknown_order, no_quantity, no_liquidity for a market order against an
+// empty side, cannot_fill for a FillAndKill order, cannot_fully_fill for a
+// FillOrKill order, or order_rejected for any other. The engine reports to
+// its observer, which Instrument replaces, so it should be called before
+// orders are entered.
+func Instrument(r *Registry, e *engine.Engine) {
+	m := &engineMetrics{
+		engine: e,
+
+		accepted:    r.NewCounter("orderbook_orders_accepted_total", "Orders that entered a book.", "symbol"),
+		rejected:    r.NewCounter("orderbook_orders_rejected_total", "Orders refused by the engine.", "symbol", "reason"),
+		canceled:    r.NewCounter("orderbook_orders_canceled_total", "Orders canceled on request.", "symbol"),
+		cancelRatio: r.NewGauge("orderbook_cancel_ratio", "Orders canceled per order accepted.", "symbol"),
+		trades:      r.NewCounter("orderbook_trades_total", "Matches between two orders.", "symbol"),
+		volume:      r.NewCounter("orderbook_volume_total", "Shares matched.", "symbol"),
+		resting:     r.NewGauge("orderbook_resting_orders", "Orders resting in the book.", "symbol"),
+		levels:      r.NewGauge("orderbook_levels", "Price levels of each side of the book.", "symbol", "side"),
+		lockTaken:   r.NewCounter("orderbook_lock_acquisitions_total", "Acquisitions of the lock of the book.", "symbol"),
+		lockWaits:   r.NewCounter("orderbook_lock_contentions_total", "Acquisitions of the lock of the book that waited for another holder.", "symbol"),
+		lockWait:    r.NewCounter("orderbook_lock_wait_seconds_total", "Time spent waiting for the lock of the book.", "symbol"),
+		latency: r.NewHistogram("orderbook_order_latency_seconds", "Time taken by order operations of the engine.",
+			LatencyBuckets, "operation"),
+
+		adds:       make(map[engine.Symbol]uint64),
+		cancels:    make(map[engine.Symbol]uint64),
+		executions: make(map[engine.Symbol]uint64),
+	}
+	e.UseObserver(m)
+	e.AddListener(engine.ListenerFunc(m.onEvent))
+	r.OnCollect(m.collect)
+}
+
+func (m *engineMetrics) ObserveOrder(op engine.Operation, symbol engine.Symbol, took time.Duration, err error) {
+	m.latency.Observe(took.Seconds(), op.String())
+	switch {
+	case op == engine.OpAdd && err != nil:
+		m.rejected.Inc(string(symbol), rejectReason(err))
+	case op == engine.OpAdd:
+		m.accepted.Inc(string(symbol))
+		m.m.Lock()
+		m.adds[symbol]++
+		m.m.Unlock()
+	case op == engine.OpCancel && err == nil:
+		m.canceled.Inc(string(symbol))
+		m.m.Lock()
+		m.cancels[symbol]++
+		m.m.Unlock()
+	}
+}
+
+func rejectReason(err error) string {
+	switch {
+	case errors.Is(err, engine.ErrDuplicateOrder), errors.Is(err, orderbook.ErrOrderExists):
+		return "duplicate_order"
+	case errors.Is(err, engine.ErrUnknownSymbol):
+		return "unknown_symbol"
+	case errors.Is(err, engine.ErrUnknownOrder), errors.Is(err, orderbook.ErrUnknownOrder):
+		return "unknown_order"
+	case errors.Is(err, orderbook.ErrNoQuantity):
+		return "no_quantity"
+	case errors.Is(err, orderbook.ErrNoLiquidity):
+		return "no_liquidity"
+	case errors.Is(err, orderbook.ErrCannotFill):
+		return "cannot_fill"
+	case errors.Is(err, orderbook.ErrCannotFullyFill):
+		return "cannot_fully_fill"
+	}
+	return "order_rejected"
+}
+
+func (m *engineMetrics) onEvent(symbol engine.Symbol, ev orderbook.Event) {
+	if ev.Type != orderbook.OrderExecuted {
+		return
+	}
+	// Both orders of a match are executed; count the match once.
+	m.m.Lock()
+	first := m.executions[symbol] != ev.MatchId
+	m.executions[symbol] = ev.MatchId
+	m.m.Unlock()
+	if first {
+		m.trades.Inc(string(symbol))
+		m.volume.Add(float64(ev.Quantity), string(symbol))
+	}
+}
+
+// collect reads the state of every book.
+func (m *engineMetrics) collect() {
+	for _, symbol := range m.engine.Symbols() {
+		book, ok := m.engine.Book(symbol)
+		if !ok {
+			continue
+		}
+		s := string(symbol)
+		m.resting.Set(float64(book.Size()), s)
+		bids, asks := book.Depth()
+		m.levels.Set(float64(bids), s, "buy")
+		m.levels.Set(float64(asks), s, "sell")
+		stats := book.LockStats()
+		m.lockTaken.Set(float64(stats.Acquired), s)
+		m.lockWaits.Set(float64(stats.Contended), s)
+		m.lockWait.Set(stats.Wait.Seconds(), s)
+	}
+
+	m.m.Lock()
+	defer m.m.Unlock()
+	for symbol, adds := range m.adds {
+		m.cancelRatio.Set(float64(m.cancels[symbol])/float64(adds), string(symbol))
+	}
+}
@@ -0,0 +1,345 @@
+// Package metrics keeps counters, gauges and histograms and exposes them in
+// the Prometheus text format, without depending on the Prometheus client.
+//
+// Metrics are created on a Registry with the names of their labels, and
+// every update names the values of those labels in the same order. A series
+// is exposed from its first update on.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the media type of the exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var (
+	validName  = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	validLabel = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// Registry holds metrics and writes them out. It implements http.Handler to
+// serve them to a Prometheus server.
+type Registry struct {
+	m          sync.Mutex
+	families   map[string]*family
+	collectors []func()
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// OnCollect registers a function that is called before every exposition,
+// to bring metrics kept elsewhere up to date.
+func (r *Registry) OnCollect(f func()) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.collectors = append(r.collectors, f)
+}
+
+// register adds a family. Like http.Handle, it panics on an invalid or
+// duplicate name, which is a programming error.
+func (r *Registry) register(f *family) *family {
+	if !validName.MatchString(f.name) {
+		panic(fmt.Sprintf("metrics: invalid metric name %q", f.name))
+	}
+	for _, label := range f.labels {
+		if !validLabel.MatchString(label) || label == "le" {
+			panic(fmt.Sprintf("metrics: invalid label name %q of %s", label, f.name))
+		}
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+	if _, exists := r.families[f.name]; exists {
+		panic(fmt.Sprintf("metrics: %s registered twice", f.name))
+	}
+	r.families[f.name] = f
+	return f
+}
+
+// NewCounter registers a counter, a value that only goes up.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{r.register(newFamily(name, help, "counter", labels, nil))}
+}
+
+// NewGauge registers a gauge, a value that goes up and down.
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r.register(newFamily(name, help, "gauge", labels, nil))}
+}
+
+// NewHistogram registers a histogram that counts observations into buckets
+// by their upper bounds, which must be increasing. A last bucket for
+// infinity is always added.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("metrics: buckets of %s are not sorted", name))
+	}
+	return &Histogram{r.register(newFamily(name, help, "histogram", labels, buckets))}
+}
+
+// ExponentialBuckets returns n bucket bounds, the first at start and every
+// next one factor times the one before.
+func ExponentialBuckets(start, factor float64, n int) []float64 {
+	buckets := make([]float64, n)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
+
+// LatencyBuckets span one microsecond to about a second, in seconds.
+var LatencyBuckets = ExponentialBuckets(1e-6, 4, 11)
+
+// Counter is a family of counters with the same name.
+type Counter struct {
+	f *family
+}
+
+// Inc adds one to the counter of the label values.
+func (c *Counter) Inc(labels ...string) {
+	c.Add(1, labels...)
+}
+
+// Add adds v, which must not be negative, to the counter of the label
+// values.
+func (c *Counter) Add(v float64, labels ...string) {
+	if v < 0 {
+		panic(fmt.Sprintf("metrics: %s decreased", c.f.name))
+	}
+	c.f.m.Lock()
+	c.f.get(labels).value += v
+	c.f.m.Unlock()
+}
+
+// Set sets the counter of the label values to a total counted elsewhere,
+// which must never decrease.
+func (c *Counter) Set(v float64, labels ...string) {
+	c.f.m.Lock()
+	c.f.get(labels).value = v
+	c.f.m.Unlock()
+}
+
+// Gauge is a family of gauges with the same name.
+type Gauge struct {
+	f *family
+}
+
+// Set sets the gauge of the label values.
+func (g *Gauge) Set(v float64, labels ...string) {
+	g.f.m.Lock()
+	g.f.get(labels).value = v
+	g.f.m.Unlock()
+}
+
+// Add adds v, which may be negative, to the gauge of the label values.
+func (g *Gauge) Add(v float64, labels ...string) {
+	g.f.m.Lock()
+	g.f.get(labels).value += v
+	g.f.m.Unlock()
+}
+
+// Histogram is a family of histograms with the same name and buckets.
+type Histogram struct {
+	f *family
+}
+
+// Observe counts v in the histogram of the label values.
+func (h *Histogram) Observe(v float64, labels ...string) {
+	h.f.m.Lock()
+	s := h.f.get(labels)
+	// The bucket of v is the first with an upper bound of at least v, or
+	// the infinite one past the end.
+	s.buckets[sort.SearchFloat64s(h.f.buckets, v)]++
+	s.count++
+	s.sum += v
+	h.f.m.Unlock()
+}
+
+type family struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+
+	// m guards series.
+	m      sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labels []string
+	value  float64
+	// buckets counts the observations of each bucket of a histogram, not
+	// cumulated, with the infinite bucket last.
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+func newFamily(name, help, kind string, labels []string, buckets []float64) *family {
+	return &family{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+}
+
+// get returns the series of the label values, creating it on first use. It
+// should only be called with f.m held.
+func (f *family) get(labels []string) *series {
+	if len(labels) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", f.name, len(f.labels), len(labels)))
+	}
+	key := strings.Join(labels, "\xff")
+	s, exists := f.series[key]
+	if !exists {
+		s = &series{labels: append([]string(nil), labels...)}
+		if f.kind == "histogram" {
+			s.buckets = make([]uint64, len(f.buckets)+1)
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// WriteTo writes every metric in the text format, sorted by name and label
+// values.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.m.Lock()
+	collectors := append([]func(){}, r.collectors...)
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.m.Unlock()
+
+	for _, collect := range collectors {
+		collect()
+	}
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].name < families[j].name
+	})
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, f := range families {
+		f.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP serves the metrics to a scrape.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", ContentType)
+	r.WriteTo(w)
+}
+
+func (f *family) write(w *bufio.Writer) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	if len(f.series) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, helpEscaper.Replace(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+	for _, key := range keys {
+		s := f.series[key]
+		if f.kind != "histogram" {
+			f.sample(w, "", s.labels, "", s.value)
+			continue
+		}
+		var cumulative uint64
+		for i, count := range s.buckets {
+			cumulative += count
+			le := math.Inf(1)
+			if i < len(f.buckets) {
+				le = f.buckets[i]
+			}
+			f.sample(w, "_bucket", s.labels, formatFloat(le), float64(cumulative))
+		}
+		f.sample(w, "_sum", s.labels, "", s.sum)
+		f.sample(w, "_count", s.labels, "", float64(s.count))
+	}
+}
+
+// sample writes a single line. le is the bound of a histogram bucket, or
+// empty.
+func (f *family) sample(w *bufio.Writer, suffix string, labels []string, le string, v float64) {
+	w.WriteString(f.name)
+	w.WriteString(suffix)
+	if len(labels) > 0 || le != "" {
+		w.WriteByte('{')
+		for i, value := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, f.labels[i], labelEscaper.Replace(value))
+		}
+		if le != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `le="%s"`, le)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,128 @@
+package metrics
+
+import (
+	"bytes"
+	"go-orderbook/pkg/engine"
+	"go-orderbook/pkg/orderbook"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExposition(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounter("requests_total", "Requests by path.\nSecond line.", "path")
+	temperature := r.NewGauge("temperature", `Degrees, in \C.`)
+	latency := r.NewHistogram("latency_seconds", "Latency.", []float64{0.1, 1}, "op")
+	r.NewCounter("unused_total", "Never updated.")
+
+	requests.Inc("/b")
+	requests.Add(2, `/a"\`+"\n")
+	temperature.Set(21.5)
+	temperature.Add(-1)
+	latency.Observe(0.05, "add")
+	latency.Observe(0.1, "add")
+	latency.Observe(5, "add")
+
+	var buf bytes.Buffer
+	n, err := r.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(buf.Len()), n)
+	assert.Equal(t, strings.Join([]string{
+		`# HELP latency_seconds Latency.`,
+		`# TYPE latency_seconds histogram`,
+		`latency_seconds_bucket{op="add",le="0.1"} 2`,
+		`latency_seconds_bucket{op="add",le="1"} 2`,
+		`latency_seconds_bucket{op="add",le="+Inf"} 3`,
+		`latency_seconds_sum{op="add"} 5.15`,
+		`latency_seconds_count{op="add"} 3`,
+		`# HELP requests_total Requests by path.\nSecond line.`,
+		`# TYPE requests_total counter`,
+		`requests_total{path="/a\"\\\n"} 2`,
+		`requests_total{path="/b"} 1`,
+		`# HELP temperature Degrees, in \\C.`,
+		`# TYPE temperature gauge`,
+		`temperature 20.5`,
+		``,
+	}, "\n"), buf.String())
+}
+
+func TestRegistration(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("orders_total", "Orders.", "symbol")
+	assert.Panics(t, func() { r.NewGauge("orders_total", "Again.") })
+	assert.Panics(t, func() { r.NewGauge("orders-total", "Invalid.") })
+	assert.Panics(t, func() { r.NewGauge("le_total", "Reserved label.", "le") })
+	assert.Panics(t, func() { r.NewHistogram("unsorted", "Unsorted.", []float64{2, 1}) })
+	assert.Panics(t, func() { c.Inc() })
+	assert.Panics(t, func() { c.Add(-1, "ACME") })
+}
+
+func TestServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.NewGauge("up", "Whether the engine is up.").Set(1)
+	calls := 0
+	r.OnCollect(func() { calls++ })
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, ContentType, rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), "\nup 1\n")
+	assert.Equal(t, 1, calls)
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestInstrument(t *testing.T) {
+	e := engine.NewEngine()
+	require.NoError(t, e.List("ACME"))
+	r := NewRegistry()
+	Instrument(r, e)
+
+	e.AddOrder("ACME", orderbook.NewOrder(orderbook.GoodTillCancel, 1, orderbook.Sell, 101, 10))
+	e.AddOrder("ACME", orderbook.NewOrder(orderbook.GoodTillCancel, 2, orderbook.Sell, 100, 10))
+	e.AddOrder("ACME", orderbook.NewOrder(orderbook.GoodTillCancel, 3, orderbook.Buy, 99, 5))
+	e.AddOrder("ACME", orderbook.NewOrder(orderbook.GoodTillCancel, 4, orderbook.Buy, 101, 15))
+	e.AddOrder("ACME", orderbook.NewOrder(orderbook.GoodTillCancel, 1, orderbook.Buy, 99, 5))
+	e.AddOrder("ACME", orderbook.NewOrder(orderbook.FillAndKill, 5, orderbook.Buy, 90, 5))
+	e.AddOrder("NOPE", orderbook.NewOrder(orderbook.GoodTillCancel, 6, orderbook.Buy, 90, 5))
+	e.CancelOrder(3)
+	e.CancelOrder(3)
+	e.AddOrder("ACME", orderbook.NewOrder(orderbook.FillOrKill, 7, orderbook.Buy, 101, 50))
+	e.AddOrder("ACME", orderbook.NewOrder(orderbook.Market, 8, orderbook.Sell, 0, 5))
+	e.AddOrder("ACME", orderbook.NewOrder(orderbook.GoodTillCancel, 9, orderbook.Buy, 99, 0))
+
+	var buf bytes.Buffer
+	_, err := r.WriteTo(&buf)
+	require.NoError(t, err)
+	out := buf.String()
+	for _, line := range []string{
+		`orderbook_orders_accepted_total{symbol="ACME"} 4`,
+		`orderbook_orders_rejected_total{symbol="ACME",reason="duplicate_order"} 1`,
+		`orderbook_orders_rejected_total{symbol="ACME",reason="cannot_fill"} 1`,
+		`orderbook_orders_rejected_total{symbol="ACME",reason="cannot_fully_fill"} 1`,
+		`orderbook_orders_rejected_total{symbol="ACME",reason="no_liquidity"} 1`,
+		`orderbook_orders_rejected_total{symbol="ACME",reason="no_quantity"} 1`,
+		`orderbook_orders_rejected_total{symbol="NOPE",reason="unknown_symbol"} 1`,
+		`orderbook_orders_canceled_total{symbol="ACME"} 1`,
+		`orderbook_cancel_ratio{symbol="ACME"} 0.25`,
+		`orderbook_trades_total{symbol="ACME"} 2`,
+		`orderbook_volume_total{symbol="ACME"} 15`,
+		`orderbook_resting_orders{symbol="ACME"} 1`,
+		`orderbook_levels{symbol="ACME",side="buy"} 0`,
+		`orderbook_levels{symbol="ACME",side="sell"} 1`,
+		`orderbook_lock_contentions_total{symbol="ACME"} 0`,
+		`orderbook_order_latency_seconds_count{operation="add"} 10`,
+		`orderbook_order_latency_seconds_count{operation="cancel"} 2`,
+	} {
+		assert.Contains(t, out, line+"\n")
+	}
+	assert.Contains(t, out, `orderbook_lock_acquisitions_total{symbol="ACME"} `)
+}
@@ -0,0 +1,114 @@
+package orderbook
+
+type EventType int
+
+const (
+	// OrderAdded is published when an order is placed at the back of the
+	// queue for its price level.
+	OrderAdded EventType = iota
+	// OrderExecuted is published for each side of a match. An order whose
+	// remaining quantity reaches zero is removed from the book without a
+	// separate OrderDeleted event.
+	OrderExecuted
+	// OrderReduced is published when the open quantity of a resting order is
+	// lowered in place, keeping its time priority.
+	OrderReduced
+	// OrderDeleted is published when a resting order is removed from the book
+	// by a cancel, a cancel/replace or a FillAndKill remainder.
+	OrderDeleted
+	// TradeBusted is published for each side of a match that was cancelled
+	// after the fact, with the fields of its OrderExecuted event.
+	TradeBusted
+	// TradeCorrected is published for each side of a match whose price was
+	// corrected after the fact, with the fields of its OrderExecuted event
+	// and the corrected Price and TradePrice.
+	TradeCorrected
+)
+
+func (t EventType) String() string {
+	switch t {
+	case OrderAdded:
+		return "Add"
+	case OrderExecuted:
+		return "Execute"
+	case OrderReduced:
+		return "Reduce"
+	case OrderDeleted:
+		return "Delete"
+	case TradeBusted:
+		return "Bust"
+	case TradeCorrected:
+		return "Correct"
+	}
+	return "Unknown"
+}
+
+// An Event describes a single change to the queue of a price level. Applying
+// every event in Sequence order rebuilds the exact order-by-order state of the
+// book. TradeBusted and TradeCorrected events leave the book untouched but
+// take a Sequence of their own.
+type Event struct {
+	Type     EventType
+	Sequence uint64
+	OrderId  OrderId
+	Side     Side
+	Price    Price
+	// TradePrice is the price an OrderExecuted event traded at: the price of
+	// the resting order of the match, which may improve on the Price of the
+	// aggressor.
+	TradePrice Price
+	// Quantity is the quantity added, executed or reduced. For OrderDeleted it
+	// is the quantity that was still open.
+	Quantity Quantity
+	// Position is the zero-based position in the level queue of an added
+	// order.
+	Position int
+	// MatchId is shared by the two OrderExecuted events of a single match.
+	MatchId uint64
+	// Aggressor is set on the OrderExecuted event of the order that took
+	// liquidity; the other order of the match was resting.
+	Aggressor bool
+	// Fee is charged for an OrderExecuted event, negative for a rebate. Books
+	// leave it zero; an engine.Engine sets it from its fee model.
+	Fee int64
+}
+
+// A Listener receives every Event published by an Orderbook. Events are
+// delivered synchronously while the book is locked, so listeners must not
+// call back into the book.
+type Listener interface {
+	OnEvent(e Event)
+}
+
+// ListenerFunc adapts an ordinary function to the Listener interface.
+type ListenerFunc func(e Event)
+
+func (f ListenerFunc) OnEvent(e Event) {
+	f(e)
+}
+
+// AddListener registers a Listener for all subsequent events.
+func (o *Orderbook) AddListener(l Listener) {
+	o.lock()
+	defer o.m.Unlock()
+	o.listeners = append(o.listeners, l)
+}
+
+// Publish stamps an event that leaves the book untouched, such as a trade
+// bust, with the next sequence number and hands it to every listener, in
+// order with the events of the book.
+func (o *Orderbook) Publish(e Event) {
+	o.lock()
+	defer o.m.Unlock()
+	o.emit(e)
+}
+
+// emit stamps the event with the next sequence number and hands it to every
+// listener. It should only be called with the lock held.
+func (o *Orderbook) emit(e Event) {
+	o.sequence++
+	e.Sequence = o.sequence
+	for _, l := range o.listeners {
+		l.OnEvent(e)
+	}
+}
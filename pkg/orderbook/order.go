@@ -15,6 +15,24 @@ const (
 	FillOrKill
 )
 
+// String returns the name of the order type, as used by the REST API and the
+// audit log.
+func (t OrderType) String() string {
+	switch t {
+	case Market:
+		return "market"
+	case GoodTillCancel:
+		return "good_till_cancel"
+	case GoodForDay:
+		return "good_for_day"
+	case FillAndKill:
+		return "fill_and_kill"
+	case FillOrKill:
+		return "fill_or_kill"
+	}
+	return "unknown"
+}
+
 type Side int
 
 const (
@@ -76,6 +94,10 @@ func (o *Order) InitialQuantity() Quantity {
 	return o.initialQuantity
 }
 
+func (o *Order) RemainingQuantity() Quantity {
+	return o.remainingQuantity
+}
+
 func (o *Order) FilledQuantity() Quantity {
 	return o.initialQuantity - o.remainingQuantity
 }
@@ -107,8 +129,23 @@ func (o *Order) ToGoodTillCancel(price Price) error {
 	return nil
 }
 
+// reduce lowers the open quantity of the order without treating the
+// difference as filled.
+func (o *Order) reduce(quantity Quantity) error {
+	if quantity > o.remainingQuantity {
+		return fmt.Errorf(
+			"Order %d cannot be reduced by more than it's remaining quantity",
+			o.orderId,
+		)
+	}
+	o.initialQuantity -= quantity
+	o.remainingQuantity -= quantity
+	return nil
+}
+
+// Orders is the time-priority queue of resting orders at a single price level.
 type Orders struct {
-	list.LinkedList[Order]
+	list.LinkedList[*Order]
 }
 
 type OrderModify struct {
@@ -1,26 +1,47 @@
 package orderbook
 
 import (
+	"errors"
 	"fmt"
+	"go-orderbook/pkg/ds/list"
 	"go-orderbook/pkg/ds/rbmap"
 	"go-orderbook/pkg/util"
+	"slices"
 	"sync"
 	"sync/atomic"
+	"time"
+)
+
+// Errors of orders the book refuses, wrapped with the id of the order.
+var (
+	ErrOrderExists     = errors.New("order already exists")
+	ErrUnknownOrder    = errors.New("order does not exist")
+	ErrNoQuantity      = errors.New("order has no quantity")
+	ErrNoLiquidity     = errors.New("no liquidity for market order")
+	ErrCannotFill      = errors.New("order cannot be filled immediately")
+	ErrCannotFullyFill = errors.New("order cannot be fully filled")
 )
 
 type Orderbook struct {
-	m        *sync.Mutex
-	bids     *rbmap.Map[Price, Orders]
-	asks     *rbmap.Map[Price, Orders]
-	orders   map[OrderId]OrderEntry
-	levels   map[Price]LevelData
-	shutdown atomic.Bool
-	cond     *sync.Cond
+	m         *sync.Mutex
+	lockStats *lockStats
+	bids      *rbmap.Map[Price, *Orders]
+	asks      *rbmap.Map[Price, *Orders]
+	orders    map[OrderId]OrderEntry
+	levels    map[Price]LevelData
+	shutdown  atomic.Bool
+	cond      *sync.Cond
+	listeners []Listener
+	sequence  uint64
+	matchId   uint64
+	// aggressor is the side of the order inserted last. Matching only
+	// follows an insert, so it is the side taking liquidity.
+	aggressor Side
 }
 
 type OrderEntry struct {
-	order    Order
-	location int
+	order    *Order
+	location list.Element[*Order]
 }
 
 type LevelData struct {
@@ -28,12 +49,61 @@ type LevelData struct {
 	count    Quantity
 }
 
+type levelDataAction int
+
+const (
+	levelAdd levelDataAction = iota
+	levelRemove
+	levelMatch
+)
+
+// NewOrderbook creates an empty orderbook. Bids are kept in descending and
+// asks in ascending price order, so the first level of each side is the best.
 func NewOrderbook() Orderbook {
 	return Orderbook{
-		m:      &sync.Mutex{},
-		bids:   rbmap.NewMap[Price, Orders](rbmap.Ascending[Price]),
-		asks:   rbmap.NewMap[Price, Orders](rbmap.Descending[Price]),
-		orders: make(map[OrderId]OrderEntry),
+		m:         &sync.Mutex{},
+		lockStats: &lockStats{},
+		bids:      rbmap.NewMap[Price, *Orders](rbmap.Descending[Price]),
+		asks:      rbmap.NewMap[Price, *Orders](rbmap.Ascending[Price]),
+		orders:    make(map[OrderId]OrderEntry),
+		levels:    make(map[Price]LevelData),
+	}
+}
+
+// LockStats counts the acquisitions of the lock of a book. Contended counts
+// those that had to wait for another holder, and Wait is the total time
+// they waited.
+type LockStats struct {
+	Acquired  uint64
+	Contended uint64
+	Wait      time.Duration
+}
+
+type lockStats struct {
+	acquired  atomic.Uint64
+	contended atomic.Uint64
+	wait      atomic.Int64
+}
+
+// lock takes the lock of the book. The clock is only read when the lock is
+// held by someone else, so uncontended acquisitions stay cheap.
+func (o *Orderbook) lock() {
+	o.lockStats.acquired.Add(1)
+	if o.m.TryLock() {
+		return
+	}
+	start := time.Now()
+	o.m.Lock()
+	o.lockStats.contended.Add(1)
+	o.lockStats.wait.Add(int64(time.Since(start)))
+}
+
+// LockStats returns the lock counters of the book since it was created.
+func (o *Orderbook) LockStats() LockStats {
+	return LockStats{
+		Acquired:  o.lockStats.acquired.Load(),
+		Contended: o.lockStats.contended.Load(),
+		Wait:      time.Duration(o.lockStats.wait.Load()),
 	}
 }
 
@@ -48,13 +118,73 @@ func (o *Orderbook) Shutdown() {
 }
 
 func (o *Orderbook) Size() int {
+	o.lock()
+	defer o.m.Unlock()
 	return len(o.orders)
 }
 
+// OrderIds returns the ids of all resting orders in ascending order.
+func (o *Orderbook) OrderIds() OrderIds {
+	o.lock()
+	defer o.m.Unlock()
+
+	orderIds := make(OrderIds, 0, len(o.orders))
+	for id := range o.orders {
+		orderIds = append(orderIds, id)
+	}
+	slices.Sort(orderIds)
+	return orderIds
+}
+
+// Order returns a copy of a resting order.
+func (o *Orderbook) Order(orderId OrderId) (Order, bool) {
+	o.lock()
+	defer o.m.Unlock()
+
+	entry, exists := o.orders[orderId]
+	if !exists {
+		return Order{}, false
+	}
+	return *entry.order, true
+}
+
+// Top returns the best level of each side. A side with no resting orders
+// returns a zero LevelInfo.
+func (o *Orderbook) Top() (bid, ask LevelInfo) {
+	o.lock()
+	defer o.m.Unlock()
+
+	if best := o.bids.Begin(); best.Valid() {
+		bid.Price = best.Key()
+		bid.Quantity = o.levels[bid.Price].quantity
+	}
+	if best := o.asks.Begin(); best.Valid() {
+		ask.Price = best.Key()
+		ask.Quantity = o.levels[ask.Price].quantity
+	}
+	return bid, ask
+}
+
+// Depth returns the number of price levels of each side.
+func (o *Orderbook) Depth() (bids, asks int) {
+	o.lock()
+	defer o.m.Unlock()
+	return o.bids.Size(), o.asks.Size()
+}
+
 // CanMatch checks if a given order can be matched at a given price.
 func (o *Orderbook) CanMatch(
 	side Side,
 	price Price,
+) bool {
+	o.lock()
+	defer o.m.Unlock()
+	return o.canMatch(side, price)
+}
+
+func (o *Orderbook) canMatch(
+	side Side,
+	price Price,
 ) bool {
 	if side == Buy {
 		if o.asks.Empty() {
@@ -74,13 +204,24 @@ func (o *Orderbook) CanMatch(
 	}
 }
 
-// TODO: Finish this function
+// CanFullyFill checks if an order of the given quantity would be filled
+// completely by the opposite side, at prices up to its limit.
 func (o *Orderbook) CanFullyFill(
 	side Side,
 	price Price,
 	quantity Quantity,
 ) bool {
-	if !o.CanMatch(side, price) {
+	o.lock()
+	defer o.m.Unlock()
+	return o.canFullyFill(side, price, quantity)
+}
+
+func (o *Orderbook) canFullyFill(
+	side Side,
+	price Price,
+	quantity Quantity,
+) bool {
+	if !o.canMatch(side, price) {
 		return false
 	}
 	var threshold Price
@@ -95,8 +236,8 @@ func (o *Orderbook) CanFullyFill(
 	}
 
 	for priceLevel, levelData := range o.levels {
-		if side == Buy && priceLevel > threshold ||
-			side == Sell && priceLevel < threshold {
+		if side == Buy && priceLevel < threshold ||
+			side == Sell && priceLevel > threshold {
 			continue
 		}
 		if side == Buy && priceLevel > price ||
@@ -106,6 +247,7 @@ func (o *Orderbook) CanFullyFill(
 		if levelData.quantity >= quantity {
 			return true
 		}
+		quantity -= levelData.quantity
 	}
 
 	return false
@@ -115,6 +257,8 @@ func (o *Orderbook) CanFullyFill(
 // generate Trades from their stored Orders. If a bid is available at
 // a price greater than or equal to that of the best ask, a trade is generated.
 func (o *Orderbook) MatchOrders() (Trades, error) {
+	o.lock()
+	defer o.m.Unlock()
 	return o.matchOrdersNoLock()
 }
 
@@ -163,27 +307,19 @@ func (o *Orderbook) matchOrdersNoLock() (Trades, error) {
 				return trades, err
 			}
 
-			if bid.IsFilled() {
-				bids.DeleteHead()
-				delete(o.orders, bid.OrderId())
-			}
-
-			if ask.IsFilled() {
-				asks.DeleteHead()
-				delete(o.orders, ask.OrderId())
-			}
-
-			if bids.IsEmpty() {
-				o.bids.Delete(bidPrice)
-			}
-
-			if asks.IsEmpty() {
-				o.asks.Delete(askPrice)
+			o.matchId++
+			// trades are priced at the resting order
+			price := bidPrice
+			if o.aggressor == Buy {
+				price = askPrice
 			}
+			o.onOrderMatched(bids, bid, quantity, price, o.aggressor == Buy)
+			o.onOrderMatched(asks, ask, quantity, price, o.aggressor == Sell)
 
 			// append the trade to the list of trades
 			trades = append(trades,
 				Trade{
+					id: o.matchId,
 					bidTrade: TradeInfo{
 						orderId:  bid.OrderId(),
 						price:    bid.Price(),
@@ -194,39 +330,119 @@ func (o *Orderbook) matchOrdersNoLock() (Trades, error) {
 						price:    ask.Price(),
 						quantity: quantity,
 					},
+					aggressor: o.aggressor,
 				},
 			)
+		}
 
-			// handle FillAndKill orders
-			if !bids.IsEmpty() {
-				bid, _ = bids.Head()
-				if bid.OrderType() == FillAndKill {
-					bids.DeleteHead()
-					delete(o.orders, bid.OrderId())
-				}
-			}
+		if bids.IsEmpty() {
+			o.bids.Delete(bidPrice)
+		}
 
-			if !asks.IsEmpty() {
-				ask, _ = asks.Head()
-				if ask.OrderType() == FillAndKill {
-					asks.DeleteHead()
-					delete(o.orders, ask.OrderId())
-				}
-			}
+		if asks.IsEmpty() {
+			o.asks.Delete(askPrice)
+		}
+	}
+
+	// handle FillAndKill orders, whose remainder must not rest on the book
+	if !o.bids.Empty() {
+		bidIt := o.bids.Begin()
+		bid, _ := bidIt.Value().Head()
+		if bid.OrderType() == FillAndKill {
+			o.cancelOrder(bid.OrderId())
+		}
+	}
+
+	if !o.asks.Empty() {
+		askIt := o.asks.Begin()
+		ask, _ := askIt.Value().Head()
+		if ask.OrderType() == FillAndKill {
+			o.cancelOrder(ask.OrderId())
 		}
 	}
 	return trades, nil
 }
 
+// onOrderMatched publishes the execution of an order at the head of its level
+// and removes it from the book once it is filled. Removing the level itself
+// is left to the caller.
+func (o *Orderbook) onOrderMatched(orders *Orders, order *Order, quantity Quantity, price Price, aggressor bool) {
+	o.emit(Event{
+		Type:       OrderExecuted,
+		OrderId:    order.OrderId(),
+		Side:       order.Side(),
+		Price:      order.Price(),
+		TradePrice: price,
+		Quantity:   quantity,
+		MatchId:    o.matchId,
+		Aggressor:  aggressor,
+	})
+
+	if order.IsFilled() {
+		orders.Remove(o.orders[order.OrderId()].location)
+		delete(o.orders, order.OrderId())
+		o.updateLevelData(order.Price(), quantity, levelRemove)
+	} else {
+		o.updateLevelData(order.Price(), quantity, levelMatch)
+	}
+}
+
+// updateLevelData keeps the aggregate quantity and order count of a price
+// level in step with its queue.
+func (o *Orderbook) updateLevelData(
+	price Price,
+	quantity Quantity,
+	action levelDataAction,
+) {
+	data := o.levels[price]
+	switch action {
+	case levelAdd:
+		data.count++
+		data.quantity += quantity
+	case levelRemove:
+		data.count--
+		data.quantity -= quantity
+	case levelMatch:
+		data.quantity -= quantity
+	}
+
+	if data.count == 0 {
+		delete(o.levels, price)
+		return
+	}
+	o.levels[price] = data
+}
+
+// AddOrder enters an order and matches it against the book. An order without
+// quantity is rejected with ErrNoQuantity rather than left resting with
+// nothing to trade, and a FillOrKill order that the opposite side cannot fill
+// completely up to its limit with ErrCannotFullyFill rather than entered like
+// a GoodTillCancel one.
 func (o *Orderbook) AddOrder(order Order) (Trades, error) {
-	o.m.Lock()
+	o.lock()
 	defer o.m.Unlock()
+	return o.addOrder(order)
+}
 
+func (o *Orderbook) addOrder(order Order) (Trades, error) {
 	if _, exists := o.orders[order.OrderId()]; exists {
-		return nil, fmt.Errorf(
-			"Order %d already exists",
-			order.OrderId(),
-		)
+		return nil, fmt.Errorf("%w: %d", ErrOrderExists, order.OrderId())
+	}
+	if err := o.checkOrder(&order); err != nil {
+		return nil, err
+	}
+
+	o.insertOrder(&order)
+	// Call the no-lock version since we already have the lock
+	return o.matchOrdersNoLock()
+}
+
+// checkOrder rejects an order that cannot enter the book as it stands, and
+// converts a market order to a limit order.
+func (o *Orderbook) checkOrder(order *Order) error {
+	// An order with nothing open would rest without ever trading.
+	if order.InitialQuantity() == 0 {
+		return fmt.Errorf("%w: %d", ErrNoQuantity, order.OrderId())
 	}
 
 	// Market orders are converted to GoodTillCancel with the max/worst price
@@ -242,59 +458,65 @@ func (o *Orderbook) AddOrder(order Order) (Trades, error) {
 			maxPrice, _, _ := o.bids.Last()
 			err = order.ToGoodTillCancel(maxPrice)
 		} else {
-			// TODO: Improve this message
-			return nil, fmt.Errorf("invalid state")
+			return fmt.Errorf("%w: %d", ErrNoLiquidity, order.OrderId())
 		}
 		if err != nil {
-			return nil, err
+			return err
 		}
 	}
 
 	if order.OrderType() == FillAndKill &&
-		!o.CanMatch(order.Side(), order.Price()) {
-		return nil, fmt.Errorf(
-			"Order %d cannot be filled immediately",
-			order.OrderId(),
-		)
+		!o.canMatch(order.Side(), order.Price()) {
+		return fmt.Errorf("%w: %d", ErrCannotFill, order.OrderId())
 	}
 
-	if order.OrderType() == FillOrKill {
+	if order.OrderType() == FillOrKill &&
+		!o.canFullyFill(order.Side(), order.Price(), order.InitialQuantity()) {
+		return fmt.Errorf("%w: %d", ErrCannotFullyFill, order.OrderId())
 	}
 
-	var orders Orders
+	return nil
+}
 
-	// TODO: Refactor this code create zero values by default
-	// check if price level exists and create if not, inserting the order.
-	// store the Orders for the appropriate side in `orders`
+// insertOrder places an order at the back of the queue for its price level,
+// creating the level if it does not exist yet.
+func (o *Orderbook) insertOrder(order *Order) {
+	levels := o.asks
 	if order.Side() == Buy {
-		if _, exists := o.bids.Get(order.Price()); !exists {
-			o.bids.Insert(order.Price(), orders)
-		}
-		orders, _ = o.bids.Get(order.Price())
-	} else {
-		if _, exists := o.asks.Get(order.Price()); !exists {
-			o.asks.Insert(order.Price(), orders)
-		}
-		orders, _ = o.asks.Get(order.Price())
+		levels = o.bids
 	}
 
+	orders, exists := levels.Get(order.Price())
+	if !exists {
+		orders = &Orders{}
+		levels.Insert(order.Price(), orders)
+	}
+
+	position := orders.Size()
 	o.orders[order.OrderId()] = OrderEntry{
 		order:    order,
-		location: orders.Size() - 1,
+		location: orders.AppendElement(order),
 	}
-	// Call the no-lock version since we already have the lock
-	o.matchOrdersNoLock()
-	return nil, nil
+	o.updateLevelData(order.Price(), order.remainingQuantity, levelAdd)
+	o.aggressor = order.Side()
+	o.emit(Event{
+		Type:     OrderAdded,
+		OrderId:  order.OrderId(),
+		Side:     order.Side(),
+		Price:    order.Price(),
+		Quantity: order.remainingQuantity,
+		Position: position,
+	})
 }
 
 func (o *Orderbook) CancelOrder(orderId OrderId) error {
-	o.m.Lock()
+	o.lock()
 	defer o.m.Unlock()
 	return o.cancelOrder(orderId)
 }
 
 func (o *Orderbook) CancelOrders(orderIds OrderIds) error {
-	o.m.Lock()
+	o.lock()
 	defer o.m.Unlock()
 	for _, id := range orderIds {
 		if err := o.cancelOrder(id); err != nil {
@@ -306,7 +528,7 @@ func (o *Orderbook) CancelOrders(orderIds OrderIds) error {
 
 func (o *Orderbook) cancelOrder(orderId OrderId) error {
 	if _, exists := o.orders[orderId]; !exists {
-		return fmt.Errorf("Order %d does not exist", orderId)
+		return fmt.Errorf("%w: %d", ErrUnknownOrder, orderId)
 	}
 
 	entry := o.orders[orderId]
@@ -314,30 +536,85 @@ func (o *Orderbook) cancelOrder(orderId OrderId) error {
 	location := entry.location
 	delete(o.orders, orderId)
 
+	levels := o.asks
 	if order.Side() == Buy {
-		orders, _ := o.bids.Get(order.Price())
-		orders.RemoveAt(location)
-		if orders.IsEmpty() {
-			o.bids.Delete(order.Price())
-		}
-	} else {
-		orders, _ := o.asks.Get(order.Price())
-		orders.RemoveAt(location)
-		if orders.IsEmpty() {
-			o.asks.Delete(order.Price())
-		}
+		levels = o.bids
 	}
+	orders, _ := levels.Get(order.Price())
+	orders.Remove(location)
+	if orders.IsEmpty() {
+		levels.Delete(order.Price())
+	}
+
+	o.updateLevelData(order.Price(), order.remainingQuantity, levelRemove)
+	o.emit(Event{
+		Type:     OrderDeleted,
+		OrderId:  orderId,
+		Side:     order.Side(),
+		Price:    order.Price(),
+		Quantity: order.remainingQuantity,
+	})
 	return nil
 }
 
+// ModifyOrder replaces an existing order. Lowering the quantity of an order
+// without changing its side or price reduces it in place and keeps its time
+// priority; any other change cancels the order and adds it again at the back
+// of the queue. A replacement that would be rejected leaves the order as it
+// was.
 func (o *Orderbook) ModifyOrder(modify OrderModify) (Trades, error) {
+	o.lock()
+	defer o.m.Unlock()
+
 	if _, exists := o.orders[modify.OrderId()]; !exists {
-		return nil, fmt.Errorf("Order %d does not exist", modify.OrderId())
+		return nil, fmt.Errorf("%w: %d", ErrUnknownOrder, modify.OrderId())
 	}
 
 	existingOrder := o.orders[modify.OrderId()].order
-	o.CancelOrder(modify.OrderId())
-	return o.AddOrder(modify.ToOrder(existingOrder.OrderType()))
+	if modify.Side() == existingOrder.Side() &&
+		modify.Price() == existingOrder.Price() &&
+		modify.Quantity() <= existingOrder.remainingQuantity {
+		return nil, o.reduceOrder(
+			existingOrder,
+			existingOrder.remainingQuantity-modify.Quantity(),
+		)
+	}
+
+	// Only orders that may rest are ever replaced, so the checks of the
+	// replacement do not depend on the liquidity of the original.
+	order := modify.ToOrder(existingOrder.OrderType())
+	if err := o.checkOrder(&order); err != nil {
+		return nil, err
+	}
+	if err := o.cancelOrder(modify.OrderId()); err != nil {
+		return nil, err
+	}
+	o.insertOrder(&order)
+	return o.matchOrdersNoLock()
+}
+
+// reduceOrder lowers the open quantity of a resting order, removing it from
+// the book if nothing remains.
+func (o *Orderbook) reduceOrder(order *Order, quantity Quantity) error {
+	if quantity == 0 {
+		return nil
+	}
+	if quantity == order.remainingQuantity {
+		return o.cancelOrder(order.OrderId())
+	}
+	if err := order.reduce(quantity); err != nil {
+		return err
+	}
+
+	o.updateLevelData(order.Price(), quantity, levelMatch)
+	o.emit(Event{
+		Type:     OrderReduced,
+		OrderId:  order.OrderId(),
+		Side:     order.Side(),
+		Price:    order.Price(),
+		Quantity: quantity,
+	})
+	return nil
 }
 
 // PruneGoodForDayOrders removes all GoodForDay orders from the orderbook at 4pm
@@ -404,7 +681,7 @@ func (o *Orderbook) ModifyOrder(modify OrderModify) (Trades, error) {
 // }
 
 func (o *Orderbook) OrderInfo() OrderbookLevelsInfo {
-	o.m.Lock()
+	o.lock()
 	defer o.m.Unlock()
 
 	var (
@@ -415,8 +692,7 @@ func (o *Orderbook) OrderInfo() OrderbookLevelsInfo {
 		var l LevelInfo
 		var q Quantity
 		l.Price = bids.Key()
-		orders := bids.Value()
-		it := orders.Iterator()
+		it := bids.Value().Iterator()
 		for order, ok := it.Next(); ok; order, ok = it.Next() {
 			q += order.remainingQuantity
 		}
@@ -428,8 +704,7 @@ func (o *Orderbook) OrderInfo() OrderbookLevelsInfo {
 		var l LevelInfo
 		var q Quantity
 		l.Price = asks.Key()
-		orders := asks.Value()
-		it := orders.Iterator()
+		it := asks.Value().Iterator()
 		for order, ok := it.Next(); ok; order, ok = it.Next() {
 			q += order.remainingQuantity
 		}
@@ -1,9 +1,12 @@
 package orderbook
 
 import (
+	"runtime"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestOrderBook(t *testing.T) {
@@ -26,3 +29,216 @@ func TestOrderBook(t *testing.T) {
 	t.Logf("Orderbook Size: %d", ob.Size())
 	assert.Equal(t, 0, ob.Size())
 }
+
+func TestOrderBookMatch(t *testing.T) {
+	ob := NewOrderbook()
+
+	_, err := ob.AddOrder(NewOrder(GoodTillCancel, 1, Sell, 101, 10))
+	assert.NoError(t, err)
+	_, err = ob.AddOrder(NewOrder(GoodTillCancel, 2, Sell, 100, 10))
+	assert.NoError(t, err)
+
+	// The best ask is matched first, the remainder rests at the bid price
+	trades, err := ob.AddOrder(NewOrder(GoodTillCancel, 3, Buy, 100, 15))
+	assert.NoError(t, err)
+	assert.Len(t, trades, 1)
+	assert.Equal(t, OrderId(2), trades[0].askTrade.orderId)
+	assert.Equal(t, Quantity(10), trades[0].askTrade.quantity)
+	assert.Equal(t, Buy, trades[0].Aggressor())
+	assert.Equal(t, 2, ob.Size())
+
+	// FillAndKill remainders do not rest
+	trades, err = ob.AddOrder(NewOrder(FillAndKill, 4, Buy, 101, 20))
+	assert.NoError(t, err)
+	assert.Len(t, trades, 1)
+	assert.Equal(t, 1, ob.Size())
+
+	info := ob.OrderInfo()
+	assert.Equal(t, LevelsInfo{{Price: 100, Quantity: 5}}, info.GetBids())
+	assert.Empty(t, info.GetAsks())
+}
+
+func TestTop(t *testing.T) {
+	ob := NewOrderbook()
+	bid, ask := ob.Top()
+	assert.Equal(t, LevelInfo{}, bid)
+	assert.Equal(t, LevelInfo{}, ask)
+
+	ob.AddOrder(NewOrder(GoodTillCancel, 1, Buy, 100, 10))
+	ob.AddOrder(NewOrder(GoodTillCancel, 2, Buy, 100, 5))
+	ob.AddOrder(NewOrder(GoodTillCancel, 3, Buy, 99, 7))
+	ob.AddOrder(NewOrder(GoodTillCancel, 4, Sell, 102, 8))
+	ob.AddOrder(NewOrder(GoodTillCancel, 5, Sell, 100, 4))
+	var modify OrderModify
+	ob.ModifyOrder(modify.New(2, 100, Buy, 3))
+
+	bid, ask = ob.Top()
+	assert.Equal(t, LevelInfo{Price: 100, Quantity: 9}, bid)
+	assert.Equal(t, LevelInfo{Price: 102, Quantity: 8}, ask)
+
+	info := ob.OrderInfo()
+	assert.Equal(t, info.GetBids()[0], bid)
+	assert.Equal(t, info.GetAsks()[0], ask)
+
+	bids, asks := ob.Depth()
+	assert.Equal(t, 2, bids)
+	assert.Equal(t, 1, asks)
+}
+
+func TestLockStats(t *testing.T) {
+	ob := NewOrderbook()
+	ob.AddOrder(NewOrder(GoodTillCancel, 1, Buy, 100, 10))
+	ob.Size()
+	stats := ob.LockStats()
+	assert.Equal(t, uint64(2), stats.Acquired)
+	assert.Zero(t, stats.Contended)
+	assert.Zero(t, stats.Wait)
+
+	ob.m.Lock()
+	done := make(chan struct{})
+	go func() {
+		ob.Size()
+		close(done)
+	}()
+	for ob.LockStats().Acquired < 3 {
+		runtime.Gosched()
+	}
+	time.Sleep(time.Millisecond)
+	ob.m.Unlock()
+	<-done
+	stats = ob.LockStats()
+	assert.Equal(t, uint64(1), stats.Contended)
+	assert.GreaterOrEqual(t, stats.Wait, time.Millisecond)
+}
+
+func TestAggressor(t *testing.T) {
+	ob := NewOrderbook()
+	var executions []Event
+	ob.AddListener(ListenerFunc(func(e Event) {
+		if e.Type == OrderExecuted {
+			executions = append(executions, e)
+		}
+	}))
+
+	ob.AddOrder(NewOrder(GoodTillCancel, 1, Buy, 100, 10))
+	trades, _ := ob.AddOrder(NewOrder(GoodTillCancel, 2, Sell, 99, 4))
+	assert.Equal(t, Sell, trades[0].Aggressor())
+	assert.Equal(t, []bool{false, true}, []bool{executions[0].Aggressor, executions[1].Aggressor})
+
+	// A modify that crosses takes liquidity as well.
+	ob.AddOrder(NewOrder(GoodTillCancel, 3, Sell, 101, 4))
+	var modify OrderModify
+	trades, _ = ob.ModifyOrder(modify.New(1, 101, Buy, 6))
+	assert.Equal(t, Buy, trades[0].Aggressor())
+	assert.Equal(t, []bool{true, false}, []bool{executions[2].Aggressor, executions[3].Aggressor})
+}
+
+func TestModifyRejected(t *testing.T) {
+	ob := NewOrderbook()
+	_, err := ob.AddOrder(NewOrder(GoodTillCancel, 1, Sell, 100, 10))
+	require.NoError(t, err)
+	var events []Event
+	ob.AddListener(ListenerFunc(func(e Event) {
+		events = append(events, e)
+	}))
+
+	// A replacement that cannot enter the book leaves the order in place.
+	var modify OrderModify
+	_, err = ob.ModifyOrder(modify.New(1, 101, Sell, 0))
+	assert.ErrorIs(t, err, ErrNoQuantity)
+	order, ok := ob.Order(1)
+	require.True(t, ok)
+	assert.Equal(t, Price(100), order.Price())
+	assert.Equal(t, Quantity(10), order.RemainingQuantity())
+	assert.Empty(t, events)
+}
+
+func TestValidate(t *testing.T) {
+	build := func() *Orderbook {
+		ob := NewOrderbook()
+		ob.AddOrder(NewOrder(GoodTillCancel, 1, Buy, 99, 10))
+		ob.AddOrder(NewOrder(GoodTillCancel, 2, Buy, 99, 5))
+		ob.AddOrder(NewOrder(GoodTillCancel, 3, Sell, 101, 7))
+		ob.AddOrder(NewOrder(FillAndKill, 4, Buy, 101, 2))
+		ob.ModifyOrder((&OrderModify{}).New(2, 99, Buy, 4))
+		require.NoError(t, ob.Validate())
+		return &ob
+	}
+
+	ob := build()
+	ob.levels[99] = LevelData{quantity: 13, count: 2}
+	assert.ErrorContains(t, ob.Validate(), "Level 99 holds 13 shares in 2 orders, its orders 14 in 2")
+
+	ob = build()
+	orders, _ := ob.bids.Get(99)
+	orders.Remove(ob.orders[1].location)
+	err := ob.Validate()
+	assert.ErrorContains(t, err, "Order 1 is not queued at buy 99")
+	assert.ErrorContains(t, err, "2 orders are queued but 3 are resting")
+
+	ob = build()
+	ob.asks.Insert(102, &Orders{})
+	assert.ErrorContains(t, ob.Validate(), "Level sell 102 is empty")
+
+	ob = build()
+	ob.insertOrder(&Order{orderType: GoodTillCancel, orderId: 5, side: Buy, price: 101, initialQuantity: 1, remainingQuantity: 1})
+	assert.ErrorContains(t, ob.Validate(), "Book is crossed: best bid 101, best ask 101")
+}
+
+func TestZeroQuantity(t *testing.T) {
+	ob := NewOrderbook()
+	var events []Event
+	ob.AddListener(ListenerFunc(func(e Event) {
+		events = append(events, e)
+	}))
+
+	// An order without quantity neither rests nor publishes anything.
+	_, err := ob.AddOrder(NewOrder(GoodTillCancel, 1, Buy, 99, 0))
+	assert.ErrorIs(t, err, ErrNoQuantity)
+	assert.Zero(t, ob.Size())
+	assert.Empty(t, events)
+	require.NoError(t, ob.Validate())
+
+	// Reducing a resting order to nothing removes it instead.
+	_, err = ob.AddOrder(NewOrder(GoodTillCancel, 2, Buy, 99, 5))
+	require.NoError(t, err)
+	_, err = ob.ModifyOrder((&OrderModify{}).New(2, 99, Buy, 0))
+	assert.NoError(t, err)
+	assert.Zero(t, ob.Size())
+	require.NoError(t, ob.Validate())
+}
+
+func TestFillOrKill(t *testing.T) {
+	ob := NewOrderbook()
+	for i, price := range []Price{100, 101, 102} {
+		_, err := ob.AddOrder(NewOrder(GoodTillCancel, OrderId(i+1), Sell, price, 5))
+		require.NoError(t, err)
+	}
+	_, err := ob.AddOrder(NewOrder(GoodTillCancel, 4, Buy, 99, 20))
+	require.NoError(t, err)
+
+	// The asks are summed across levels up to the limit; the bids on the
+	// same side as the order are not.
+	assert.True(t, ob.CanFullyFill(Buy, 101, 10))
+	assert.False(t, ob.CanFullyFill(Buy, 101, 11))
+	assert.True(t, ob.CanFullyFill(Buy, 102, 15))
+	assert.False(t, ob.CanFullyFill(Buy, 99, 1))
+	assert.True(t, ob.CanFullyFill(Sell, 99, 20))
+	assert.False(t, ob.CanFullyFill(Sell, 99, 21))
+
+	// An order that cannot be fully filled is rejected without trading.
+	var events []Event
+	ob.AddListener(ListenerFunc(func(e Event) {
+		events = append(events, e)
+	}))
+	_, err = ob.AddOrder(NewOrder(FillOrKill, 5, Buy, 101, 11))
+	assert.ErrorIs(t, err, ErrCannotFullyFill)
+	assert.Empty(t, events)
+	assert.Equal(t, 4, ob.Size())
+
+	trades, err := ob.AddOrder(NewOrder(FillOrKill, 6, Buy, 101, 10))
+	require.NoError(t, err)
+	assert.Len(t, trades, 2)
+	assert.Equal(t, 2, ob.Size())
+	require.NoError(t, ob.Validate())
+}
@@ -0,0 +1,316 @@
+package orderbook
+
+import (
+	"errors"
+	"flag"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// The differential test checks the book with 200,000 operations, or 20,000
+// with -short. Soak it with millions, for example with
+// go test -run TestDifferential ./pkg/orderbook -args -ops=10000000.
+var (
+	differentialOps  = flag.Int("ops", 200_000, "operations of the differential test")
+	differentialSeed = flag.Int64("seed", 1, "seed of the differential test")
+)
+
+// referenceBook is a slow matcher written to be obviously correct, that the
+// Orderbook is checked against. Resting orders are kept in a single slice in
+// time priority and every step scans all of them.
+type referenceBook struct {
+	orders  []*Order
+	matchId uint64
+}
+
+func (r *referenceBook) find(orderId OrderId) int {
+	for i, order := range r.orders {
+		if order.orderId == orderId {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r *referenceBook) remove(orderId OrderId) {
+	i := r.find(orderId)
+	r.orders = append(r.orders[:i], r.orders[i+1:]...)
+}
+
+// best returns the resting order of a side with the best price, the earliest
+// of them on a tie, or nil.
+func (r *referenceBook) best(side Side) *Order {
+	var best *Order
+	for _, order := range r.orders {
+		if order.side != side {
+			continue
+		}
+		if best == nil ||
+			side == Buy && order.price > best.price ||
+			side == Sell && order.price < best.price {
+			best = order
+		}
+	}
+	return best
+}
+
+// crosses reports whether a price of side reaches a resting price of the
+// other side.
+func crosses(side Side, price, resting Price) bool {
+	if side == Buy {
+		return price >= resting
+	}
+	return price <= resting
+}
+
+func opposite(side Side) Side {
+	if side == Buy {
+		return Sell
+	}
+	return Buy
+}
+
+func (r *referenceBook) AddOrder(order Order) (Trades, error) {
+	if r.find(order.orderId) >= 0 {
+		return nil, errors.New("duplicate order")
+	}
+	if err := r.check(&order); err != nil {
+		return nil, err
+	}
+	return r.match(&order), nil
+}
+
+// check rejects an order the book cannot take and prices a market order.
+func (r *referenceBook) check(order *Order) error {
+	if order.initialQuantity == 0 {
+		return errors.New("no quantity")
+	}
+	other := opposite(order.side)
+
+	switch order.orderType {
+	case Market:
+		// A market order becomes a limit order at the worst resting price
+		// of the other side, so that it sweeps it entirely if it needs to.
+		var worst *Order
+		for _, resting := range r.orders {
+			if resting.side == other && (worst == nil || crosses(order.side, resting.price, worst.price)) {
+				worst = resting
+			}
+		}
+		if worst == nil {
+			return errors.New("no liquidity")
+		}
+		order.orderType = GoodTillCancel
+		order.price = worst.price
+	case FillAndKill:
+		if best := r.best(other); best == nil || !crosses(order.side, order.price, best.price) {
+			return errors.New("cannot fill")
+		}
+	case FillOrKill:
+		var available Quantity
+		for _, resting := range r.orders {
+			if resting.side == other && crosses(order.side, order.price, resting.price) {
+				available += resting.remainingQuantity
+			}
+		}
+		if available < order.initialQuantity {
+			return errors.New("cannot fully fill")
+		}
+	}
+	return nil
+}
+
+// match rests an order and matches the book until it no longer crosses.
+func (r *referenceBook) match(order *Order) Trades {
+	r.orders = append(r.orders, order)
+	var trades Trades
+	for {
+		bid, ask := r.best(Buy), r.best(Sell)
+		if bid == nil || ask == nil || bid.price < ask.price {
+			break
+		}
+		quantity := min(bid.remainingQuantity, ask.remainingQuantity)
+		bid.remainingQuantity -= quantity
+		ask.remainingQuantity -= quantity
+		r.matchId++
+		trades = append(trades, Trade{
+			id:        r.matchId,
+			bidTrade:  TradeInfo{bid.orderId, bid.price, quantity},
+			askTrade:  TradeInfo{ask.orderId, ask.price, quantity},
+			aggressor: order.side,
+		})
+		for _, filled := range []*Order{bid, ask} {
+			if filled.remainingQuantity == 0 {
+				r.remove(filled.orderId)
+			}
+		}
+	}
+	if order.orderType == FillAndKill && r.find(order.orderId) >= 0 {
+		r.remove(order.orderId)
+	}
+	return trades
+}
+
+func (r *referenceBook) CancelOrder(orderId OrderId) error {
+	if r.find(orderId) < 0 {
+		return errors.New("unknown order")
+	}
+	r.remove(orderId)
+	return nil
+}
+
+func (r *referenceBook) ModifyOrder(modify OrderModify) (Trades, error) {
+	i := r.find(modify.orderId)
+	if i < 0 {
+		return nil, errors.New("unknown order")
+	}
+	order := r.orders[i]
+	// Lowering the quantity keeps the place of the order in the queue.
+	if modify.side == order.side && modify.price == order.price &&
+		modify.quantity <= order.remainingQuantity {
+		if modify.quantity == 0 {
+			r.remove(order.orderId)
+			return nil, nil
+		}
+		order.initialQuantity -= order.remainingQuantity - modify.quantity
+		order.remainingQuantity = modify.quantity
+		return nil, nil
+	}
+	// A rejected replacement leaves the order as it was.
+	replacement := modify.ToOrder(order.orderType)
+	if err := r.check(&replacement); err != nil {
+		return nil, err
+	}
+	r.remove(order.orderId)
+	return r.match(&replacement), nil
+}
+
+// levels returns the aggregate levels of a side, best first.
+func (r *referenceBook) levels(side Side) LevelsInfo {
+	totals := make(map[Price]Quantity)
+	for _, order := range r.orders {
+		if order.side == side {
+			totals[order.price] += order.remainingQuantity
+		}
+	}
+	var levels LevelsInfo
+	for price, quantity := range totals {
+		levels = append(levels, LevelInfo{price, quantity})
+	}
+	sort.Slice(levels, func(i, j int) bool {
+		if side == Buy {
+			return levels[i].Price > levels[j].Price
+		}
+		return levels[i].Price < levels[j].Price
+	})
+	return levels
+}
+
+// TestDifferential applies random operations to an Orderbook and to the
+// reference, and checks after each that they agree on the outcome, the
+// trades and every resting order, and that the book is valid.
+func TestDifferential(t *testing.T) {
+	ops := *differentialOps
+	if testing.Short() {
+		ops = 20_000
+	}
+	rng := rand.New(rand.NewSource(*differentialSeed))
+	book := NewOrderbook()
+	var ref referenceBook
+
+	// Operations mostly target resting orders, and sometimes ids that left
+	// the book, to exercise unknown and reused ids.
+	var (
+		nextId OrderId
+		dead   []OrderId
+	)
+	pick := func() OrderId {
+		if len(ref.orders) > 0 && rng.Intn(10) > 0 {
+			return ref.orders[rng.Intn(len(ref.orders))].orderId
+		}
+		if len(dead) > 0 && rng.Intn(2) == 0 {
+			return dead[rng.Intn(len(dead))]
+		}
+		return nextId + 1
+	}
+	price := func() Price { return Price(95 + rng.Intn(11)) }
+	quantity := func() Quantity {
+		if rng.Intn(50) == 0 {
+			return 0
+		}
+		return Quantity(1 + rng.Intn(20))
+	}
+	side := func() Side { return Side(rng.Intn(2)) }
+	types := []OrderType{GoodTillCancel, GoodTillCancel, GoodTillCancel, GoodForDay, FillAndKill, FillOrKill, Market}
+
+	for i := 0; i < ops; i++ {
+		var (
+			op              string
+			id              OrderId
+			got, want       Trades
+			gotErr, wantErr error
+		)
+		switch n := rng.Intn(10); {
+		case n < 5:
+			op = "add"
+			id = nextId + 1
+			if rng.Intn(20) == 0 {
+				id = pick()
+			}
+			if id > nextId {
+				nextId = id
+			}
+			order := NewOrder(types[rng.Intn(len(types))], id, side(), price(), quantity())
+			got, gotErr = book.AddOrder(order)
+			want, wantErr = ref.AddOrder(order)
+		case n < 8:
+			op = "cancel"
+			id = pick()
+			gotErr = book.CancelOrder(id)
+			wantErr = ref.CancelOrder(id)
+		default:
+			op = "modify"
+			id = pick()
+			var modify OrderModify
+			if j := ref.find(id); j >= 0 && rng.Intn(2) == 0 {
+				// Reduce in place.
+				resting := ref.orders[j]
+				modify = modify.New(id, resting.price, resting.side, Quantity(rng.Intn(int(resting.remainingQuantity)+1)))
+			} else {
+				modify = modify.New(id, price(), side(), quantity())
+			}
+			got, gotErr = book.ModifyOrder(modify)
+			want, wantErr = ref.ModifyOrder(modify)
+		}
+
+		require.Equalf(t, wantErr == nil, gotErr == nil,
+			"op %d %s: book error %v, reference error %v", i, op, gotErr, wantErr)
+		require.Equalf(t, want, got, "op %d %s: trades", i, op)
+		require.NoErrorf(t, book.Validate(), "op %d %s", i, op)
+		compareBooks(t, &book, &ref, i, op)
+
+		if ref.find(id) < 0 {
+			if len(dead) < 64 {
+				dead = append(dead, id)
+			} else {
+				dead[rng.Intn(len(dead))] = id
+			}
+		}
+	}
+}
+
+func compareBooks(t *testing.T, book *Orderbook, ref *referenceBook, i int, op string) {
+	t.Helper()
+	require.Equalf(t, len(ref.orders), book.Size(), "op %d %s: resting orders", i, op)
+	for _, want := range ref.orders {
+		got, ok := book.Order(want.orderId)
+		require.Truef(t, ok, "op %d %s: order %d is not resting", i, op, want.orderId)
+		require.Equalf(t, *want, got, "op %d %s: order %d", i, op, want.orderId)
+	}
+	info := book.OrderInfo()
+	require.Equalf(t, ref.levels(Buy), info.GetBids(), "op %d %s: bids", i, op)
+	require.Equalf(t, ref.levels(Sell), info.GetAsks(), "op %d %s: asks", i, op)
+}
@@ -0,0 +1,212 @@
+package orderbook
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"go-orderbook/pkg/ds/rbmap"
+	"hash/crc32"
+)
+
+// Snapshot format, all integers little-endian:
+//
+//	magic    [4]byte "OBSN"
+//	version  uint16
+//	sequence uint64  last published event sequence
+//	matchId  uint64  last match id
+//	bids     levels, best first
+//	asks     levels, best first
+//	checksum uint32  CRC-32C of everything before it
+//
+// where levels is a uint32 count followed by, for each level, its price
+// (int32), aggregate quantity (uint32), order count (uint32) and its orders in
+// time priority. Every order is its type (uint8), id (uint64), initial
+// quantity (uint32) and remaining quantity (uint32).
+const (
+	snapshotMagic   = "OBSN"
+	snapshotVersion = 1
+
+	snapshotHeaderSize = 4 + 2 + 8 + 8
+	snapshotLevelSize  = 4 + 4 + 4
+	snapshotOrderSize  = 1 + 8 + 4 + 4
+)
+
+var (
+	ErrSnapshotFormat   = errors.New("malformed snapshot")
+	ErrSnapshotVersion  = errors.New("unsupported snapshot version")
+	ErrSnapshotChecksum = errors.New("snapshot checksum mismatch")
+)
+
+var snapshotCrcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// MarshalBinary encodes the complete state of the book: every resting order
+// in time priority, the level aggregates and the event sequence and match id
+// counters. Restoring the result with UnmarshalBinary and encoding again
+// yields the same bytes.
+func (o *Orderbook) MarshalBinary() ([]byte, error) {
+	o.lock()
+	defer o.m.Unlock()
+
+	b := make([]byte, 0, snapshotHeaderSize+
+		len(o.levels)*snapshotLevelSize+
+		len(o.orders)*snapshotOrderSize+16)
+	b = append(b, snapshotMagic...)
+	b = binary.LittleEndian.AppendUint16(b, snapshotVersion)
+	b = binary.LittleEndian.AppendUint64(b, o.sequence)
+	b = binary.LittleEndian.AppendUint64(b, o.matchId)
+	b = appendSnapshotLevels(b, o.bids)
+	b = appendSnapshotLevels(b, o.asks)
+	b = binary.LittleEndian.AppendUint32(b, crc32.Checksum(b, snapshotCrcTable))
+	return b, nil
+}
+
+func appendSnapshotLevels(b []byte, side *rbmap.Map[Price, *Orders]) []byte {
+	b = binary.LittleEndian.AppendUint32(b, uint32(side.Size()))
+	for levels := side.Begin(); levels.Valid(); levels.Next() {
+		orders := levels.Value()
+
+		var quantity Quantity
+		it := orders.Iterator()
+		for order, ok := it.Next(); ok; order, ok = it.Next() {
+			quantity += order.remainingQuantity
+		}
+
+		b = binary.LittleEndian.AppendUint32(b, uint32(levels.Key()))
+		b = binary.LittleEndian.AppendUint32(b, uint32(quantity))
+		b = binary.LittleEndian.AppendUint32(b, uint32(orders.Size()))
+
+		it = orders.Iterator()
+		for order, ok := it.Next(); ok; order, ok = it.Next() {
+			b = append(b, byte(order.orderType))
+			b = binary.LittleEndian.AppendUint64(b, uint64(order.orderId))
+			b = binary.LittleEndian.AppendUint32(b, uint32(order.initialQuantity))
+			b = binary.LittleEndian.AppendUint32(b, uint32(order.remainingQuantity))
+		}
+	}
+	return b
+}
+
+// UnmarshalBinary replaces the state of the book with a snapshot produced by
+// MarshalBinary. The book must have been created with NewOrderbook; its
+// listeners are kept and no events are published for the restored orders.
+func (o *Orderbook) UnmarshalBinary(data []byte) error {
+	if len(data) < snapshotHeaderSize+4 || string(data[:4]) != snapshotMagic {
+		return ErrSnapshotFormat
+	}
+	if version := binary.LittleEndian.Uint16(data[4:]); version != snapshotVersion {
+		return fmt.Errorf("%w: %d", ErrSnapshotVersion, version)
+	}
+	body, checksum := data[:len(data)-4], binary.LittleEndian.Uint32(data[len(data)-4:])
+	if crc32.Checksum(body, snapshotCrcTable) != checksum {
+		return ErrSnapshotChecksum
+	}
+
+	restored := NewOrderbook()
+	restored.sequence = binary.LittleEndian.Uint64(body[6:])
+	restored.matchId = binary.LittleEndian.Uint64(body[14:])
+
+	r := snapshotReader{b: body[snapshotHeaderSize:]}
+	for _, side := range []Side{Buy, Sell} {
+		if err := restored.restoreLevels(&r, side); err != nil {
+			return err
+		}
+	}
+	if len(r.b) != 0 {
+		return fmt.Errorf("%w: %d trailing bytes", ErrSnapshotFormat, len(r.b))
+	}
+
+	o.lock()
+	defer o.m.Unlock()
+	o.bids = restored.bids
+	o.asks = restored.asks
+	o.orders = restored.orders
+	o.levels = restored.levels
+	o.sequence = restored.sequence
+	o.matchId = restored.matchId
+	return nil
+}
+
+func (o *Orderbook) restoreLevels(r *snapshotReader, side Side) error {
+	count, err := r.uint32()
+	if err != nil {
+		return err
+	}
+
+	var previous Price
+	for i := uint32(0); i < count; i++ {
+		if len(r.b) < snapshotLevelSize {
+			return ErrSnapshotFormat
+		}
+		price := Price(binary.LittleEndian.Uint32(r.b))
+		quantity := Quantity(binary.LittleEndian.Uint32(r.b[4:]))
+		orders := binary.LittleEndian.Uint32(r.b[8:])
+		r.b = r.b[snapshotLevelSize:]
+
+		if i > 0 && (side == Buy && price >= previous ||
+			side == Sell && price <= previous) {
+			return fmt.Errorf("%w: levels out of order at %d", ErrSnapshotFormat, price)
+		}
+		if orders == 0 {
+			return fmt.Errorf("%w: empty level at %d", ErrSnapshotFormat, price)
+		}
+		previous = price
+
+		var sum Quantity
+		for j := uint32(0); j < orders; j++ {
+			if len(r.b) < snapshotOrderSize {
+				return ErrSnapshotFormat
+			}
+			order := &Order{
+				orderType:         OrderType(r.b[0]),
+				orderId:           OrderId(binary.LittleEndian.Uint64(r.b[1:])),
+				side:              side,
+				price:             price,
+				initialQuantity:   Quantity(binary.LittleEndian.Uint32(r.b[9:])),
+				remainingQuantity: Quantity(binary.LittleEndian.Uint32(r.b[13:])),
+			}
+			r.b = r.b[snapshotOrderSize:]
+
+			if _, exists := o.orders[order.orderId]; exists {
+				return fmt.Errorf("%w: duplicate order %d", ErrSnapshotFormat, order.orderId)
+			}
+			if order.remainingQuantity == 0 ||
+				order.remainingQuantity > order.initialQuantity {
+				return fmt.Errorf("%w: invalid quantity for order %d", ErrSnapshotFormat, order.orderId)
+			}
+			sum += order.remainingQuantity
+
+			levels := o.asks
+			if side == Buy {
+				levels = o.bids
+			}
+			queue, exists := levels.Get(price)
+			if !exists {
+				queue = &Orders{}
+				levels.Insert(price, queue)
+			}
+			o.orders[order.orderId] = OrderEntry{
+				order:    order,
+				location: queue.AppendElement(order),
+			}
+			o.updateLevelData(price, order.remainingQuantity, levelAdd)
+		}
+
+		if sum != quantity {
+			return fmt.Errorf("%w: level %d quantity mismatch", ErrSnapshotFormat, price)
+		}
+	}
+	return nil
+}
+
+type snapshotReader struct {
+	b []byte
+}
+
+func (r *snapshotReader) uint32() (uint32, error) {
+	if len(r.b) < 4 {
+		return 0, ErrSnapshotFormat
+	}
+	v := binary.LittleEndian.Uint32(r.b)
+	r.b = r.b[4:]
+	return v, nil
+}
@@ -0,0 +1,60 @@
+package orderbook
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	ob := setupOrderbook(50)
+	assert.NotZero(t, ob.Size())
+
+	// Reduce an order in place so that initial and remaining quantities differ
+	var modify OrderModify
+	id := ob.OrderIds()[0]
+	entry := ob.orders[id]
+	_, err := ob.ModifyOrder(modify.New(id, entry.order.Price(), entry.order.Side(), 1))
+	assert.NoError(t, err)
+
+	data, err := ob.MarshalBinary()
+	assert.NoError(t, err)
+
+	restored := NewOrderbook()
+	assert.NoError(t, restored.UnmarshalBinary(data))
+	again, err := restored.MarshalBinary()
+	assert.NoError(t, err)
+	assert.Equal(t, data, again)
+	assert.Equal(t, ob.Size(), restored.Size())
+	assert.Equal(t, ob.OrderInfo(), restored.OrderInfo())
+
+	// Both books continue identically, including time priority and counters
+	var want, got []Event
+	ob.AddListener(ListenerFunc(func(e Event) { want = append(want, e) }))
+	restored.AddListener(ListenerFunc(func(e Event) { got = append(got, e) }))
+	for _, book := range []*Orderbook{ob, &restored} {
+		_, err := book.AddOrder(NewOrder(GoodTillCancel, 1000, Buy, 6100, 500))
+		assert.NoError(t, err)
+		_, err = book.AddOrder(NewOrder(GoodTillCancel, 1001, Sell, 5000, 300))
+		assert.NoError(t, err)
+	}
+	assert.NotEmpty(t, want)
+	assert.Equal(t, want, got)
+}
+
+func TestSnapshotRejectsCorruption(t *testing.T) {
+	ob := setupOrderbook(5)
+	data, err := ob.MarshalBinary()
+	assert.NoError(t, err)
+
+	corrupt := append([]byte(nil), data...)
+	corrupt[len(corrupt)/2] ^= 0xff
+	restored := NewOrderbook()
+	assert.ErrorIs(t, restored.UnmarshalBinary(corrupt), ErrSnapshotChecksum)
+
+	future := append([]byte(nil), data...)
+	future[4] = 9
+	assert.ErrorIs(t, restored.UnmarshalBinary(future), ErrSnapshotVersion)
+	assert.ErrorIs(t, restored.UnmarshalBinary(data[:10]), ErrSnapshotFormat)
+	assert.Equal(t, 0, restored.Size())
+}
@@ -6,19 +6,57 @@ type TradeInfo struct {
 	quantity Quantity
 }
 
+func (t *TradeInfo) OrderId() OrderId {
+	return t.orderId
+}
+
+func (t *TradeInfo) Price() Price {
+	return t.price
+}
+
+func (t *TradeInfo) Quantity() Quantity {
+	return t.quantity
+}
+
 // A Trade represents a matching bid and ask.
 type Trade struct {
-	bidTrade TradeInfo
-	askTrade TradeInfo
+	id        uint64
+	bidTrade  TradeInfo
+	askTrade  TradeInfo
+	aggressor Side
 }
 
 func (t *Trade) New(
+	id uint64,
 	bidTrade, askTrade TradeInfo,
+	aggressor Side,
 ) Trade {
 	return Trade{
-		bidTrade: bidTrade,
-		askTrade: askTrade,
+		id:        id,
+		bidTrade:  bidTrade,
+		askTrade:  askTrade,
+		aggressor: aggressor,
 	}
 }
 
+// Id identifies the trade within its book. It is the MatchId of the
+// OrderExecuted events of the trade, and is kept by snapshots.
+func (t *Trade) Id() uint64 {
+	return t.id
+}
+
+func (t *Trade) BidTrade() TradeInfo {
+	return t.bidTrade
+}
+
+func (t *Trade) AskTrade() TradeInfo {
+	return t.askTrade
+}
+
+// Aggressor is the side of the order that took liquidity: the incoming order
+// that matched against the resting, passive one.
+func (t *Trade) Aggressor() Side {
+	return t.aggressor
+}
+
 type Trades []Trade
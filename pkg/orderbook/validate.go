@@ -0,0 +1,127 @@
+package orderbook
+
+import (
+	"errors"
+	"fmt"
+	"go-orderbook/pkg/ds/rbmap"
+	"slices"
+)
+
+// Validate checks the invariants of the book and returns an error describing
+// every violation, or nil:
+//
+//   - every resting order is queued at the level of its side and price, and
+//     every queued order is resting, with nothing left open;
+//   - the aggregate quantity and order count of every level match its orders;
+//   - no level is empty;
+//   - the book is not crossed.
+//
+// It walks every order, so it is meant for tests and diagnostics.
+func (o *Orderbook) Validate() error {
+	o.lock()
+	defer o.m.Unlock()
+	return o.validate()
+}
+
+func (o *Orderbook) validate() error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	// Levels of both sides share the aggregates of their price.
+	aggregates := make(map[Price]LevelData)
+	queued := 0
+	for _, side := range []struct {
+		side   Side
+		levels *rbmap.Map[Price, *Orders]
+	}{{Buy, o.bids}, {Sell, o.asks}} {
+		for level := side.levels.Begin(); level.Valid(); level.Next() {
+			price, orders := level.Key(), level.Value()
+			if orders.IsEmpty() {
+				fail("Level %s %d is empty", sideName(side.side), price)
+				continue
+			}
+			it := orders.Iterator()
+			for order, ok := it.Next(); ok; order, ok = it.Next() {
+				queued++
+				if entry, exists := o.orders[order.OrderId()]; !exists {
+					fail("Order %d is queued at %s %d but not resting",
+						order.OrderId(), sideName(side.side), price)
+				} else if entry.order != order {
+					fail("Order %d is queued at %s %d as a different order",
+						order.OrderId(), sideName(side.side), price)
+				}
+				if order.Side() != side.side || order.Price() != price {
+					fail("Order %d of %s %d is queued at %s %d", order.OrderId(),
+						sideName(order.Side()), order.Price(), sideName(side.side), price)
+				}
+				if order.remainingQuantity == 0 {
+					fail("Order %d is queued with nothing open", order.OrderId())
+				}
+				data := aggregates[price]
+				data.count++
+				data.quantity += order.remainingQuantity
+				aggregates[price] = data
+			}
+		}
+	}
+
+	ids := make([]OrderId, 0, len(o.orders))
+	for id := range o.orders {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	for _, id := range ids {
+		entry := o.orders[id]
+		levels := o.asks
+		if entry.order.Side() == Buy {
+			levels = o.bids
+		}
+		orders, exists := levels.Get(entry.order.Price())
+		switch {
+		case entry.order.OrderId() != id:
+			fail("Order %d is resting under id %d", entry.order.OrderId(), id)
+		case !exists || !orders.Contains(entry.location):
+			fail("Order %d is not queued at %s %d",
+				id, sideName(entry.order.Side()), entry.order.Price())
+		case entry.location.Value() != entry.order:
+			fail("Order %d is located at order %d", id, entry.location.Value().OrderId())
+		}
+	}
+	if queued != len(o.orders) {
+		fail("%d orders are queued but %d are resting", queued, len(o.orders))
+	}
+
+	prices := make([]Price, 0, len(o.levels)+len(aggregates))
+	for price := range o.levels {
+		prices = append(prices, price)
+	}
+	for price := range aggregates {
+		if _, exists := o.levels[price]; !exists {
+			prices = append(prices, price)
+		}
+	}
+	slices.Sort(prices)
+	for _, price := range prices {
+		if data, want := o.levels[price], aggregates[price]; data != want {
+			fail("Level %d holds %d shares in %d orders, its orders %d in %d",
+				price, data.quantity, data.count, want.quantity, want.count)
+		}
+	}
+
+	if !o.bids.Empty() && !o.asks.Empty() {
+		bids, asks := o.bids.Begin(), o.asks.Begin()
+		if bid, ask := bids.Key(), asks.Key(); bid >= ask {
+			fail("Book is crossed: best bid %d, best ask %d", bid, ask)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func sideName(side Side) string {
+	if side == Sell {
+		return "sell"
+	}
+	return "buy"
+}
@@ -0,0 +1,491 @@
+package ouch
+
+import (
+	"errors"
+	"go-orderbook/pkg/engine"
+	"go-orderbook/pkg/orderbook"
+	"go-orderbook/pkg/soupbintcp"
+	"sync"
+	"time"
+)
+
+type tokenKey struct {
+	username string
+	token    Token
+}
+
+type orderState struct {
+	session   *soupbintcp.Session
+	orderId   orderbook.OrderId
+	token     Token
+	stock     Stock
+	symbol    engine.Symbol
+	side      orderbook.Side
+	orderType orderbook.OrderType
+	price     orderbook.Price
+	leaves    orderbook.Quantity
+}
+
+type pendingEvent struct {
+	symbol engine.Symbol
+	event  orderbook.Event
+}
+
+// Gateway is the soupbintcp.Handler that maps Enter, Replace and Cancel
+// messages onto an engine.Engine and answers with Accepted, Replaced,
+// Canceled, Executed and Rejected messages. Decoding and encoding reuse the
+// buffers of the gateway and the state of orders that left the book is
+// reused for new ones, so the gateway adds no allocations to those of the
+// engine.
+type Gateway struct {
+	engine *engine.Engine
+
+	// m serializes messages, so that the reports of one message are sent
+	// before those of the next.
+	m           sync.Mutex
+	orders      map[orderbook.OrderId]*orderState
+	tokens      map[tokenKey]*orderState
+	free        []*orderState
+	symbols     map[Stock]engine.Symbol
+	nextOrderId orderbook.OrderId
+	// active is the order of the message being processed. Its executions
+	// removed liquidity, and its deletes and reductions are reported by the
+	// message itself.
+	active orderbook.OrderId
+
+	enter   EnterOrder
+	replace ReplaceOrder
+	cancel  CancelOrder
+	buf     []byte
+
+	// pendingM guards owned, the symbols of the orders of the gateway by
+	// engine id, and pending, the events of those orders appended to by the
+	// engine listener while a book is locked.
+	pendingM sync.Mutex
+	owned    map[orderbook.OrderId]engine.Symbol
+	pending  []pendingEvent
+	draining []pendingEvent
+	// wake is signalled when a command has published its events, for run to
+	// report those of commands that did not come through OnMessage. done
+	// stops run.
+	wake chan struct{}
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// gatewayListener queues the book events of the orders of a Gateway.
+type gatewayListener struct {
+	g *Gateway
+}
+
+func (l gatewayListener) OnEvent(symbol engine.Symbol, ev orderbook.Event) {
+	l.g.pendingM.Lock()
+	if owner, ok := l.g.owned[ev.OrderId]; ok && owner == symbol {
+		l.g.pending = append(l.g.pending, pendingEvent{symbol: symbol, event: ev})
+	}
+	l.g.pendingM.Unlock()
+}
+
+func (l gatewayListener) OnCommandEnd(symbol engine.Symbol) {
+	select {
+	case l.g.wake <- struct{}{}:
+	default:
+	}
+}
+
+// NewGateway creates a gateway on an engine. Orders entered through the
+// gateway are given engine order ids from its own counter, skipping ids
+// already in use; the id is reported as the order reference. The gateway
+// reports executions and cancels caused by other users of the engine from its
+// own goroutine until Close.
+func NewGateway(e *engine.Engine) *Gateway {
+	g := &Gateway{
+		engine:      e,
+		orders:      make(map[orderbook.OrderId]*orderState),
+		tokens:      make(map[tokenKey]*orderState),
+		symbols:     make(map[Stock]engine.Symbol),
+		nextOrderId: 1,
+		buf:         make([]byte, 0, ReplacedSize),
+		owned:       make(map[orderbook.OrderId]engine.Symbol),
+		wake:        make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+	e.AddListener(gatewayListener{g: g})
+	g.wg.Add(1)
+	go g.run()
+	return g
+}
+
+// Close stops reporting the events of commands that did not come through the
+// gateway.
+func (g *Gateway) Close() {
+	close(g.done)
+	g.wg.Wait()
+}
+
+// run drains the pending events whenever a command ends. Those of a command
+// entered through OnMessage are drained by OnMessage itself, after its own
+// messages, since run waits for g.m.
+func (g *Gateway) run() {
+	defer g.wg.Done()
+	for {
+		select {
+		case <-g.wake:
+			g.m.Lock()
+			g.drain()
+			g.m.Unlock()
+		case <-g.done:
+			return
+		}
+	}
+}
+
+func (g *Gateway) OnLogin(s *soupbintcp.Session)  {}
+func (g *Gateway) OnLogout(s *soupbintcp.Session) {}
+
+// OnMessage handles one inbound message. Malformed messages and cancels or
+// replaces of unknown tokens are ignored, as in OUCH.
+func (g *Gateway) OnMessage(s *soupbintcp.Session, payload []byte) {
+	if len(payload) == 0 {
+		return
+	}
+
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	switch payload[0] {
+	case MsgEnterOrder:
+		if g.enter.Decode(payload) == nil {
+			g.enterOrder(s)
+		}
+	case MsgReplaceOrder:
+		if g.replace.Decode(payload) == nil {
+			g.replaceOrder(s)
+		}
+	case MsgCancelOrder:
+		if g.cancel.Decode(payload) == nil {
+			g.cancelOrder(s)
+		}
+	}
+	g.drain()
+	g.active = 0
+}
+
+func timestamp() uint64 {
+	return uint64(time.Now().UnixNano())
+}
+
+func (g *Gateway) reject(s *soupbintcp.Session, token Token, reason byte) {
+	m := Rejected{Timestamp: timestamp(), Token: token, Reason: reason}
+	g.buf = m.AppendTo(g.buf[:0])
+	s.Send(g.buf)
+}
+
+func (g *Gateway) enterOrder(s *soupbintcp.Session) {
+	m := &g.enter
+	key := tokenKey{username: s.Username(), token: m.Token}
+	if _, exists := g.tokens[key]; exists {
+		g.reject(s, m.Token, RejectDuplicateToken)
+		return
+	}
+
+	var side orderbook.Side
+	switch m.Side {
+	case SideBuy:
+		side = orderbook.Buy
+	case SideSell:
+		side = orderbook.Sell
+	default:
+		g.reject(s, m.Token, RejectOther)
+		return
+	}
+
+	var orderType orderbook.OrderType
+	switch m.TimeInForce {
+	case TimeInForceDay:
+		orderType = orderbook.GoodForDay
+	case TimeInForceGTC:
+		orderType = orderbook.GoodTillCancel
+	case TimeInForceIOC:
+		orderType = orderbook.FillAndKill
+	case TimeInForceFOK:
+		orderType = orderbook.FillOrKill
+	case TimeInForceMarket:
+		orderType = orderbook.Market
+	default:
+		g.reject(s, m.Token, RejectInvalidTIF)
+		return
+	}
+
+	if m.Shares == 0 {
+		g.reject(s, m.Token, RejectInvalidQuantity)
+		return
+	}
+	if orderType != orderbook.Market && (m.Price == 0 || m.Price > 1<<31-1) {
+		g.reject(s, m.Token, RejectInvalidPrice)
+		return
+	}
+
+	symbol, ok := g.symbols[m.Stock]
+	if !ok {
+		symbol = engine.Symbol(trim(m.Stock[:]))
+		if _, listed := g.engine.Book(symbol); !listed {
+			g.reject(s, m.Token, RejectUnknownSymbol)
+			return
+		}
+		g.symbols[m.Stock] = symbol
+	}
+
+	o := g.newOrderState()
+	*o = orderState{
+		session:   s,
+		token:     m.Token,
+		stock:     m.Stock,
+		symbol:    symbol,
+		side:      side,
+		orderType: orderType,
+		price:     orderbook.Price(m.Price),
+		leaves:    orderbook.Quantity(m.Shares),
+	}
+	for {
+		o.orderId = g.nextOrderId
+		g.nextOrderId++
+		g.orders[o.orderId] = o
+		g.own(o)
+		g.active = o.orderId
+
+		_, err := g.engine.AddOrder(
+			symbol,
+			orderbook.NewOrder(orderType, o.orderId, side, o.price, o.leaves),
+		)
+		if err == nil {
+			break
+		}
+		delete(g.orders, o.orderId)
+		g.disown(o)
+		if !errors.Is(err, engine.ErrDuplicateOrder) {
+			g.free = append(g.free, o)
+			g.reject(s, m.Token, RejectOther)
+			return
+		}
+	}
+	g.tokens[key] = o
+
+	accepted := Accepted{
+		Timestamp:   timestamp(),
+		Token:       o.token,
+		Side:        m.Side,
+		Shares:      m.Shares,
+		Stock:       o.stock,
+		Price:       m.Price,
+		TimeInForce: m.TimeInForce,
+		OrderRef:    uint64(o.orderId),
+	}
+	g.buf = accepted.AppendTo(g.buf[:0])
+	s.Send(g.buf)
+}
+
+func (g *Gateway) replaceOrder(s *soupbintcp.Session) {
+	m := &g.replace
+	o, ok := g.tokens[tokenKey{username: s.Username(), token: m.ExistingToken}]
+	if !ok {
+		return
+	}
+	replacement := tokenKey{username: s.Username(), token: m.ReplacementToken}
+	if _, exists := g.tokens[replacement]; exists {
+		g.reject(s, m.ReplacementToken, RejectDuplicateToken)
+		return
+	}
+	if m.Shares == 0 {
+		g.reject(s, m.ReplacementToken, RejectInvalidQuantity)
+		return
+	}
+	if m.Price == 0 || m.Price > 1<<31-1 {
+		g.reject(s, m.ReplacementToken, RejectInvalidPrice)
+		return
+	}
+
+	var modify orderbook.OrderModify
+	g.active = o.orderId
+	_, err := g.engine.ModifyOrder(modify.New(
+		o.orderId,
+		orderbook.Price(m.Price),
+		o.side,
+		orderbook.Quantity(m.Shares),
+	))
+	if err != nil {
+		g.reject(s, m.ReplacementToken, RejectOther)
+		return
+	}
+
+	delete(g.tokens, tokenKey{username: s.Username(), token: o.token})
+	g.tokens[replacement] = o
+	previous := o.token
+	o.token = m.ReplacementToken
+	o.price = orderbook.Price(m.Price)
+	o.leaves = orderbook.Quantity(m.Shares)
+
+	replaced := Replaced{
+		Accepted: Accepted{
+			Timestamp:   timestamp(),
+			Token:       o.token,
+			Side:        sideByte(o.side),
+			Shares:      m.Shares,
+			Stock:       o.stock,
+			Price:       m.Price,
+			TimeInForce: tifByte(o.orderType),
+			OrderRef:    uint64(o.orderId),
+		},
+		PreviousToken: previous,
+	}
+	g.buf = replaced.AppendTo(g.buf[:0])
+	s.Send(g.buf)
+}
+
+func (g *Gateway) cancelOrder(s *soupbintcp.Session) {
+	m := &g.cancel
+	o, ok := g.tokens[tokenKey{username: s.Username(), token: m.Token}]
+	if !ok || orderbook.Quantity(m.Shares) >= o.leaves {
+		return
+	}
+
+	g.active = o.orderId
+	decrement := o.leaves - orderbook.Quantity(m.Shares)
+	var err error
+	if m.Shares == 0 {
+		err = g.engine.CancelOrder(o.orderId)
+	} else {
+		var modify orderbook.OrderModify
+		_, err = g.engine.ModifyOrder(
+			modify.New(o.orderId, o.price, o.side, orderbook.Quantity(m.Shares)),
+		)
+	}
+	if err != nil {
+		return
+	}
+
+	o.leaves -= decrement
+	g.canceled(o, decrement, CancelUser)
+	if o.leaves == 0 {
+		g.forget(o)
+	}
+}
+
+func (g *Gateway) canceled(o *orderState, decrement orderbook.Quantity, reason byte) {
+	m := Canceled{
+		Timestamp:         timestamp(),
+		Token:             o.token,
+		DecrementedShares: uint32(decrement),
+		Reason:            reason,
+	}
+	g.buf = m.AppendTo(g.buf[:0])
+	o.session.Send(g.buf)
+}
+
+// drain reports the book events collected since the last drain. It should
+// only be called with g.m held.
+func (g *Gateway) drain() {
+	g.pendingM.Lock()
+	g.pending, g.draining = g.draining[:0], g.pending
+	g.pendingM.Unlock()
+
+	for _, p := range g.draining {
+		ev := p.event
+		o, ok := g.orders[ev.OrderId]
+		if !ok || o.symbol != p.symbol {
+			continue
+		}
+
+		switch ev.Type {
+		case orderbook.OrderExecuted:
+			liquidity := LiquidityAdded
+			if ev.Aggressor {
+				liquidity = LiquidityRemoved
+			}
+			o.leaves -= ev.Quantity
+			m := Executed{
+				Timestamp:      timestamp(),
+				Token:          o.token,
+				ExecutedShares: uint32(ev.Quantity),
+				ExecutionPrice: uint32(ev.TradePrice),
+				Liquidity:      liquidity,
+				MatchNumber:    ev.MatchId,
+			}
+			g.buf = m.AppendTo(g.buf[:0])
+			o.session.Send(g.buf)
+			if o.leaves == 0 {
+				g.forget(o)
+			}
+		case orderbook.OrderDeleted:
+			if ev.OrderId == g.active && o.orderType != orderbook.FillAndKill {
+				continue
+			}
+			reason := CancelSupervisory
+			if o.orderType == orderbook.FillAndKill {
+				reason = CancelImmediate
+			}
+			o.leaves = 0
+			g.canceled(o, ev.Quantity, reason)
+			g.forget(o)
+		case orderbook.OrderReduced:
+			if ev.OrderId == g.active {
+				continue
+			}
+			o.leaves -= ev.Quantity
+			g.canceled(o, ev.Quantity, CancelSupervisory)
+		}
+	}
+}
+
+// own makes the engine listener queue the events of an order, and disown
+// stops it once the order left the book or was rejected.
+func (g *Gateway) own(o *orderState) {
+	g.pendingM.Lock()
+	g.owned[o.orderId] = o.symbol
+	g.pendingM.Unlock()
+}
+
+func (g *Gateway) disown(o *orderState) {
+	g.pendingM.Lock()
+	delete(g.owned, o.orderId)
+	g.pendingM.Unlock()
+}
+
+func (g *Gateway) newOrderState() *orderState {
+	if n := len(g.free); n > 0 {
+		o := g.free[n-1]
+		g.free = g.free[:n-1]
+		return o
+	}
+	return &orderState{}
+}
+
+// forget removes an order that left the book and keeps its state for reuse,
+// so it must not be used afterwards.
+func (g *Gateway) forget(o *orderState) {
+	delete(g.orders, o.orderId)
+	g.disown(o)
+	delete(g.tokens, tokenKey{username: o.session.Username(), token: o.token})
+	g.free = append(g.free, o)
+}
+
+func sideByte(side orderbook.Side) byte {
+	if side == orderbook.Sell {
+		return SideSell
+	}
+	return SideBuy
+}
+
+func tifByte(orderType orderbook.OrderType) byte {
+	switch orderType {
+	case orderbook.GoodTillCancel:
+		return TimeInForceGTC
+	case orderbook.FillAndKill:
+		return TimeInForceIOC
+	case orderbook.FillOrKill:
+		return TimeInForceFOK
+	case orderbook.Market:
+		return TimeInForceMarket
+	}
+	return TimeInForceDay
+}
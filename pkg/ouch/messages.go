@@ -0,0 +1,358 @@
+// Package ouch implements an OUCH-style fixed-layout binary order entry
+// protocol, carried over the soupbintcp session layer.
+//
+// All integers are big-endian. Tokens and stock symbols are ASCII, left
+// justified and padded with spaces. Encoding and decoding work on caller
+// supplied buffers and do not allocate.
+package ouch
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Inbound message types, sent by the client as unsequenced data.
+const (
+	MsgEnterOrder   byte = 'O'
+	MsgReplaceOrder byte = 'U'
+	MsgCancelOrder  byte = 'X'
+)
+
+// Outbound message types, sent by the server as sequenced data.
+const (
+	MsgAccepted byte = 'A'
+	MsgReplaced byte = 'U'
+	MsgCanceled byte = 'C'
+	MsgExecuted byte = 'E'
+	MsgRejected byte = 'J'
+)
+
+// Sides.
+const (
+	SideBuy  byte = 'B'
+	SideSell byte = 'S'
+)
+
+// Time in force, which also selects market orders.
+const (
+	TimeInForceDay    byte = 'D'
+	TimeInForceGTC    byte = 'G'
+	TimeInForceIOC    byte = 'I'
+	TimeInForceFOK    byte = 'F'
+	TimeInForceMarket byte = 'M'
+)
+
+// Liquidity flags of an execution.
+const (
+	LiquidityAdded   byte = 'A'
+	LiquidityRemoved byte = 'R'
+)
+
+// Cancel reasons.
+const (
+	CancelUser        byte = 'U'
+	CancelImmediate   byte = 'I'
+	CancelSupervisory byte = 'S'
+)
+
+// Reject reasons.
+const (
+	RejectUnknownSymbol   byte = 'S'
+	RejectDuplicateToken  byte = 'D'
+	RejectInvalidQuantity byte = 'Z'
+	RejectInvalidPrice    byte = 'X'
+	RejectInvalidTIF      byte = 'T'
+	RejectOther           byte = 'O'
+)
+
+// Encoded sizes, including the message type.
+const (
+	EnterOrderSize   = 1 + 14 + 1 + 4 + 8 + 4 + 1
+	ReplaceOrderSize = 1 + 14 + 14 + 4 + 4
+	CancelOrderSize  = 1 + 14 + 4
+	AcceptedSize     = 1 + 8 + 14 + 1 + 4 + 8 + 4 + 1 + 8
+	ReplacedSize     = AcceptedSize + 14
+	CanceledSize     = 1 + 8 + 14 + 4 + 1
+	ExecutedSize     = 1 + 8 + 14 + 4 + 4 + 1 + 8
+	RejectedSize     = 1 + 8 + 14 + 1
+)
+
+var ErrMessageSize = errors.New("invalid message size")
+
+// Token is the client assigned identifier of an order.
+type Token [14]byte
+
+// Stock is the symbol of an instrument.
+type Stock [8]byte
+
+// NewToken pads s with spaces. Longer strings are truncated.
+func NewToken(s string) Token {
+	var t Token
+	pad(t[:], s)
+	return t
+}
+
+// NewStock pads s with spaces. Longer strings are truncated.
+func NewStock(s string) Stock {
+	var st Stock
+	pad(st[:], s)
+	return st
+}
+
+func pad(b []byte, s string) {
+	n := copy(b, s)
+	for i := n; i < len(b); i++ {
+		b[i] = ' '
+	}
+}
+
+func trim(b []byte) []byte {
+	for len(b) > 0 && b[len(b)-1] == ' ' {
+		b = b[:len(b)-1]
+	}
+	return b
+}
+
+func (t Token) String() string {
+	return string(trim(t[:]))
+}
+
+func (s Stock) String() string {
+	return string(trim(s[:]))
+}
+
+func checkSize(b []byte, msgType byte, size int) error {
+	if len(b) != size || b[0] != msgType {
+		return fmt.Errorf("%w: %q message of %d bytes", ErrMessageSize, msgType, len(b))
+	}
+	return nil
+}
+
+type EnterOrder struct {
+	Token       Token
+	Side        byte
+	Shares      uint32
+	Stock       Stock
+	Price       uint32
+	TimeInForce byte
+}
+
+func (m *EnterOrder) AppendTo(b []byte) []byte {
+	b = append(b, MsgEnterOrder)
+	b = append(b, m.Token[:]...)
+	b = append(b, m.Side)
+	b = binary.BigEndian.AppendUint32(b, m.Shares)
+	b = append(b, m.Stock[:]...)
+	b = binary.BigEndian.AppendUint32(b, m.Price)
+	return append(b, m.TimeInForce)
+}
+
+func (m *EnterOrder) Decode(b []byte) error {
+	if err := checkSize(b, MsgEnterOrder, EnterOrderSize); err != nil {
+		return err
+	}
+	copy(m.Token[:], b[1:15])
+	m.Side = b[15]
+	m.Shares = binary.BigEndian.Uint32(b[16:])
+	copy(m.Stock[:], b[20:28])
+	m.Price = binary.BigEndian.Uint32(b[28:])
+	m.TimeInForce = b[32]
+	return nil
+}
+
+// ReplaceOrder replaces the price and the total open shares of an order,
+// giving it a new token.
+type ReplaceOrder struct {
+	ExistingToken    Token
+	ReplacementToken Token
+	Shares           uint32
+	Price            uint32
+}
+
+func (m *ReplaceOrder) AppendTo(b []byte) []byte {
+	b = append(b, MsgReplaceOrder)
+	b = append(b, m.ExistingToken[:]...)
+	b = append(b, m.ReplacementToken[:]...)
+	b = binary.BigEndian.AppendUint32(b, m.Shares)
+	return binary.BigEndian.AppendUint32(b, m.Price)
+}
+
+func (m *ReplaceOrder) Decode(b []byte) error {
+	if err := checkSize(b, MsgReplaceOrder, ReplaceOrderSize); err != nil {
+		return err
+	}
+	copy(m.ExistingToken[:], b[1:15])
+	copy(m.ReplacementToken[:], b[15:29])
+	m.Shares = binary.BigEndian.Uint32(b[29:])
+	m.Price = binary.BigEndian.Uint32(b[33:])
+	return nil
+}
+
+// CancelOrder reduces an order to Shares open shares. Zero cancels it.
+type CancelOrder struct {
+	Token  Token
+	Shares uint32
+}
+
+func (m *CancelOrder) AppendTo(b []byte) []byte {
+	b = append(b, MsgCancelOrder)
+	b = append(b, m.Token[:]...)
+	return binary.BigEndian.AppendUint32(b, m.Shares)
+}
+
+func (m *CancelOrder) Decode(b []byte) error {
+	if err := checkSize(b, MsgCancelOrder, CancelOrderSize); err != nil {
+		return err
+	}
+	copy(m.Token[:], b[1:15])
+	m.Shares = binary.BigEndian.Uint32(b[15:])
+	return nil
+}
+
+type Accepted struct {
+	Timestamp   uint64
+	Token       Token
+	Side        byte
+	Shares      uint32
+	Stock       Stock
+	Price       uint32
+	TimeInForce byte
+	OrderRef    uint64
+}
+
+func (m *Accepted) appendBody(b []byte) []byte {
+	b = binary.BigEndian.AppendUint64(b, m.Timestamp)
+	b = append(b, m.Token[:]...)
+	b = append(b, m.Side)
+	b = binary.BigEndian.AppendUint32(b, m.Shares)
+	b = append(b, m.Stock[:]...)
+	b = binary.BigEndian.AppendUint32(b, m.Price)
+	b = append(b, m.TimeInForce)
+	return binary.BigEndian.AppendUint64(b, m.OrderRef)
+}
+
+func (m *Accepted) decodeBody(b []byte) {
+	m.Timestamp = binary.BigEndian.Uint64(b[0:])
+	copy(m.Token[:], b[8:22])
+	m.Side = b[22]
+	m.Shares = binary.BigEndian.Uint32(b[23:])
+	copy(m.Stock[:], b[27:35])
+	m.Price = binary.BigEndian.Uint32(b[35:])
+	m.TimeInForce = b[39]
+	m.OrderRef = binary.BigEndian.Uint64(b[40:])
+}
+
+func (m *Accepted) AppendTo(b []byte) []byte {
+	return m.appendBody(append(b, MsgAccepted))
+}
+
+func (m *Accepted) Decode(b []byte) error {
+	if err := checkSize(b, MsgAccepted, AcceptedSize); err != nil {
+		return err
+	}
+	m.decodeBody(b[1:])
+	return nil
+}
+
+// Replaced reports the new state of a replaced order under its replacement
+// token.
+type Replaced struct {
+	Accepted
+	PreviousToken Token
+}
+
+func (m *Replaced) AppendTo(b []byte) []byte {
+	b = m.appendBody(append(b, MsgReplaced))
+	return append(b, m.PreviousToken[:]...)
+}
+
+func (m *Replaced) Decode(b []byte) error {
+	if err := checkSize(b, MsgReplaced, ReplacedSize); err != nil {
+		return err
+	}
+	m.decodeBody(b[1:])
+	copy(m.PreviousToken[:], b[AcceptedSize:])
+	return nil
+}
+
+type Canceled struct {
+	Timestamp         uint64
+	Token             Token
+	DecrementedShares uint32
+	Reason            byte
+}
+
+func (m *Canceled) AppendTo(b []byte) []byte {
+	b = append(b, MsgCanceled)
+	b = binary.BigEndian.AppendUint64(b, m.Timestamp)
+	b = append(b, m.Token[:]...)
+	b = binary.BigEndian.AppendUint32(b, m.DecrementedShares)
+	return append(b, m.Reason)
+}
+
+func (m *Canceled) Decode(b []byte) error {
+	if err := checkSize(b, MsgCanceled, CanceledSize); err != nil {
+		return err
+	}
+	m.Timestamp = binary.BigEndian.Uint64(b[1:])
+	copy(m.Token[:], b[9:23])
+	m.DecrementedShares = binary.BigEndian.Uint32(b[23:])
+	m.Reason = b[27]
+	return nil
+}
+
+type Executed struct {
+	Timestamp      uint64
+	Token          Token
+	ExecutedShares uint32
+	ExecutionPrice uint32
+	Liquidity      byte
+	MatchNumber    uint64
+}
+
+func (m *Executed) AppendTo(b []byte) []byte {
+	b = append(b, MsgExecuted)
+	b = binary.BigEndian.AppendUint64(b, m.Timestamp)
+	b = append(b, m.Token[:]...)
+	b = binary.BigEndian.AppendUint32(b, m.ExecutedShares)
+	b = binary.BigEndian.AppendUint32(b, m.ExecutionPrice)
+	b = append(b, m.Liquidity)
+	return binary.BigEndian.AppendUint64(b, m.MatchNumber)
+}
+
+func (m *Executed) Decode(b []byte) error {
+	if err := checkSize(b, MsgExecuted, ExecutedSize); err != nil {
+		return err
+	}
+	m.Timestamp = binary.BigEndian.Uint64(b[1:])
+	copy(m.Token[:], b[9:23])
+	m.ExecutedShares = binary.BigEndian.Uint32(b[23:])
+	m.ExecutionPrice = binary.BigEndian.Uint32(b[27:])
+	m.Liquidity = b[31]
+	m.MatchNumber = binary.BigEndian.Uint64(b[32:])
+	return nil
+}
+
+type Rejected struct {
+	Timestamp uint64
+	Token     Token
+	Reason    byte
+}
+
+func (m *Rejected) AppendTo(b []byte) []byte {
+	b = append(b, MsgRejected)
+	b = binary.BigEndian.AppendUint64(b, m.Timestamp)
+	b = append(b, m.Token[:]...)
+	return append(b, m.Reason)
+}
+
+func (m *Rejected) Decode(b []byte) error {
+	if err := checkSize(b, MsgRejected, RejectedSize); err != nil {
+		return err
+	}
+	m.Timestamp = binary.BigEndian.Uint64(b[1:])
+	copy(m.Token[:], b[9:23])
+	m.Reason = b[23]
+	return nil
+}
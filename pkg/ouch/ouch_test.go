@@ -0,0 +1,283 @@
+package ouch
+
+import (
+	"go-orderbook/pkg/engine"
+	"go-orderbook/pkg/orderbook"
+	"go-orderbook/pkg/soupbintcp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	enter := EnterOrder{
+		Token:       NewToken("T1"),
+		Side:        SideBuy,
+		Shares:      100,
+		Stock:       NewStock("ACME"),
+		Price:       12345,
+		TimeInForce: TimeInForceDay,
+	}
+	b := enter.AppendTo(nil)
+	assert.Len(t, b, EnterOrderSize)
+	var gotEnter EnterOrder
+	require.NoError(t, gotEnter.Decode(b))
+	assert.Equal(t, enter, gotEnter)
+	assert.Equal(t, "T1", gotEnter.Token.String())
+	assert.Equal(t, "ACME", gotEnter.Stock.String())
+
+	replaced := Replaced{
+		Accepted: Accepted{
+			Timestamp:   1,
+			Token:       NewToken("T2"),
+			Side:        SideSell,
+			Shares:      5,
+			Stock:       NewStock("ACME"),
+			Price:       7,
+			TimeInForce: TimeInForceGTC,
+			OrderRef:    9,
+		},
+		PreviousToken: NewToken("T1"),
+	}
+	b = replaced.AppendTo(nil)
+	assert.Len(t, b, ReplacedSize)
+	var gotReplaced Replaced
+	require.NoError(t, gotReplaced.Decode(b))
+	assert.Equal(t, replaced, gotReplaced)
+
+	executed := Executed{
+		Timestamp:      2,
+		Token:          NewToken("T2"),
+		ExecutedShares: 3,
+		ExecutionPrice: 7,
+		Liquidity:      LiquidityAdded,
+		MatchNumber:    4,
+	}
+	b = executed.AppendTo(nil)
+	var gotExecuted Executed
+	require.NoError(t, gotExecuted.Decode(b))
+	assert.Equal(t, executed, gotExecuted)
+
+	assert.ErrorIs(t, gotExecuted.Decode(b[:len(b)-1]), ErrMessageSize)
+	var accepted Accepted
+	assert.Error(t, accepted.Decode(b))
+}
+
+func TestMessageAllocations(t *testing.T) {
+	enter := EnterOrder{Token: NewToken("T1"), Side: SideBuy, Shares: 1, Stock: NewStock("ACME"), Price: 1}
+	executed := Executed{Token: NewToken("T1"), ExecutedShares: 1}
+	buf := make([]byte, 0, ReplacedSize)
+	var decoded EnterOrder
+
+	allocs := testing.AllocsPerRun(100, func() {
+		b := enter.AppendTo(buf[:0])
+		decoded.Decode(b)
+		executed.AppendTo(buf[:0])
+	})
+	assert.Zero(t, allocs)
+}
+
+type testClient struct {
+	t *testing.T
+	c *soupbintcp.Client
+}
+
+func (c *testClient) send(m interface{ AppendTo([]byte) []byte }) {
+	require.NoError(c.t, c.c.Send(m.AppendTo(nil)))
+}
+
+func (c *testClient) next(msgType byte) []byte {
+	packetType, payload, err := c.c.Next(2 * time.Second)
+	require.NoError(c.t, err)
+	require.Equal(c.t, soupbintcp.SequencedData, packetType)
+	require.NotEmpty(c.t, payload)
+	require.Equal(c.t, string(msgType), string(payload[0]))
+	return payload
+}
+
+func TestGateway(t *testing.T) {
+	e := engine.NewEngine()
+	require.NoError(t, e.List("ACME"))
+
+	g := NewGateway(e)
+	defer g.Close()
+	server := soupbintcp.NewServer("OUCH", func(username, password string) bool {
+		return true
+	}, g)
+	require.NoError(t, server.Listen("127.0.0.1:0"))
+	defer server.Close()
+
+	dial := func(username string) *testClient {
+		c, err := soupbintcp.Dial(server.Addr().String(), username, "", 1)
+		require.NoError(t, err)
+		t.Cleanup(func() { c.Close() })
+		return &testClient{t: t, c: c}
+	}
+	maker := dial("maker")
+	taker := dial("taker")
+
+	// Resting sell.
+	maker.send(&EnterOrder{
+		Token: NewToken("S1"), Side: SideSell, Shares: 100,
+		Stock: NewStock("ACME"), Price: 1000, TimeInForce: TimeInForceGTC,
+	})
+	var accepted Accepted
+	require.NoError(t, accepted.Decode(maker.next(MsgAccepted)))
+	assert.Equal(t, NewToken("S1"), accepted.Token)
+	assert.NotZero(t, accepted.OrderRef)
+
+	// Duplicate tokens are rejected per user.
+	maker.send(&EnterOrder{
+		Token: NewToken("S1"), Side: SideSell, Shares: 1,
+		Stock: NewStock("ACME"), Price: 1000, TimeInForce: TimeInForceGTC,
+	})
+	var rejected Rejected
+	require.NoError(t, rejected.Decode(maker.next(MsgRejected)))
+	assert.Equal(t, RejectDuplicateToken, rejected.Reason)
+
+	taker.send(&EnterOrder{
+		Token: NewToken("B1"), Side: SideBuy, Shares: 1,
+		Stock: NewStock("NOPE"), Price: 1000, TimeInForce: TimeInForceDay,
+	})
+	require.NoError(t, rejected.Decode(taker.next(MsgRejected)))
+	assert.Equal(t, RejectUnknownSymbol, rejected.Reason)
+
+	// A crossing buy fills against the resting sell.
+	taker.send(&EnterOrder{
+		Token: NewToken("B1"), Side: SideBuy, Shares: 50,
+		Stock: NewStock("ACME"), Price: 1000, TimeInForce: TimeInForceDay,
+	})
+	taker.next(MsgAccepted)
+	var executed Executed
+	require.NoError(t, executed.Decode(taker.next(MsgExecuted)))
+	assert.Equal(t, uint32(50), executed.ExecutedShares)
+	assert.Equal(t, LiquidityRemoved, executed.Liquidity)
+	makerMatch := executed.MatchNumber
+
+	require.NoError(t, executed.Decode(maker.next(MsgExecuted)))
+	assert.Equal(t, NewToken("S1"), executed.Token)
+	assert.Equal(t, uint32(50), executed.ExecutedShares)
+	assert.Equal(t, uint32(1000), executed.ExecutionPrice)
+	assert.Equal(t, LiquidityAdded, executed.Liquidity)
+	assert.Equal(t, makerMatch, executed.MatchNumber)
+
+	// Replace the remaining 50 with 30 at a new price.
+	maker.send(&ReplaceOrder{
+		ExistingToken: NewToken("S1"), ReplacementToken: NewToken("S2"),
+		Shares: 30, Price: 1010,
+	})
+	var replaced Replaced
+	require.NoError(t, replaced.Decode(maker.next(MsgReplaced)))
+	assert.Equal(t, NewToken("S2"), replaced.Token)
+	assert.Equal(t, NewToken("S1"), replaced.PreviousToken)
+	assert.Equal(t, uint32(30), replaced.Shares)
+	assert.Equal(t, uint32(1010), replaced.Price)
+
+	// Reduce to 10 open shares, then cancel the rest.
+	maker.send(&CancelOrder{Token: NewToken("S2"), Shares: 10})
+	var canceled Canceled
+	require.NoError(t, canceled.Decode(maker.next(MsgCanceled)))
+	assert.Equal(t, uint32(20), canceled.DecrementedShares)
+	assert.Equal(t, CancelUser, canceled.Reason)
+
+	maker.send(&CancelOrder{Token: NewToken("S2")})
+	require.NoError(t, canceled.Decode(maker.next(MsgCanceled)))
+	assert.Equal(t, uint32(10), canceled.DecrementedShares)
+	assert.Equal(t, 0, e.Size())
+
+	// An IOC remainder is canceled by the exchange.
+	maker.send(&EnterOrder{
+		Token: NewToken("S3"), Side: SideSell, Shares: 5,
+		Stock: NewStock("ACME"), Price: 1000, TimeInForce: TimeInForceGTC,
+	})
+	maker.next(MsgAccepted)
+	taker.send(&EnterOrder{
+		Token: NewToken("B2"), Side: SideBuy, Shares: 8,
+		Stock: NewStock("ACME"), Price: 1000, TimeInForce: TimeInForceIOC,
+	})
+	taker.next(MsgAccepted)
+	taker.next(MsgExecuted)
+	require.NoError(t, canceled.Decode(taker.next(MsgCanceled)))
+	assert.Equal(t, uint32(3), canceled.DecrementedShares)
+	assert.Equal(t, CancelImmediate, canceled.Reason)
+	maker.next(MsgExecuted)
+
+	// A buy limited above the resting sell executes at the resting price.
+	maker.send(&EnterOrder{
+		Token: NewToken("S4"), Side: SideSell, Shares: 5,
+		Stock: NewStock("ACME"), Price: 1000, TimeInForce: TimeInForceGTC,
+	})
+	maker.next(MsgAccepted)
+	taker.send(&EnterOrder{
+		Token: NewToken("B3"), Side: SideBuy, Shares: 5,
+		Stock: NewStock("ACME"), Price: 1050, TimeInForce: TimeInForceDay,
+	})
+	taker.next(MsgAccepted)
+	require.NoError(t, executed.Decode(taker.next(MsgExecuted)))
+	assert.Equal(t, uint32(1000), executed.ExecutionPrice)
+	require.NoError(t, executed.Decode(maker.next(MsgExecuted)))
+	assert.Equal(t, uint32(1000), executed.ExecutionPrice)
+
+	// An execution by an order entered on the engine directly is reported
+	// without waiting for the next message, and the events of that order
+	// are not queued.
+	maker.send(&EnterOrder{
+		Token: NewToken("S5"), Side: SideSell, Shares: 5,
+		Stock: NewStock("ACME"), Price: 1000, TimeInForce: TimeInForceGTC,
+	})
+	maker.next(MsgAccepted)
+	_, err := e.AddOrder("ACME", orderbook.NewOrder(orderbook.GoodTillCancel, 1<<40, orderbook.Buy, 1000, 2))
+	require.NoError(t, err)
+	require.NoError(t, executed.Decode(maker.next(MsgExecuted)))
+	assert.Equal(t, uint32(2), executed.ExecutedShares)
+	assert.Equal(t, LiquidityAdded, executed.Liquidity)
+	g.pendingM.Lock()
+	assert.Empty(t, g.pending)
+	g.pendingM.Unlock()
+}
+
+func TestGatewayAllocations(t *testing.T) {
+	e := engine.NewEngine()
+	require.NoError(t, e.List("ACME"))
+	g := NewGateway(e)
+	defer g.Close()
+	server := soupbintcp.NewServer("OUCH", func(username, password string) bool {
+		return true
+	}, g)
+	require.NoError(t, server.Listen("127.0.0.1:0"))
+	defer server.Close()
+	c, err := soupbintcp.Dial(server.Addr().String(), "alice", "", 1)
+	require.NoError(t, err)
+	defer c.Close()
+	s, ok := server.Session("alice")
+	require.True(t, ok)
+
+	sell := (&EnterOrder{
+		Token: NewToken("S"), Side: SideSell, Shares: 1,
+		Stock: NewStock("ACME"), Price: 1000, TimeInForce: TimeInForceGTC,
+	}).AppendTo(nil)
+	buy := (&EnterOrder{
+		Token: NewToken("B"), Side: SideBuy, Shares: 1,
+		Stock: NewStock("ACME"), Price: 1000, TimeInForce: TimeInForceGTC,
+	}).AppendTo(nil)
+	cancel := (&CancelOrder{Token: NewToken("S")}).AppendTo(nil)
+	gateway := testing.AllocsPerRun(100, func() {
+		g.OnMessage(s, sell)
+		g.OnMessage(s, cancel)
+		g.OnMessage(s, sell)
+		g.OnMessage(s, buy)
+	})
+	assert.Zero(t, e.Size())
+
+	// The same orders entered on the engine directly.
+	id := orderbook.OrderId(1 << 40)
+	direct := testing.AllocsPerRun(100, func() {
+		e.AddOrder("ACME", orderbook.NewOrder(orderbook.GoodTillCancel, id, orderbook.Sell, 1000, 1))
+		e.CancelOrder(id)
+		e.AddOrder("ACME", orderbook.NewOrder(orderbook.GoodTillCancel, id, orderbook.Sell, 1000, 1))
+		e.AddOrder("ACME", orderbook.NewOrder(orderbook.GoodTillCancel, id+1, orderbook.Buy, 1000, 1))
+	})
+	assert.LessOrEqual(t, gateway, direct)
+}
@@ -0,0 +1,456 @@
+// Package position keeps the positions and P&L of accounts from the trades
+// of an engine.
+//
+// Positions use average cost: buys and sells that add to a position add to
+// its cost, and those that reduce it realize the difference between the
+// trade price and the average entry price. A bust or price correction of a
+// trade rebuilds the positions of its accounts from their fills; fills older
+// than engine.TradeRetention can no longer be amended and are only kept
+// summed up. Prices and P&L are in price ticks, times shares for P&L.
+package position
+
+import (
+	"encoding/csv"
+	"go-orderbook/pkg/engine"
+	"go-orderbook/pkg/orderbook"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Mark selects the price open positions are valued at.
+type Mark int
+
+const (
+	// MarkLastTrade values positions at the last trade of their symbol.
+	MarkLastTrade Mark = iota
+	// MarkMidpoint values positions at the middle of the best bid and ask,
+	// or at the last trade while a side of the book is empty.
+	MarkMidpoint
+)
+
+// Position is the holding of an account in one symbol.
+type Position struct {
+	Account string
+	Symbol  engine.Symbol
+	// Quantity is the shares held, negative when short.
+	Quantity int64
+	// Cost is what the shares held were bought for, or sold for when
+	// short, negative.
+	Cost     int64
+	Realized int64
+	Bought   uint64
+	Sold     uint64
+	// Mark is the price Unrealized is valued at. It is zero when the symbol
+	// has not traded yet, and so is Unrealized.
+	Mark       orderbook.Price
+	Unrealized int64
+}
+
+// AveragePrice is the average entry price of the shares held.
+func (p Position) AveragePrice() float64 {
+	if p.Quantity == 0 {
+		return 0
+	}
+	return float64(p.Cost) / float64(p.Quantity)
+}
+
+// fill applies a trade of quantity shares at price, positive when bought.
+func (p *Position) fill(quantity int64, price orderbook.Price) {
+	if quantity > 0 {
+		p.Bought += uint64(quantity)
+	} else {
+		p.Sold += uint64(-quantity)
+	}
+
+	if p.Quantity != 0 && (p.Quantity > 0) != (quantity > 0) {
+		closed := min(abs(quantity), abs(p.Quantity))
+		// The cost of a partial close is rounded towards zero; closing
+		// the whole position always leaves no cost behind.
+		cost := p.Cost * closed / abs(p.Quantity)
+		proceeds := closed * int64(price)
+		if p.Quantity < 0 {
+			proceeds = -proceeds
+		}
+		p.Realized += proceeds - cost
+		p.Cost -= cost
+		if p.Quantity > 0 {
+			p.Quantity -= closed
+			quantity += closed
+		} else {
+			p.Quantity += closed
+			quantity -= closed
+		}
+	}
+	p.Quantity += quantity
+	p.Cost += quantity * int64(price)
+}
+
+// value marks the position at mark, unless the symbol has not traded yet.
+func (p *Position) value(mark orderbook.Price) {
+	if mark != 0 {
+		p.Mark = mark
+		p.Unrealized = p.Quantity*int64(mark) - p.Cost
+	}
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+type key struct {
+	account string
+	symbol  engine.Symbol
+}
+
+type symbolState struct {
+	// execution is the first OrderExecuted event of the current match.
+	execution orderbook.Event
+	// trades holds the trades of the symbol that can still be amended in
+	// match order, and lastPrice the price of the last one not busted.
+	// settledPrice is the price of the last older trade not busted.
+	trades       []trade
+	lastPrice    orderbook.Price
+	settledPrice orderbook.Price
+}
+
+type trade struct {
+	matchId uint64
+	price   orderbook.Price
+	busted  bool
+	time    time.Time
+}
+
+// expire forgets the trades that matched engine.TradeRetention or longer
+// before now.
+func (s *symbolState) expire(now time.Time) {
+	n := 0
+	for _, t := range s.trades {
+		if now.Sub(t.time) < engine.TradeRetention {
+			break
+		}
+		if !t.busted {
+			s.settledPrice = t.price
+		}
+		n++
+	}
+	s.trades = s.trades[n:]
+}
+
+// amend applies a bust or price correction to a trade of the symbol.
+func (s *symbolState) amend(ev orderbook.Event) {
+	i := sort.Search(len(s.trades), func(i int) bool {
+		return s.trades[i].matchId >= ev.MatchId
+	})
+	if i == len(s.trades) || s.trades[i].matchId != ev.MatchId {
+		return
+	}
+	if ev.Type == orderbook.TradeBusted {
+		s.trades[i].busted = true
+	} else {
+		s.trades[i].price = ev.TradePrice
+	}
+
+	s.lastPrice = s.settledPrice
+	for i := len(s.trades) - 1; i >= 0; i-- {
+		if !s.trades[i].busted {
+			s.lastPrice = s.trades[i].price
+			break
+		}
+	}
+}
+
+type fillKey struct {
+	symbol  engine.Symbol
+	matchId uint64
+	orderId orderbook.OrderId
+}
+
+// fill is a trade of a position, positive when bought.
+type fill struct {
+	key      fillKey
+	position key
+	quantity int64
+	price    orderbook.Price
+	busted   bool
+	time     time.Time
+}
+
+// Ledger follows the trades of an engine and books them to the accounts
+// orders were assigned to. Trades of orders without an account are not
+// booked.
+type Ledger struct {
+	engine *engine.Engine
+	mark   Mark
+	now    func() time.Time
+
+	// m guards the fields below, kept up to date by the engine listener.
+	m         sync.Mutex
+	accounts  *engine.OrderAccounts
+	positions map[key]*Position
+	symbols   map[engine.Symbol]*symbolState
+	fills     map[fillKey]*fill
+	// history holds the fills of every position that can still be amended,
+	// in trade order, and settled the position of the older ones.
+	history map[key][]*fill
+	settled map[key]Position
+}
+
+// ledgerListener follows the engine for a Ledger.
+type ledgerListener struct {
+	l *Ledger
+}
+
+func (ll ledgerListener) OnEvent(symbol engine.Symbol, ev orderbook.Event) {
+	ll.l.onEvent(symbol, ev)
+}
+
+func (ll ledgerListener) OnCommandEnd(symbol engine.Symbol) {
+	ll.l.m.Lock()
+	defer ll.l.m.Unlock()
+	ll.l.accounts.OnCommandEnd(symbol)
+}
+
+// NewLedger creates a ledger for an engine. Trades matched before it was
+// created are not booked.
+func NewLedger(e *engine.Engine, mark Mark) *Ledger {
+	l := &Ledger{
+		engine:    e,
+		mark:      mark,
+		now:       time.Now,
+		accounts:  engine.NewOrderAccounts(),
+		positions: make(map[key]*Position),
+		symbols:   make(map[engine.Symbol]*symbolState),
+		fills:     make(map[fillKey]*fill),
+		history:   make(map[key][]*fill),
+		settled:   make(map[key]Position),
+	}
+	e.AddListener(ledgerListener{l: l})
+	return l
+}
+
+// Assign books the trades of an order to account. It should be called before
+// the order enters the book; the account is forgotten once the order left
+// it, as the id may then be reused.
+func (l *Ledger) Assign(orderId orderbook.OrderId, account string) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.accounts.Assign(orderId, account)
+}
+
+// Unassign forgets the account given to an order whose add was rejected.
+func (l *Ledger) Unassign(orderId orderbook.OrderId) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.accounts.Unassign(orderId)
+}
+
+func (l *Ledger) symbol(symbol engine.Symbol) *symbolState {
+	s, ok := l.symbols[symbol]
+	if !ok {
+		s = &symbolState{}
+		l.symbols[symbol] = s
+	}
+	return s
+}
+
+func (l *Ledger) onEvent(symbol engine.Symbol, ev orderbook.Event) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	l.accounts.OnEvent(symbol, ev)
+	s := l.symbol(symbol)
+	switch ev.Type {
+	case orderbook.OrderExecuted:
+		if s.execution.MatchId != ev.MatchId {
+			s.execution = ev
+			return
+		}
+		price := ev.TradePrice
+		now := l.now()
+		s.expire(now)
+		s.trades = append(s.trades, trade{matchId: ev.MatchId, price: price, time: now})
+		s.lastPrice = price
+		l.book(symbol, s.execution, price, now)
+		l.book(symbol, ev, price, now)
+	case orderbook.TradeBusted, orderbook.TradeCorrected:
+		s.amend(ev)
+		f, ok := l.fills[fillKey{symbol, ev.MatchId, ev.OrderId}]
+		if !ok {
+			return
+		}
+		if ev.Type == orderbook.TradeBusted {
+			f.busted = true
+		} else {
+			f.price = ev.TradePrice
+		}
+		l.rebuild(f.position)
+	}
+}
+
+func (l *Ledger) book(symbol engine.Symbol, ev orderbook.Event, price orderbook.Price, now time.Time) {
+	account, ok := l.accounts.Account(ev.OrderId)
+	if !ok {
+		return
+	}
+	k := key{account, symbol}
+	p, ok := l.positions[k]
+	if !ok {
+		p = &Position{Account: account, Symbol: symbol}
+		l.positions[k] = p
+	}
+	f := &fill{
+		key:      fillKey{symbol, ev.MatchId, ev.OrderId},
+		position: k,
+		quantity: int64(ev.Quantity),
+		price:    price,
+		time:     now,
+	}
+	if ev.Side == orderbook.Sell {
+		f.quantity = -f.quantity
+	}
+	l.expire(k, now)
+	l.fills[f.key] = f
+	l.history[k] = append(l.history[k], f)
+	p.fill(f.quantity, f.price)
+}
+
+// expire sums the fills of a position that matched engine.TradeRetention or
+// longer before now into its settled position.
+func (l *Ledger) expire(k key, now time.Time) {
+	history := l.history[k]
+	n := 0
+	for _, f := range history {
+		if now.Sub(f.time) < engine.TradeRetention {
+			break
+		}
+		if !f.busted {
+			settled := l.settled[k]
+			settled.fill(f.quantity, f.price)
+			l.settled[k] = settled
+		}
+		delete(l.fills, f.key)
+		n++
+	}
+	l.history[k] = history[n:]
+}
+
+// rebuild replays the fills of a position that are not busted onto its
+// settled position.
+func (l *Ledger) rebuild(k key) {
+	p := new(Position)
+	*p = l.settled[k]
+	p.Account, p.Symbol = k.account, k.symbol
+	for _, f := range l.history[k] {
+		if !f.busted {
+			p.fill(f.quantity, f.price)
+		}
+	}
+	l.positions[k] = p
+}
+
+// markOf returns the mark of a symbol that last traded at last.
+func (l *Ledger) markOf(symbol engine.Symbol, last orderbook.Price) orderbook.Price {
+	if l.mark == MarkMidpoint {
+		if book, ok := l.engine.Book(symbol); ok {
+			if bid, ask := book.Top(); bid.Quantity > 0 && ask.Quantity > 0 {
+				return (bid.Price + ask.Price) / 2
+			}
+		}
+	}
+	return last
+}
+
+// marks returns the mark of every symbol.
+func (l *Ledger) marks() map[engine.Symbol]orderbook.Price {
+	l.m.Lock()
+	marks := make(map[engine.Symbol]orderbook.Price, len(l.symbols))
+	for symbol, s := range l.symbols {
+		marks[symbol] = s.lastPrice
+	}
+	l.m.Unlock()
+
+	for symbol, last := range marks {
+		marks[symbol] = l.markOf(symbol, last)
+	}
+	return marks
+}
+
+// Positions returns the positions of an account, or of every account when
+// account is empty, sorted by account and symbol.
+func (l *Ledger) Positions(account string) []Position {
+	marks := l.marks()
+
+	l.m.Lock()
+	positions := make([]Position, 0, len(l.positions))
+	for k, p := range l.positions {
+		if account != "" && k.account != account {
+			continue
+		}
+		position := *p
+		position.value(marks[k.symbol])
+		positions = append(positions, position)
+	}
+	l.m.Unlock()
+
+	sort.Slice(positions, func(i, j int) bool {
+		if positions[i].Account != positions[j].Account {
+			return positions[i].Account < positions[j].Account
+		}
+		return positions[i].Symbol < positions[j].Symbol
+	})
+	return positions
+}
+
+// Position returns the position of an account in a symbol.
+func (l *Ledger) Position(account string, symbol engine.Symbol) (Position, bool) {
+	l.m.Lock()
+	p, ok := l.positions[key{account, symbol}]
+	var (
+		position Position
+		last     orderbook.Price
+	)
+	if ok {
+		position = *p
+	}
+	if s, exists := l.symbols[symbol]; exists {
+		last = s.lastPrice
+	}
+	l.m.Unlock()
+
+	if !ok {
+		return Position{}, false
+	}
+	position.value(l.markOf(symbol, last))
+	return position, true
+}
+
+// WriteStatement writes the positions of every account as CSV, with a
+// header line.
+func (l *Ledger) WriteStatement(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{
+		"account", "symbol", "quantity", "average_price", "bought", "sold",
+		"mark", "realized", "unrealized",
+	})
+	for _, p := range l.Positions("") {
+		cw.Write([]string{
+			p.Account,
+			string(p.Symbol),
+			strconv.FormatInt(p.Quantity, 10),
+			strconv.FormatFloat(p.AveragePrice(), 'f', -1, 64),
+			strconv.FormatUint(p.Bought, 10),
+			strconv.FormatUint(p.Sold, 10),
+			strconv.FormatInt(int64(p.Mark), 10),
+			strconv.FormatInt(p.Realized, 10),
+			strconv.FormatInt(p.Unrealized, 10),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
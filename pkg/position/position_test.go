@@ -0,0 +1,237 @@
+package position
+
+import (
+	"bytes"
+	"go-orderbook/pkg/engine"
+	"go-orderbook/pkg/orderbook"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFill(t *testing.T) {
+	var p Position
+	p.fill(1, 10)
+	p.fill(2, 11)
+	assert.Equal(t, int64(3), p.Quantity)
+	assert.Equal(t, int64(32), p.Cost)
+	assert.InDelta(t, 10.667, p.AveragePrice(), 0.001)
+
+	// Partial closes realize against the average price; the rounding of
+	// the first one is made up by the last.
+	p.fill(-1, 12)
+	assert.Equal(t, int64(2), p.Realized)
+	assert.Equal(t, int64(22), p.Cost)
+	p.fill(-2, 12)
+	assert.Equal(t, int64(4), p.Realized)
+	assert.Zero(t, p.Quantity)
+	assert.Zero(t, p.Cost)
+
+	// A fill through zero flips the position at the fill price.
+	p.fill(-2, 20)
+	p.fill(5, 18)
+	assert.Equal(t, int64(8), p.Realized)
+	assert.Equal(t, int64(3), p.Quantity)
+	assert.Equal(t, int64(54), p.Cost)
+	assert.Equal(t, uint64(8), p.Bought)
+	assert.Equal(t, uint64(5), p.Sold)
+}
+
+func newLedger(t *testing.T, mark Mark) (*engine.Engine, *Ledger) {
+	t.Helper()
+	e := engine.NewEngine()
+	require.NoError(t, e.List("DEMO"))
+	return e, NewLedger(e, mark)
+}
+
+func add(t *testing.T, e *engine.Engine, l *Ledger, account string, orderId orderbook.OrderId, side orderbook.Side, price orderbook.Price, quantity orderbook.Quantity) {
+	t.Helper()
+	if account != "" {
+		l.Assign(orderId, account)
+	}
+	_, err := e.AddOrder("DEMO", orderbook.NewOrder(orderbook.GoodTillCancel, orderId, side, price, quantity))
+	require.NoError(t, err)
+}
+
+func TestLedger(t *testing.T) {
+	e, l := newLedger(t, MarkLastTrade)
+
+	// Trades are priced at the resting order.
+	add(t, e, l, "alice", 1, orderbook.Buy, 100, 10)
+	add(t, e, l, "bob", 2, orderbook.Sell, 99, 4)
+	alice, ok := l.Position("alice", "DEMO")
+	require.True(t, ok)
+	assert.Equal(t, Position{
+		Account: "alice", Symbol: "DEMO", Quantity: 4, Cost: 400, Bought: 4, Mark: 100,
+	}, alice)
+	bob, _ := l.Position("bob", "DEMO")
+	assert.Equal(t, int64(-4), bob.Quantity)
+	assert.Equal(t, int64(-400), bob.Cost)
+
+	// Alice sells through her position and bob buys through his.
+	add(t, e, l, "bob", 3, orderbook.Buy, 110, 6)
+	add(t, e, l, "alice", 4, orderbook.Sell, 105, 10)
+	alice, _ = l.Position("alice", "DEMO")
+	assert.Equal(t, int64(-2), alice.Quantity)
+	assert.Equal(t, int64(-220), alice.Cost)
+	assert.Equal(t, int64(40), alice.Realized)
+	assert.Equal(t, orderbook.Price(110), alice.Mark)
+	assert.Zero(t, alice.Unrealized)
+	bob, _ = l.Position("bob", "DEMO")
+	assert.Equal(t, int64(2), bob.Quantity)
+	assert.Equal(t, int64(-40), bob.Realized)
+
+	// Orders without an account are not booked.
+	add(t, e, l, "", 5, orderbook.Sell, 100, 1)
+	alice, _ = l.Position("alice", "DEMO")
+	assert.Equal(t, int64(-1), alice.Quantity)
+	assert.Equal(t, int64(50), alice.Realized)
+	assert.Equal(t, int64(10), alice.Unrealized)
+	assert.Len(t, l.Positions(""), 2)
+
+	_, ok = l.Position("carol", "DEMO")
+	assert.False(t, ok)
+}
+
+func TestModify(t *testing.T) {
+	e, l := newLedger(t, MarkLastTrade)
+	add(t, e, l, "alice", 1, orderbook.Buy, 100, 10)
+
+	// A modify that moves the order keeps its account.
+	var modify orderbook.OrderModify
+	_, err := e.ModifyOrder(modify.New(1, 101, orderbook.Buy, 10))
+	require.NoError(t, err)
+	add(t, e, l, "bob", 2, orderbook.Sell, 101, 10)
+
+	alice, _ := l.Position("alice", "DEMO")
+	assert.Equal(t, int64(10), alice.Quantity)
+	assert.Equal(t, int64(1010), alice.Cost)
+}
+
+func TestMidpoint(t *testing.T) {
+	e, l := newLedger(t, MarkMidpoint)
+	add(t, e, l, "alice", 1, orderbook.Buy, 100, 10)
+	add(t, e, l, "bob", 2, orderbook.Sell, 100, 4)
+
+	// With one side empty the mark is the last trade.
+	alice, _ := l.Position("alice", "DEMO")
+	assert.Equal(t, orderbook.Price(100), alice.Mark)
+
+	add(t, e, l, "", 3, orderbook.Sell, 105, 1)
+	alice, _ = l.Position("alice", "DEMO")
+	assert.Equal(t, orderbook.Price(102), alice.Mark)
+	assert.Equal(t, int64(8), alice.Unrealized)
+	bob, _ := l.Position("bob", "DEMO")
+	assert.Equal(t, int64(-8), bob.Unrealized)
+}
+
+func TestStatement(t *testing.T) {
+	e, l := newLedger(t, MarkLastTrade)
+	add(t, e, l, "bob", 1, orderbook.Sell, 100, 3)
+	add(t, e, l, "alice", 2, orderbook.Buy, 100, 2)
+	add(t, e, l, "alice", 3, orderbook.Buy, 101, 1)
+	add(t, e, l, "", 4, orderbook.Sell, 102, 1)
+	add(t, e, l, "bob", 5, orderbook.Buy, 102, 1)
+
+	var buf bytes.Buffer
+	require.NoError(t, l.WriteStatement(&buf))
+	assert.Equal(t, `account,symbol,quantity,average_price,bought,sold,mark,realized,unrealized
+alice,DEMO,3,100,3,0,102,0,6
+bob,DEMO,-2,100,1,3,102,-2,-4
+`, buf.String())
+}
+
+func TestCorrections(t *testing.T) {
+	e, l := newLedger(t, MarkLastTrade)
+	add(t, e, l, "alice", 1, orderbook.Buy, 100, 10)
+	add(t, e, l, "bob", 2, orderbook.Sell, 99, 4)
+	add(t, e, l, "bob", 3, orderbook.Sell, 100, 6)
+	add(t, e, l, "alice", 4, orderbook.Sell, 105, 5)
+	add(t, e, l, "bob", 5, orderbook.Buy, 105, 5)
+
+	_, err := e.CorrectTrade("DEMO", 1, 95, "ops", "")
+	require.NoError(t, err)
+	alice, _ := l.Position("alice", "DEMO")
+	assert.Equal(t, int64(5), alice.Quantity)
+	assert.Equal(t, int64(490), alice.Cost)
+	assert.Equal(t, int64(35), alice.Realized)
+
+	// Busting a trade rebuilds the positions without it.
+	_, err = e.BustTrade("DEMO", 2, "ops", "")
+	require.NoError(t, err)
+	alice, _ = l.Position("alice", "DEMO")
+	assert.Equal(t, int64(-1), alice.Quantity)
+	assert.Equal(t, int64(-105), alice.Cost)
+	assert.Equal(t, int64(40), alice.Realized)
+	assert.Equal(t, uint64(4), alice.Bought)
+	bob, _ := l.Position("bob", "DEMO")
+	assert.Equal(t, int64(1), bob.Quantity)
+	assert.Equal(t, int64(-40), bob.Realized)
+
+	// The mark follows the last trade that was not busted.
+	assert.Equal(t, orderbook.Price(105), bob.Mark)
+	_, err = e.CorrectTrade("DEMO", 3, 104, "ops", "")
+	require.NoError(t, err)
+	bob, _ = l.Position("bob", "DEMO")
+	assert.Equal(t, orderbook.Price(104), bob.Mark)
+	_, err = e.BustTrade("DEMO", 3, "ops", "")
+	require.NoError(t, err)
+	bob, _ = l.Position("bob", "DEMO")
+	assert.Equal(t, orderbook.Price(95), bob.Mark)
+	assert.Equal(t, int64(-4), bob.Quantity)
+	assert.Zero(t, bob.Unrealized)
+}
+
+func TestReusedOrderId(t *testing.T) {
+	e, l := newLedger(t, MarkLastTrade)
+	add(t, e, l, "alice", 1, orderbook.Buy, 100, 5)
+	add(t, e, l, "bob", 2, orderbook.Sell, 100, 5)
+
+	// The id of the filled order is taken by an order of carol.
+	add(t, e, l, "carol", 1, orderbook.Buy, 99, 5)
+	_, err := e.BustTrade("DEMO", 1, "ops", "")
+	require.NoError(t, err)
+	alice, _ := l.Position("alice", "DEMO")
+	assert.Zero(t, alice.Quantity)
+	assert.Equal(t, uint64(0), alice.Bought)
+	_, ok := l.Position("carol", "DEMO")
+	assert.False(t, ok)
+
+	// Once an order left the book its id is no longer booked to its
+	// account, even when reused by an order without one.
+	add(t, e, l, "bob", 3, orderbook.Sell, 99, 5)
+	add(t, e, l, "", 3, orderbook.Buy, 101, 2)
+	add(t, e, l, "dave", 4, orderbook.Sell, 101, 2)
+	bob, _ := l.Position("bob", "DEMO")
+	assert.Equal(t, int64(-5), bob.Quantity)
+	assert.Equal(t, uint64(5), bob.Sold)
+	_, ok = l.Position("carol", "DEMO")
+	assert.True(t, ok)
+	_, ok = l.accounts.Account(1)
+	assert.False(t, ok)
+}
+
+func TestRetention(t *testing.T) {
+	e, l := newLedger(t, MarkLastTrade)
+	now := time.Unix(0, 0)
+	l.now = func() time.Time { return now }
+	add(t, e, l, "alice", 1, orderbook.Buy, 100, 5)
+	add(t, e, l, "bob", 2, orderbook.Sell, 100, 5)
+	now = now.Add(engine.TradeRetention)
+	add(t, e, l, "alice", 3, orderbook.Sell, 110, 2)
+	add(t, e, l, "bob", 4, orderbook.Buy, 110, 2)
+
+	// The first trade is only kept summed up in the positions, which are
+	// still rebuilt from it.
+	assert.Len(t, l.fills, 2)
+	assert.Len(t, l.symbols["DEMO"].trades, 1)
+	_, err := e.BustTrade("DEMO", 2, "ops", "")
+	require.NoError(t, err)
+	alice, _ := l.Position("alice", "DEMO")
+	assert.Equal(t, int64(5), alice.Quantity)
+	assert.Equal(t, int64(500), alice.Cost)
+	assert.Zero(t, alice.Realized)
+	assert.Equal(t, orderbook.Price(100), alice.Mark)
+}
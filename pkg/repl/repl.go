@@ -0,0 +1,460 @@
+// Package repl implements a line oriented shell that drives a single
+// orderbook by hand, printing the trades and the depth ladder after every
+// command that changes the book.
+package repl
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"go-orderbook/pkg/orderbook"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+var errQuit = errors.New("quit")
+
+const help = `commands:
+  add <gtc|gfd|fak|fok> <buy|sell> <price> <quantity> [id]
+  market <buy|sell> <quantity> [id]
+  cancel <id>
+  modify <id> <buy|sell> <price> <quantity>
+  book                     print the depth ladder
+  trades [n]               print the last n trades, all by default
+  info [id]                print book statistics, or a resting order
+  undo                     revert the last add, market, cancel, modify or restore
+  snapshot save <file>     write the book to a file
+  snapshot load <file>     replace the book with a file
+  load <file>              run the commands of a script
+  help
+  quit
+`
+
+type undoEntry struct {
+	snapshot []byte
+	trades   int
+	command  string
+}
+
+// Shell drives an orderbook from text commands. Ids are assigned from a
+// counter when a command omits them.
+type Shell struct {
+	out         io.Writer
+	book        orderbook.Orderbook
+	trades      orderbook.Trades
+	undo        []undoEntry
+	nextOrderId orderbook.OrderId
+	sequence    uint64
+}
+
+func NewShell(out io.Writer) *Shell {
+	s := &Shell{
+		out:         out,
+		book:        orderbook.NewOrderbook(),
+		nextOrderId: 1,
+	}
+	s.book.AddListener(orderbook.ListenerFunc(func(e orderbook.Event) {
+		s.sequence = e.Sequence
+	}))
+	return s
+}
+
+// Run executes the commands read from in until it is exhausted or a quit
+// command. Errors are printed and do not stop the shell. A non-empty prompt
+// is printed before every line.
+func (s *Shell) Run(in io.Reader, prompt string) error {
+	scanner := bufio.NewScanner(in)
+	for {
+		if prompt != "" {
+			fmt.Fprint(s.out, prompt)
+		}
+		if !scanner.Scan() {
+			return scanner.Err()
+		}
+		if err := s.Execute(scanner.Text()); err != nil {
+			if err == errQuit {
+				return nil
+			}
+			fmt.Fprintf(s.out, "error: %v\n", err)
+		}
+	}
+}
+
+// Load executes the commands of a script, stopping at the first error.
+// Blank lines and lines starting with # are skipped.
+func (s *Shell) Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if err := s.Execute(scanner.Text()); err != nil {
+			if err == errQuit {
+				return nil
+			}
+			return fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// Execute runs a single command.
+func (s *Shell) Execute(line string) error {
+	args := strings.Fields(line)
+	if len(args) == 0 || strings.HasPrefix(args[0], "#") {
+		return nil
+	}
+
+	switch args[0] {
+	case "add", "market", "cancel", "modify":
+		return s.mutate(line, func() (orderbook.Trades, error) {
+			switch args[0] {
+			case "add":
+				return s.add(args[1:])
+			case "market":
+				return s.market(args[1:])
+			case "cancel":
+				return nil, s.cancel(args[1:])
+			}
+			return s.modify(args[1:])
+		})
+	case "book":
+		s.printBook()
+	case "trades":
+		n := len(s.trades)
+		if len(args) > 1 {
+			var err error
+			if n, err = strconv.Atoi(args[1]); err != nil || n < 0 {
+				return fmt.Errorf("invalid count %q", args[1])
+			}
+		}
+		s.printTrades(s.trades[max(len(s.trades)-n, 0):])
+	case "info":
+		return s.info(args[1:])
+	case "undo":
+		return s.undoLast()
+	case "snapshot":
+		return s.snapshot(line, args[1:])
+	case "load":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: load <file>")
+		}
+		return s.Load(args[1])
+	case "help":
+		fmt.Fprint(s.out, help)
+	case "quit", "exit":
+		return errQuit
+	default:
+		return fmt.Errorf("unknown command %q, try help", args[0])
+	}
+	return nil
+}
+
+// mutate runs a command that changes the book, recording the state before it
+// for undo, and prints its trades and the resulting book.
+func (s *Shell) mutate(line string, run func() (orderbook.Trades, error)) error {
+	snapshot, err := s.book.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	sequence := s.sequence
+	trades, err := run()
+	if err != nil {
+		// A failed modify may still have canceled the order.
+		if s.sequence == sequence {
+			return err
+		}
+	}
+	s.undo = append(s.undo, undoEntry{
+		snapshot: snapshot,
+		trades:   len(s.trades),
+		command:  strings.TrimSpace(line),
+	})
+	if err != nil {
+		return err
+	}
+	s.trades = append(s.trades, trades...)
+	s.printTrades(trades)
+	s.printBook()
+	return nil
+}
+
+func parseSide(s string) (orderbook.Side, error) {
+	switch strings.ToLower(s) {
+	case "buy", "b":
+		return orderbook.Buy, nil
+	case "sell", "s":
+		return orderbook.Sell, nil
+	}
+	return 0, fmt.Errorf("invalid side %q", s)
+}
+
+func parseType(s string) (orderbook.OrderType, error) {
+	switch strings.ToLower(s) {
+	case "gtc":
+		return orderbook.GoodTillCancel, nil
+	case "gfd":
+		return orderbook.GoodForDay, nil
+	case "fak", "ioc":
+		return orderbook.FillAndKill, nil
+	case "fok":
+		return orderbook.FillOrKill, nil
+	}
+	return 0, fmt.Errorf("invalid order type %q", s)
+}
+
+func parseOrderId(s string) (orderbook.OrderId, error) {
+	id, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid order id %q", s)
+	}
+	return orderbook.OrderId(id), nil
+}
+
+func parsePrice(s string) (orderbook.Price, error) {
+	price, err := strconv.ParseInt(s, 10, 32)
+	if err != nil || price <= 0 {
+		return 0, fmt.Errorf("invalid price %q", s)
+	}
+	return orderbook.Price(price), nil
+}
+
+func parseQuantity(s string) (orderbook.Quantity, error) {
+	quantity, err := strconv.ParseUint(s, 10, 32)
+	if err != nil || quantity == 0 {
+		return 0, fmt.Errorf("invalid quantity %q", s)
+	}
+	return orderbook.Quantity(quantity), nil
+}
+
+// orderId returns the id given as an optional argument, or the next unused
+// id of the counter.
+func (s *Shell) orderId(args []string) (orderbook.OrderId, error) {
+	if len(args) > 0 {
+		return parseOrderId(args[0])
+	}
+	for {
+		id := s.nextOrderId
+		s.nextOrderId++
+		if _, exists := s.book.Order(id); !exists {
+			return id, nil
+		}
+	}
+}
+
+func (s *Shell) add(args []string) (orderbook.Trades, error) {
+	if len(args) != 4 && len(args) != 5 {
+		return nil, fmt.Errorf("usage: add <gtc|gfd|fak|fok> <buy|sell> <price> <quantity> [id]")
+	}
+	orderType, err := parseType(args[0])
+	if err != nil {
+		return nil, err
+	}
+	side, err := parseSide(args[1])
+	if err != nil {
+		return nil, err
+	}
+	price, err := parsePrice(args[2])
+	if err != nil {
+		return nil, err
+	}
+	quantity, err := parseQuantity(args[3])
+	if err != nil {
+		return nil, err
+	}
+	orderId, err := s.orderId(args[4:])
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(s.out, "order %d\n", orderId)
+	return s.book.AddOrder(orderbook.NewOrder(orderType, orderId, side, price, quantity))
+}
+
+func (s *Shell) market(args []string) (orderbook.Trades, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, fmt.Errorf("usage: market <buy|sell> <quantity> [id]")
+	}
+	side, err := parseSide(args[0])
+	if err != nil {
+		return nil, err
+	}
+	quantity, err := parseQuantity(args[1])
+	if err != nil {
+		return nil, err
+	}
+	orderId, err := s.orderId(args[2:])
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(s.out, "order %d\n", orderId)
+	return s.book.AddOrder(orderbook.NewMarketOrder(orderId, side, quantity))
+}
+
+func (s *Shell) cancel(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: cancel <id>")
+	}
+	orderId, err := parseOrderId(args[0])
+	if err != nil {
+		return err
+	}
+	return s.book.CancelOrder(orderId)
+}
+
+func (s *Shell) modify(args []string) (orderbook.Trades, error) {
+	if len(args) != 4 {
+		return nil, fmt.Errorf("usage: modify <id> <buy|sell> <price> <quantity>")
+	}
+	orderId, err := parseOrderId(args[0])
+	if err != nil {
+		return nil, err
+	}
+	side, err := parseSide(args[1])
+	if err != nil {
+		return nil, err
+	}
+	price, err := parsePrice(args[2])
+	if err != nil {
+		return nil, err
+	}
+	quantity, err := parseQuantity(args[3])
+	if err != nil {
+		return nil, err
+	}
+	var modify orderbook.OrderModify
+	return s.book.ModifyOrder(modify.New(orderId, price, side, quantity))
+}
+
+func (s *Shell) undoLast() error {
+	if len(s.undo) == 0 {
+		return fmt.Errorf("nothing to undo")
+	}
+	last := s.undo[len(s.undo)-1]
+	if err := s.book.UnmarshalBinary(last.snapshot); err != nil {
+		return err
+	}
+	s.undo = s.undo[:len(s.undo)-1]
+	s.trades = s.trades[:last.trades]
+	fmt.Fprintf(s.out, "undid: %s\n", last.command)
+	s.printBook()
+	return nil
+}
+
+func (s *Shell) snapshot(line string, args []string) error {
+	if len(args) != 2 || (args[0] != "save" && args[0] != "load") {
+		return fmt.Errorf("usage: snapshot <save|load> <file>")
+	}
+	if args[0] == "save" {
+		b, err := s.book.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(args[1], b, 0o644); err != nil {
+			return err
+		}
+		fmt.Fprintf(s.out, "saved %d orders to %s\n", s.book.Size(), args[1])
+		return nil
+	}
+
+	b, err := os.ReadFile(args[1])
+	if err != nil {
+		return err
+	}
+	return s.mutate(line, func() (orderbook.Trades, error) {
+		return nil, s.book.UnmarshalBinary(b)
+	})
+}
+
+func (s *Shell) info(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: info [id]")
+	}
+	if len(args) == 1 {
+		orderId, err := parseOrderId(args[0])
+		if err != nil {
+			return err
+		}
+		order, exists := s.book.Order(orderId)
+		if !exists {
+			return fmt.Errorf("order %d is not resting", orderId)
+		}
+		fmt.Fprintf(s.out,
+			"order %d: %s %s %d @ %d, filled %d, open %d\n",
+			order.OrderId(),
+			order.OrderType(),
+			formatSide(order.Side()),
+			order.InitialQuantity(),
+			order.Price(),
+			order.FilledQuantity(),
+			order.RemainingQuantity(),
+		)
+		return nil
+	}
+
+	info := s.book.OrderInfo()
+	bids, asks := info.GetBids(), info.GetAsks()
+	w := tabwriter.NewWriter(s.out, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "orders\t%d\n", s.book.Size())
+	fmt.Fprintf(w, "levels\t%d bid, %d ask\n", len(bids), len(asks))
+	if len(bids) > 0 {
+		fmt.Fprintf(w, "best bid\t%d x %d\n", bids[0].Price, bids[0].Quantity)
+	}
+	if len(asks) > 0 {
+		fmt.Fprintf(w, "best ask\t%d x %d\n", asks[0].Price, asks[0].Quantity)
+	}
+	if len(bids) > 0 && len(asks) > 0 {
+		fmt.Fprintf(w, "spread\t%d\n", asks[0].Price-bids[0].Price)
+	}
+	fmt.Fprintf(w, "trades\t%d\n", len(s.trades))
+	fmt.Fprintf(w, "sequence\t%d\n", s.sequence)
+	fmt.Fprintf(w, "undo\t%d\n", len(s.undo))
+	return w.Flush()
+}
+
+// printBook prints the ladder with asks above bids, highest price first.
+func (s *Shell) printBook() {
+	info := s.book.OrderInfo()
+	bids, asks := info.GetBids(), info.GetAsks()
+	if len(bids) == 0 && len(asks) == 0 {
+		fmt.Fprintln(s.out, "book is empty")
+		return
+	}
+
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 8, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "BID\tPRICE\tASK\t")
+	for i := len(asks) - 1; i >= 0; i-- {
+		fmt.Fprintf(w, "\t%d\t%d\t\n", asks[i].Price, asks[i].Quantity)
+	}
+	for _, bid := range bids {
+		fmt.Fprintf(w, "%d\t%d\t\t\n", bid.Quantity, bid.Price)
+	}
+	w.Flush()
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		fmt.Fprintln(s.out, strings.TrimRight(line, " "))
+	}
+}
+
+func (s *Shell) printTrades(trades orderbook.Trades) {
+	for _, t := range trades {
+		bid, ask := t.BidTrade(), t.AskTrade()
+		fmt.Fprintf(s.out,
+			"trade %d: bid %d @ %d, ask %d @ %d\n",
+			bid.Quantity(), bid.OrderId(), bid.Price(), ask.OrderId(), ask.Price(),
+		)
+	}
+}
+
+func formatSide(side orderbook.Side) string {
+	if side == orderbook.Sell {
+		return "sell"
+	}
+	return "buy"
+}
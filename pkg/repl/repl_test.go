@@ -0,0 +1,100 @@
+package repl
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func run(t *testing.T, s *Shell, out *bytes.Buffer, lines ...string) string {
+	out.Reset()
+	for _, line := range lines {
+		require.NoError(t, s.Execute(line), line)
+	}
+	return out.String()
+}
+
+func TestShell(t *testing.T) {
+	var out bytes.Buffer
+	s := NewShell(&out)
+
+	got := run(t, s, &out,
+		"add gtc sell 101 5",
+		"add gtc sell 102 7",
+		"add gtc buy 99 3",
+	)
+	assert.Contains(t, got, "order 3\n")
+
+	got = run(t, s, &out, "book")
+	assert.Equal(t, strings.Join([]string{
+		"     BID   PRICE     ASK",
+		"             102       7",
+		"             101       5",
+		"       3      99",
+		"",
+	}, "\n"), got)
+
+	got = run(t, s, &out, "market buy 6")
+	assert.Contains(t, got, "trade 5: bid 4 @ 102, ask 1 @ 101\n")
+	assert.Contains(t, got, "trade 1: bid 4 @ 102, ask 2 @ 102\n")
+
+	got = run(t, s, &out, "info")
+	assert.Contains(t, got, "orders   2\n")
+	assert.Contains(t, got, "trades   2\n")
+
+	got = run(t, s, &out, "info 2")
+	assert.Equal(t, "order 2: good_till_cancel sell 7 @ 102, filled 1, open 6\n", got)
+
+	// Undo restores the book and the trade log.
+	got = run(t, s, &out, "undo")
+	assert.Contains(t, got, "undid: market buy 6\n")
+	got = run(t, s, &out, "info 1", "trades")
+	assert.Equal(t, "order 1: good_till_cancel sell 5 @ 101, filled 0, open 5\n", got)
+
+	assert.Error(t, s.Execute("cancel 42"))
+	assert.Error(t, s.Execute("add gtc up 1 1"))
+	assert.Error(t, s.Execute("frobnicate"))
+	require.NoError(t, s.Execute("modify 1 sell 101 2"))
+	got = run(t, s, &out, "info 1")
+	assert.Equal(t, "order 1: good_till_cancel sell 2 @ 101, filled 0, open 2\n", got)
+}
+
+func TestSnapshotAndLoad(t *testing.T) {
+	dir := t.TempDir()
+	snapshot := filepath.Join(dir, "book.snap")
+	script := filepath.Join(dir, "script.txt")
+	require.NoError(t, os.WriteFile(script, []byte(strings.Join([]string{
+		"# two resting orders",
+		"add gtc buy 100 10 7",
+		"",
+		"add gtc sell 105 4",
+		"snapshot save " + snapshot,
+	}, "\n")), 0o644))
+
+	var out bytes.Buffer
+	s := NewShell(&out)
+	require.NoError(t, s.Load(script))
+
+	restored := NewShell(&out)
+	require.NoError(t, restored.Execute("snapshot load "+snapshot))
+	assert.Equal(t, s.book.OrderIds(), restored.book.OrderIds())
+	require.NoError(t, restored.Execute("undo"))
+	assert.Zero(t, restored.book.Size())
+
+	require.NoError(t, os.WriteFile(script, []byte("add gtc buy 100 1\ncancel 99\n"), 0o644))
+	err := NewShell(&out).Load(script)
+	assert.ErrorContains(t, err, "script.txt:2:")
+}
+
+func TestRun(t *testing.T) {
+	var out bytes.Buffer
+	s := NewShell(&out)
+	require.NoError(t, s.Run(strings.NewReader("bogus\nadd gtc buy 1 1\nquit\nadd gtc buy 1 1\n"), "> "))
+	assert.Contains(t, out.String(), "> error: unknown command")
+	assert.Equal(t, 1, s.book.Size())
+}
@@ -0,0 +1,544 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"go-orderbook/pkg/audit"
+	"go-orderbook/pkg/clearing"
+	"go-orderbook/pkg/engine"
+	"go-orderbook/pkg/fee"
+	"go-orderbook/pkg/metrics"
+	"go-orderbook/pkg/orderbook"
+	"go-orderbook/pkg/position"
+	"go-orderbook/pkg/risk"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestServer(t *testing.T, auth Authenticator) *httptest.Server {
+	e := engine.NewEngine()
+	require.NoError(t, e.List("ACME"))
+	ts := httptest.NewServer(NewServer(e, auth))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func do(t *testing.T, ts *httptest.Server, method, path, body string, status int, v any) {
+	doAs(t, ts, "", method, path, body, status, v)
+}
+
+func doAs(t *testing.T, ts *httptest.Server, token, method, path, body string, status int, v any) {
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	require.NoError(t, err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var buf bytes.Buffer
+	buf.ReadFrom(resp.Body)
+	require.Equal(t, status, resp.StatusCode, buf.String())
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	if v != nil {
+		require.NoError(t, json.Unmarshal(buf.Bytes(), v))
+	}
+}
+
+func expectError(t *testing.T, ts *httptest.Server, method, path, body string, status int, code string) {
+	var e Error
+	do(t, ts, method, path, body, status, &e)
+	assert.Equal(t, code, e.Error.Code)
+	assert.NotEmpty(t, e.Error.Message)
+}
+
+func TestOrders(t *testing.T) {
+	ts := newTestServer(t, nil)
+
+	var sell OrderResponse
+	do(t, ts, http.MethodPost, "/orders",
+		`{"symbol":"ACME","type":"good_till_cancel","side":"sell","price":100,"quantity":10}`,
+		http.StatusCreated, &sell)
+	assert.Equal(t, uint64(1), sell.Order.Id)
+	assert.Equal(t, StatusOpen, sell.Order.Status)
+	assert.Equal(t, uint32(10), sell.Order.Open)
+	assert.Empty(t, sell.Trades)
+
+	var buy OrderResponse
+	do(t, ts, http.MethodPost, "/orders",
+		`{"id":42,"symbol":"ACME","type":"fill_and_kill","side":"buy","price":105,"quantity":4}`,
+		http.StatusCreated, &buy)
+	assert.Equal(t, uint64(42), buy.Order.Id)
+	assert.Equal(t, StatusFilled, buy.Order.Status)
+	assert.Equal(t, uint32(4), buy.Order.Filled)
+	require.Len(t, buy.Trades, 1)
+	assert.Equal(t, uint64(1), buy.Trades[0].Id)
+	// The buy limited at 105 trades at the resting 100.
+	assert.Equal(t, int32(100), buy.Trades[0].Price)
+	assert.Equal(t, TradeSide{OrderId: 42, Price: 105, Quantity: 4, Liquidity: "taker"}, buy.Trades[0].Bid)
+	assert.Equal(t, TradeSide{OrderId: 1, Price: 100, Quantity: 4, Liquidity: "maker"}, buy.Trades[0].Ask)
+
+	var order Order
+	do(t, ts, http.MethodGet, "/orders/1", "", http.StatusOK, &order)
+	assert.Equal(t, uint32(6), order.Open)
+	assert.Equal(t, uint32(4), order.Filled)
+
+	var modified OrderResponse
+	do(t, ts, http.MethodPatch, "/orders/1", `{"price":105}`, http.StatusOK, &modified)
+	assert.Equal(t, int32(105), modified.Order.Price)
+	assert.Equal(t, uint32(6), modified.Order.Open)
+	assert.Equal(t, StatusOpen, modified.Order.Status)
+
+	do(t, ts, http.MethodPost, "/orders",
+		`{"symbol":"ACME","type":"good_till_cancel","side":"buy","price":99,"quantity":3}`,
+		http.StatusCreated, nil)
+
+	var book Book
+	do(t, ts, http.MethodGet, "/book/ACME?depth=1", "", http.StatusOK, &book)
+	assert.Equal(t, Book{
+		Symbol: "ACME",
+		Bids:   []Level{{Price: 99, Quantity: 3}},
+		Asks:   []Level{{Price: 105, Quantity: 6}},
+	}, book)
+
+	do(t, ts, http.MethodDelete, "/orders/1", "", http.StatusOK, &order)
+	assert.Equal(t, StatusCanceled, order.Status)
+	assert.Zero(t, order.Open)
+
+	var trades TradeList
+	do(t, ts, http.MethodGet, "/trades?symbol=ACME", "", http.StatusOK, &trades)
+	assert.Len(t, trades.Trades, 1)
+	do(t, ts, http.MethodGet, "/trades?after=1", "", http.StatusOK, &trades)
+	assert.Empty(t, trades.Trades)
+}
+
+func TestErrors(t *testing.T) {
+	ts := newTestServer(t, nil)
+
+	expectError(t, ts, http.MethodPost, "/orders", `{"symbol":"ACME"`,
+		http.StatusBadRequest, CodeInvalidRequest)
+	expectError(t, ts, http.MethodPost, "/orders",
+		`{"symbol":"ACME","type":"good_till_cancel","side":"up","price":1,"quantity":1}`,
+		http.StatusBadRequest, CodeInvalidRequest)
+	expectError(t, ts, http.MethodPost, "/orders",
+		`{"symbol":"NOPE","type":"good_till_cancel","side":"buy","price":1,"quantity":1}`,
+		http.StatusNotFound, CodeUnknownSymbol)
+	expectError(t, ts, http.MethodPost, "/orders",
+		`{"symbol":"ACME","type":"fill_and_kill","side":"buy","price":1,"quantity":1}`,
+		http.StatusUnprocessableEntity, CodeOrderRejected)
+
+	do(t, ts, http.MethodPost, "/orders",
+		`{"id":7,"symbol":"ACME","type":"good_till_cancel","side":"buy","price":1,"quantity":1}`,
+		http.StatusCreated, nil)
+	expectError(t, ts, http.MethodPost, "/orders",
+		`{"id":7,"symbol":"ACME","type":"good_till_cancel","side":"buy","price":1,"quantity":1}`,
+		http.StatusConflict, CodeDuplicateOrder)
+	do(t, ts, http.MethodDelete, "/orders/7", "", http.StatusOK, nil)
+	expectError(t, ts, http.MethodDelete, "/orders/7", "", http.StatusConflict, CodeOrderNotOpen)
+	expectError(t, ts, http.MethodPatch, "/orders/7", `{}`, http.StatusConflict, CodeOrderNotOpen)
+
+	expectError(t, ts, http.MethodGet, "/orders/8", "", http.StatusNotFound, CodeUnknownOrder)
+	expectError(t, ts, http.MethodGet, "/orders/x", "", http.StatusBadRequest, CodeInvalidRequest)
+	expectError(t, ts, http.MethodGet, "/book/NOPE", "", http.StatusNotFound, CodeUnknownSymbol)
+	expectError(t, ts, http.MethodGet, "/book/ACME?depth=-1", "", http.StatusBadRequest, CodeInvalidRequest)
+	expectError(t, ts, http.MethodGet, "/trades?limit=0", "", http.StatusBadRequest, CodeInvalidRequest)
+	expectError(t, ts, http.MethodPut, "/orders/7", "", http.StatusMethodNotAllowed, CodeMethodNotAllowed)
+	expectError(t, ts, http.MethodGet, "/nope", "", http.StatusNotFound, CodeNotFound)
+}
+
+func TestAccounts(t *testing.T) {
+	ts := newTestServer(t, testAuth)
+
+	expectError(t, ts, http.MethodPost, "/orders",
+		`{"symbol":"ACME","type":"good_till_cancel","side":"buy","price":1,"quantity":1}`,
+		http.StatusUnauthorized, CodeUnauthorized)
+
+	var resp OrderResponse
+	doAs(t, ts, "alice-token", http.MethodPost, "/orders",
+		`{"symbol":"ACME","type":"good_till_cancel","side":"buy","price":1,"quantity":1}`,
+		http.StatusCreated, &resp)
+	assert.Equal(t, "alice", resp.Order.Account)
+
+	// Orders of other accounts are not visible.
+	var e Error
+	doAs(t, ts, "bob-token", http.MethodDelete, "/orders/1", "", http.StatusNotFound, &e)
+	assert.Equal(t, CodeUnknownOrder, e.Error.Code)
+	doAs(t, ts, "alice-token", http.MethodGet, "/orders/1", "", http.StatusOK, nil)
+
+	// Market data needs no token.
+	do(t, ts, http.MethodGet, "/book/ACME", "", http.StatusOK, nil)
+}
+
+func TestRisk(t *testing.T) {
+	e := engine.NewEngine()
+	require.NoError(t, e.List("ACME"))
+	s := NewServer(e, testAuth)
+	s.UseRisk(risk.NewChecker(e, risk.Config{
+		Accounts: map[string]risk.Limits{"alice": {MaxOpenOrders: 1}},
+	}))
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+
+	doAs(t, ts, "alice-token", http.MethodPost, "/orders",
+		`{"symbol":"ACME","type":"good_till_cancel","side":"buy","price":1,"quantity":1}`,
+		http.StatusCreated, nil)
+	var resp Error
+	doAs(t, ts, "alice-token", http.MethodPost, "/orders",
+		`{"symbol":"ACME","type":"good_till_cancel","side":"buy","price":2,"quantity":1}`,
+		http.StatusUnprocessableEntity, &resp)
+	assert.Equal(t, CodeRiskRejected, resp.Error.Code)
+	assert.Contains(t, resp.Error.Message, "open_orders")
+
+	// Bob has no limits, and a modify replaces the open order.
+	doAs(t, ts, "bob-token", http.MethodPost, "/orders",
+		`{"symbol":"ACME","type":"good_till_cancel","side":"buy","price":2,"quantity":1}`,
+		http.StatusCreated, nil)
+	doAs(t, ts, "alice-token", http.MethodPatch, "/orders/1", `{"price":3}`, http.StatusOK, nil)
+	doAs(t, ts, "alice-token", http.MethodDelete, "/orders/1", "", http.StatusOK, nil)
+	doAs(t, ts, "alice-token", http.MethodPost, "/orders",
+		`{"symbol":"ACME","type":"good_till_cancel","side":"buy","price":2,"quantity":1}`,
+		http.StatusCreated, nil)
+}
+
+func TestPositions(t *testing.T) {
+	e := engine.NewEngine()
+	require.NoError(t, e.List("ACME"))
+	s := NewServer(e, testAuth)
+	s.UseLedger(position.NewLedger(e, position.MarkLastTrade))
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+
+	doAs(t, ts, "alice-token", http.MethodPost, "/orders",
+		`{"symbol":"ACME","type":"good_till_cancel","side":"sell","price":100,"quantity":10}`,
+		http.StatusCreated, nil)
+	doAs(t, ts, "bob-token", http.MethodPost, "/orders",
+		`{"symbol":"ACME","type":"fill_and_kill","side":"buy","price":100,"quantity":4}`,
+		http.StatusCreated, nil)
+	doAs(t, ts, "bob-token", http.MethodPost, "/orders",
+		`{"symbol":"ACME","type":"fill_and_kill","side":"sell","price":100,"quantity":4}`,
+		http.StatusUnprocessableEntity, nil)
+
+	var list PositionList
+	doAs(t, ts, "alice-token", http.MethodGet, "/positions", "", http.StatusOK, &list)
+	assert.Equal(t, []Position{{
+		Symbol: "ACME", Quantity: -4, AveragePrice: 100, Sold: 4, Mark: 100,
+	}}, list.Positions)
+	doAs(t, ts, "carol-token", http.MethodGet, "/positions", "", http.StatusOK, &list)
+	assert.Empty(t, list.Positions)
+	expectError(t, ts, http.MethodGet, "/positions", "", http.StatusUnauthorized, CodeUnauthorized)
+
+	// Without a ledger there are no positions.
+	expectError(t, newTestServer(t, nil), http.MethodGet, "/positions", "", http.StatusNotFound, CodeNotFound)
+}
+
+func TestFees(t *testing.T) {
+	e := engine.NewEngine()
+	require.NoError(t, e.List("ACME"))
+	fees := fee.NewCalculator(fee.Config{Schedules: map[string]map[engine.Symbol]fee.Schedule{
+		fee.Any: {fee.Any: {{Maker: -20, Taker: 30}}},
+	}})
+	e.UseFees(fees)
+	s := NewServer(e, testAuth)
+	s.UseFees(fees)
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+
+	doAs(t, ts, "alice-token", http.MethodPost, "/orders",
+		`{"symbol":"ACME","type":"good_till_cancel","side":"sell","price":100,"quantity":10}`,
+		http.StatusCreated, nil)
+	var resp OrderResponse
+	doAs(t, ts, "bob-token", http.MethodPost, "/orders",
+		`{"symbol":"ACME","type":"fill_and_kill","side":"buy","price":100,"quantity":4}`,
+		http.StatusCreated, &resp)
+	require.Len(t, resp.Trades, 1)
+	assert.Equal(t, TradeSide{OrderId: 2, Price: 100, Quantity: 4, Liquidity: "taker", Fee: 12000}, resp.Trades[0].Bid)
+	assert.Equal(t, TradeSide{OrderId: 1, Price: 100, Quantity: 4, Liquidity: "maker", Fee: -8000}, resp.Trades[0].Ask)
+	assert.Equal(t, int64(12000), resp.Order.Fees)
+
+	var order Order
+	doAs(t, ts, "alice-token", http.MethodGet, "/orders/1", "", http.StatusOK, &order)
+	assert.Equal(t, int64(-8000), order.Fees)
+	assert.Equal(t, fee.Total{Volume: 4, Maker: -8000}, fees.Total("alice"))
+}
+
+func TestClearing(t *testing.T) {
+	e := engine.NewEngine()
+	require.NoError(t, e.List("ACME"))
+	s := NewServer(e, testAuth)
+	house := clearing.NewHouse(e)
+	s.UseClearing(house)
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+
+	doAs(t, ts, "alice-token", http.MethodPost, "/orders",
+		`{"symbol":"ACME","type":"good_till_cancel","side":"sell","price":100,"quantity":10}`,
+		http.StatusCreated, nil)
+	doAs(t, ts, "bob-token", http.MethodPost, "/orders",
+		`{"symbol":"ACME","type":"fill_and_kill","side":"buy","price":100,"quantity":4}`,
+		http.StatusCreated, nil)
+
+	assert.Equal(t, []clearing.Obligation{
+		{Account: "alice", Symbol: "ACME", Trades: 1, Sold: 4, Deliver: 4, Collect: 400},
+		{Account: "bob", Symbol: "ACME", Trades: 1, Bought: 4, Receive: 4, Pay: 400},
+	}, house.Close().Obligations)
+}
+
+func TestCorrections(t *testing.T) {
+	e := engine.NewEngine()
+	require.NoError(t, e.List("ACME"))
+	fees := fee.NewCalculator(fee.Config{Schedules: map[string]map[engine.Symbol]fee.Schedule{
+		fee.Any: {fee.Any: {{Maker: -20, Taker: 30}}},
+	}})
+	e.UseFees(fees)
+	ledger := position.NewLedger(e, position.MarkLastTrade)
+	s := NewServer(e, testAuth)
+	s.UseFees(fees)
+	s.UseLedger(ledger)
+	s.UseAdmins("ops")
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+
+	doAs(t, ts, "alice-token", http.MethodPost, "/orders",
+		`{"symbol":"ACME","type":"good_till_cancel","side":"sell","price":100,"quantity":10}`,
+		http.StatusCreated, nil)
+	doAs(t, ts, "bob-token", http.MethodPost, "/orders",
+		`{"symbol":"ACME","type":"fill_and_kill","side":"buy","price":100,"quantity":4}`,
+		http.StatusCreated, nil)
+
+	// Only admins correct trades.
+	var e403 Error
+	doAs(t, ts, "bob-token", http.MethodPost, "/admin/trades/1/bust", `{"reason":"fat finger"}`,
+		http.StatusForbidden, &e403)
+	assert.Equal(t, CodeForbidden, e403.Error.Code)
+	expectError(t, ts, http.MethodGet, "/admin/corrections", "", http.StatusUnauthorized, CodeUnauthorized)
+
+	var c Correction
+	doAs(t, ts, "ops-token", http.MethodPost, "/admin/trades/1/correct", `{"price":101,"reason":"wrong print"}`,
+		http.StatusOK, &c)
+	assert.Equal(t, "price_correction", c.Type)
+	assert.Equal(t, "ops", c.Operator)
+	assert.Equal(t, uint64(1), c.TradeId)
+	assert.Equal(t, int32(100), c.OldPrice)
+	assert.Equal(t, int32(101), c.NewPrice)
+
+	var trades TradeList
+	do(t, ts, http.MethodGet, "/trades", "", http.StatusOK, &trades)
+	require.Len(t, trades.Trades, 1)
+	assert.Equal(t, TradeCorrected, trades.Trades[0].Status)
+	assert.Equal(t, int32(101), trades.Trades[0].Price)
+	assert.Equal(t, TradeSide{OrderId: 2, Price: 100, Quantity: 4, Liquidity: "taker", Fee: 12120}, trades.Trades[0].Bid)
+	assert.Equal(t, TradeSide{OrderId: 1, Price: 100, Quantity: 4, Liquidity: "maker", Fee: -8080}, trades.Trades[0].Ask)
+	p, _ := ledger.Position("alice", "ACME")
+	assert.Equal(t, int64(-404), p.Cost)
+
+	doAs(t, ts, "ops-token", http.MethodPost, "/admin/trades/1/bust", `{"reason":"erroneous"}`,
+		http.StatusOK, &c)
+	assert.Equal(t, "bust", c.Type)
+	do(t, ts, http.MethodGet, "/trades", "", http.StatusOK, &trades)
+	assert.Equal(t, TradeBusted, trades.Trades[0].Status)
+	assert.Zero(t, trades.Trades[0].Bid.Fee)
+	var order Order
+	doAs(t, ts, "alice-token", http.MethodGet, "/orders/1", "", http.StatusOK, &order)
+	assert.Zero(t, order.Fees)
+	p, _ = ledger.Position("alice", "ACME")
+	assert.Zero(t, p.Quantity)
+
+	var e4xx Error
+	doAs(t, ts, "ops-token", http.MethodPost, "/admin/trades/1/bust", `{"reason":"again"}`,
+		http.StatusConflict, &e4xx)
+	assert.Equal(t, CodeTradeBusted, e4xx.Error.Code)
+	doAs(t, ts, "ops-token", http.MethodPost, "/admin/trades/2/bust", `{"reason":"unknown"}`,
+		http.StatusNotFound, &e4xx)
+	assert.Equal(t, CodeUnknownTrade, e4xx.Error.Code)
+	doAs(t, ts, "ops-token", http.MethodPost, "/admin/trades/1/bust", `{"price":100,"reason":"priced"}`,
+		http.StatusBadRequest, &e4xx)
+	doAs(t, ts, "ops-token", http.MethodPost, "/admin/trades/1/correct", `{"reason":"unpriced"}`,
+		http.StatusBadRequest, &e4xx)
+
+	var list CorrectionList
+	doAs(t, ts, "ops-token", http.MethodGet, "/admin/corrections", "", http.StatusOK, &list)
+	require.Len(t, list.Corrections, 2)
+	assert.Equal(t, "price_correction", list.Corrections[0].Type)
+	assert.Equal(t, "bust", list.Corrections[1].Type)
+	assert.Equal(t, "erroneous", list.Corrections[1].Reason)
+}
+
+func TestMetrics(t *testing.T) {
+	e := engine.NewEngine()
+	require.NoError(t, e.List("ACME"))
+	s := NewServer(e, testAuth)
+	r := metrics.NewRegistry()
+	s.UseMetrics(r)
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+
+	doAs(t, ts, "alice-token", http.MethodPost, "/orders",
+		`{"symbol":"ACME","type":"good_till_cancel","side":"sell","price":100,"quantity":10}`,
+		http.StatusCreated, nil)
+	doAs(t, ts, "alice-token", http.MethodPost, "/orders",
+		`{"symbol":"ACME","type":"good_till_cancel","side":"sell","price":0,"quantity":10}`,
+		http.StatusBadRequest, nil)
+	doAs(t, ts, "alice-token", http.MethodPost, "/orders",
+		`{"symbol":"NOPE","type":"good_till_cancel","side":"sell","price":100,"quantity":10}`,
+		http.StatusNotFound, nil)
+	do(t, ts, http.MethodPost, "/orders", `{}`, http.StatusUnauthorized, nil)
+
+	var buf bytes.Buffer
+	_, err := r.WriteTo(&buf)
+	require.NoError(t, err)
+	for _, line := range []string{
+		`rest_orders_accepted_total 1`,
+		`rest_orders_rejected_total{reason="invalid_request"} 1`,
+		`rest_orders_rejected_total{reason="unknown_symbol"} 1`,
+		`rest_orders_rejected_total{reason="unauthorized"} 1`,
+	} {
+		assert.Contains(t, buf.String(), line+"\n")
+	}
+}
+
+func TestAudit(t *testing.T) {
+	e := engine.NewEngine()
+	require.NoError(t, e.List("ACME"))
+	dir := t.TempDir()
+	w, err := audit.OpenWriter(dir, audit.Options{})
+	require.NoError(t, err)
+	s := NewServer(e, testAuth)
+	s.UseAudit(audit.New(slog.New(slog.NewJSONHandler(w, nil)), e))
+	s.UseAdmins("ops")
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+
+	doAs(t, ts, "alice-token", http.MethodPost, "/orders",
+		`{"symbol":"ACME","type":"good_till_cancel","side":"sell","price":100,"quantity":10}`,
+		http.StatusCreated, nil)
+	doAs(t, ts, "bob-token", http.MethodPost, "/orders",
+		`{"symbol":"ACME","type":"fill_and_kill","side":"buy","price":100,"quantity":4}`,
+		http.StatusCreated, nil)
+	doAs(t, ts, "alice-token", http.MethodPatch, "/orders/1", `{"price":102}`, http.StatusOK, nil)
+	doAs(t, ts, "ops-token", http.MethodPost, "/admin/trades/1/bust", `{"reason":"fat finger"}`, http.StatusOK, nil)
+	doAs(t, ts, "alice-token", http.MethodDelete, "/orders/1", "", http.StatusOK, nil)
+	require.NoError(t, w.Close())
+
+	var msgs []string
+	_, err = audit.Query(dir, audit.Filter{OrderId: 1, Account: "alice"}, func(e audit.Entry) error {
+		msgs = append(msgs, e.Message)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"order.request", "order.added", "order.accepted",
+		"order.executed",
+		"order.request", "order.deleted", "order.added", "order.accepted",
+		"trade.busted",
+		"order.request", "order.deleted", "order.accepted",
+	}, msgs)
+
+	var operators []any
+	_, err = audit.Query(dir, audit.Filter{OrderId: 2}, func(e audit.Entry) error {
+		if e.Message == "trade.amended" {
+			operators = append(operators, e.Fields["operator"])
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []any{"ops"}, operators)
+}
+
+func testAuth(token string) (string, bool) {
+	account, ok := strings.CutSuffix(token, "-token")
+	return account, ok
+}
+
+// TestSchema checks that the schema describes exactly the JSON fields of
+// every type.
+func TestSchema(t *testing.T) {
+	var doc struct {
+		Defs map[string]struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+		} `json:"$defs"`
+	}
+	require.NoError(t, json.Unmarshal(schema, &doc))
+
+	types := []any{
+		OrderRequest{}, ModifyRequest{}, Order{}, OrderResponse{},
+		TradeSide{}, Trade{}, TradeList{}, Level{}, Book{}, ErrorDetail{}, Error{},
+		StreamRequest{}, Notice{}, Heartbeat{}, L1Update{}, L2Snapshot{}, L2Update{},
+		TradeUpdate{}, OrderUpdate{}, Position{}, PositionList{},
+		CorrectionRequest{}, Correction{}, CorrectionList{},
+	}
+	for _, v := range types {
+		typ := reflect.TypeOf(v)
+		def, ok := doc.Defs[typ.Name()]
+		require.True(t, ok, typ.Name())
+
+		var fields, properties []string
+		for i := 0; i < typ.NumField(); i++ {
+			name, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+			fields = append(fields, name)
+		}
+		for name := range def.Properties {
+			properties = append(properties, name)
+		}
+		sort.Strings(fields)
+		sort.Strings(properties)
+		assert.Equal(t, fields, properties, typ.Name())
+	}
+}
+
+func TestRetention(t *testing.T) {
+	e := engine.NewEngine()
+	require.NoError(t, e.List("ACME"))
+	s := NewServer(e, testAuth)
+	s.UseAdmins("ops")
+	now := time.Date(2024, 3, 1, 15, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+
+	doAs(t, ts, "alice-token", http.MethodPost, "/orders",
+		`{"symbol":"ACME","type":"good_till_cancel","side":"sell","price":100,"quantity":10}`,
+		http.StatusCreated, nil)
+	doAs(t, ts, "bob-token", http.MethodPost, "/orders",
+		`{"symbol":"ACME","type":"fill_and_kill","side":"buy","price":100,"quantity":4}`,
+		http.StatusCreated, nil)
+
+	// Trades and orders that left the book are forgotten after the
+	// retention, open orders are kept.
+	now = now.Add(engine.TradeRetention)
+	doAs(t, ts, "bob-token", http.MethodPost, "/orders",
+		`{"symbol":"ACME","type":"fill_and_kill","side":"buy","price":100,"quantity":4}`,
+		http.StatusCreated, nil)
+	var trades TradeList
+	do(t, ts, http.MethodGet, "/trades", "", http.StatusOK, &trades)
+	require.Len(t, trades.Trades, 1)
+	assert.Equal(t, uint64(2), trades.Trades[0].Id)
+	do(t, ts, http.MethodGet, "/trades?after=1", "", http.StatusOK, &trades)
+	assert.Len(t, trades.Trades, 1)
+
+	var e404 Error
+	doAs(t, ts, "bob-token", http.MethodGet, "/orders/2", "", http.StatusNotFound, &e404)
+	doAs(t, ts, "bob-token", http.MethodGet, "/orders/3", "", http.StatusOK, nil)
+	doAs(t, ts, "alice-token", http.MethodGet, "/orders/1", "", http.StatusOK, nil)
+	doAs(t, ts, "ops-token", http.MethodPost, "/admin/trades/1/bust", `{"reason":"late"}`,
+		http.StatusNotFound, &e404)
+	assert.Equal(t, CodeUnknownTrade, e404.Error.Code)
+}
+
+func TestOrderTypes(t *testing.T) {
+	// The API names order types as the orderbook does.
+	for _, orderType := range []orderbook.OrderType{orderbook.Market, orderbook.GoodTillCancel, orderbook.GoodForDay, orderbook.FillAndKill, orderbook.FillOrKill} {
+		parsed, err := parseType(orderType.String())
+		require.NoError(t, err)
+		assert.Equal(t, orderType, parsed)
+	}
+}
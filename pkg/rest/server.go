@@ -0,0 +1,1129 @@
+// Package rest serves order entry and book queries for an engine.Engine as
+// an HTTP/JSON API.
+//
+//	POST   /orders              enter an OrderRequest
+//	GET    /orders/{id}         the Order
+//	PATCH  /orders/{id}         modify with a ModifyRequest
+//	DELETE /orders/{id}         cancel
+//	GET    /book/{symbol}       the Book; ?depth=N limits the levels per side
+//	GET    /trades              a TradeList; ?symbol=S&after=ID&limit=N
+//	GET    /positions           the PositionList of the account
+//	POST   /admin/trades/{id}/bust     bust with a CorrectionRequest
+//	POST   /admin/trades/{id}/correct  correct the price with a CorrectionRequest
+//	GET    /admin/corrections          the CorrectionList
+//	GET    /schema              JSON Schema of every request and response
+//	GET    /stream              WebSocket stream, see StreamRequest
+//
+// Errors are answered with an Error body carrying one of the Code constants.
+// Trades, and orders that left the book, are kept for engine.TradeRetention.
+//
+// When the server has an Authenticator, order requests must carry an
+// "Authorization: Bearer <token>" header and only see the orders of their
+// account. The book, trades and public stream channels need no token.
+//
+// With a risk.Checker, orders are checked against the limits of their
+// account and breaches are answered with CodeRiskRejected. With a
+// position.Ledger, the trades of orders are booked to their account and
+// served by /positions. With a fee.Calculator, trades and orders report the
+// fees charged to their account. With a clearing.House, their trades are
+// cleared for their account.
+//
+// With a metrics.Registry, entered orders are counted as accepted or as
+// rejected by error code. With an audit.Trail, order requests are recorded
+// with their account and the decision of the engine, and busts and
+// corrections with their operator.
+//
+// Admin requests are only accepted from the accounts given to UseAdmins.
+// Busts and corrections leave the book untouched; they amend the trade in
+// the log and on the trades channel, and the fees of its orders.
+package rest
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-orderbook/pkg/audit"
+	"go-orderbook/pkg/clearing"
+	"go-orderbook/pkg/ds/rbmap"
+	"go-orderbook/pkg/engine"
+	"go-orderbook/pkg/fee"
+	"go-orderbook/pkg/metrics"
+	"go-orderbook/pkg/orderbook"
+	"go-orderbook/pkg/position"
+	"go-orderbook/pkg/risk"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed schema.json
+var schema []byte
+
+const (
+	maxBodySize = 1 << 20
+
+	defaultTradeLimit = 100
+	maxTradeLimit     = 1000
+)
+
+// Authenticator maps the bearer token of a request to an account.
+type Authenticator func(token string) (account string, ok bool)
+
+// bookState is the depth of a book, rebuilt from its events, and the stream
+// clients subscribed to it.
+type bookState struct {
+	bids      *rbmap.Map[orderbook.Price, orderbook.Quantity]
+	asks      *rbmap.Map[orderbook.Price, orderbook.Quantity]
+	sequence  uint64
+	bid, ask  Level
+	execution orderbook.Event
+
+	subscribers map[string]map[*client]struct{}
+}
+
+// Server is an http.Handler for an engine. It keeps the state of every order
+// entered through it, including orders that left the book, so their ids are
+// not taken again by later requests, and every trade of the engine. It
+// follows the books from their events, missing those of orders entered
+// before it was created.
+type Server struct {
+	engine *engine.Engine
+	auth   Authenticator
+	risk   *risk.Checker
+	audit  *audit.Trail
+	ledger *position.Ledger
+	// assigners are told the account of every order before it enters the
+	// book.
+	assigners []assigner
+	admins    map[string]bool
+	accepted  *metrics.Counter
+	rejected  *metrics.Counter
+
+	now func() time.Time
+
+	// m guards the fields below, which the engine listener keeps.
+	m           sync.Mutex
+	orders      map[orderbook.OrderId]*Order
+	nextOrderId orderbook.OrderId
+	// finished holds when the orders that left the book did so, and
+	// finishing the same in that order, to forget them after
+	// engine.TradeRetention.
+	finished  map[orderbook.OrderId]time.Time
+	finishing []finishedOrder
+	// trades holds the trades of the last engine.TradeRetention, after the
+	// forgotten ones that took the first ids.
+	trades    []Trade
+	forgotten uint64
+	// tradeIds maps the symbol and match id of a trade to its id.
+	tradeIds map[tradeKey]uint64
+	books    map[engine.Symbol]*bookState
+	// private holds the clients subscribed to the orders of each account.
+	private map[string]map[*client]struct{}
+}
+
+// NewServer creates a server for an engine. A nil auth accepts every request
+// and leaves orders without an account.
+func NewServer(e *engine.Engine, auth Authenticator) *Server {
+	s := &Server{
+		engine:      e,
+		auth:        auth,
+		now:         time.Now,
+		orders:      make(map[orderbook.OrderId]*Order),
+		nextOrderId: 1,
+		finished:    make(map[orderbook.OrderId]time.Time),
+		tradeIds:    make(map[tradeKey]uint64),
+		books:       make(map[engine.Symbol]*bookState),
+		private:     make(map[string]map[*client]struct{}),
+		admins:      make(map[string]bool),
+	}
+	e.AddListener(engine.ListenerFunc(s.onEvent))
+	return s
+}
+
+// UseRisk sends the orders of the server through a risk checker of the same
+// engine. It must be called before the server is used.
+func (s *Server) UseRisk(c *risk.Checker) {
+	s.risk = c
+}
+
+// UseAudit records the order requests of the server and their decisions in
+// a trail of the same engine. It must be called before the server is used.
+func (s *Server) UseAudit(t *audit.Trail) {
+	s.audit = t
+}
+
+type tradeKey struct {
+	symbol  engine.Symbol
+	matchId uint64
+}
+
+// UseAdmins allows accounts to bust and correct trades. It must be called
+// before the server is used.
+func (s *Server) UseAdmins(accounts ...string) {
+	for _, account := range accounts {
+		s.admins[account] = true
+	}
+}
+
+// UseMetrics counts the orders entered through the server in a registry as
+// rest_orders_accepted_total and rest_orders_rejected_total by reason, the
+// error code of the response. It must be called before the server is used.
+func (s *Server) UseMetrics(r *metrics.Registry) {
+	s.accepted = r.NewCounter("rest_orders_accepted_total", "Orders entered through the HTTP API.")
+	s.rejected = r.NewCounter("rest_orders_rejected_total", "Orders refused by the HTTP API.", "reason")
+}
+
+// An assigner books the trades of orders to the account they were entered
+// for.
+type assigner interface {
+	Assign(orderId orderbook.OrderId, account string)
+	Unassign(orderId orderbook.OrderId)
+}
+
+// UseLedger books the trades of the orders of the server to their account
+// in a ledger of the same engine. It must be called before the server is
+// used.
+func (s *Server) UseLedger(l *position.Ledger) {
+	s.ledger = l
+	s.assigners = append(s.assigners, l)
+}
+
+// UseFees charges the executions of the orders of the server to their
+// account. The calculator must be the fee model of the engine. It must be
+// called before the server is used.
+func (s *Server) UseFees(c *fee.Calculator) {
+	s.assigners = append(s.assigners, c)
+}
+
+// UseClearing clears the trades of the orders of the server for their
+// account in a clearing house of the same engine. It must be called before
+// the server is used.
+func (s *Server) UseClearing(h *clearing.House) {
+	s.assigners = append(s.assigners, h)
+}
+
+func (s *Server) addOrder(
+	account string,
+	symbol engine.Symbol,
+	order orderbook.Order,
+) (trades orderbook.Trades, err error) {
+	for _, a := range s.assigners {
+		a.Assign(order.OrderId(), account)
+	}
+	defer func() {
+		if err != nil {
+			for _, a := range s.assigners {
+				a.Unassign(order.OrderId())
+			}
+		}
+	}()
+	enter := func() (orderbook.Trades, error) {
+		if s.risk != nil {
+			return s.risk.AddOrder(account, symbol, order)
+		}
+		return s.engine.AddOrder(symbol, order)
+	}
+	if s.audit != nil {
+		return s.audit.AddOrder(account, symbol, order, enter)
+	}
+	return enter()
+}
+
+func (s *Server) modify(account string, modify orderbook.OrderModify) (orderbook.Trades, error) {
+	enter := func() (orderbook.Trades, error) {
+		if s.risk != nil {
+			return s.risk.ModifyOrder(account, modify)
+		}
+		return s.engine.ModifyOrder(modify)
+	}
+	if s.audit != nil {
+		return s.audit.ModifyOrder(account, modify, enter)
+	}
+	return enter()
+}
+
+func (s *Server) cancel(account string, orderId orderbook.OrderId) error {
+	enter := func() error {
+		if s.risk != nil {
+			return s.risk.CancelOrder(account, orderId)
+		}
+		return s.engine.CancelOrder(orderId)
+	}
+	if s.audit != nil {
+		return s.audit.CancelOrder(account, orderId, enter)
+	}
+	return enter()
+}
+
+// book returns the state of a book, creating it on first use. It should only
+// be called with s.m held.
+func (s *Server) book(symbol engine.Symbol) *bookState {
+	book, exists := s.books[symbol]
+	if !exists {
+		book = &bookState{
+			bids:        rbmap.NewMap[orderbook.Price, orderbook.Quantity](rbmap.Descending[orderbook.Price]),
+			asks:        rbmap.NewMap[orderbook.Price, orderbook.Quantity](rbmap.Ascending[orderbook.Price]),
+			subscribers: make(map[string]map[*client]struct{}),
+		}
+		s.books[symbol] = book
+	}
+	return book
+}
+
+func (s *Server) onEvent(symbol engine.Symbol, ev orderbook.Event) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	book := s.book(symbol)
+	book.sequence = ev.Sequence
+	switch ev.Type {
+	case orderbook.TradeBusted, orderbook.TradeCorrected:
+		s.amendTrade(symbol, book, ev)
+	default:
+		s.updateDepth(symbol, book, ev)
+	}
+	if ev.Type == orderbook.OrderExecuted {
+		if book.execution.MatchId != ev.MatchId {
+			book.execution = ev
+		} else {
+			s.recordTrade(symbol, book, book.execution, ev)
+		}
+	}
+
+	o, exists := s.orders[ev.OrderId]
+	if !exists || o.Symbol != string(symbol) {
+		return
+	}
+	update := OrderUpdate{Type: "order", Quantity: uint32(ev.Quantity), MatchId: ev.MatchId, Fee: ev.Fee}
+	switch ev.Type {
+	case orderbook.OrderAdded:
+		update.Event = "added"
+		o.Side = formatSide(ev.Side)
+		o.Price = int32(ev.Price)
+		o.Open = uint32(ev.Quantity)
+		o.Status = StatusOpen
+		// A replaced order is deleted and added again.
+		delete(s.finished, ev.OrderId)
+	case orderbook.OrderExecuted:
+		update.Event = "executed"
+		o.Open -= uint32(ev.Quantity)
+		o.Filled += uint32(ev.Quantity)
+		o.Fees += ev.Fee
+		if o.Open == 0 {
+			o.Status = StatusFilled
+			s.finish(ev.OrderId)
+		}
+	case orderbook.OrderReduced:
+		update.Event = "reduced"
+		o.Open -= uint32(ev.Quantity)
+	case orderbook.OrderDeleted:
+		update.Event = "deleted"
+		o.Open = 0
+		o.Status = StatusCanceled
+		s.finish(ev.OrderId)
+	case orderbook.TradeBusted:
+		update.Event = "busted"
+		o.Fees += ev.Fee
+	case orderbook.TradeCorrected:
+		update.Event = "corrected"
+		o.Fees += ev.Fee
+	}
+	if clients := s.private[o.Account]; o.Account != "" && len(clients) > 0 {
+		update.Order = *o
+		s.publish(clients, update)
+	}
+}
+
+// updateDepth applies an event to the depth of a book and publishes the
+// changed level, and the top of book if it changed.
+func (s *Server) updateDepth(symbol engine.Symbol, book *bookState, ev orderbook.Event) {
+	levels := book.bids
+	if ev.Side == orderbook.Sell {
+		levels = book.asks
+	}
+	quantity, _ := levels.Get(ev.Price)
+	if ev.Type == orderbook.OrderAdded {
+		quantity += ev.Quantity
+	} else {
+		quantity -= ev.Quantity
+	}
+	if quantity == 0 {
+		levels.Delete(ev.Price)
+	} else {
+		levels.Insert(ev.Price, quantity)
+	}
+
+	if clients := book.subscribers[ChannelL2]; len(clients) > 0 {
+		s.publish(clients, L2Update{
+			Type:     ChannelL2,
+			Symbol:   string(symbol),
+			Sequence: ev.Sequence,
+			Side:     formatSide(ev.Side),
+			Price:    int32(ev.Price),
+			Quantity: uint32(quantity),
+		})
+	}
+
+	bid, ask := best(book.bids), best(book.asks)
+	if bid != book.bid || ask != book.ask {
+		book.bid, book.ask = bid, ask
+		if clients := book.subscribers[ChannelL1]; len(clients) > 0 {
+			s.publish(clients, book.l1(symbol))
+		}
+	}
+}
+
+func best(levels *rbmap.Map[orderbook.Price, orderbook.Quantity]) Level {
+	it := levels.Begin()
+	if !it.Valid() {
+		return Level{}
+	}
+	return Level{Price: int32(it.Key()), Quantity: uint32(it.Value())}
+}
+
+func (b *bookState) l1(symbol engine.Symbol) L1Update {
+	update := L1Update{Type: ChannelL1, Symbol: string(symbol), Sequence: b.sequence}
+	if b.bid.Quantity > 0 {
+		bid := b.bid
+		update.Bid = &bid
+	}
+	if b.ask.Quantity > 0 {
+		ask := b.ask
+		update.Ask = &ask
+	}
+	return update
+}
+
+func (b *bookState) l2(symbol engine.Symbol) L2Snapshot {
+	snapshot := L2Snapshot{
+		Type:     "l2_snapshot",
+		Symbol:   string(symbol),
+		Sequence: b.sequence,
+		Bids:     []Level{},
+		Asks:     []Level{},
+	}
+	for it := b.bids.Begin(); it.Valid(); it.Next() {
+		snapshot.Bids = append(snapshot.Bids, Level{Price: int32(it.Key()), Quantity: uint32(it.Value())})
+	}
+	for it := b.asks.Begin(); it.Valid(); it.Next() {
+		snapshot.Asks = append(snapshot.Asks, Level{Price: int32(it.Key()), Quantity: uint32(it.Value())})
+	}
+	return snapshot
+}
+
+// finishedOrder is an order that left the book at a time.
+type finishedOrder struct {
+	orderId orderbook.OrderId
+	time    time.Time
+}
+
+// finish records that an order left the book, and forgets the orders and
+// trades older than engine.TradeRetention. It should only be called with s.m
+// held.
+func (s *Server) finish(orderId orderbook.OrderId) {
+	now := s.now()
+	s.finished[orderId] = now
+	s.finishing = append(s.finishing, finishedOrder{orderId: orderId, time: now})
+	s.expire(now)
+}
+
+// expire forgets the orders that left the book and the trades that matched
+// engine.TradeRetention or longer before now. It should only be called with
+// s.m held.
+func (s *Server) expire(now time.Time) {
+	n := 0
+	for _, f := range s.finishing {
+		if now.Sub(f.time) < engine.TradeRetention {
+			break
+		}
+		// An order added again or finished later is forgotten later.
+		if t, ok := s.finished[f.orderId]; ok && t.Equal(f.time) {
+			delete(s.finished, f.orderId)
+			delete(s.orders, f.orderId)
+		}
+		n++
+	}
+	s.finishing = s.finishing[n:]
+
+	n = 0
+	for _, t := range s.trades {
+		if now.Sub(t.Time) < engine.TradeRetention {
+			break
+		}
+		// The match id of a relisted symbol may have been taken again.
+		key := tradeKey{engine.Symbol(t.Symbol), t.MatchId}
+		if s.tradeIds[key] == t.Id {
+			delete(s.tradeIds, key)
+		}
+		n++
+	}
+	s.trades = s.trades[n:]
+	s.forgotten += uint64(n)
+}
+
+// logged returns the number of trades logged so far, including the forgotten
+// ones. It should only be called with s.m held.
+func (s *Server) logged() uint64 {
+	return s.forgotten + uint64(len(s.trades))
+}
+
+// trade returns the logged trade with an id, if it is not forgotten. It
+// should only be called with s.m held.
+func (s *Server) trade(id uint64) (*Trade, bool) {
+	if id <= s.forgotten || id > s.logged() {
+		return nil, false
+	}
+	return &s.trades[id-s.forgotten-1], true
+}
+
+// recordTrade appends the match of two OrderExecuted events to the trade log
+// and publishes it.
+func (s *Server) recordTrade(symbol engine.Symbol, book *bookState, first, second orderbook.Event) {
+	bid, ask := first, second
+	if bid.Side != orderbook.Buy {
+		bid, ask = ask, bid
+	}
+	now := s.now()
+	s.expire(now)
+	trade := Trade{
+		Id:      s.logged() + 1,
+		Symbol:  string(symbol),
+		MatchId: second.MatchId,
+		Time:    now.UTC(),
+		Status:  TradeExecuted,
+		Price:   int32(second.TradePrice),
+		Bid: TradeSide{
+			OrderId:   uint64(bid.OrderId),
+			Price:     int32(bid.Price),
+			Quantity:  uint32(bid.Quantity),
+			Liquidity: formatLiquidity(bid),
+			Fee:       bid.Fee,
+		},
+		Ask: TradeSide{
+			OrderId:   uint64(ask.OrderId),
+			Price:     int32(ask.Price),
+			Quantity:  uint32(ask.Quantity),
+			Liquidity: formatLiquidity(ask),
+			Fee:       ask.Fee,
+		},
+	}
+	s.trades = append(s.trades, trade)
+	s.tradeIds[tradeKey{symbol, trade.MatchId}] = trade.Id
+	if clients := book.subscribers[ChannelTrades]; len(clients) > 0 {
+		s.publish(clients, TradeUpdate{Type: "trade", Sequence: second.Sequence, Trade: trade})
+	}
+}
+
+// amendTrade applies the bust or correction of one side of a trade to the
+// trade log, and publishes the trade once both sides are amended.
+func (s *Server) amendTrade(symbol engine.Symbol, book *bookState, ev orderbook.Event) {
+	id, ok := s.tradeIds[tradeKey{symbol, ev.MatchId}]
+	if !ok {
+		return
+	}
+	trade, _ := s.trade(id)
+	side := &trade.Bid
+	if ev.Side == orderbook.Sell {
+		side = &trade.Ask
+	}
+	side.Fee += ev.Fee
+	if ev.Type == orderbook.TradeBusted {
+		trade.Status = TradeBusted
+	} else {
+		trade.Status = TradeCorrected
+		trade.Price = int32(ev.TradePrice)
+	}
+
+	// The engine amends the buy side first.
+	if clients := book.subscribers[ChannelTrades]; ev.Side == orderbook.Sell && len(clients) > 0 {
+		s.publish(clients, TradeUpdate{Type: "trade", Sequence: ev.Sequence, Trade: *trade})
+	}
+}
+
+func formatLiquidity(ev orderbook.Event) string {
+	if ev.Aggressor {
+		return "taker"
+	}
+	return "maker"
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "orders":
+		if !allow(w, r, http.MethodPost) {
+			return
+		}
+		if s.accepted == nil {
+			s.enterOrder(w, r)
+			return
+		}
+		rec := &codeRecorder{ResponseWriter: w}
+		s.enterOrder(rec, r)
+		if rec.code == "" {
+			s.accepted.Inc()
+		} else {
+			s.rejected.Inc(rec.code)
+		}
+	case len(parts) == 2 && parts[0] == "orders":
+		if !allow(w, r, http.MethodGet, http.MethodPatch, http.MethodDelete) {
+			return
+		}
+		id, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid order id %q", parts[1])
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			s.getOrder(w, r, orderbook.OrderId(id))
+		case http.MethodPatch:
+			s.modifyOrder(w, r, orderbook.OrderId(id))
+		case http.MethodDelete:
+			s.cancelOrder(w, r, orderbook.OrderId(id))
+		}
+	case len(parts) == 2 && parts[0] == "book":
+		if allow(w, r, http.MethodGet) {
+			s.getBook(w, r, engine.Symbol(parts[1]))
+		}
+	case len(parts) == 1 && parts[0] == "trades":
+		if allow(w, r, http.MethodGet) {
+			s.getTrades(w, r)
+		}
+	case len(parts) == 1 && parts[0] == "positions" && s.ledger != nil:
+		if allow(w, r, http.MethodGet) {
+			s.getPositions(w, r)
+		}
+	case len(parts) == 4 && parts[0] == "admin" && parts[1] == "trades" &&
+		(parts[3] == "bust" || parts[3] == "correct"):
+		if !allow(w, r, http.MethodPost) {
+			return
+		}
+		id, err := strconv.ParseUint(parts[2], 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid trade id %q", parts[2])
+			return
+		}
+		s.correctTrade(w, r, id, parts[3] == "bust")
+	case len(parts) == 2 && parts[0] == "admin" && parts[1] == "corrections":
+		if allow(w, r, http.MethodGet) {
+			s.getCorrections(w, r)
+		}
+	case len(parts) == 1 && parts[0] == "stream":
+		if allow(w, r, http.MethodGet) {
+			s.stream(w, r)
+		}
+	case len(parts) == 1 && parts[0] == "schema":
+		if allow(w, r, http.MethodGet) {
+			w.Header().Set("Content-Type", "application/schema+json")
+			w.Write(schema)
+		}
+	default:
+		writeError(w, http.StatusNotFound, CodeNotFound, "no such resource %s", r.URL.Path)
+	}
+}
+
+func allow(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method %s not allowed", r.Method)
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, format string, args ...any) {
+	if rec, ok := w.(*codeRecorder); ok {
+		rec.code = code
+	}
+	writeJSON(w, status, Error{Error: ErrorDetail{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}})
+}
+
+// codeRecorder remembers the error code of a response.
+type codeRecorder struct {
+	http.ResponseWriter
+	code string
+}
+
+// writeEngineError answers with the status and code of an error returned by
+// the engine or by a book.
+func writeEngineError(w http.ResponseWriter, err error) {
+	var reject *risk.Reject
+	switch {
+	case errors.As(err, &reject):
+		writeError(w, http.StatusUnprocessableEntity, CodeRiskRejected, "%v", err)
+	case errors.Is(err, engine.ErrUnknownSymbol):
+		writeError(w, http.StatusNotFound, CodeUnknownSymbol, "%v", err)
+	case errors.Is(err, engine.ErrUnknownOrder), errors.Is(err, orderbook.ErrUnknownOrder):
+		writeError(w, http.StatusConflict, CodeOrderNotOpen, "%v", err)
+	case errors.Is(err, engine.ErrDuplicateOrder), errors.Is(err, orderbook.ErrOrderExists):
+		writeError(w, http.StatusConflict, CodeDuplicateOrder, "%v", err)
+	case errors.Is(err, engine.ErrUnknownTrade):
+		writeError(w, http.StatusNotFound, CodeUnknownTrade, "%v", err)
+	case errors.Is(err, engine.ErrTradeBusted):
+		writeError(w, http.StatusConflict, CodeTradeBusted, "%v", err)
+	default:
+		writeError(w, http.StatusUnprocessableEntity, CodeOrderRejected, "%v", err)
+	}
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid body: %v", err)
+		return false
+	}
+	return true
+}
+
+// account authenticates a request. Without an Authenticator every request
+// is accepted with an empty account.
+func (s *Server) account(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if s.auth == nil {
+		return "", true
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if ok {
+		if account, ok := s.auth(token); ok {
+			return account, true
+		}
+	}
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	writeError(w, http.StatusUnauthorized, CodeUnauthorized, "missing or invalid bearer token")
+	return "", false
+}
+
+// admin authenticates a request and checks that its account is an admin.
+func (s *Server) admin(w http.ResponseWriter, r *http.Request) (string, bool) {
+	account, ok := s.account(w, r)
+	if !ok {
+		return "", false
+	}
+	if !s.admins[account] {
+		writeError(w, http.StatusForbidden, CodeForbidden, "admin requests are not allowed for this account")
+		return "", false
+	}
+	return account, true
+}
+
+// tradesOf returns the first n trades of an order logged after the first
+// start trades. A request that matches an order logs its n trades before any
+// later request can match it again. It should only be called with s.m held.
+func (s *Server) tradesOf(orderId orderbook.OrderId, start uint64, n int) []Trade {
+	trades := make([]Trade, 0, n)
+	first := uint64(0)
+	if start > s.forgotten {
+		first = start - s.forgotten
+	}
+	for _, t := range s.trades[first:] {
+		if len(trades) == n {
+			break
+		}
+		if t.Bid.OrderId == uint64(orderId) || t.Ask.OrderId == uint64(orderId) {
+			trades = append(trades, t)
+		}
+	}
+	return trades
+}
+
+func (s *Server) enterOrder(w http.ResponseWriter, r *http.Request) {
+	account, ok := s.account(w, r)
+	if !ok {
+		return
+	}
+	var req OrderRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	orderType, err := parseType(req.Type)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "%v", err)
+		return
+	}
+	side, err := parseSide(req.Side)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "%v", err)
+		return
+	}
+	switch {
+	case req.Symbol == "":
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "missing symbol")
+		return
+	case req.Quantity == 0:
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "quantity must be positive")
+		return
+	case orderType != orderbook.Market && req.Price <= 0:
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "price must be positive")
+		return
+	}
+	if orderType == orderbook.Market {
+		req.Price = 0
+	}
+
+	for {
+		// Register the order before it enters the book, so that the
+		// listener tracks it from its first event.
+		s.m.Lock()
+		var orderId orderbook.OrderId
+		if req.Id != nil {
+			orderId = orderbook.OrderId(*req.Id)
+			if _, exists := s.orders[orderId]; exists {
+				s.m.Unlock()
+				writeError(w, http.StatusConflict, CodeDuplicateOrder, "order %d already exists", orderId)
+				return
+			}
+		} else {
+			for {
+				orderId = s.nextOrderId
+				s.nextOrderId++
+				if _, exists := s.orders[orderId]; !exists {
+					break
+				}
+			}
+		}
+		s.orders[orderId] = &Order{
+			Id:       uint64(orderId),
+			Account:  account,
+			Symbol:   req.Symbol,
+			Type:     req.Type,
+			Side:     req.Side,
+			Price:    req.Price,
+			Quantity: req.Quantity,
+			Status:   StatusOpen,
+		}
+		start := s.logged()
+		s.m.Unlock()
+
+		trades, err := s.addOrder(
+			account,
+			engine.Symbol(req.Symbol),
+			orderbook.NewOrder(
+				orderType,
+				orderId,
+				side,
+				orderbook.Price(req.Price),
+				orderbook.Quantity(req.Quantity),
+			),
+		)
+
+		s.m.Lock()
+		if err != nil {
+			delete(s.orders, orderId)
+			s.m.Unlock()
+			if req.Id == nil && errors.Is(err, engine.ErrDuplicateOrder) {
+				continue
+			}
+			writeEngineError(w, err)
+			return
+		}
+		resp := OrderResponse{
+			Order:  *s.orders[orderId],
+			Trades: s.tradesOf(orderId, start, len(trades)),
+		}
+		s.m.Unlock()
+
+		writeJSON(w, http.StatusCreated, resp)
+		return
+	}
+}
+
+// order returns the state of an order of the account of the request,
+// answering the request if there is none.
+func (s *Server) order(w http.ResponseWriter, r *http.Request, orderId orderbook.OrderId) (Order, uint64, bool) {
+	account, ok := s.account(w, r)
+	if !ok {
+		return Order{}, 0, false
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	o, exists := s.orders[orderId]
+	if !exists || o.Account != account {
+		writeError(w, http.StatusNotFound, CodeUnknownOrder, "unknown order %d", orderId)
+		return Order{}, 0, false
+	}
+	return *o, s.logged(), true
+}
+
+func (s *Server) getOrder(w http.ResponseWriter, r *http.Request, orderId orderbook.OrderId) {
+	if o, _, ok := s.order(w, r, orderId); ok {
+		writeJSON(w, http.StatusOK, o)
+	}
+}
+
+func (s *Server) modifyOrder(w http.ResponseWriter, r *http.Request, orderId orderbook.OrderId) {
+	o, start, ok := s.order(w, r, orderId)
+	if !ok {
+		return
+	}
+	var req ModifyRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if o.Status != StatusOpen {
+		writeError(w, http.StatusConflict, CodeOrderNotOpen, "order %d is %s", orderId, o.Status)
+		return
+	}
+
+	sideName, price, quantity := o.Side, o.Price, o.Open
+	if req.Side != nil {
+		sideName = *req.Side
+	}
+	if req.Price != nil {
+		price = *req.Price
+	}
+	if req.Quantity != nil {
+		quantity = *req.Quantity
+	}
+	side, err := parseSide(sideName)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "%v", err)
+		return
+	}
+	switch {
+	case quantity == 0:
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "quantity must be positive")
+		return
+	case price <= 0:
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "price must be positive")
+		return
+	}
+
+	var modify orderbook.OrderModify
+	trades, err := s.modify(o.Account, modify.New(
+		orderId,
+		orderbook.Price(price),
+		side,
+		orderbook.Quantity(quantity),
+	))
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+
+	s.m.Lock()
+	resp := OrderResponse{
+		Order:  *s.orders[orderId],
+		Trades: s.tradesOf(orderId, start, len(trades)),
+	}
+	s.m.Unlock()
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) cancelOrder(w http.ResponseWriter, r *http.Request, orderId orderbook.OrderId) {
+	o, _, ok := s.order(w, r, orderId)
+	if !ok {
+		return
+	}
+	if err := s.cancel(o.Account, orderId); err != nil {
+		writeEngineError(w, err)
+		return
+	}
+	s.m.Lock()
+	o = *s.orders[orderId]
+	s.m.Unlock()
+	writeJSON(w, http.StatusOK, o)
+}
+
+func (s *Server) getBook(w http.ResponseWriter, r *http.Request, symbol engine.Symbol) {
+	depth := -1
+	if v := r.URL.Query().Get("depth"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid depth %q", v)
+			return
+		}
+		depth = n
+	}
+
+	info, err := s.engine.OrderInfo(symbol)
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, Book{
+		Symbol: string(symbol),
+		Bids:   levels(info.GetBids(), depth),
+		Asks:   levels(info.GetAsks(), depth),
+	})
+}
+
+func levels(info orderbook.LevelsInfo, depth int) []Level {
+	if depth >= 0 && len(info) > depth {
+		info = info[:depth]
+	}
+	levels := make([]Level, 0, len(info))
+	for _, l := range info {
+		levels = append(levels, Level{Price: int32(l.Price), Quantity: uint32(l.Quantity)})
+	}
+	return levels
+}
+
+func (s *Server) getTrades(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	symbol := query.Get("symbol")
+	var after uint64
+	if v := query.Get("after"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid after %q", v)
+			return
+		}
+		after = n
+	}
+	limit := defaultTradeLimit
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxTradeLimit {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest,
+				"limit must be between 1 and %d", maxTradeLimit)
+			return
+		}
+		limit = n
+	}
+
+	list := TradeList{Trades: []Trade{}}
+	s.m.Lock()
+	// Trade ids are positions in the log, starting at 1.
+	if after < s.forgotten {
+		after = s.forgotten
+	}
+	if after < s.logged() {
+		for _, t := range s.trades[after-s.forgotten:] {
+			if len(list.Trades) == limit {
+				break
+			}
+			if symbol == "" || t.Symbol == symbol {
+				list.Trades = append(list.Trades, t)
+			}
+		}
+	}
+	s.m.Unlock()
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) getPositions(w http.ResponseWriter, r *http.Request) {
+	account, ok := s.account(w, r)
+	if !ok {
+		return
+	}
+	list := PositionList{Positions: []Position{}}
+	for _, p := range s.ledger.Positions(account) {
+		// Without an Authenticator every order has the empty account, and
+		// Positions of "" would list every account.
+		if p.Account != account {
+			continue
+		}
+		list.Positions = append(list.Positions, Position{
+			Symbol:       string(p.Symbol),
+			Quantity:     p.Quantity,
+			AveragePrice: p.AveragePrice(),
+			Bought:       p.Bought,
+			Sold:         p.Sold,
+			Mark:         int32(p.Mark),
+			Realized:     p.Realized,
+			Unrealized:   p.Unrealized,
+		})
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) correctTrade(w http.ResponseWriter, r *http.Request, id uint64, bust bool) {
+	operator, ok := s.admin(w, r)
+	if !ok {
+		return
+	}
+	var req CorrectionRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	switch {
+	case bust && req.Price != nil:
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "a bust takes no price")
+		return
+	case !bust && (req.Price == nil || *req.Price <= 0):
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, "price must be positive")
+		return
+	}
+
+	s.m.Lock()
+	var trade Trade
+	if t, ok := s.trade(id); ok {
+		trade = *t
+	}
+	s.m.Unlock()
+	if trade.Id == 0 {
+		writeError(w, http.StatusNotFound, CodeUnknownTrade, "trade %d does not exist", id)
+		return
+	}
+
+	var (
+		c   engine.Correction
+		err error
+	)
+	symbol := engine.Symbol(trade.Symbol)
+	if bust {
+		c, err = s.engine.BustTrade(symbol, trade.MatchId, operator, req.Reason)
+	} else {
+		c, err = s.engine.CorrectTrade(symbol, trade.MatchId, orderbook.Price(*req.Price), operator, req.Reason)
+	}
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+	if s.audit != nil {
+		s.audit.Amended(c)
+	}
+
+	s.m.Lock()
+	resp := s.correction(c)
+	s.m.Unlock()
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) getCorrections(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.admin(w, r); !ok {
+		return
+	}
+	corrections := s.engine.Corrections()
+	list := CorrectionList{Corrections: make([]Correction, 0, len(corrections))}
+	s.m.Lock()
+	for _, c := range corrections {
+		list.Corrections = append(list.Corrections, s.correction(c))
+	}
+	s.m.Unlock()
+	writeJSON(w, http.StatusOK, list)
+}
+
+// correction converts the audit record of the engine. It should only be
+// called with s.m held.
+func (s *Server) correction(c engine.Correction) Correction {
+	return Correction{
+		Type:        c.Type.String(),
+		Time:        c.Time,
+		Operator:    c.Operator,
+		Reason:      c.Reason,
+		TradeId:     s.tradeIds[tradeKey{c.Symbol, c.MatchId}],
+		Symbol:      string(c.Symbol),
+		MatchId:     c.MatchId,
+		BuyOrderId:  uint64(c.BuyOrderId),
+		SellOrderId: uint64(c.SellOrderId),
+		Quantity:    uint32(c.Quantity),
+		OldPrice:    int32(c.OldPrice),
+		NewPrice:    int32(c.NewPrice),
+	}
+}
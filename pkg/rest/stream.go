@@ -0,0 +1,262 @@
+package rest
+
+import (
+	"encoding/json"
+	"go-orderbook/pkg/engine"
+	"go-orderbook/pkg/websocket"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// sendQueueSize is the number of messages a stream client may fall
+	// behind by. A client whose queue is full is disconnected.
+	sendQueueSize = 1024
+
+	heartbeatInterval = 15 * time.Second
+	// readTimeout bounds the time between frames from a client, so that a
+	// client that stops answering pings is disconnected.
+	readTimeout = 3 * heartbeatInterval
+)
+
+type subscription struct {
+	channel string
+	symbol  engine.Symbol
+}
+
+// client is a stream connection. Its fields, except conn and send, are
+// guarded by Server.m.
+type client struct {
+	conn *websocket.Conn
+	// send queues encoded messages for the writer. It is closed, with the
+	// reason in closeCode and closeReason, when the client is dropped.
+	send          chan []byte
+	closed        bool
+	closeCode     int
+	closeReason   string
+	account       string
+	private       bool
+	subscriptions map[subscription]struct{}
+}
+
+// publish queues a message for every client of a set. It should only be
+// called with s.m held.
+func (s *Server) publish(clients map[*client]struct{}, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	for c := range clients {
+		s.enqueue(c, b)
+	}
+}
+
+// enqueue queues an encoded message without blocking, dropping the client if
+// it is too far behind. It should only be called with s.m held.
+func (s *Server) enqueue(c *client, b []byte) {
+	if c.closed {
+		return
+	}
+	select {
+	case c.send <- b:
+	default:
+		s.drop(c, websocket.ClosePolicyViolation, "slow consumer")
+	}
+}
+
+func (s *Server) reply(c *client, v any) {
+	if b, err := json.Marshal(v); err == nil {
+		s.enqueue(c, b)
+	}
+}
+
+// drop unsubscribes a client and makes its writer close the connection. It
+// should only be called with s.m held.
+func (s *Server) drop(c *client, code int, reason string) {
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.closeCode = code
+	c.closeReason = reason
+	for sub := range c.subscriptions {
+		delete(s.books[sub.symbol].subscribers[sub.channel], c)
+	}
+	if c.private {
+		delete(s.private[c.account], c)
+		if len(s.private[c.account]) == 0 {
+			delete(s.private, c.account)
+		}
+	}
+	close(c.send)
+}
+
+func (s *Server) stream(w http.ResponseWriter, r *http.Request) {
+	c := &client{
+		send:          make(chan []byte, sendQueueSize),
+		subscriptions: make(map[subscription]struct{}),
+	}
+	if s.auth != nil {
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			c.account, _ = s.auth(token)
+		}
+	}
+
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	c.conn = conn
+	conn.SetReadTimeout(readTimeout)
+	conn.SetMaxMessageSize(4096)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.write(c)
+	}()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		var req StreamRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			s.m.Lock()
+			s.reply(c, Notice{Type: "error", Message: "invalid request: " + err.Error()})
+			s.m.Unlock()
+			continue
+		}
+		s.handle(c, req)
+	}
+
+	s.m.Lock()
+	s.drop(c, websocket.CloseNormal, "")
+	s.m.Unlock()
+	<-done
+}
+
+// write sends the queued messages of a client and its heartbeats until the
+// client is dropped or a write fails.
+func (s *Server) write(c *client) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case b, ok := <-c.send:
+			if !ok {
+				c.conn.Close(c.closeCode, c.closeReason)
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.OpText, b); err != nil {
+				c.conn.Close(websocket.CloseGoingAway, "")
+				return
+			}
+		case now := <-ticker.C:
+			b, _ := json.Marshal(Heartbeat{Type: "heartbeat", Time: now.UTC()})
+			if c.conn.WriteControl(websocket.OpPing, nil) != nil ||
+				c.conn.WriteMessage(websocket.OpText, b) != nil {
+				c.conn.Close(websocket.CloseGoingAway, "")
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) handle(c *client, req StreamRequest) {
+	symbol := engine.Symbol(req.Symbol)
+	public := req.Channel == ChannelL1 || req.Channel == ChannelL2 || req.Channel == ChannelTrades
+	listed := true
+	if public {
+		// The engine must not be called with s.m held.
+		_, listed = s.engine.Book(symbol)
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	// A dropped client must not subscribe again.
+	if c.closed {
+		return
+	}
+	notice := Notice{Channel: req.Channel, Symbol: req.Symbol}
+	fail := func(message string) {
+		notice.Type = "error"
+		notice.Message = message
+		s.reply(c, notice)
+	}
+
+	switch {
+	case req.Op == OpAuth:
+		notice.Type = "authenticated"
+		if s.auth == nil {
+			fail("authentication is not enabled")
+			return
+		}
+		if c.private {
+			fail("already subscribed to orders")
+			return
+		}
+		account, ok := s.auth(req.Token)
+		if !ok {
+			fail("invalid token")
+			return
+		}
+		c.account = account
+		s.reply(c, notice)
+	case req.Op != OpSubscribe && req.Op != OpUnsubscribe:
+		fail("unknown op " + req.Op)
+	case req.Channel == ChannelOrders:
+		if c.account == "" {
+			fail("authentication required")
+			return
+		}
+		if req.Op == OpSubscribe {
+			notice.Type = "subscribed"
+			c.private = true
+			if s.private[c.account] == nil {
+				s.private[c.account] = make(map[*client]struct{})
+			}
+			s.private[c.account][c] = struct{}{}
+		} else {
+			notice.Type = "unsubscribed"
+			c.private = false
+			delete(s.private[c.account], c)
+			if len(s.private[c.account]) == 0 {
+				delete(s.private, c.account)
+			}
+		}
+		s.reply(c, notice)
+	case !public:
+		fail("unknown channel " + req.Channel)
+	case !listed:
+		fail("unknown symbol " + req.Symbol)
+	default:
+		book := s.book(symbol)
+		sub := subscription{channel: req.Channel, symbol: symbol}
+		if req.Op == OpUnsubscribe {
+			notice.Type = "unsubscribed"
+			delete(c.subscriptions, sub)
+			delete(book.subscribers[req.Channel], c)
+			s.reply(c, notice)
+			return
+		}
+
+		notice.Type = "subscribed"
+		c.subscriptions[sub] = struct{}{}
+		if book.subscribers[req.Channel] == nil {
+			book.subscribers[req.Channel] = make(map[*client]struct{})
+		}
+		book.subscribers[req.Channel][c] = struct{}{}
+		s.reply(c, notice)
+		// The snapshot is queued under the same lock as the updates, so
+		// the first update follows it without a gap.
+		switch req.Channel {
+		case ChannelL1:
+			s.reply(c, book.l1(symbol))
+		case ChannelL2:
+			s.reply(c, book.l2(symbol))
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package rest
+
+import (
+	"encoding/json"
+	"go-orderbook/pkg/engine"
+	"go-orderbook/pkg/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type streamClient struct {
+	t    *testing.T
+	conn *websocket.Conn
+}
+
+func dialStream(t *testing.T, ts *httptest.Server) *streamClient {
+	conn, err := websocket.Dial("ws://"+strings.TrimPrefix(ts.URL, "http://")+"/stream", nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close(websocket.CloseNormal, "") })
+	conn.SetReadTimeout(2 * time.Second)
+	return &streamClient{t: t, conn: conn}
+}
+
+func (c *streamClient) send(req StreamRequest) {
+	b, _ := json.Marshal(req)
+	require.NoError(c.t, c.conn.WriteMessage(websocket.OpText, b))
+}
+
+// next reads the next message, which must be of the given type, into v.
+func (c *streamClient) next(typ string, v any) {
+	_, data, err := c.conn.ReadMessage()
+	require.NoError(c.t, err)
+	var header struct{ Type string }
+	require.NoError(c.t, json.Unmarshal(data, &header))
+	require.Equal(c.t, typ, header.Type, string(data))
+	if v != nil {
+		require.NoError(c.t, json.Unmarshal(data, v))
+	}
+}
+
+func TestStream(t *testing.T) {
+	ts := newTestServer(t, testAuth)
+
+	public := dialStream(t, ts)
+	var l2 L2Snapshot
+	public.send(StreamRequest{Op: OpSubscribe, Channel: ChannelL2, Symbol: "ACME"})
+	public.next("subscribed", nil)
+	public.next("l2_snapshot", &l2)
+	assert.Empty(t, l2.Bids)
+	var l1 L1Update
+	public.send(StreamRequest{Op: OpSubscribe, Channel: ChannelL1, Symbol: "ACME"})
+	public.next("subscribed", nil)
+	public.next("l1", &l1)
+	assert.Nil(t, l1.Bid)
+	public.send(StreamRequest{Op: OpSubscribe, Channel: ChannelTrades, Symbol: "ACME"})
+	public.next("subscribed", nil)
+	public.send(StreamRequest{Op: OpSubscribe, Channel: ChannelL2, Symbol: "NOPE"})
+	public.next("error", nil)
+
+	private := dialStream(t, ts)
+	private.send(StreamRequest{Op: OpSubscribe, Channel: ChannelOrders})
+	private.next("error", nil)
+	private.send(StreamRequest{Op: OpAuth, Token: "alice-token"})
+	private.next("authenticated", nil)
+	private.send(StreamRequest{Op: OpSubscribe, Channel: ChannelOrders})
+	private.next("subscribed", nil)
+
+	doAs(t, ts, "alice-token", http.MethodPost, "/orders",
+		`{"symbol":"ACME","type":"good_till_cancel","side":"sell","price":100,"quantity":10}`,
+		http.StatusCreated, nil)
+	doAs(t, ts, "bob-token", http.MethodPost, "/orders",
+		`{"symbol":"ACME","type":"good_till_cancel","side":"buy","price":100,"quantity":4}`,
+		http.StatusCreated, nil)
+
+	var update L2Update
+	public.next("l2", &update)
+	assert.Equal(t, L2Update{Type: "l2", Symbol: "ACME", Sequence: 1, Side: SideSell, Price: 100, Quantity: 10}, update)
+	public.next("l1", &l1)
+	assert.Equal(t, &Level{Price: 100, Quantity: 10}, l1.Ask)
+	public.next("l2", &update)
+	assert.Equal(t, uint32(4), update.Quantity)
+	public.next("l1", &l1)
+	assert.Equal(t, &Level{Price: 100, Quantity: 4}, l1.Bid)
+	public.next("l2", &update)
+	assert.Equal(t, uint32(0), update.Quantity)
+	l1 = L1Update{}
+	public.next("l1", &l1)
+	assert.Nil(t, l1.Bid)
+	public.next("l2", &update)
+	assert.Equal(t, L2Update{Type: "l2", Symbol: "ACME", Sequence: 4, Side: SideSell, Price: 100, Quantity: 6}, update)
+	public.next("l1", nil)
+	var trade TradeUpdate
+	public.next("trade", &trade)
+	assert.Equal(t, uint64(1), trade.Trade.Id)
+	assert.Equal(t, uint64(2), trade.Trade.Bid.OrderId)
+	assert.Equal(t, uint64(1), trade.Trade.Ask.OrderId)
+
+	// Only alice's order is reported on her private channel.
+	var order OrderUpdate
+	private.next("order", &order)
+	assert.Equal(t, "added", order.Event)
+	private.next("order", &order)
+	assert.Equal(t, "executed", order.Event)
+	assert.Equal(t, uint32(4), order.Quantity)
+	assert.Equal(t, uint32(6), order.Order.Open)
+
+	// A late subscriber starts from a snapshot of the current depth.
+	late := dialStream(t, ts)
+	late.send(StreamRequest{Op: OpSubscribe, Channel: ChannelL2, Symbol: "ACME"})
+	late.next("subscribed", nil)
+	late.next("l2_snapshot", &l2)
+	assert.Equal(t, uint64(4), l2.Sequence)
+	assert.Equal(t, []Level{{Price: 100, Quantity: 6}}, l2.Asks)
+}
+
+func TestSlowConsumer(t *testing.T) {
+	s := NewServer(engine.NewEngine(), nil)
+	c := &client{send: make(chan []byte, 1), subscriptions: make(map[subscription]struct{})}
+
+	s.m.Lock()
+	s.enqueue(c, []byte("{}"))
+	assert.False(t, c.closed)
+	s.enqueue(c, []byte("{}"))
+	s.m.Unlock()
+
+	assert.True(t, c.closed)
+	assert.Equal(t, websocket.ClosePolicyViolation, c.closeCode)
+	<-c.send
+	_, open := <-c.send
+	assert.False(t, open)
+}
+
+func TestDroppedClient(t *testing.T) {
+	e := engine.NewEngine()
+	require.NoError(t, e.List("DEMO"))
+	s := NewServer(e, nil)
+	c := &client{send: make(chan []byte, 4), subscriptions: make(map[subscription]struct{}), account: "alice"}
+
+	// Unsubscribing the last client of an account forgets the account.
+	s.handle(c, StreamRequest{Op: OpSubscribe, Channel: ChannelOrders})
+	assert.Len(t, s.private["alice"], 1)
+	s.handle(c, StreamRequest{Op: OpUnsubscribe, Channel: ChannelOrders})
+	assert.NotContains(t, s.private, "alice")
+
+	// A dropped client is not subscribed again.
+	s.m.Lock()
+	s.drop(c, websocket.CloseGoingAway, "")
+	s.m.Unlock()
+	s.handle(c, StreamRequest{Op: OpSubscribe, Channel: ChannelOrders})
+	s.handle(c, StreamRequest{Op: OpSubscribe, Channel: ChannelTrades, Symbol: "DEMO"})
+	assert.NotContains(t, s.private, "alice")
+	assert.Empty(t, c.subscriptions)
+	assert.NotContains(t, s.books, engine.Symbol("DEMO"))
+}
@@ -0,0 +1,330 @@
+package rest
+
+import (
+	"fmt"
+	"go-orderbook/pkg/orderbook"
+	"time"
+)
+
+// Order types, as they appear in requests and responses.
+const (
+	TypeMarket         = "market"
+	TypeGoodTillCancel = "good_till_cancel"
+	TypeGoodForDay     = "good_for_day"
+	TypeFillAndKill    = "fill_and_kill"
+	TypeFillOrKill     = "fill_or_kill"
+)
+
+// Sides.
+const (
+	SideBuy  = "buy"
+	SideSell = "sell"
+)
+
+// Order statuses.
+const (
+	StatusOpen     = "open"
+	StatusFilled   = "filled"
+	StatusCanceled = "canceled"
+)
+
+// Trade statuses. A busted or corrected trade keeps its id.
+const (
+	TradeExecuted  = "executed"
+	TradeBusted    = "busted"
+	TradeCorrected = "corrected"
+)
+
+// Error codes.
+const (
+	CodeInvalidRequest   = "invalid_request"
+	CodeUnauthorized     = "unauthorized"
+	CodeUnknownSymbol    = "unknown_symbol"
+	CodeUnknownOrder     = "unknown_order"
+	CodeDuplicateOrder   = "duplicate_order"
+	CodeOrderNotOpen     = "order_not_open"
+	CodeOrderRejected    = "order_rejected"
+	CodeRiskRejected     = "risk_rejected"
+	CodeForbidden        = "forbidden"
+	CodeUnknownTrade     = "unknown_trade"
+	CodeTradeBusted      = "trade_busted"
+	CodeNotFound         = "not_found"
+	CodeMethodNotAllowed = "method_not_allowed"
+)
+
+// OrderRequest is the body of POST /orders. The server assigns an id when Id
+// is omitted. Price is ignored for market orders.
+type OrderRequest struct {
+	Id       *uint64 `json:"id,omitempty"`
+	Symbol   string  `json:"symbol"`
+	Type     string  `json:"type"`
+	Side     string  `json:"side"`
+	Price    int32   `json:"price"`
+	Quantity uint32  `json:"quantity"`
+}
+
+// ModifyRequest is the body of PATCH /orders/{id}. Omitted fields keep their
+// current value. Quantity is the new open quantity; lowering it at the same
+// side and price keeps the time priority of the order.
+type ModifyRequest struct {
+	Side     *string `json:"side,omitempty"`
+	Price    *int32  `json:"price,omitempty"`
+	Quantity *uint32 `json:"quantity,omitempty"`
+}
+
+// Order is the state of an order entered through the API. Account is the
+// account that entered it, when the server authenticates requests.
+type Order struct {
+	Id       uint64 `json:"id"`
+	Account  string `json:"account,omitempty"`
+	Symbol   string `json:"symbol"`
+	Type     string `json:"type"`
+	Side     string `json:"side"`
+	Price    int32  `json:"price"`
+	Quantity uint32 `json:"quantity"`
+	Open     uint32 `json:"open"`
+	Filled   uint32 `json:"filled"`
+	Status   string `json:"status"`
+	// Fees is the sum of the fees of its trades.
+	Fees int64 `json:"fees"`
+}
+
+// OrderResponse answers POST and PATCH /orders with the order and the trades
+// the request caused.
+type OrderResponse struct {
+	Order  Order   `json:"order"`
+	Trades []Trade `json:"trades"`
+}
+
+// TradeSide is one order of a trade, with the limit price of the order.
+// Liquidity is "maker" for the resting order and "taker" for the order that
+// matched it. Fees are in millionths of a price tick times a share, negative
+// for a rebate.
+type TradeSide struct {
+	OrderId   uint64 `json:"order_id"`
+	Price     int32  `json:"price"`
+	Quantity  uint32 `json:"quantity"`
+	Liquidity string `json:"liquidity"`
+	Fee       int64  `json:"fee"`
+}
+
+// Trade is a match between a bid and an ask. Ids increase with every trade.
+// MatchId identifies the trade within the book of its symbol. Price is the
+// price the trade executed at, the price of the resting order, or the
+// corrected price of a corrected trade. Trades are kept for
+// engine.TradeRetention.
+type Trade struct {
+	Id      uint64    `json:"id"`
+	Symbol  string    `json:"symbol"`
+	MatchId uint64    `json:"match_id"`
+	Time    time.Time `json:"time"`
+	Status  string    `json:"status"`
+	Price   int32     `json:"price"`
+	Bid     TradeSide `json:"bid"`
+	Ask     TradeSide `json:"ask"`
+}
+
+// TradeList is the body of GET /trades.
+type TradeList struct {
+	Trades []Trade `json:"trades"`
+}
+
+// Level is the aggregated quantity at a price.
+type Level struct {
+	Price    int32  `json:"price"`
+	Quantity uint32 `json:"quantity"`
+}
+
+// Book is the body of GET /book/{symbol}, best levels first.
+type Book struct {
+	Symbol string  `json:"symbol"`
+	Bids   []Level `json:"bids"`
+	Asks   []Level `json:"asks"`
+}
+
+// Position is the holding of the account of the request in one symbol.
+// Prices are in ticks and P&L in ticks times shares; unrealized P&L is
+// valued at mark, which is zero until the symbol trades.
+type Position struct {
+	Symbol       string  `json:"symbol"`
+	Quantity     int64   `json:"quantity"`
+	AveragePrice float64 `json:"average_price"`
+	Bought       uint64  `json:"bought"`
+	Sold         uint64  `json:"sold"`
+	Mark         int32   `json:"mark"`
+	Realized     int64   `json:"realized"`
+	Unrealized   int64   `json:"unrealized"`
+}
+
+// PositionList is the body of GET /positions.
+type PositionList struct {
+	Positions []Position `json:"positions"`
+}
+
+// CorrectionRequest is the body of POST /admin/trades/{id}/bust and
+// /admin/trades/{id}/correct. Price is the corrected price, and is only
+// allowed for a correction.
+type CorrectionRequest struct {
+	Price  *int32 `json:"price,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// Correction is the audit record of a bust or price correction of a trade,
+// made by the Operator account. NewPrice is zero for a bust.
+type Correction struct {
+	Type        string    `json:"type"`
+	Time        time.Time `json:"time"`
+	Operator    string    `json:"operator"`
+	Reason      string    `json:"reason"`
+	TradeId     uint64    `json:"trade_id"`
+	Symbol      string    `json:"symbol"`
+	MatchId     uint64    `json:"match_id"`
+	BuyOrderId  uint64    `json:"buy_order_id"`
+	SellOrderId uint64    `json:"sell_order_id"`
+	Quantity    uint32    `json:"quantity"`
+	OldPrice    int32     `json:"old_price"`
+	NewPrice    int32     `json:"new_price"`
+}
+
+// CorrectionList is the body of GET /admin/corrections, oldest first.
+type CorrectionList struct {
+	Corrections []Correction `json:"corrections"`
+}
+
+// Stream channels. L1, L2 and trades are public and per symbol; orders is the
+// private channel of an authenticated account.
+const (
+	ChannelL1     = "l1"
+	ChannelL2     = "l2"
+	ChannelTrades = "trades"
+	ChannelOrders = "orders"
+)
+
+// Stream operations sent by clients.
+const (
+	OpAuth        = "auth"
+	OpSubscribe   = "subscribe"
+	OpUnsubscribe = "unsubscribe"
+)
+
+// StreamRequest is a message sent by a stream client.
+type StreamRequest struct {
+	Op      string `json:"op"`
+	Channel string `json:"channel,omitempty"`
+	Symbol  string `json:"symbol,omitempty"`
+	Token   string `json:"token,omitempty"`
+}
+
+// Notice answers a StreamRequest. Its type is "authenticated",
+// "subscribed", "unsubscribed" or "error".
+type Notice struct {
+	Type    string `json:"type"`
+	Channel string `json:"channel,omitempty"`
+	Symbol  string `json:"symbol,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// Heartbeat is sent periodically to every stream client.
+type Heartbeat struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+}
+
+// L1Update has type "l1" and carries the top of book, sent on subscription
+// and whenever it changes. An empty side is omitted. Sequence is the
+// sequence number of the book event that caused it.
+type L1Update struct {
+	Type     string `json:"type"`
+	Symbol   string `json:"symbol"`
+	Sequence uint64 `json:"sequence"`
+	Bid      *Level `json:"bid,omitempty"`
+	Ask      *Level `json:"ask,omitempty"`
+}
+
+// L2Snapshot has type "l2_snapshot" and carries the full depth of a book,
+// sent on subscription. L2Updates follow from the next sequence number.
+type L2Snapshot struct {
+	Type     string  `json:"type"`
+	Symbol   string  `json:"symbol"`
+	Sequence uint64  `json:"sequence"`
+	Bids     []Level `json:"bids"`
+	Asks     []Level `json:"asks"`
+}
+
+// L2Update has type "l2" and sets the quantity of a single level. A zero
+// quantity removes the level. Updates are sent for every book event but
+// trade busts and corrections, which leave the depth untouched and are sent
+// on the trades channel, so any other gap in Sequence means an update was
+// missed.
+type L2Update struct {
+	Type     string `json:"type"`
+	Symbol   string `json:"symbol"`
+	Sequence uint64 `json:"sequence"`
+	Side     string `json:"side"`
+	Price    int32  `json:"price"`
+	Quantity uint32 `json:"quantity"`
+}
+
+// TradeUpdate has type "trade".
+type TradeUpdate struct {
+	Type     string `json:"type"`
+	Sequence uint64 `json:"sequence"`
+	Trade    Trade  `json:"trade"`
+}
+
+// OrderUpdate has type "order" and reports a change to an order of the
+// account: "added", "executed", "reduced" or "deleted" by Quantity, or an
+// execution "busted" or "corrected" after the fact. Order is its state
+// after the change.
+type OrderUpdate struct {
+	Type     string `json:"type"`
+	Event    string `json:"event"`
+	Quantity uint32 `json:"quantity"`
+	MatchId  uint64 `json:"match_id,omitempty"`
+	Fee      int64  `json:"fee,omitempty"`
+	Order    Order  `json:"order"`
+}
+
+// ErrorDetail describes a failed request.
+type ErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error is the body of every response with an error status.
+type Error struct {
+	Error ErrorDetail `json:"error"`
+}
+
+func parseType(s string) (orderbook.OrderType, error) {
+	switch s {
+	case TypeMarket:
+		return orderbook.Market, nil
+	case TypeGoodTillCancel:
+		return orderbook.GoodTillCancel, nil
+	case TypeGoodForDay:
+		return orderbook.GoodForDay, nil
+	case TypeFillAndKill:
+		return orderbook.FillAndKill, nil
+	case TypeFillOrKill:
+		return orderbook.FillOrKill, nil
+	}
+	return 0, fmt.Errorf("invalid order type %q", s)
+}
+
+func parseSide(s string) (orderbook.Side, error) {
+	switch s {
+	case SideBuy:
+		return orderbook.Buy, nil
+	case SideSell:
+		return orderbook.Sell, nil
+	}
+	return 0, fmt.Errorf("invalid side %q", s)
+}
+
+func formatSide(s orderbook.Side) string {
+	if s == orderbook.Sell {
+		return SideSell
+	}
+	return SideBuy
+}
@@ -0,0 +1,417 @@
+// Package risk checks orders against per-account limits before they reach
+// the engine, and keeps the exposure of every account up to date from the
+// events of the books.
+package risk
+
+import (
+	"fmt"
+	"go-orderbook/pkg/engine"
+	"go-orderbook/pkg/orderbook"
+	"sync"
+)
+
+// Exposure is the state of an account in one symbol, or across all of them.
+type Exposure struct {
+	OpenOrders int
+	// OpenBuy and OpenSell are the open shares of the resting orders.
+	OpenBuy  int64
+	OpenSell int64
+	// Net is the shares bought less the shares sold.
+	Net int64
+	// Volume is the shares traded since the last reset.
+	Volume uint64
+}
+
+// Gross is the absolute net position plus every open order.
+func (e Exposure) Gross() int64 {
+	net := e.Net
+	if net < 0 {
+		net = -net
+	}
+	return net + e.OpenBuy + e.OpenSell
+}
+
+type key struct {
+	account string
+	// symbol is empty for the totals of the account.
+	symbol engine.Symbol
+}
+
+type openOrder struct {
+	key   key
+	side  orderbook.Side
+	price orderbook.Price
+	open  orderbook.Quantity
+}
+
+// Checker sends the orders of accounts to an engine once they pass the
+// limits of its Config. Only orders sent through the Checker count towards
+// exposure.
+type Checker struct {
+	engine *engine.Engine
+
+	// cmd serializes commands, so that every order is checked against an
+	// exposure that includes the orders sent before it.
+	cmd sync.Mutex
+
+	// m guards the fields below, which the engine listener updates as
+	// orders rest, fill and leave the book.
+	m         sync.Mutex
+	cfg       Config
+	exposures map[key]*Exposure
+	orders    map[orderbook.OrderId]*openOrder
+	// replacing holds the new state of an order being modified, until the
+	// book adds it again.
+	replacing map[orderbook.OrderId]*openOrder
+}
+
+// NewChecker creates a Checker for an engine. It follows the books from
+// their events, so the orders resting before it count for no exposure.
+func NewChecker(e *engine.Engine, cfg Config) *Checker {
+	c := &Checker{
+		engine:    e,
+		cfg:       cfg,
+		exposures: make(map[key]*Exposure),
+		orders:    make(map[orderbook.OrderId]*openOrder),
+		replacing: make(map[orderbook.OrderId]*openOrder),
+	}
+	if c.cfg.ReferencePrices == nil {
+		c.cfg.ReferencePrices = make(map[engine.Symbol]orderbook.Price)
+	}
+	e.AddListener(engine.ListenerFunc(c.onEvent))
+	return c
+}
+
+// SetReferencePrice sets the price limit orders in symbol may deviate from.
+func (c *Checker) SetReferencePrice(symbol engine.Symbol, price orderbook.Price) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.cfg.ReferencePrices[symbol] = price
+}
+
+// ResetDailyVolume starts a new trading day.
+func (c *Checker) ResetDailyVolume() {
+	c.m.Lock()
+	defer c.m.Unlock()
+	for _, e := range c.exposures {
+		e.Volume = 0
+	}
+}
+
+// Exposure returns the exposure of an account in a symbol, or across all
+// symbols when symbol is empty.
+func (c *Checker) Exposure(account string, symbol engine.Symbol) Exposure {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if e, ok := c.exposures[key{account, symbol}]; ok {
+		return *e
+	}
+	return Exposure{}
+}
+
+// exposure returns the exposure of a key, creating it on first use. It
+// should only be called with c.m held.
+func (c *Checker) exposure(k key) *Exposure {
+	e, ok := c.exposures[k]
+	if !ok {
+		e = &Exposure{}
+		c.exposures[k] = e
+	}
+	return e
+}
+
+// each calls fn with the exposure of the symbol of k and with the totals of
+// its account.
+func (c *Checker) each(k key, fn func(e *Exposure)) {
+	fn(c.exposure(k))
+	fn(c.exposure(key{account: k.account}))
+}
+
+func (c *Checker) reserve(orderId orderbook.OrderId, o *openOrder) {
+	c.orders[orderId] = o
+	c.each(o.key, func(e *Exposure) {
+		e.OpenOrders++
+		if o.side == orderbook.Buy {
+			e.OpenBuy += int64(o.open)
+		} else {
+			e.OpenSell += int64(o.open)
+		}
+	})
+}
+
+// reduce lowers the open shares of an order, releasing it once none are
+// left.
+func (c *Checker) reduce(orderId orderbook.OrderId, o *openOrder, quantity orderbook.Quantity) {
+	quantity = min(quantity, o.open)
+	o.open -= quantity
+	c.each(o.key, func(e *Exposure) {
+		if o.side == orderbook.Buy {
+			e.OpenBuy -= int64(quantity)
+		} else {
+			e.OpenSell -= int64(quantity)
+		}
+		if o.open == 0 {
+			e.OpenOrders--
+		}
+	})
+	if o.open == 0 {
+		delete(c.orders, orderId)
+	}
+}
+
+func (c *Checker) onEvent(symbol engine.Symbol, ev orderbook.Event) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if ev.Type == orderbook.OrderAdded {
+		if o, ok := c.replacing[ev.OrderId]; ok {
+			delete(c.replacing, ev.OrderId)
+			c.reserve(ev.OrderId, o)
+		}
+		return
+	}
+
+	o, ok := c.orders[ev.OrderId]
+	if !ok {
+		return
+	}
+	switch ev.Type {
+	case orderbook.OrderExecuted:
+		c.each(o.key, func(e *Exposure) {
+			if o.side == orderbook.Buy {
+				e.Net += int64(ev.Quantity)
+			} else {
+				e.Net -= int64(ev.Quantity)
+			}
+			e.Volume += uint64(ev.Quantity)
+		})
+		c.reduce(ev.OrderId, o, ev.Quantity)
+	case orderbook.OrderReduced:
+		c.reduce(ev.OrderId, o, ev.Quantity)
+	case orderbook.OrderDeleted:
+		c.reduce(ev.OrderId, o, o.open)
+	}
+}
+
+// marketPrice returns the highest price a market order of side could trade
+// at in symbol: the worst ask for a buy, which it may sweep up to, and the
+// best bid for a sell. It returns zero if the order cannot trade. It must
+// not be called with c.m held.
+func (c *Checker) marketPrice(symbol engine.Symbol, side orderbook.Side) orderbook.Price {
+	book, ok := c.engine.Book(symbol)
+	if !ok {
+		return 0
+	}
+	if side == orderbook.Sell {
+		bid, _ := book.Top()
+		return bid.Price
+	}
+	info := book.OrderInfo()
+	asks := info.GetAsks()
+	if len(asks) == 0 {
+		return 0
+	}
+	return asks[len(asks)-1].Price
+}
+
+// check returns a Reject if an order of account in the symbol of k would
+// breach a limit. A market order, of price zero, is valued at marketPrice,
+// or at the reference price if it is zero too. The exposure of replaced,
+// if not nil, is left out, as the order replaces it. It should only be
+// called with c.m held.
+func (c *Checker) check(
+	k key,
+	side orderbook.Side,
+	price orderbook.Price,
+	marketPrice orderbook.Price,
+	quantity orderbook.Quantity,
+	replaced *openOrder,
+) error {
+	reference := c.cfg.ReferencePrices[k.symbol]
+
+	scopes := []struct {
+		symbol engine.Symbol
+		limits Limits
+	}{
+		{k.symbol, c.cfg.instrumentLimits(k.account, k.symbol)},
+		{"", c.cfg.accountLimits(k.account)},
+	}
+	for _, scope := range scopes {
+		l := scope.limits
+		e := *c.exposure(key{k.account, scope.symbol})
+		if replaced != nil {
+			e.OpenOrders--
+			if replaced.side == orderbook.Buy {
+				e.OpenBuy -= int64(replaced.open)
+			} else {
+				e.OpenSell -= int64(replaced.open)
+			}
+		}
+
+		reject := func(reason Reason, limit, value int64) error {
+			return &Reject{
+				Reason:  reason,
+				Account: k.account,
+				Symbol:  scope.symbol,
+				Limit:   limit,
+				Value:   value,
+			}
+		}
+		q := int64(quantity)
+
+		if l.MaxOrderQuantity > 0 && quantity > l.MaxOrderQuantity {
+			return reject(ReasonOrderQuantity, int64(l.MaxOrderQuantity), q)
+		}
+		notionalPrice := price
+		if notionalPrice == 0 {
+			notionalPrice = marketPrice
+		}
+		if notionalPrice == 0 {
+			notionalPrice = reference
+		}
+		if notional := int64(notionalPrice) * q; l.MaxOrderNotional > 0 && notional > l.MaxOrderNotional {
+			return reject(ReasonOrderNotional, l.MaxOrderNotional, notional)
+		}
+		if l.MaxPriceDeviation > 0 && price > 0 && reference > 0 {
+			deviation := int64(price - reference)
+			if deviation < 0 {
+				deviation = -deviation
+			}
+			if bps := deviation * 10000 / int64(reference); bps > l.MaxPriceDeviation {
+				return reject(ReasonPriceDeviation, l.MaxPriceDeviation, bps)
+			}
+		}
+		if l.MaxOpenOrders > 0 && e.OpenOrders+1 > l.MaxOpenOrders {
+			return reject(ReasonOpenOrders, int64(l.MaxOpenOrders), int64(e.OpenOrders+1))
+		}
+		if gross := e.Gross() + q; l.MaxGrossPosition > 0 && gross > l.MaxGrossPosition {
+			return reject(ReasonGrossPosition, l.MaxGrossPosition, gross)
+		}
+		net := e.Net + e.OpenBuy + q
+		if side == orderbook.Sell {
+			net = e.OpenSell + q - e.Net
+		}
+		if l.MaxNetPosition > 0 && net > l.MaxNetPosition {
+			return reject(ReasonNetPosition, l.MaxNetPosition, net)
+		}
+		if volume := e.Volume + uint64(quantity); l.MaxDailyVolume > 0 && volume > l.MaxDailyVolume {
+			return reject(ReasonDailyVolume, int64(l.MaxDailyVolume), int64(volume))
+		}
+	}
+	return nil
+}
+
+// AddOrder checks an order of account and adds it to the book of symbol.
+// Breached limits are reported as a *Reject.
+func (c *Checker) AddOrder(
+	account string,
+	symbol engine.Symbol,
+	order orderbook.Order,
+) (orderbook.Trades, error) {
+	c.cmd.Lock()
+	defer c.cmd.Unlock()
+
+	var marketPrice orderbook.Price
+	if order.OrderType() == orderbook.Market {
+		marketPrice = c.marketPrice(symbol, order.Side())
+	}
+
+	orderId := order.OrderId()
+	c.m.Lock()
+	if _, exists := c.orders[orderId]; exists {
+		c.m.Unlock()
+		return nil, fmt.Errorf("%w: %d", engine.ErrDuplicateOrder, orderId)
+	}
+	k := key{account, symbol}
+	err := c.check(k, order.Side(), order.Price(), marketPrice, order.InitialQuantity(), nil)
+	if err != nil {
+		c.m.Unlock()
+		return nil, err
+	}
+	// The order is reserved before it enters the book, so that its fills
+	// are counted from the first event.
+	o := &openOrder{
+		key:   k,
+		side:  order.Side(),
+		price: order.Price(),
+		open:  order.InitialQuantity(),
+	}
+	c.reserve(orderId, o)
+	c.m.Unlock()
+
+	trades, err := c.engine.AddOrder(symbol, order)
+	if err != nil {
+		// A rejected order never reached the book, so no event released
+		// the reservation.
+		c.m.Lock()
+		c.reduce(orderId, o, o.open)
+		c.m.Unlock()
+	}
+	return trades, err
+}
+
+// owned returns an open order of account. It should only be called with c.m
+// held.
+func (c *Checker) owned(account string, orderId orderbook.OrderId) (*openOrder, error) {
+	o, ok := c.orders[orderId]
+	if !ok || o.key.account != account {
+		return nil, fmt.Errorf("%w: %d", engine.ErrUnknownOrder, orderId)
+	}
+	return o, nil
+}
+
+// ModifyOrder checks the new state of an open order of account and modifies
+// it. Reducing an order in place is never rejected.
+func (c *Checker) ModifyOrder(
+	account string,
+	modify orderbook.OrderModify,
+) (orderbook.Trades, error) {
+	c.cmd.Lock()
+	defer c.cmd.Unlock()
+
+	orderId := modify.OrderId()
+	c.m.Lock()
+	o, err := c.owned(account, orderId)
+	if err != nil {
+		c.m.Unlock()
+		return nil, err
+	}
+	reduce := modify.Side() == o.side &&
+		modify.Price() == o.price &&
+		modify.Quantity() <= o.open
+	if !reduce {
+		err := c.check(o.key, modify.Side(), modify.Price(), 0, modify.Quantity(), o)
+		if err != nil {
+			c.m.Unlock()
+			return nil, err
+		}
+		c.replacing[orderId] = &openOrder{
+			key:   o.key,
+			side:  modify.Side(),
+			price: modify.Price(),
+			open:  modify.Quantity(),
+		}
+	}
+	c.m.Unlock()
+
+	trades, err := c.engine.ModifyOrder(modify)
+
+	c.m.Lock()
+	delete(c.replacing, orderId)
+	c.m.Unlock()
+	return trades, err
+}
+
+// CancelOrder cancels an open order of account.
+func (c *Checker) CancelOrder(account string, orderId orderbook.OrderId) error {
+	c.cmd.Lock()
+	defer c.cmd.Unlock()
+
+	c.m.Lock()
+	_, err := c.owned(account, orderId)
+	c.m.Unlock()
+	if err != nil {
+		return err
+	}
+	return c.engine.CancelOrder(orderId)
+}
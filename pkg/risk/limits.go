@@ -0,0 +1,135 @@
+package risk
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-orderbook/pkg/engine"
+	"go-orderbook/pkg/orderbook"
+	"os"
+)
+
+// Any matches every account or every symbol in a Config.
+const Any = "*"
+
+// Limits caps the orders and exposure of an account. A zero field is no
+// limit. Quantities are in shares and notionals in price ticks times shares.
+type Limits struct {
+	MaxOrderQuantity orderbook.Quantity `json:"max_order_quantity,omitempty"`
+	MaxOrderNotional int64              `json:"max_order_notional,omitempty"`
+	MaxOpenOrders    int                `json:"max_open_orders,omitempty"`
+	// MaxGrossPosition caps the absolute net position plus every open
+	// order, on both sides.
+	MaxGrossPosition int64 `json:"max_gross_position,omitempty"`
+	// MaxNetPosition caps the absolute net position the account would have
+	// if all its open orders on one side were filled.
+	MaxNetPosition int64 `json:"max_net_position,omitempty"`
+	// MaxDailyVolume caps the shares traded since the last
+	// Checker.ResetDailyVolume, counting new orders as if fully filled.
+	MaxDailyVolume uint64 `json:"max_daily_volume,omitempty"`
+	// MaxPriceDeviation caps the distance of a limit price from the
+	// reference price of the symbol, in basis points.
+	MaxPriceDeviation int64 `json:"max_price_deviation_bps,omitempty"`
+}
+
+// Config holds the limits of every account. Accounts limits the totals of
+// an account across all symbols; Instruments, keyed by account and then
+// symbol, limits an account in a single symbol. Either key can be Any. The
+// most specific entry applies, the account taking precedence over the
+// symbol.
+type Config struct {
+	Accounts    map[string]Limits                   `json:"accounts,omitempty"`
+	Instruments map[string]map[engine.Symbol]Limits `json:"instruments,omitempty"`
+	// ReferencePrices are the prices limit orders may deviate from. Orders
+	// in symbols without one are not checked for price deviation. Market
+	// orders are valued at the prices of the book they could trade at, or
+	// at the reference price when the book has none.
+	ReferencePrices map[engine.Symbol]orderbook.Price `json:"reference_prices,omitempty"`
+}
+
+// LoadConfig reads a Config from a JSON file.
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func (c *Config) accountLimits(account string) Limits {
+	if l, ok := c.Accounts[account]; ok {
+		return l
+	}
+	return c.Accounts[Any]
+}
+
+func (c *Config) instrumentLimits(account string, symbol engine.Symbol) Limits {
+	for _, k := range []struct {
+		account string
+		symbol  engine.Symbol
+	}{
+		{account, symbol},
+		{account, Any},
+		{Any, symbol},
+		{Any, Any},
+	} {
+		if l, ok := c.Instruments[k.account][k.symbol]; ok {
+			return l
+		}
+	}
+	return Limits{}
+}
+
+type Reason int
+
+const (
+	ReasonOrderQuantity Reason = iota + 1
+	ReasonOrderNotional
+	ReasonOpenOrders
+	ReasonGrossPosition
+	ReasonNetPosition
+	ReasonDailyVolume
+	ReasonPriceDeviation
+)
+
+func (r Reason) String() string {
+	switch r {
+	case ReasonOrderQuantity:
+		return "order_quantity"
+	case ReasonOrderNotional:
+		return "order_notional"
+	case ReasonOpenOrders:
+		return "open_orders"
+	case ReasonGrossPosition:
+		return "gross_position"
+	case ReasonNetPosition:
+		return "net_position"
+	case ReasonDailyVolume:
+		return "daily_volume"
+	case ReasonPriceDeviation:
+		return "price_deviation"
+	}
+	return "unknown"
+}
+
+// Reject is the error returned for an order that would breach a limit.
+type Reject struct {
+	Reason  Reason
+	Account string
+	// Symbol is empty for a limit on the totals of the account.
+	Symbol engine.Symbol
+	Limit  int64
+	// Value is what the order would have brought the limited quantity to.
+	Value int64
+}
+
+func (r *Reject) Error() string {
+	scope := fmt.Sprintf("account %q", r.Account)
+	if r.Symbol != "" {
+		scope += " in " + string(r.Symbol)
+	}
+	return fmt.Sprintf("%s limit of %d for %s breached: %d", r.Reason, r.Limit, scope, r.Value)
+}
@@ -0,0 +1,271 @@
+package risk
+
+import (
+	"errors"
+	"go-orderbook/pkg/engine"
+	"go-orderbook/pkg/orderbook"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newChecker(t *testing.T, cfg Config) (*engine.Engine, *Checker) {
+	t.Helper()
+	e := engine.NewEngine()
+	require.NoError(t, e.List("DEMO"))
+	require.NoError(t, e.List("OTHER"))
+	return e, NewChecker(e, cfg)
+}
+
+func gtc(orderId orderbook.OrderId, side orderbook.Side, price orderbook.Price, quantity orderbook.Quantity) orderbook.Order {
+	return orderbook.NewOrder(orderbook.GoodTillCancel, orderId, side, price, quantity)
+}
+
+func requireReject(t *testing.T, err error, reason Reason, symbol engine.Symbol, limit, value int64) {
+	t.Helper()
+	var reject *Reject
+	require.True(t, errors.As(err, &reject), "expected a reject, got %v", err)
+	assert.Equal(t, reason, reject.Reason)
+	assert.Equal(t, symbol, reject.Symbol)
+	assert.Equal(t, limit, reject.Limit)
+	assert.Equal(t, value, reject.Value)
+}
+
+func TestOrderLimits(t *testing.T) {
+	_, c := newChecker(t, Config{
+		Instruments: map[string]map[engine.Symbol]Limits{
+			Any: {"DEMO": {
+				MaxOrderQuantity:  100,
+				MaxOrderNotional:  5000,
+				MaxPriceDeviation: 500,
+			}},
+		},
+		ReferencePrices: map[engine.Symbol]orderbook.Price{"DEMO": 100},
+	})
+
+	_, err := c.AddOrder("a", "DEMO", gtc(1, orderbook.Buy, 100, 101))
+	requireReject(t, err, ReasonOrderQuantity, "DEMO", 100, 101)
+
+	_, err = c.AddOrder("a", "DEMO", gtc(1, orderbook.Buy, 100, 51))
+	requireReject(t, err, ReasonOrderNotional, "DEMO", 5000, 5100)
+
+	_, err = c.AddOrder("a", "DEMO", gtc(1, orderbook.Buy, 94, 10))
+	requireReject(t, err, ReasonPriceDeviation, "DEMO", 500, 600)
+	_, err = c.AddOrder("a", "DEMO", gtc(1, orderbook.Sell, 106, 10))
+	requireReject(t, err, ReasonPriceDeviation, "DEMO", 500, 600)
+
+	// Market orders are priced at the reference for notional.
+	_, err = c.AddOrder("a", "DEMO", orderbook.NewMarketOrder(1, orderbook.Buy, 60))
+	requireReject(t, err, ReasonOrderNotional, "DEMO", 5000, 6000)
+
+	_, err = c.AddOrder("a", "DEMO", gtc(1, orderbook.Buy, 95, 50))
+	require.NoError(t, err)
+
+	// Other symbols are not limited.
+	_, err = c.AddOrder("a", "OTHER", gtc(2, orderbook.Buy, 1, 1000))
+	require.NoError(t, err)
+
+	c.SetReferencePrice("DEMO", 200)
+	_, err = c.AddOrder("a", "DEMO", gtc(3, orderbook.Buy, 95, 10))
+	requireReject(t, err, ReasonPriceDeviation, "DEMO", 500, 5250)
+}
+
+func TestMarketOrderNotional(t *testing.T) {
+	e, c := newChecker(t, Config{
+		Instruments: map[string]map[engine.Symbol]Limits{
+			Any: {"DEMO": {MaxOrderNotional: 5000}},
+		},
+	})
+	for _, o := range []orderbook.Order{
+		gtc(1, orderbook.Sell, 100, 10),
+		gtc(2, orderbook.Sell, 110, 100),
+		gtc(3, orderbook.Buy, 90, 10),
+		gtc(4, orderbook.Buy, 80, 100),
+	} {
+		_, err := e.AddOrder("DEMO", o)
+		require.NoError(t, err)
+	}
+
+	// Without a reference price, a buy is valued at the worst ask it may
+	// sweep to and a sell at the best bid.
+	_, err := c.AddOrder("a", "DEMO", orderbook.NewMarketOrder(5, orderbook.Buy, 50))
+	requireReject(t, err, ReasonOrderNotional, "DEMO", 5000, 5500)
+	_, err = c.AddOrder("a", "DEMO", orderbook.NewMarketOrder(5, orderbook.Sell, 60))
+	requireReject(t, err, ReasonOrderNotional, "DEMO", 5000, 5400)
+	_, err = c.AddOrder("a", "DEMO", orderbook.NewMarketOrder(5, orderbook.Buy, 45))
+	require.NoError(t, err)
+}
+
+func TestExposureFollowsBook(t *testing.T) {
+	e, c := newChecker(t, Config{})
+
+	_, err := c.AddOrder("a", "DEMO", gtc(1, orderbook.Buy, 100, 10))
+	require.NoError(t, err)
+	_, err = c.AddOrder("a", "DEMO", gtc(2, orderbook.Sell, 105, 4))
+	require.NoError(t, err)
+	_, err = c.AddOrder("a", "OTHER", gtc(3, orderbook.Sell, 50, 7))
+	require.NoError(t, err)
+	assert.Equal(t, Exposure{OpenOrders: 2, OpenBuy: 10, OpenSell: 4}, c.Exposure("a", "DEMO"))
+	assert.Equal(t, Exposure{OpenOrders: 3, OpenBuy: 10, OpenSell: 11}, c.Exposure("a", ""))
+
+	// A fill from an order entered around the checker.
+	_, err = e.AddOrder("DEMO", gtc(10, orderbook.Sell, 100, 6))
+	require.NoError(t, err)
+	assert.Equal(t, Exposure{OpenOrders: 2, OpenBuy: 4, OpenSell: 4, Net: 6, Volume: 6}, c.Exposure("a", "DEMO"))
+
+	// Reduce in place, then move the price.
+	var modify orderbook.OrderModify
+	_, err = c.ModifyOrder("a", modify.New(2, 105, orderbook.Sell, 3))
+	require.NoError(t, err)
+	_, err = c.ModifyOrder("a", modify.New(1, 101, orderbook.Buy, 8))
+	require.NoError(t, err)
+	assert.Equal(t, Exposure{OpenOrders: 2, OpenBuy: 8, OpenSell: 3, Net: 6, Volume: 6}, c.Exposure("a", "DEMO"))
+	assert.Equal(t, int64(6+8+3+7), c.Exposure("a", "").Gross())
+
+	require.NoError(t, c.CancelOrder("a", 1))
+	assert.Equal(t, Exposure{OpenOrders: 1, OpenSell: 3, Net: 6, Volume: 6}, c.Exposure("a", "DEMO"))
+
+	// A rejected order releases its reservation.
+	_, err = c.AddOrder("a", "DEMO", orderbook.NewOrder(orderbook.FillAndKill, 4, orderbook.Buy, 90, 5))
+	require.Error(t, err)
+	assert.Equal(t, Exposure{OpenOrders: 1, OpenSell: 3, Net: 6, Volume: 6}, c.Exposure("a", "DEMO"))
+
+	// Orders of other accounts cannot be touched.
+	assert.ErrorIs(t, c.CancelOrder("b", 2), engine.ErrUnknownOrder)
+	_, err = c.ModifyOrder("b", modify.New(2, 105, orderbook.Sell, 1))
+	assert.ErrorIs(t, err, engine.ErrUnknownOrder)
+	_, err = c.AddOrder("b", "DEMO", gtc(2, orderbook.Buy, 90, 1))
+	assert.ErrorIs(t, err, engine.ErrDuplicateOrder)
+
+	c.ResetDailyVolume()
+	assert.Zero(t, c.Exposure("a", "DEMO").Volume)
+	assert.Zero(t, c.Exposure("a", "").Volume)
+}
+
+func TestPositionLimits(t *testing.T) {
+	_, c := newChecker(t, Config{
+		Instruments: map[string]map[engine.Symbol]Limits{
+			"a": {Any: {MaxOpenOrders: 2, MaxNetPosition: 20, MaxDailyVolume: 25}},
+		},
+		Accounts: map[string]Limits{
+			Any: {MaxGrossPosition: 30},
+		},
+	})
+
+	_, err := c.AddOrder("a", "DEMO", gtc(1, orderbook.Buy, 100, 15))
+	require.NoError(t, err)
+	_, err = c.AddOrder("a", "DEMO", gtc(2, orderbook.Buy, 99, 6))
+	requireReject(t, err, ReasonNetPosition, "DEMO", 20, 21)
+	_, err = c.AddOrder("a", "DEMO", gtc(2, orderbook.Sell, 110, 10))
+	require.NoError(t, err)
+	_, err = c.AddOrder("a", "DEMO", gtc(3, orderbook.Sell, 110, 1))
+	requireReject(t, err, ReasonOpenOrders, "DEMO", 2, 3)
+
+	// The account totals span symbols.
+	_, err = c.AddOrder("a", "OTHER", gtc(3, orderbook.Sell, 110, 6))
+	requireReject(t, err, ReasonGrossPosition, "", 30, 31)
+
+	// A sell of 15 against the bid makes the account long 15.
+	_, err = c.AddOrder("x", "DEMO", gtc(4, orderbook.Sell, 100, 15))
+	require.NoError(t, err)
+	assert.Equal(t, Exposure{OpenOrders: 1, OpenSell: 10, Net: 15, Volume: 15}, c.Exposure("a", "DEMO"))
+
+	// New orders count towards the daily volume as if filled.
+	require.NoError(t, c.CancelOrder("a", 2))
+	_, err = c.AddOrder("a", "DEMO", gtc(5, orderbook.Sell, 110, 11))
+	requireReject(t, err, ReasonDailyVolume, "DEMO", 25, 26)
+	_, err = c.AddOrder("a", "DEMO", gtc(5, orderbook.Sell, 110, 10))
+	require.NoError(t, err)
+
+	// Modifies are checked without the order they replace.
+	var modify orderbook.OrderModify
+	_, err = c.ModifyOrder("a", modify.New(5, 111, orderbook.Sell, 11))
+	requireReject(t, err, ReasonDailyVolume, "DEMO", 25, 26)
+	_, err = c.ModifyOrder("a", modify.New(5, 111, orderbook.Sell, 10))
+	require.NoError(t, err)
+	assert.Equal(t, Exposure{OpenOrders: 1, OpenSell: 10, Net: 15, Volume: 15}, c.Exposure("a", "DEMO"))
+
+	// Other accounts only have the account wide limit.
+	_, err = c.AddOrder("b", "DEMO", gtc(6, orderbook.Buy, 90, 30))
+	require.NoError(t, err)
+}
+
+func TestConfigLookup(t *testing.T) {
+	cfg := Config{
+		Accounts: map[string]Limits{
+			"a": {MaxOpenOrders: 1},
+			Any: {MaxOpenOrders: 2},
+		},
+		Instruments: map[string]map[engine.Symbol]Limits{
+			"a": {"DEMO": {MaxOpenOrders: 3}, Any: {MaxOpenOrders: 4}},
+			Any: {"DEMO": {MaxOpenOrders: 5}, Any: {MaxOpenOrders: 6}},
+		},
+	}
+	assert.Equal(t, 1, cfg.accountLimits("a").MaxOpenOrders)
+	assert.Equal(t, 2, cfg.accountLimits("b").MaxOpenOrders)
+	assert.Equal(t, 3, cfg.instrumentLimits("a", "DEMO").MaxOpenOrders)
+	assert.Equal(t, 4, cfg.instrumentLimits("a", "OTHER").MaxOpenOrders)
+	assert.Equal(t, 5, cfg.instrumentLimits("b", "DEMO").MaxOpenOrders)
+	assert.Equal(t, 6, cfg.instrumentLimits("b", "OTHER").MaxOpenOrders)
+	assert.Equal(t, Limits{}, (&Config{}).instrumentLimits("a", "DEMO"))
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "risk.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"accounts": {"*": {"max_gross_position": 1000}},
+		"instruments": {"alice": {"DEMO": {"max_order_quantity": 10, "max_price_deviation_bps": 250}}},
+		"reference_prices": {"DEMO": 10000}
+	}`), 0o644))
+
+	cfg, err := LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, Config{
+		Accounts: map[string]Limits{Any: {MaxGrossPosition: 1000}},
+		Instruments: map[string]map[engine.Symbol]Limits{
+			"alice": {"DEMO": {MaxOrderQuantity: 10, MaxPriceDeviation: 250}},
+		},
+		ReferencePrices: map[engine.Symbol]orderbook.Price{"DEMO": 10000},
+	}, cfg)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"accounts": 1}`), 0o644))
+	_, err = LoadConfig(path)
+	assert.Error(t, err)
+}
+
+func TestConcurrentOrders(t *testing.T) {
+	e, c := newChecker(t, Config{
+		Accounts: map[string]Limits{Any: {MaxOpenOrders: 50}},
+	})
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		g := g
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				orderId := orderbook.OrderId(g*1000 + i + 1)
+				side := orderbook.Buy
+				price := orderbook.Price(90 + i%5)
+				if g%2 == 1 {
+					side = orderbook.Sell
+					price = orderbook.Price(95 + i%5)
+				}
+				c.AddOrder("a", "DEMO", gtc(orderId, side, price, 1))
+				if i%3 == 0 {
+					c.CancelOrder("a", orderId)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	exposure := c.Exposure("a", "")
+	assert.LessOrEqual(t, exposure.OpenOrders, 50)
+	assert.Equal(t, e.Size(), exposure.OpenOrders)
+}
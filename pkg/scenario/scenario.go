@@ -0,0 +1,320 @@
+// Package scenario runs orderbook scenarios written as text files, in the
+// format of the tests of the C++ project this book mirrors. Each line is an
+// action or an assertion; blank lines and lines starting with # are skipped:
+//
+//	A <B|S> <type> <price> <quantity> <id>   add an order
+//	M <id> <B|S> <price> <quantity>          modify an order
+//	C <id>                                   cancel an order
+//	T <bid id> <bid price> <ask id> <ask price> <quantity>
+//	R <orders> <bid levels> <ask levels>     assert the book
+//
+// Types are GoodTillCancel, GoodForDay, FillAndKill, FillOrKill and Market;
+// the price of a market order is ignored. Actions the book rejects leave it
+// unchanged, as in the C++ tests.
+//
+// T lines list, in order, the trades of the action they follow. When a
+// scenario has any T line, an action followed by none must not trade;
+// scenarios without T lines do not check trades. A scenario must end with an
+// R line and may have more as checkpoints.
+package scenario
+
+import (
+	"bufio"
+	"fmt"
+	"go-orderbook/pkg/orderbook"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type Trade struct {
+	BidOrderId orderbook.OrderId
+	BidPrice   orderbook.Price
+	AskOrderId orderbook.OrderId
+	AskPrice   orderbook.Price
+	Quantity   orderbook.Quantity
+}
+
+func (t Trade) String() string {
+	return fmt.Sprintf(
+		"T %d %d %d %d %d",
+		t.BidOrderId, t.BidPrice, t.AskOrderId, t.AskPrice, t.Quantity,
+	)
+}
+
+// Result is the expected shape of the book.
+type Result struct {
+	Orders    int
+	BidLevels int
+	AskLevels int
+}
+
+// Step is a single action, with the trades it is expected to produce, or an
+// assertion on the book.
+type Step struct {
+	Line int
+
+	// Action is 'A', 'M' or 'C', or 0 for a Result.
+	Action  byte
+	Order   orderbook.Order
+	Modify  orderbook.OrderModify
+	OrderId orderbook.OrderId
+	Trades  []Trade
+
+	Result Result
+}
+
+type Scenario struct {
+	Steps []Step
+	// CheckTrades is set when the scenario has T lines.
+	CheckTrades bool
+}
+
+func ParseFile(path string) (*Scenario, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+func Parse(r io.Reader) (*Scenario, error) {
+	s := &Scenario{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if err := s.parseLine(line, fields); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(s.Steps) == 0 || s.Steps[len(s.Steps)-1].Action != 0 {
+		return nil, fmt.Errorf("scenario must end with an R line")
+	}
+	return s, nil
+}
+
+func (s *Scenario) parseLine(line int, fields []string) error {
+	var p parser
+	p.fields = fields[1:]
+	step := Step{Line: line}
+
+	switch fields[0] {
+	case "A":
+		step.Action = 'A'
+		side := p.side()
+		orderType := p.orderType()
+		price := p.price()
+		quantity := p.quantity()
+		orderId := p.orderId()
+		step.Order = orderbook.NewOrder(orderType, orderId, side, price, quantity)
+		if orderType == orderbook.Market {
+			step.Order = orderbook.NewMarketOrder(orderId, side, quantity)
+		}
+	case "M":
+		step.Action = 'M'
+		orderId := p.orderId()
+		side := p.side()
+		price := p.price()
+		quantity := p.quantity()
+		step.Modify = step.Modify.New(orderId, price, side, quantity)
+	case "C":
+		step.Action = 'C'
+		step.OrderId = p.orderId()
+	case "T":
+		if len(s.Steps) == 0 || s.Steps[len(s.Steps)-1].Action == 0 {
+			return fmt.Errorf("T line must follow an action")
+		}
+		t := Trade{
+			BidOrderId: p.orderId(),
+			BidPrice:   p.price(),
+			AskOrderId: p.orderId(),
+			AskPrice:   p.price(),
+			Quantity:   p.quantity(),
+		}
+		if err := p.done(); err != nil {
+			return err
+		}
+		last := &s.Steps[len(s.Steps)-1]
+		last.Trades = append(last.Trades, t)
+		s.CheckTrades = true
+		return nil
+	case "R":
+		step.Result = Result{
+			Orders:    p.count(),
+			BidLevels: p.count(),
+			AskLevels: p.count(),
+		}
+	default:
+		return fmt.Errorf("unknown line type %q", fields[0])
+	}
+
+	if err := p.done(); err != nil {
+		return err
+	}
+	s.Steps = append(s.Steps, step)
+	return nil
+}
+
+// parser consumes the fields of a line, keeping the first error.
+type parser struct {
+	fields []string
+	err    error
+}
+
+func (p *parser) next(name string) string {
+	if p.err != nil {
+		return ""
+	}
+	if len(p.fields) == 0 {
+		p.err = fmt.Errorf("missing %s", name)
+		return ""
+	}
+	field := p.fields[0]
+	p.fields = p.fields[1:]
+	return field
+}
+
+func (p *parser) uint(name string, bits int) uint64 {
+	field := p.next(name)
+	if p.err != nil {
+		return 0
+	}
+	v, err := strconv.ParseUint(field, 10, bits)
+	if err != nil {
+		p.err = fmt.Errorf("invalid %s %q", name, field)
+	}
+	return v
+}
+
+func (p *parser) side() orderbook.Side {
+	switch field := p.next("side"); field {
+	case "B":
+		return orderbook.Buy
+	case "S":
+		return orderbook.Sell
+	default:
+		if p.err == nil {
+			p.err = fmt.Errorf("invalid side %q", field)
+		}
+	}
+	return 0
+}
+
+func (p *parser) orderType() orderbook.OrderType {
+	switch field := p.next("order type"); field {
+	case "GoodTillCancel":
+		return orderbook.GoodTillCancel
+	case "GoodForDay":
+		return orderbook.GoodForDay
+	case "FillAndKill":
+		return orderbook.FillAndKill
+	case "FillOrKill":
+		return orderbook.FillOrKill
+	case "Market":
+		return orderbook.Market
+	default:
+		if p.err == nil {
+			p.err = fmt.Errorf("invalid order type %q", field)
+		}
+	}
+	return 0
+}
+
+func (p *parser) price() orderbook.Price {
+	return orderbook.Price(p.uint("price", 31))
+}
+
+func (p *parser) quantity() orderbook.Quantity {
+	return orderbook.Quantity(p.uint("quantity", 32))
+}
+
+func (p *parser) orderId() orderbook.OrderId {
+	return orderbook.OrderId(p.uint("order id", 64))
+}
+
+func (p *parser) count() int {
+	return int(p.uint("count", 31))
+}
+
+func (p *parser) done() error {
+	if p.err == nil && len(p.fields) > 0 {
+		p.err = fmt.Errorf("unexpected %q", strings.Join(p.fields, " "))
+	}
+	return p.err
+}
+
+// Run plays the scenario against a new book and returns the first mismatch.
+func (s *Scenario) Run() error {
+	ob := orderbook.NewOrderbook()
+	for _, step := range s.Steps {
+		var trades orderbook.Trades
+		switch step.Action {
+		case 'A':
+			trades, _ = ob.AddOrder(step.Order)
+		case 'M':
+			trades, _ = ob.ModifyOrder(step.Modify)
+		case 'C':
+			ob.CancelOrder(step.OrderId)
+		case 0:
+			info := ob.OrderInfo()
+			got := Result{
+				Orders:    ob.Size(),
+				BidLevels: len(info.GetBids()),
+				AskLevels: len(info.GetAsks()),
+			}
+			if got != step.Result {
+				return fmt.Errorf(
+					"line %d: expected R %d %d %d, got R %d %d %d",
+					step.Line,
+					step.Result.Orders, step.Result.BidLevels, step.Result.AskLevels,
+					got.Orders, got.BidLevels, got.AskLevels,
+				)
+			}
+			continue
+		}
+		if err := ob.Validate(); err != nil {
+			return fmt.Errorf("line %d: %w", step.Line, err)
+		}
+
+		if !s.CheckTrades {
+			continue
+		}
+		got := make([]Trade, 0, len(trades))
+		for _, t := range trades {
+			bid, ask := t.BidTrade(), t.AskTrade()
+			got = append(got, Trade{
+				BidOrderId: bid.OrderId(),
+				BidPrice:   bid.Price(),
+				AskOrderId: ask.OrderId(),
+				AskPrice:   ask.Price(),
+				Quantity:   bid.Quantity(),
+			})
+		}
+		if err := compareTrades(step.Trades, got); err != nil {
+			return fmt.Errorf("line %d: %w", step.Line, err)
+		}
+	}
+	return nil
+}
+
+func compareTrades(expected, got []Trade) error {
+	for i := 0; i < len(expected) || i < len(got); i++ {
+		switch {
+		case i >= len(got):
+			return fmt.Errorf("missing trade %s", expected[i])
+		case i >= len(expected):
+			return fmt.Errorf("unexpected trade %s", got[i])
+		case expected[i] != got[i]:
+			return fmt.Errorf("expected trade %s, got %s", expected[i], got[i])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package scenario
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScenarios(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.txt"))
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+
+	for _, file := range files {
+		file := file
+		t.Run(strings.TrimSuffix(filepath.Base(file), ".txt"), func(t *testing.T) {
+			s, err := ParseFile(file)
+			require.NoError(t, err)
+			assert.NoError(t, s.Run())
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		err   string
+	}{
+		{"A B GoodTillCancel 100 10 1\n", "scenario must end with an R line"},
+		{"\n# comment\nA X GoodTillCancel 100 10 1\n", `line 3: invalid side "X"`},
+		{"A B Limit 100 10 1\n", `line 1: invalid order type "Limit"`},
+		{"A B GoodTillCancel 100 ten 1\n", `line 1: invalid quantity "ten"`},
+		{"C\n", "line 1: missing order id"},
+		{"C 1 2\n", `line 1: unexpected "2"`},
+		{"T 1 100 2 100 10\n", "line 1: T line must follow an action"},
+		{"Q 1\n", `line 1: unknown line type "Q"`},
+	}
+	for _, test := range tests {
+		_, err := Parse(strings.NewReader(test.input))
+		assert.EqualError(t, err, test.err, test.input)
+	}
+}
+
+func TestRunMismatch(t *testing.T) {
+	tests := []struct {
+		input string
+		err   string
+	}{
+		{
+			"A B GoodTillCancel 100 10 1\nR 1 0 1\n",
+			"line 2: expected R 1 0 1, got R 1 1 0",
+		},
+		{
+			"A B GoodTillCancel 100 10 1\nA S GoodTillCancel 100 4 2\nT 1 100 2 100 5\nR 1 1 0\n",
+			"line 2: expected trade T 1 100 2 100 5, got T 1 100 2 100 4",
+		},
+		{
+			"A B GoodTillCancel 100 10 1\nA S GoodTillCancel 100 4 2\nA S GoodTillCancel 100 4 3\nT 1 100 2 100 4\nR 1 1 0\n",
+			"line 2: unexpected trade T 1 100 2 100 4",
+		},
+		{
+			"A B GoodTillCancel 100 10 1\nT 1 100 2 100 5\nR 1 1 0\n",
+			"line 1: missing trade T 1 100 2 100 5",
+		},
+	}
+	for _, test := range tests {
+		s, err := Parse(strings.NewReader(test.input))
+		require.NoError(t, err, test.input)
+		assert.EqualError(t, s.Run(), test.err, test.input)
+	}
+}
@@ -0,0 +1,109 @@
+package sim
+
+import "go-orderbook/pkg/orderbook"
+
+type agent interface {
+	rate() float64
+	arrive(s *Simulator)
+}
+
+// resting keeps the ids of the orders an agent may still have in the book.
+type resting []orderbook.OrderId
+
+// prune drops the orders that have left the book.
+func (r *resting) prune(book Book) {
+	live := (*r)[:0]
+	for _, orderId := range *r {
+		if _, ok := book.Order(orderId); ok {
+			live = append(live, orderId)
+		}
+	}
+	*r = live
+}
+
+type marketMaker struct {
+	cfg    *MarketMakers
+	quotes resting
+}
+
+func (m *marketMaker) rate() float64 {
+	return m.cfg.Rate
+}
+
+func (m *marketMaker) arrive(s *Simulator) {
+	m.quotes.prune(s.book)
+	for _, orderId := range m.quotes {
+		s.cancel(orderId)
+	}
+	m.quotes = m.quotes[:0]
+
+	mid := s.mid()
+	bid := clamp(mid - m.cfg.HalfSpread - s.offset(m.cfg.Offset))
+	ask := clamp(mid + m.cfg.HalfSpread + s.offset(m.cfg.Offset))
+	if orderId, ok := s.add(orderbook.GoodTillCancel, orderbook.Buy, bid, s.size(m.cfg.Size)); ok {
+		m.quotes = append(m.quotes, orderId)
+	}
+	if orderId, ok := s.add(orderbook.GoodTillCancel, orderbook.Sell, ask, s.size(m.cfg.Size)); ok {
+		m.quotes = append(m.quotes, orderId)
+	}
+}
+
+type noiseTrader struct {
+	cfg    *NoiseTraders
+	orders resting
+}
+
+func (n *noiseTrader) rate() float64 {
+	return n.cfg.Rate
+}
+
+func (n *noiseTrader) arrive(s *Simulator) {
+	side := orderbook.Buy
+	if s.rng.Intn(2) == 1 {
+		side = orderbook.Sell
+	}
+
+	u := s.rng.Float64()
+	switch {
+	case u < n.cfg.MarketProbability:
+		s.add(orderbook.Market, side, 0, s.size(n.cfg.Size))
+	case u < n.cfg.MarketProbability+n.cfg.CancelProbability:
+		n.orders.prune(s.book)
+		if len(n.orders) == 0 {
+			return
+		}
+		i := s.rng.Intn(len(n.orders))
+		s.cancel(n.orders[i])
+		n.orders = append(n.orders[:i], n.orders[i+1:]...)
+	default:
+		price := s.mid() - s.offset(n.cfg.Offset)
+		if side == orderbook.Sell {
+			price = s.mid() + s.offset(n.cfg.Offset)
+		}
+		orderId, ok := s.add(orderbook.GoodTillCancel, side, clamp(price), s.size(n.cfg.Size))
+		if ok {
+			n.orders = append(n.orders, orderId)
+		}
+	}
+}
+
+type momentumTaker struct {
+	cfg *MomentumTakers
+}
+
+func (m *momentumTaker) rate() float64 {
+	return m.cfg.Rate
+}
+
+func (m *momentumTaker) arrive(s *Simulator) {
+	if len(s.prices) <= m.cfg.Lookback {
+		return
+	}
+	move := s.prices[len(s.prices)-1] - s.prices[len(s.prices)-1-m.cfg.Lookback]
+	switch {
+	case move >= m.cfg.Threshold:
+		s.add(orderbook.Market, orderbook.Buy, 0, s.size(m.cfg.Size))
+	case move <= -m.cfg.Threshold:
+		s.add(orderbook.Market, orderbook.Sell, 0, s.size(m.cfg.Size))
+	}
+}
@@ -0,0 +1,32 @@
+package sim
+
+import (
+	"math"
+	"math/rand"
+)
+
+// PowerLaw is a Pareto distribution with tail exponent Alpha, starting at Min
+// and truncated at Max. The smaller Alpha, the heavier the tail.
+type PowerLaw struct {
+	Min   float64
+	Max   float64
+	Alpha float64
+}
+
+// Sample draws a value by inverse transform sampling of the truncated
+// distribution, so that no draws are thrown away.
+func (p PowerLaw) Sample(rng *rand.Rand) float64 {
+	if p.Max <= p.Min {
+		return p.Min
+	}
+	// F(x) = (1 - (Min/x)^Alpha) / (1 - (Min/Max)^Alpha)
+	tail := 1 - math.Pow(p.Min/p.Max, p.Alpha)
+	u := rng.Float64() * tail
+	return p.Min * math.Pow(1-u, -1/p.Alpha)
+}
+
+// exponential draws the time to the next arrival of a Poisson process with
+// the given rate per second, in seconds.
+func exponential(rng *rand.Rand, rate float64) float64 {
+	return rng.ExpFloat64() / rate
+}
@@ -0,0 +1,354 @@
+// Package sim generates synthetic order flow from a population of agents
+// trading against an Orderbook.
+//
+// Every agent arrives at the book following its own Poisson process and
+// sizes and prices its orders from power-law distributions. All randomness
+// comes from a single seeded source and agents arrive one at a time in
+// simulated time, so a run is reproduced exactly by its seed and config.
+package sim
+
+import (
+	"container/heap"
+	"go-orderbook/pkg/backtest"
+	"go-orderbook/pkg/orderbook"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Book is what the agents trade against. *orderbook.Orderbook implements it.
+type Book interface {
+	AddOrder(order orderbook.Order) (orderbook.Trades, error)
+	CancelOrder(orderId orderbook.OrderId) error
+	Order(orderId orderbook.OrderId) (orderbook.Order, bool)
+	Top() (bid, ask orderbook.LevelInfo)
+}
+
+// MarketMakers quote both sides around the mid price, replacing their quotes
+// on every arrival.
+type MarketMakers struct {
+	Count int
+	// Rate is the number of arrivals per second of each agent.
+	Rate float64
+	// HalfSpread is the distance in ticks from the mid price to the
+	// innermost quote.
+	HalfSpread orderbook.Price
+	// Offset is the extra distance in ticks of a quote.
+	Offset PowerLaw
+	Size   PowerLaw
+}
+
+// NoiseTraders send limit orders around the mid price, market orders and
+// cancels at random.
+type NoiseTraders struct {
+	Count int
+	Rate  float64
+	// MarketProbability and CancelProbability are the chances that an
+	// arrival sends a market order or cancels a resting order of the agent.
+	// Other arrivals send a limit order.
+	MarketProbability float64
+	CancelProbability float64
+	// Offset is the distance in ticks of a limit order from the mid price,
+	// on the passive side.
+	Offset PowerLaw
+	Size   PowerLaw
+}
+
+// MomentumTakers send market orders in the direction of the recent price
+// move.
+type MomentumTakers struct {
+	Count int
+	Rate  float64
+	// Lookback is the number of trades the move is measured over.
+	Lookback int
+	// Threshold is the move in ticks that triggers an order.
+	Threshold orderbook.Price
+	Size      PowerLaw
+}
+
+type Config struct {
+	Seed int64
+	// Start is the timestamp of the beginning of the run.
+	Start time.Time
+	// InitialPrice is the mid price while one side of the book is empty
+	// and no trade has happened.
+	InitialPrice orderbook.Price
+	// FirstOrderId is the id of the first order. Zero means 1.
+	FirstOrderId orderbook.OrderId
+
+	MarketMakers   MarketMakers
+	NoiseTraders   NoiseTraders
+	MomentumTakers MomentumTakers
+}
+
+// DefaultConfig is a small, liquid market around a price of 10000 ticks.
+func DefaultConfig() Config {
+	return Config{
+		Seed:         1,
+		Start:        time.Unix(0, 0),
+		InitialPrice: 10000,
+		MarketMakers: MarketMakers{
+			Count:      4,
+			Rate:       2,
+			HalfSpread: 1,
+			Offset:     PowerLaw{Min: 1, Max: 20, Alpha: 1.5},
+			Size:       PowerLaw{Min: 10, Max: 1000, Alpha: 1.5},
+		},
+		NoiseTraders: NoiseTraders{
+			Count:             20,
+			Rate:              0.5,
+			MarketProbability: 0.2,
+			CancelProbability: 0.3,
+			Offset:            PowerLaw{Min: 1, Max: 100, Alpha: 1.2},
+			Size:              PowerLaw{Min: 1, Max: 500, Alpha: 1.5},
+		},
+		MomentumTakers: MomentumTakers{
+			Count:     4,
+			Rate:      0.2,
+			Lookback:  20,
+			Threshold: 3,
+			Size:      PowerLaw{Min: 5, Max: 200, Alpha: 1.5},
+		},
+	}
+}
+
+// Stats counts what a run has done so far.
+type Stats struct {
+	Arrivals int
+	Actions  int
+	// Rejected counts the actions the book rejected, such as market
+	// orders against an empty side.
+	Rejected int
+	Trades   int
+	Volume   uint64
+}
+
+type arrival struct {
+	at    time.Duration
+	seq   uint64
+	agent int
+}
+
+// arrivals is a min-heap of arrivals in time order. Ties are broken by the
+// order they were scheduled in, to keep runs reproducible.
+type arrivals []arrival
+
+func (a arrivals) Len() int { return len(a) }
+func (a arrivals) Less(i, j int) bool {
+	if a[i].at != a[j].at {
+		return a[i].at < a[j].at
+	}
+	return a[i].seq < a[j].seq
+}
+func (a arrivals) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a *arrivals) Push(x any)   { *a = append(*a, x.(arrival)) }
+func (a *arrivals) Pop() any {
+	old := *a
+	x := old[len(old)-1]
+	*a = old[:len(old)-1]
+	return x
+}
+
+// Simulator runs the agents of a Config against a Book. It is not safe for
+// concurrent use.
+type Simulator struct {
+	book   Book
+	cfg    Config
+	rng    *rand.Rand
+	agents []agent
+
+	queue  arrivals
+	seq    uint64
+	now    time.Duration
+	nextId orderbook.OrderId
+
+	// prices holds the last trade prices, oldest first, up to the longest
+	// lookback.
+	prices    []orderbook.Price
+	lookback  int
+	lastPrice orderbook.Price
+
+	actions []backtest.Action
+	stats   Stats
+}
+
+func New(book Book, cfg Config) *Simulator {
+	s := &Simulator{
+		book:      book,
+		cfg:       cfg,
+		rng:       rand.New(rand.NewSource(cfg.Seed)),
+		nextId:    cfg.FirstOrderId,
+		lookback:  cfg.MomentumTakers.Lookback + 1,
+		lastPrice: cfg.InitialPrice,
+	}
+	if s.nextId == 0 {
+		s.nextId = 1
+	}
+
+	for i := 0; i < cfg.MarketMakers.Count; i++ {
+		s.agents = append(s.agents, &marketMaker{cfg: &s.cfg.MarketMakers})
+	}
+	for i := 0; i < cfg.NoiseTraders.Count; i++ {
+		s.agents = append(s.agents, &noiseTrader{cfg: &s.cfg.NoiseTraders})
+	}
+	for i := 0; i < cfg.MomentumTakers.Count; i++ {
+		s.agents = append(s.agents, &momentumTaker{cfg: &s.cfg.MomentumTakers})
+	}
+	for i, a := range s.agents {
+		s.schedule(i, a.rate())
+	}
+	return s
+}
+
+func (s *Simulator) schedule(agent int, rate float64) {
+	if rate <= 0 {
+		return
+	}
+	s.seq++
+	heap.Push(&s.queue, arrival{
+		at:    s.now + time.Duration(exponential(s.rng, rate)*float64(time.Second)),
+		seq:   s.seq,
+		agent: agent,
+	})
+}
+
+// Now returns the simulated time since the start of the run.
+func (s *Simulator) Now() time.Duration {
+	return s.now
+}
+
+// Stats returns the counts of the run so far.
+func (s *Simulator) Stats() Stats {
+	return s.stats
+}
+
+// Step advances to the next arrival and returns the actions the agent sent,
+// which have already been applied to the book. The slice is only valid until
+// the next call. Step returns nil when no agent has a positive rate.
+func (s *Simulator) Step() []backtest.Action {
+	if len(s.queue) == 0 {
+		return nil
+	}
+	next := heap.Pop(&s.queue).(arrival)
+	s.now = next.at
+	s.stats.Arrivals++
+
+	s.actions = s.actions[:0]
+	a := s.agents[next.agent]
+	a.arrive(s)
+	s.schedule(next.agent, a.rate())
+	return s.actions
+}
+
+// NextArrival returns the simulated time of the next arrival, or false when
+// no agent has a positive rate.
+func (s *Simulator) NextArrival() (time.Duration, bool) {
+	if len(s.queue) == 0 {
+		return 0, false
+	}
+	return s.queue[0].at, true
+}
+
+// Run steps until the simulated time reaches d, calling fn, if not nil, with
+// every action.
+func (s *Simulator) Run(d time.Duration, fn func(a backtest.Action)) {
+	for next, ok := s.NextArrival(); ok && next <= d; next, ok = s.NextArrival() {
+		for _, a := range s.Step() {
+			if fn != nil {
+				fn(a)
+			}
+		}
+	}
+}
+
+func (s *Simulator) timestamp() time.Time {
+	return s.cfg.Start.Add(s.now)
+}
+
+// add sends a new order and returns its id if it rests in the book.
+func (s *Simulator) add(
+	orderType orderbook.OrderType,
+	side orderbook.Side,
+	price orderbook.Price,
+	quantity orderbook.Quantity,
+) (orderbook.OrderId, bool) {
+	orderId := s.nextId
+	s.nextId++
+
+	order := orderbook.NewOrder(orderType, orderId, side, price, quantity)
+	if orderType == orderbook.Market {
+		order = orderbook.NewMarketOrder(orderId, side, quantity)
+	}
+	s.actions = append(s.actions, backtest.Action{
+		Type:      backtest.ActionAdd,
+		Timestamp: s.timestamp(),
+		OrderType: orderType,
+		OrderId:   orderId,
+		Side:      side,
+		Price:     order.Price(),
+		Quantity:  quantity,
+	})
+	s.stats.Actions++
+
+	trades, err := s.book.AddOrder(order)
+	if err != nil {
+		s.stats.Rejected++
+		return 0, false
+	}
+	for _, t := range trades {
+		// The order just added is the aggressor, so the trade is at the
+		// price of the other side.
+		resting := t.AskTrade()
+		if side == orderbook.Sell {
+			resting = t.BidTrade()
+		}
+		s.onTrade(resting.Price(), resting.Quantity())
+	}
+	_, rests := s.book.Order(orderId)
+	return orderId, rests
+}
+
+func (s *Simulator) cancel(orderId orderbook.OrderId) {
+	s.actions = append(s.actions, backtest.Action{
+		Type:      backtest.ActionCancel,
+		Timestamp: s.timestamp(),
+		OrderId:   orderId,
+	})
+	s.stats.Actions++
+	if err := s.book.CancelOrder(orderId); err != nil {
+		s.stats.Rejected++
+	}
+}
+
+func (s *Simulator) onTrade(price orderbook.Price, quantity orderbook.Quantity) {
+	s.stats.Trades++
+	s.stats.Volume += uint64(quantity)
+	s.lastPrice = price
+	s.prices = append(s.prices, price)
+	if len(s.prices) > s.lookback {
+		s.prices = s.prices[len(s.prices)-s.lookback:]
+	}
+}
+
+// mid returns the middle of the best bid and ask, or the last trade price
+// while a side is empty.
+func (s *Simulator) mid() orderbook.Price {
+	bid, ask := s.book.Top()
+	if bid.Quantity == 0 || ask.Quantity == 0 {
+		return s.lastPrice
+	}
+	return (bid.Price + ask.Price) / 2
+}
+
+func (s *Simulator) size(p PowerLaw) orderbook.Quantity {
+	return orderbook.Quantity(max(1, math.Round(p.Sample(s.rng))))
+}
+
+func (s *Simulator) offset(p PowerLaw) orderbook.Price {
+	return orderbook.Price(math.Floor(p.Sample(s.rng)))
+}
+
+// clamp keeps prices above zero.
+func clamp(price orderbook.Price) orderbook.Price {
+	return max(1, price)
+}
@@ -0,0 +1,222 @@
+package sim
+
+import (
+	"errors"
+	"go-orderbook/pkg/backtest"
+	"go-orderbook/pkg/engine"
+	"go-orderbook/pkg/journal"
+	"go-orderbook/pkg/orderbook"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func run(t *testing.T, cfg Config, d time.Duration) ([]backtest.Action, *orderbook.Orderbook, Stats) {
+	t.Helper()
+	book := orderbook.NewOrderbook()
+	s := New(&book, cfg)
+	var actions []backtest.Action
+	s.Run(d, func(a backtest.Action) {
+		actions = append(actions, a)
+	})
+	return actions, &book, s.Stats()
+}
+
+func TestReproducible(t *testing.T) {
+	cfg := DefaultConfig()
+	first, firstBook, firstStats := run(t, cfg, time.Minute)
+	second, secondBook, secondStats := run(t, cfg, time.Minute)
+
+	require.NotEmpty(t, first)
+	assert.Equal(t, first, second)
+	assert.Equal(t, firstStats, secondStats)
+	assert.Equal(t, firstBook.OrderInfo(), secondBook.OrderInfo())
+
+	cfg.Seed = 2
+	third, _, _ := run(t, cfg, time.Minute)
+	assert.NotEqual(t, first, third)
+}
+
+func TestReplayMatchesBook(t *testing.T) {
+	actions, book, stats := run(t, DefaultConfig(), time.Minute)
+	assert.Equal(t, stats.Actions, len(actions))
+	assert.NotZero(t, stats.Trades)
+
+	replayed := orderbook.NewOrderbook()
+	for _, a := range actions {
+		backtest.Apply(&replayed, a)
+	}
+	assert.Equal(t, book.OrderInfo(), replayed.OrderInfo())
+}
+
+func TestBookStaysSane(t *testing.T) {
+	book := orderbook.NewOrderbook()
+	s := New(&book, DefaultConfig())
+	var last time.Duration
+	for i := 0; i < 5000; i++ {
+		for _, a := range s.Step() {
+			if a.Type != backtest.ActionAdd {
+				continue
+			}
+			assert.Positive(t, a.Quantity)
+			if a.OrderType != orderbook.Market {
+				assert.Positive(t, a.Price)
+			}
+		}
+		require.GreaterOrEqual(t, s.Now(), last)
+		last = s.Now()
+
+		info := book.OrderInfo()
+		if len(info.GetBids()) > 0 && len(info.GetAsks()) > 0 {
+			require.Less(t, info.GetBids()[0].Price, info.GetAsks()[0].Price)
+		}
+	}
+}
+
+func TestArrivalRate(t *testing.T) {
+	cfg := Config{
+		Seed:         7,
+		InitialPrice: 100,
+		NoiseTraders: NoiseTraders{
+			Count:  10,
+			Rate:   2,
+			Offset: PowerLaw{Min: 1, Max: 10, Alpha: 1},
+			Size:   PowerLaw{Min: 1, Max: 10, Alpha: 1},
+		},
+	}
+	book := orderbook.NewOrderbook()
+	s := New(&book, cfg)
+	s.Run(1000*time.Second, nil)
+
+	// 10 agents arriving twice a second for 1000 seconds.
+	assert.InDelta(t, 20000, s.Stats().Arrivals, 20000*0.03)
+}
+
+func TestPowerLaw(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	p := PowerLaw{Min: 1, Max: 1000, Alpha: 1.5}
+
+	const n = 100000
+	above, outside := 0, 0
+	for i := 0; i < n; i++ {
+		v := p.Sample(rng)
+		if v < p.Min || v > p.Max {
+			outside++
+		}
+		if v > 10 {
+			above++
+		}
+	}
+	assert.Zero(t, outside)
+	// P(X > 10) of the truncated distribution.
+	expected := (math.Pow(0.1, 1.5) - math.Pow(0.001, 1.5)) / (1 - math.Pow(0.001, 1.5))
+	assert.InDelta(t, expected, float64(above)/n, 0.005)
+
+	assert.Equal(t, 5.0, PowerLaw{Min: 5, Max: 5, Alpha: 2}.Sample(rng))
+}
+
+func TestMomentumFollowsMove(t *testing.T) {
+	book := orderbook.NewOrderbook()
+	s := New(&book, Config{
+		Seed:         1,
+		InitialPrice: 100,
+		MomentumTakers: MomentumTakers{
+			Count:     1,
+			Rate:      1,
+			Lookback:  2,
+			Threshold: 2,
+			Size:      PowerLaw{Min: 3, Max: 3, Alpha: 1},
+		},
+	})
+
+	// Nothing has traded yet.
+	assert.Empty(t, s.Step())
+
+	s.prices = []orderbook.Price{100, 101, 103}
+	_, err := book.AddOrder(orderbook.NewOrder(orderbook.GoodTillCancel, 1000, orderbook.Sell, 104, 10))
+	require.NoError(t, err)
+	actions := s.Step()
+	require.Len(t, actions, 1)
+	assert.Equal(t, orderbook.Market, actions[0].OrderType)
+	assert.Equal(t, orderbook.Buy, actions[0].Side)
+	assert.Equal(t, orderbook.Quantity(3), actions[0].Quantity)
+	assert.Equal(t, orderbook.Price(104), s.lastPrice)
+}
+
+// journalBook routes the orders of a simulation through a journaled engine.
+type journalBook struct {
+	*journal.Engine
+	*orderbook.Orderbook
+	duplicates int
+}
+
+func (b *journalBook) AddOrder(order orderbook.Order) (orderbook.Trades, error) {
+	trades, err := b.Engine.AddOrder("DEMO", order)
+	if errors.Is(err, engine.ErrDuplicateOrder) {
+		b.duplicates++
+	}
+	return trades, err
+}
+
+func (b *journalBook) CancelOrder(orderId orderbook.OrderId) error {
+	return b.Engine.CancelOrder(orderId)
+}
+
+// TestJournalRestart runs a simulation through a journal, crashes it while
+// a record is written, and carries on from the recovered book.
+func TestJournalRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "engine.journal")
+	run := func(e *journal.Engine, seed int64) *journalBook {
+		ob, ok := e.Engine().Book("DEMO")
+		require.True(t, ok)
+		book := &journalBook{Engine: e, Orderbook: ob}
+		cfg := DefaultConfig()
+		cfg.Seed = seed
+		cfg.FirstOrderId = e.LastOrderId() + 1
+		New(book, cfg).Run(10*time.Second, nil)
+		return book
+	}
+
+	e, err := journal.Recover(path, journal.Options{Sync: journal.SyncNever})
+	require.NoError(t, err)
+	require.NoError(t, e.List("DEMO"))
+	run(e, 1)
+	want, err := e.Engine().OrderInfo("DEMO")
+	require.NoError(t, err)
+	last := e.LastOrderId()
+	require.NotZero(t, last)
+
+	// Crash while writing a record, without closing the journal.
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{30, 0, 0, 0, 1, 2})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	e, err = journal.Recover(path, journal.Options{Sync: journal.SyncNever})
+	require.NoError(t, err)
+	got, err := e.Engine().OrderInfo("DEMO")
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+	assert.Equal(t, last, e.LastOrderId())
+
+	// The restarted run carries on with fresh ids.
+	book := run(e, 2)
+	assert.Zero(t, book.duplicates)
+	want, err = e.Engine().OrderInfo("DEMO")
+	require.NoError(t, err)
+	require.NoError(t, e.Close())
+
+	e, err = journal.Recover(path, journal.Options{Sync: journal.SyncNever})
+	require.NoError(t, err)
+	got, err = e.Engine().OrderInfo("DEMO")
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+	assert.NoError(t, e.Close())
+}
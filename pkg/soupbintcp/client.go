@@ -0,0 +1,119 @@
+package soupbintcp
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Client is the initiating side of a session.
+type Client struct {
+	conn net.Conn
+	r    *Reader
+
+	m    sync.Mutex
+	wbuf []byte
+
+	// Session is the session name announced by the server.
+	Session string
+	// NextSequence is the sequence number of the next sequenced message to
+	// be read, and the one to request when reconnecting.
+	NextSequence uint64
+}
+
+// Dial connects and logs in, requesting replay from sequence. A sequence of 0
+// starts with the next message the server sends.
+func Dial(addr, username, password string, sequence uint64) (*Client, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{conn: conn, r: NewReader(conn)}
+
+	var payload [loginRequestSize]byte
+	login := appendAlpha(payload[:0], username, usernameSize)
+	login = appendAlpha(login, password, passwordSize)
+	login = appendAlpha(login, "", sessionSize)
+	login = appendNumeric(login, sequence, sequenceSize)
+	if err := c.write(LoginRequest, login); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(loginTimeout))
+	packetType, reply, err := c.r.Next()
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	switch {
+	case packetType == LoginRejected && len(reply) == 1:
+		conn.Close()
+		return nil, fmt.Errorf("login rejected: %c", reply[0])
+	case packetType != LoginAccepted || len(reply) != loginAcceptedSize:
+		conn.Close()
+		return nil, fmt.Errorf("unexpected packet %q during login", packetType)
+	}
+
+	c.Session = string(trimSpaces(reply[:sessionSize]))
+	c.NextSequence, err = parseNumeric(reply[sessionSize:])
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Client) write(packetType byte, payload []byte) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	var err error
+	c.wbuf, err = AppendPacket(c.wbuf[:0], packetType, payload)
+	if err != nil {
+		return err
+	}
+	_, err = c.conn.Write(c.wbuf)
+	return err
+}
+
+// Send sends an unsequenced message.
+func (c *Client) Send(payload []byte) error {
+	return c.write(UnsequencedData, payload)
+}
+
+// Heartbeat sends a client heartbeat.
+func (c *Client) Heartbeat() error {
+	return c.write(ClientHeartbeat, nil)
+}
+
+// Logout asks the server to end the connection.
+func (c *Client) Logout() error {
+	return c.write(LogoutRequest, nil)
+}
+
+// Next reads the next packet, skipping server heartbeats. The payload is only
+// valid until the next call.
+func (c *Client) Next(timeout time.Duration) (byte, []byte, error) {
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(timeout))
+		packetType, payload, err := c.r.Next()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch packetType {
+		case ServerHeartbeat:
+			continue
+		case SequencedData:
+			c.NextSequence++
+		}
+		return packetType, payload, nil
+	}
+}
+
+// Close closes the connection without logging out.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,140 @@
+// Package soupbintcp implements a SoupBinTCP-like session layer: length
+// prefixed packets, login with a requested sequence number, heartbeats and
+// replay of sequenced messages on reconnect.
+package soupbintcp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Packet types sent by the client.
+const (
+	LoginRequest    byte = 'L'
+	UnsequencedData byte = 'U'
+	ClientHeartbeat byte = 'R'
+	LogoutRequest   byte = 'O'
+)
+
+// Packet types sent by the server.
+const (
+	LoginAccepted   byte = 'A'
+	LoginRejected   byte = 'J'
+	SequencedData   byte = 'S'
+	ServerHeartbeat byte = 'H'
+	EndOfSession    byte = 'Z'
+)
+
+// Packet types sent by either side.
+const (
+	Debug byte = '+'
+)
+
+// Login reject reasons.
+const (
+	RejectNotAuthorized      byte = 'A'
+	RejectSessionUnavailable byte = 'S'
+)
+
+const (
+	// MaxPayload is the largest payload that fits a packet, whose length
+	// prefix counts the type byte as well.
+	MaxPayload = 1<<16 - 2
+
+	usernameSize = 6
+	passwordSize = 10
+	sessionSize  = 10
+	sequenceSize = 20
+
+	loginRequestSize  = usernameSize + passwordSize + sessionSize + sequenceSize
+	loginAcceptedSize = sessionSize + sequenceSize
+)
+
+var ErrPacketTooLarge = errors.New("packet too large")
+
+// Reader reads packets into a buffer that is reused for every packet.
+type Reader struct {
+	r   io.Reader
+	buf [2 + 1 + MaxPayload]byte
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+// Next reads a packet and returns its type and payload. The payload is only
+// valid until the next call.
+func (r *Reader) Next() (byte, []byte, error) {
+	if _, err := io.ReadFull(r.r, r.buf[:2]); err != nil {
+		return 0, nil, err
+	}
+	size := int(binary.BigEndian.Uint16(r.buf[:2]))
+	if size == 0 {
+		return 0, nil, fmt.Errorf("empty packet")
+	}
+	if _, err := io.ReadFull(r.r, r.buf[2:2+size]); err != nil {
+		return 0, nil, err
+	}
+	return r.buf[2], r.buf[3 : 2+size], nil
+}
+
+// AppendPacket appends a packet of the given type and payload to b.
+func AppendPacket(b []byte, packetType byte, payload []byte) ([]byte, error) {
+	if len(payload) > MaxPayload {
+		return b, ErrPacketTooLarge
+	}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(payload)+1))
+	b = append(b, packetType)
+	return append(b, payload...), nil
+}
+
+// appendAlpha appends s left justified and padded with spaces to size bytes.
+func appendAlpha(b []byte, s string, size int) []byte {
+	if len(s) > size {
+		s = s[:size]
+	}
+	b = append(b, s...)
+	for i := len(s); i < size; i++ {
+		b = append(b, ' ')
+	}
+	return b
+}
+
+// appendNumeric appends n right justified and padded with spaces to size
+// bytes.
+func appendNumeric(b []byte, n uint64, size int) []byte {
+	var digits [20]byte
+	d := strconv.AppendUint(digits[:0], n, 10)
+	for i := len(d); i < size; i++ {
+		b = append(b, ' ')
+	}
+	return append(b, d...)
+}
+
+func trimSpaces(b []byte) []byte {
+	for len(b) > 0 && b[0] == ' ' {
+		b = b[1:]
+	}
+	for len(b) > 0 && b[len(b)-1] == ' ' {
+		b = b[:len(b)-1]
+	}
+	return b
+}
+
+func parseNumeric(b []byte) (uint64, error) {
+	b = trimSpaces(b)
+	if len(b) == 0 {
+		return 0, nil
+	}
+	var n uint64
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("invalid numeric field %q", b)
+		}
+		n = n*10 + uint64(c-'0')
+	}
+	return n, nil
+}
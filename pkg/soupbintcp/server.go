@@ -0,0 +1,353 @@
+package soupbintcp
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	heartbeatInterval = time.Second
+	idleTimeout       = 15 * time.Second
+	loginTimeout      = 10 * time.Second
+)
+
+// DefaultRetention is the number of bytes of sequenced messages a session
+// keeps for replay, unless the server is given another with SetRetention.
+const DefaultRetention = 64 << 20
+
+// A Handler receives the unsequenced messages of logged in sessions.
+// Payloads are only valid for the duration of the call.
+type Handler interface {
+	OnLogin(s *Session)
+	OnMessage(s *Session, payload []byte)
+	OnLogout(s *Session)
+}
+
+// Authenticator checks the credentials of a login request.
+type Authenticator func(username, password string) bool
+
+// Session is the sequenced message stream of one username. It outlives the
+// connections it is logged in through, so that a client can reconnect and
+// replay what it missed, as far back as its retention.
+type Session struct {
+	username  string
+	retention int
+
+	m sync.Mutex
+	// data holds the retained sequenced messages back to back, from
+	// sequence first; message n is data[offsets[n-first]:offsets[n-first+1]]
+	data     []byte
+	offsets  []int
+	first    uint64
+	conn     net.Conn
+	wbuf     []byte
+	lastSent time.Time
+}
+
+func newSession(username string, retention int) *Session {
+	return &Session{username: username, retention: retention, offsets: []int{0}, first: 1}
+}
+
+func (s *Session) Username() string {
+	return s.username
+}
+
+// NextSequence returns the sequence number the next message will be given.
+func (s *Session) NextSequence() uint64 {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.first + uint64(len(s.offsets)) - 1
+}
+
+// Send appends a sequenced message to the stream and writes it to the
+// connection, if the session is logged in. The payload is copied.
+func (s *Session) Send(payload []byte) error {
+	if len(payload) > MaxPayload {
+		return ErrPacketTooLarge
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if len(s.data)+len(payload) > s.retention {
+		s.drop()
+	}
+	s.data = append(s.data, payload...)
+	s.offsets = append(s.offsets, len(s.data))
+	if s.conn == nil {
+		return nil
+	}
+	return s.write(SequencedData, payload)
+}
+
+// drop forgets the older half of the retained messages, so that a client
+// can no longer replay them. It should only be called with the lock held.
+func (s *Session) drop() {
+	n := len(s.offsets) / 2
+	start := s.offsets[n]
+	s.data = s.data[:copy(s.data, s.data[start:])]
+	s.offsets = s.offsets[:copy(s.offsets, s.offsets[n:])]
+	for i := range s.offsets {
+		s.offsets[i] -= start
+	}
+	s.first += uint64(n)
+}
+
+func (s *Session) write(packetType byte, payload []byte) error {
+	s.wbuf, _ = AppendPacket(s.wbuf[:0], packetType, payload)
+	s.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if _, err := s.conn.Write(s.wbuf); err != nil {
+		return err
+	}
+	s.lastSent = time.Now()
+	return nil
+}
+
+// attach replays the stream from sequence, or from the first retained
+// message if it is older, and makes conn the connection of the session.
+// Messages sent concurrently are written after the replay.
+func (s *Session) attach(conn net.Conn, name string, sequence uint64) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.conn != nil {
+		return fmt.Errorf("session %s is already logged in", s.username)
+	}
+
+	next := s.first + uint64(len(s.offsets)) - 1
+	if sequence == 0 || sequence > next {
+		sequence = next
+	}
+	sequence = max(sequence, s.first)
+
+	s.conn = conn
+	var payload [loginAcceptedSize]byte
+	accepted := appendAlpha(payload[:0], name, sessionSize)
+	accepted = appendNumeric(accepted, sequence, sequenceSize)
+	if err := s.write(LoginAccepted, accepted); err != nil {
+		s.conn = nil
+		return err
+	}
+
+	for i := sequence - s.first; i < next-s.first; i++ {
+		if err := s.write(SequencedData, s.data[s.offsets[i]:s.offsets[i+1]]); err != nil {
+			s.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Session) detach(conn net.Conn) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.conn == conn {
+		s.conn = nil
+	}
+}
+
+func (s *Session) heartbeat(conn net.Conn, now time.Time) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.conn != conn {
+		return fmt.Errorf("session %s is not connected", s.username)
+	}
+	if now.Sub(s.lastSent) >= heartbeatInterval {
+		return s.write(ServerHeartbeat, nil)
+	}
+	return nil
+}
+
+// Server accepts SoupBinTCP connections for a single named session stream
+// per username.
+type Server struct {
+	name      string
+	auth      Authenticator
+	handler   Handler
+	retention int
+
+	m        sync.Mutex
+	sessions map[string]*Session
+	listener net.Listener
+	conns    map[net.Conn]*Session
+	wg       sync.WaitGroup
+}
+
+func NewServer(name string, auth Authenticator, handler Handler) *Server {
+	return &Server{
+		name:      name,
+		auth:      auth,
+		handler:   handler,
+		retention: DefaultRetention,
+		sessions:  make(map[string]*Session),
+		conns:     make(map[net.Conn]*Session),
+	}
+}
+
+// SetRetention sets the number of bytes of sequenced messages kept for replay
+// by the sessions of usernames that first log in later. Older messages are dropped, and a client
+// asking for them is replayed from the first message kept.
+func (s *Server) SetRetention(bytes int) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.retention = bytes
+}
+
+// Listen starts accepting connections on addr in the background.
+func (s *Server) Listen(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.m.Lock()
+	s.listener = l
+	s.m.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.m.Lock()
+			s.conns[conn] = nil
+			s.m.Unlock()
+
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.handle(conn)
+				s.m.Lock()
+				delete(s.conns, conn)
+				s.m.Unlock()
+			}()
+		}
+	}()
+	return nil
+}
+
+// Addr returns the address the server is listening on.
+func (s *Server) Addr() net.Addr {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.listener.Addr()
+}
+
+// Session returns the session of a username that has logged in at least once.
+func (s *Server) Session(username string) (*Session, bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	session, ok := s.sessions[username]
+	return session, ok
+}
+
+// Close sends End of Session to every logged in client and closes all
+// connections.
+func (s *Server) Close() error {
+	s.m.Lock()
+	err := s.listener.Close()
+	for conn, session := range s.conns {
+		if session != nil {
+			session.m.Lock()
+			if session.conn == conn {
+				session.write(EndOfSession, nil)
+			}
+			session.m.Unlock()
+		}
+		conn.Close()
+	}
+	s.m.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) session(username string) *Session {
+	s.m.Lock()
+	defer s.m.Unlock()
+	session, ok := s.sessions[username]
+	if !ok {
+		session = newSession(username, s.retention)
+		s.sessions[username] = session
+	}
+	return session
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	r := NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(loginTimeout))
+	packetType, payload, err := r.Next()
+	if err != nil || packetType != LoginRequest || len(payload) != loginRequestSize {
+		return
+	}
+
+	username := string(trimSpaces(payload[:usernameSize]))
+	password := string(trimSpaces(payload[usernameSize : usernameSize+passwordSize]))
+	requested := trimSpaces(payload[usernameSize+passwordSize : usernameSize+passwordSize+sessionSize])
+	sequence, err := parseNumeric(payload[loginRequestSize-sequenceSize:])
+
+	reject := func(reason byte) {
+		b, _ := AppendPacket(nil, LoginRejected, []byte{reason})
+		conn.Write(b)
+	}
+	if err != nil || !s.auth(username, password) {
+		reject(RejectNotAuthorized)
+		return
+	}
+	if len(requested) > 0 && string(requested) != s.name {
+		reject(RejectSessionUnavailable)
+		return
+	}
+
+	session := s.session(username)
+	if err := session.attach(conn, s.name, sequence); err != nil {
+		reject(RejectSessionUnavailable)
+		return
+	}
+	defer session.detach(conn)
+
+	s.m.Lock()
+	s.conns[conn] = session
+	s.m.Unlock()
+
+	s.handler.OnLogin(session)
+	defer s.handler.OnLogout(session)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(heartbeatInterval / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				if err := session.heartbeat(conn, now); err != nil {
+					conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		packetType, payload, err := r.Next()
+		if err != nil {
+			return
+		}
+		switch packetType {
+		case UnsequencedData:
+			s.handler.OnMessage(session, payload)
+		case LogoutRequest:
+			return
+		}
+	}
+}
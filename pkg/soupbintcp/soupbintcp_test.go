@@ -0,0 +1,143 @@
+package soupbintcp
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// echoHandler sends every message back as sequenced data.
+type echoHandler struct {
+	m      sync.Mutex
+	logins int
+}
+
+func (h *echoHandler) OnLogin(s *Session) {
+	h.m.Lock()
+	h.logins++
+	h.m.Unlock()
+}
+
+func (h *echoHandler) OnMessage(s *Session, payload []byte) {
+	s.Send(payload)
+}
+
+func (h *echoHandler) OnLogout(s *Session) {}
+
+func newTestServer(t *testing.T) (*Server, *echoHandler) {
+	h := &echoHandler{}
+	s := NewServer("TEST", func(username, password string) bool {
+		return password == "secret"
+	}, h)
+	require.NoError(t, s.Listen("127.0.0.1:0"))
+	t.Cleanup(func() { s.Close() })
+	return s, h
+}
+
+func expectData(t *testing.T, c *Client, payload string) {
+	packetType, got, err := c.Next(2 * time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, SequencedData, packetType)
+	assert.Equal(t, payload, string(got))
+}
+
+func TestReader(t *testing.T) {
+	var b []byte
+	b, err := AppendPacket(b, SequencedData, []byte("hello"))
+	require.NoError(t, err)
+	b, err = AppendPacket(b, ServerHeartbeat, nil)
+	require.NoError(t, err)
+
+	r := NewReader(bytes.NewReader(b))
+	packetType, payload, err := r.Next()
+	require.NoError(t, err)
+	assert.Equal(t, SequencedData, packetType)
+	assert.Equal(t, "hello", string(payload))
+
+	packetType, payload, err = r.Next()
+	require.NoError(t, err)
+	assert.Equal(t, ServerHeartbeat, packetType)
+	assert.Empty(t, payload)
+
+	_, err = AppendPacket(nil, SequencedData, make([]byte, 1<<16))
+	assert.ErrorIs(t, err, ErrPacketTooLarge)
+}
+
+func TestSessionRetention(t *testing.T) {
+	s, _ := newTestServer(t)
+	s.SetRetention(8)
+
+	c, err := Dial(s.Addr().String(), "alice", "secret", 1)
+	require.NoError(t, err)
+	for _, payload := range []string{"one", "two", "three"} {
+		require.NoError(t, c.Send([]byte(payload)))
+		expectData(t, c, payload)
+	}
+	require.NoError(t, c.Logout())
+	c.Close()
+
+	// Adding "three" dropped "one", which can no longer be replayed.
+	assert.Eventually(t, func() bool {
+		c, err = Dial(s.Addr().String(), "alice", "secret", 1)
+		return err == nil
+	}, 2*time.Second, 10*time.Millisecond)
+	require.NoError(t, err)
+	defer c.Close()
+	assert.Equal(t, uint64(2), c.NextSequence)
+	expectData(t, c, "two")
+	expectData(t, c, "three")
+}
+
+func TestLoginRejected(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	_, err := Dial(s.Addr().String(), "alice", "wrong", 1)
+	assert.Error(t, err)
+}
+
+func TestSessionReplay(t *testing.T) {
+	s, h := newTestServer(t)
+
+	c, err := Dial(s.Addr().String(), "alice", "secret", 1)
+	require.NoError(t, err)
+	assert.Equal(t, "TEST", c.Session)
+	assert.Equal(t, uint64(1), c.NextSequence)
+
+	require.NoError(t, c.Send([]byte("one")))
+	expectData(t, c, "one")
+	require.NoError(t, c.Send([]byte("two")))
+	expectData(t, c, "two")
+	assert.Equal(t, uint64(3), c.NextSequence)
+
+	// The echo of the last message is sequenced but never read.
+	require.NoError(t, c.Send([]byte("three")))
+	require.NoError(t, c.Logout())
+	c.Close()
+
+	// Reconnecting from sequence 2 replays what follows it, once the
+	// server has released the previous connection.
+	assert.Eventually(t, func() bool {
+		c, err = Dial(s.Addr().String(), "alice", "secret", 2)
+		return err == nil
+	}, 2*time.Second, 10*time.Millisecond)
+	require.NoError(t, err)
+	defer c.Close()
+	assert.Equal(t, uint64(2), c.NextSequence)
+	expectData(t, c, "two")
+	expectData(t, c, "three")
+	assert.Equal(t, uint64(4), c.NextSequence)
+
+	h.m.Lock()
+	assert.Equal(t, 2, h.logins)
+	h.m.Unlock()
+
+	// The server ends the session on close.
+	s.Close()
+	packetType, _, err := c.Next(2 * time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, EndOfSession, packetType)
+}
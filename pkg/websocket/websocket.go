@@ -0,0 +1,395 @@
+// Package websocket implements the parts of RFC 6455 needed to stream to
+// browsers: the opening handshake for servers and clients, message framing
+// with fragmentation, and the ping, pong and close control frames.
+// Extensions and subprotocols are not supported.
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Opcodes of data and control frames.
+const (
+	OpContinuation byte = 0x0
+	OpText         byte = 0x1
+	OpBinary       byte = 0x2
+	OpClose        byte = 0x8
+	OpPing         byte = 0x9
+	OpPong         byte = 0xa
+)
+
+// Close status codes.
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseTryAgainLater   = 1013
+)
+
+const (
+	acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	// DefaultMaxMessageSize is the largest message ReadMessage accepts unless
+	// changed with SetMaxMessageSize.
+	DefaultMaxMessageSize = 1 << 20
+
+	maxControlPayload = 125
+	writeTimeout      = 10 * time.Second
+)
+
+var (
+	ErrBadHandshake = errors.New("bad websocket handshake")
+	ErrProtocol     = errors.New("websocket protocol error")
+	ErrMessageSize  = errors.New("websocket message too big")
+)
+
+// CloseError is returned by ReadMessage when the peer closes the connection.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket closed: %d %s", e.Code, e.Reason)
+}
+
+// Conn is a websocket connection. ReadMessage must be called from a single
+// goroutine; writes may be made concurrently.
+type Conn struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	client bool
+
+	readTimeout    time.Duration
+	maxMessageSize int
+	header         [14]byte
+
+	wm     sync.Mutex
+	wbuf   []byte
+	closed bool
+}
+
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Upgrade completes the opening handshake of a server and takes over the
+// connection. On failure it answers the request with an error status.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" ||
+		key == "" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "websocket upgrade required", http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, fmt.Errorf("%w: response cannot be hijacked", ErrBadHandshake)
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n"
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetWriteDeadline(time.Time{})
+	return newConn(conn, brw.Reader, false), nil
+}
+
+// Dial opens a client connection to a ws:// URL.
+func Dial(url string, header http.Header) (*Conn, error) {
+	host, path, ok := strings.Cut(strings.TrimPrefix(url, "ws://"), "/")
+	if !ok || !strings.HasPrefix(url, "ws://") {
+		return nil, fmt.Errorf("%w: unsupported url %q", ErrBadHandshake, url)
+	}
+	conn, err := net.Dial("tcp", host)
+	if err != nil {
+		return nil, err
+	}
+
+	var nonce [16]byte
+	rand.Read(nonce[:])
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+
+	req, err := http.NewRequest(http.MethodGet, "http://"+host+"/"+path, nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		conn.Close()
+		return nil, fmt.Errorf("%w: %s", ErrBadHandshake, resp.Status)
+	}
+	return newConn(conn, r, true), nil
+}
+
+func newConn(conn net.Conn, r *bufio.Reader, client bool) *Conn {
+	return &Conn{
+		conn:           conn,
+		r:              r,
+		client:         client,
+		maxMessageSize: DefaultMaxMessageSize,
+	}
+}
+
+// SetReadTimeout makes every frame read, control frames included, fail if
+// it does not arrive within d. Zero disables the timeout.
+func (c *Conn) SetReadTimeout(d time.Duration) {
+	c.readTimeout = d
+}
+
+func (c *Conn) SetMaxMessageSize(n int) {
+	c.maxMessageSize = n
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// readFrame reads a single frame, unmasking its payload.
+func (c *Conn) readFrame(buf []byte) (fin bool, opcode byte, payload []byte, err error) {
+	if c.readTimeout > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(c.readTimeout))
+	}
+	h := c.header[:2]
+	if _, err = io.ReadFull(c.r, h); err != nil {
+		return
+	}
+	fin = h[0]&0x80 != 0
+	opcode = h[0] & 0x0f
+	masked := h[1]&0x80 != 0
+	if h[0]&0x70 != 0 || masked == c.client {
+		err = fmt.Errorf("%w: unexpected reserved bits or masking", ErrProtocol)
+		return
+	}
+
+	length := uint64(h[1] & 0x7f)
+	switch length {
+	case 126:
+		if _, err = io.ReadFull(c.r, c.header[:2]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(c.header[:2]))
+	case 127:
+		if _, err = io.ReadFull(c.r, c.header[:8]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(c.header[:8])
+	}
+	if opcode >= OpClose && (length > maxControlPayload || !fin) {
+		err = fmt.Errorf("%w: invalid control frame", ErrProtocol)
+		return
+	}
+	if length > uint64(c.maxMessageSize-len(buf)) {
+		err = ErrMessageSize
+		return
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.r, mask[:]); err != nil {
+			return
+		}
+	}
+	start := len(buf)
+	payload = append(buf, make([]byte, length)...)
+	if _, err = io.ReadFull(c.r, payload[start:]); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload[start:] {
+			payload[start+i] ^= mask[i%4]
+		}
+	}
+	return
+}
+
+// ReadMessage returns the next text or binary message. It answers pings and
+// close frames itself; when the peer closes, it returns a *CloseError.
+func (c *Conn) ReadMessage() (byte, []byte, error) {
+	var (
+		opcode  byte
+		message []byte
+		started bool
+	)
+	for {
+		fin, frameOpcode, payload, err := c.readFrame(message)
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrMessageSize):
+				c.Close(CloseMessageTooBig, "message too big")
+			case errors.Is(err, ErrProtocol):
+				c.Close(CloseProtocolError, "")
+			}
+			return 0, nil, err
+		}
+
+		switch frameOpcode {
+		case OpPing:
+			c.WriteControl(OpPong, payload[len(message):])
+			continue
+		case OpPong:
+			continue
+		case OpClose:
+			closeErr := &CloseError{Code: CloseNormal}
+			if p := payload[len(message):]; len(p) >= 2 {
+				closeErr.Code = int(binary.BigEndian.Uint16(p))
+				closeErr.Reason = string(p[2:])
+			}
+			c.Close(closeErr.Code, "")
+			return 0, nil, closeErr
+		case OpContinuation:
+			if !started {
+				c.Close(CloseProtocolError, "")
+				return 0, nil, fmt.Errorf("%w: unexpected continuation", ErrProtocol)
+			}
+		case OpText, OpBinary:
+			if started {
+				c.Close(CloseProtocolError, "")
+				return 0, nil, fmt.Errorf("%w: interleaved message", ErrProtocol)
+			}
+			started = true
+			opcode = frameOpcode
+		default:
+			c.Close(CloseProtocolError, "")
+			return 0, nil, fmt.Errorf("%w: unknown opcode %d", ErrProtocol, frameOpcode)
+		}
+
+		message = payload
+		if fin {
+			return opcode, message, nil
+		}
+	}
+}
+
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	c.wm.Lock()
+	defer c.wm.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+
+	b := append(c.wbuf[:0], 0x80|opcode)
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		b = append(b, maskBit|byte(n))
+	case n <= 0xffff:
+		b = append(b, maskBit|126)
+		b = binary.BigEndian.AppendUint16(b, uint16(n))
+	default:
+		b = append(b, maskBit|127)
+		b = binary.BigEndian.AppendUint64(b, uint64(n))
+	}
+	if c.client {
+		var mask [4]byte
+		rand.Read(mask[:])
+		b = append(b, mask[:]...)
+		start := len(b)
+		b = append(b, payload...)
+		for i := range b[start:] {
+			b[start+i] ^= mask[i%4]
+		}
+	} else {
+		b = append(b, payload...)
+	}
+	c.wbuf = b
+
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := c.conn.Write(b)
+	return err
+}
+
+// WriteMessage sends a text or binary message in a single frame.
+func (c *Conn) WriteMessage(opcode byte, data []byte) error {
+	return c.writeFrame(opcode, data)
+}
+
+// WriteControl sends a ping or pong frame.
+func (c *Conn) WriteControl(opcode byte, data []byte) error {
+	if len(data) > maxControlPayload {
+		return fmt.Errorf("%w: control payload of %d bytes", ErrProtocol, len(data))
+	}
+	return c.writeFrame(opcode, data)
+}
+
+// Close sends a close frame with the code and reason, then closes the
+// connection. It is safe to call more than once.
+func (c *Conn) Close(code int, reason string) error {
+	if len(reason) > maxControlPayload-2 {
+		reason = reason[:maxControlPayload-2]
+	}
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	c.writeFrame(OpClose, append(payload, reason...))
+
+	c.wm.Lock()
+	defer c.wm.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.conn.Close()
+}
@@ -0,0 +1,90 @@
+package websocket
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcceptKey(t *testing.T) {
+	// The example of RFC 6455, section 1.3.
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", acceptKey("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+// echoServer echoes every message and reports how the connection ended.
+func echoServer(t *testing.T) (string, <-chan error) {
+	done := make(chan error, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := Upgrade(w, r)
+		if err != nil {
+			done <- err
+			return
+		}
+		for {
+			opcode, data, err := c.ReadMessage()
+			if err != nil {
+				done <- err
+				return
+			}
+			c.WriteMessage(opcode, data)
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return "ws://" + strings.TrimPrefix(ts.URL, "http://") + "/", done
+}
+
+func TestEcho(t *testing.T) {
+	url, done := echoServer(t)
+	c, err := Dial(url, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, c.WriteMessage(OpText, []byte("hello")))
+	opcode, data, err := c.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, OpText, opcode)
+	assert.Equal(t, "hello", string(data))
+
+	// Pings are answered by the server and the pong skipped by the client.
+	require.NoError(t, c.WriteControl(OpPing, []byte("p")))
+	large := bytes.Repeat([]byte{7}, 70000)
+	require.NoError(t, c.WriteMessage(OpBinary, large))
+	opcode, data, err = c.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, OpBinary, opcode)
+	assert.Equal(t, large, data)
+
+	// A message in two masked frames, with a ping in between. The zero mask
+	// leaves the payload as is.
+	fragments := []byte{
+		0x01, 0x83, 0, 0, 0, 0, 'a', 'b', 'c',
+		0x89, 0x80, 0, 0, 0, 0,
+		0x80, 0x82, 0, 0, 0, 0, 'd', 'e',
+	}
+	_, err = c.conn.Write(fragments)
+	require.NoError(t, err)
+	opcode, data, err = c.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, OpText, opcode)
+	assert.Equal(t, "abcde", string(data))
+
+	require.NoError(t, c.Close(CloseGoingAway, "bye"))
+	err = <-done
+	var closeErr *CloseError
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, CloseGoingAway, closeErr.Code)
+	assert.Equal(t, "bye", closeErr.Reason)
+}
+
+func TestBadHandshake(t *testing.T) {
+	url, done := echoServer(t)
+	resp, err := http.Get("http" + strings.TrimPrefix(url, "ws"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.ErrorIs(t, <-done, ErrBadHandshake)
+}